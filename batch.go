@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-uploader/core"
+)
+
+// batchOutput is the JSON written to stdout for each input file uploaded to a destination template
+type batchOutput struct {
+	Input string `json:"input"`
+	uploadOutput
+	Error string `json:"error,omitempty"`
+	// Attempts are the failed attempts of an upload that failed
+	Attempts []core.UploadAttempt `json:"attempts,omitempty"`
+}
+
+// uploadBatch expands the glob patterns among the input files and uploads each file to the destination template
+// expanded for it, or to its relative path under the destination if that isn't a template, see
+// core.BatchDestination. It writes a JSON array with the result of every upload and fails if any upload failed.
+func uploadBatch(results *resultWriter, destination string, inputs []string, parallel int, disableRecording []string, opts core.UploadOptions) int {
+	files, err := core.ExpandInputGlobs(inputs, opts.Filter)
+	if err != nil {
+		glog.Errorf("Failed to expand input files: %s", err)
+		return 1
+	}
+	var uploads []*core.BatchUpload
+	for i, file := range files {
+		uri, err := core.BatchDestination(destination, file, i)
+		if err != nil {
+			glog.Errorf("Failed to parse URI for %s: %s", file.Path, err)
+			return 1
+		}
+		if recordingDisabled(uri, disableRecording) {
+			continue
+		}
+		uploads = append(uploads, &core.BatchUpload{FileName: file.Path, URI: uri})
+	}
+
+	core.UploadBatch(uploads, parallel, opts)
+	return writeBatchOutput(results, uploads, opts)
+}
+
+// uploadTar uploads the files of the tar stream on stdin, see core.UploadTar, and writes the same JSON array
+// as uploadBatch
+func uploadTar(results *resultWriter, destination string, disableRecording []string, opts core.UploadOptions) int {
+	uri, err := core.ParseOutputURI(destination)
+	if err != nil {
+		glog.Errorf("Failed to parse URI: %s", err)
+		return 1
+	}
+	if recordingDisabled(uri, disableRecording) {
+		return 0
+	}
+	uploads, err := core.UploadTar(os.Stdin, destination, opts)
+	exitCode := writeBatchOutput(results, uploads, opts)
+	if err != nil {
+		glog.Errorf("Uploading tar stream failed: %s", err)
+		return 1
+	}
+	return exitCode
+}
+
+// writeBatchOutput logs the result of each upload and writes them to stdout, as a JSON array in the legacy
+// -output-format. It returns a non-zero exit code if any upload failed.
+func writeBatchOutput(results *resultWriter, uploads []*core.BatchUpload, opts core.UploadOptions) int {
+	exitCode := 0
+	outputs := []batchOutput{}
+	for _, upload := range uploads {
+		if upload.Err != nil {
+			glog.Errorf("Uploader failed for %s: %s%s", upload.URI.Redacted(), upload.Err, opts.LogFields())
+			exitCode = 1
+			outputs = append(outputs, batchOutput{Input: upload.FileName, uploadOutput: uploadOutput{URI: core.RedactedURI(upload.URI), CorrelationIDs: opts.CorrelationIDs}, Error: upload.Err.Error(), Attempts: core.UploadAttempts(upload.Err)})
+			continue
+		}
+		glog.Infof("Uploader succeeded for %s%s", upload.URI.Redacted(), opts.LogFields())
+		outputs = append(outputs, batchOutput{Input: upload.FileName, uploadOutput: newUploadOutput(upload.URI, upload.Result, opts, results.spacesCDN)})
+	}
+	if err := results.batch(outputs, exitCode != 0); err != nil {
+		glog.Error(err)
+		return 1
+	}
+	return exitCode
+}
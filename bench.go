@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-uploader/core"
+)
+
+// runBench implements `catalyst-uploader bench <uri>...`, uploading synthetic payloads to each destination
+// and writing one JSON result per destination and payload size to stdout.
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("catalyst-uploader bench", flag.ExitOnError)
+	logs := addLogFlags(fs)
+	addConfigFlag(fs)
+	sizes := CommaSliceFlag(fs, "sizes", "Comma-separated list of payload sizes, e.g. 1MiB,8MiB (default 1MiB,8MiB)")
+	count := fs.Int("count", 10, "Number of uploads per payload size")
+	concurrency := fs.Int("concurrency", 4, "Number of concurrent uploads")
+	timeout := fs.Duration("t", 30*time.Second, "Upload timeout")
+	keep := fs.Bool("keep", false, "Keep the uploaded payloads instead of deleting them")
+
+	if err := parseFlags(fs, args); err != nil {
+		glog.Errorf("error parsing cli: %s", err)
+		return 1
+	}
+	cleanupLogs, err := logs.apply()
+	if err != nil {
+		glog.Error(err)
+		return 1
+	}
+	defer cleanupLogs()
+	if fs.NArg() == 0 {
+		glog.Error("Destination URI is not specified")
+		return 1
+	}
+
+	if len(*sizes) == 0 {
+		*sizes = []string{"1MiB", "8MiB"}
+	}
+	opts := core.BenchOptions{Count: *count, Concurrency: *concurrency, Timeout: *timeout, Keep: *keep}
+	for _, s := range *sizes {
+		size, err := core.ParseByteSize(s)
+		if err != nil {
+			glog.Errorf("Invalid payload size: %s", err)
+			return 1
+		}
+		opts.Sizes = append(opts.Sizes, size)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	exitCode := 0
+	for _, arg := range fs.Args() {
+		uri, err := core.ParseOutputURI(arg)
+		if err != nil {
+			glog.Errorf("Failed to parse URI: %s", err)
+			return 1
+		}
+		results, err := core.Bench(uri, opts)
+		for _, res := range results {
+			if err := enc.Encode(res); err != nil {
+				glog.Error(err)
+				return 1
+			}
+		}
+		if err != nil {
+			glog.Errorf("Benchmark failed for %s: %s", uri.Redacted(), err)
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-uploader/core"
+)
+
+// runCheck implements `catalyst-uploader check <uri>`, verifying that a storage is usable with a small write,
+// read and delete under the URI and writing one JSON line per step with its latency to stdout
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("catalyst-uploader check", flag.ExitOnError)
+	logs := addLogFlags(fs)
+	addConfigFlag(fs)
+	timeout := fs.Duration("t", 10*time.Second, "Timeout of each step")
+
+	if err := parseFlags(fs, args); err != nil {
+		glog.Errorf("error parsing cli: %s", err)
+		return 1
+	}
+	cleanupLogs, err := logs.apply()
+	if err != nil {
+		glog.Error(err)
+		return 1
+	}
+	defer cleanupLogs()
+	if fs.NArg() != 1 {
+		glog.Error("Expected a single storage URI")
+		return 1
+	}
+	uri, err := core.ExpandEnv(fs.Arg(0))
+	if err != nil {
+		glog.Error(err)
+		return 1
+	}
+
+	steps, checkErr := core.Check(uri, *timeout, core.UploadOptions{})
+	enc := json.NewEncoder(os.Stdout)
+	for _, step := range steps {
+		if err := enc.Encode(step); err != nil {
+			glog.Error(err)
+			return 1
+		}
+	}
+	if checkErr != nil {
+		glog.Errorf("Storage check failed: %s", checkErr)
+		return 1
+	}
+	return 0
+}
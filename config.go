@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/peterbourgon/ff"
+)
+
+// systemConfigFile holds the node level defaults, such as fallback URLs and tuning
+const systemConfigFile = "/etc/livepeer/catalyst_uploader.conf"
+
+// configSearchPath lists the default config files, highest precedence first: the node level one in
+// /etc/livepeer, then the one of the user in ~/.config/catalyst-uploader
+func configSearchPath() []string {
+	files := []string{systemConfigFile}
+	if dir, err := os.UserConfigDir(); err == nil {
+		files = append(files, filepath.Join(dir, "catalyst-uploader", "catalyst_uploader.conf"))
+	}
+	return files
+}
+
+// addConfigFlag adds the -config flag parseFlags reads the config file of the command from
+func addConfigFlag(fs *flag.FlagSet) {
+	fs.String("config", "", "Config file (optional). It takes precedence over "+systemConfigFile+" and ~/.config/catalyst-uploader/catalyst_uploader.conf, which are merged under it")
+}
+
+// parseFlags sets the flags of fs from args, the environment, the -config file and the default config files, in
+// that order of precedence
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	err := ff.Parse(fs, args,
+		ff.WithIgnoreUndefined(true),
+		ff.WithConfigFileFlag("config"),
+		ff.WithConfigFileParser(ff.PlainParser),
+		ff.WithEnvVarPrefix("CATALYST_UPLOADER"),
+	)
+	if err != nil {
+		return err
+	}
+	return parseLayeredConfig(fs, configSearchPath())
+}
+
+// parseLayeredConfig merges config files under what fs already has from the command line, the environment and
+// the -config file: a flag is only set from the first file that has it, so earlier files take precedence. Files
+// that don't exist are skipped and flags fs doesn't define are ignored, like for the -config file.
+func parseLayeredConfig(fs *flag.FlagSet, files []string) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, fileName := range files {
+		file, err := os.Open(fileName)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		fromFile := map[string]bool{}
+		err = ff.PlainParser(file, func(name, value string) error {
+			if set[name] || fs.Lookup(name) == nil {
+				return nil
+			}
+			fromFile[name] = true
+			return fs.Set(name, value)
+		})
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", fileName, err)
+		}
+		// repeated flags can be given several times in a file, but not merged across files
+		for name := range fromFile {
+			set[name] = true
+		}
+	}
+	return nil
+}
+
+// secretFlags are the flags whose whole value is a secret
+var secretFlags = map[string]bool{"smb-password": true, "grant": true}
+
+// urlCredentials matches the credentials of URLs in flag values, including those of comma-separated maps
+var urlCredentials = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)[^/?#@\s,]+@`)
+
+// redactConfigValue hides the secrets of a flag value: the credentials of URLs, which can be storage keys or
+// API tokens, and the values of secretFlags
+func redactConfigValue(name, value string) string {
+	if value != "" && secretFlags[name] {
+		return "xxxxx"
+	}
+	return urlCredentials.ReplaceAllString(value, "${1}xxxxx@")
+}
+
+// printConfig writes the effective value of every flag of fs, merged from the command line, the environment and
+// the config files, and the destinations, as JSON with their secrets redacted. Repeated flags have the list of
+// their values.
+func printConfig(w io.Writer, fs *flag.FlagSet) error {
+	config := struct {
+		Flags        map[string]interface{} `json:"flags"`
+		Destinations []string               `json:"destinations,omitempty"`
+	}{Flags: map[string]interface{}{}}
+	fs.VisitAll(func(f *flag.Flag) {
+		repeated, ok := f.Value.(*funcValue)
+		if !ok || !repeated.repeated {
+			config.Flags[f.Name] = redactConfigValue(f.Name, f.Value.String())
+			return
+		}
+		values := []string{}
+		for _, value := range repeated.given {
+			values = append(values, redactConfigValue(f.Name, value))
+		}
+		config.Flags[f.Name] = values
+	})
+	for _, arg := range fs.Args() {
+		config.Destinations = append(config.Destinations, redactConfigValue("", arg))
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(config)
+}
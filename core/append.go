@@ -0,0 +1,199 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/golang/glog"
+	"github.com/livepeer/go-tools/drivers"
+)
+
+// appendChunkSize is how much of the input is held in memory before it's sent, above the S3 minimum part size
+const appendChunkSize = 8 * 1024 * 1024
+
+// uploadAppend uploads the input in chunks as it arrives and assembles them into one object server side, so
+// that recordings of any length are uploaded without being written to disk first, see UploadOptions.Append.
+// S3 destinations get the chunks as the parts of a multipart upload, which is completed at the end of the input.
+// GCS destinations get each chunk composed onto the object, which grows as the input arrives.
+func uploadAppend(input io.Reader, outputURI *url.URL, opts UploadOptions) (*UploadResult, error) {
+	switch {
+	case outputURI.Scheme == "memory-s3":
+		s3URL, err := url.Parse(memoryS3URL(outputURI))
+		if err != nil {
+			return nil, err
+		}
+		return uploadAppend(input, s3URL, opts)
+	case isS3URL(outputURI) || isSpacesURL(outputURI):
+		dest, err := parseS3URL(outputURI)
+		if err != nil {
+			return nil, err
+		}
+		dest.storageClass = opts.Destination.StorageClass
+		return appendS3(input, dest, opts)
+	case outputURI.Scheme == "gs":
+		return appendGCS(input, outputURI, opts)
+	}
+	return nil, fmt.Errorf("append uploads to %s destinations: %w", outputURI.Scheme, drivers.ErrNotSupported)
+}
+
+// readChunks calls send with each appendChunkSize chunk of the input, and the final shorter one, until the input
+// ends. An empty input is sent as one empty chunk.
+func readChunks(input io.Reader, send func(n int, chunk []byte) error) (int64, error) {
+	buf := make([]byte, appendChunkSize)
+	var total int64
+	for n := 1; ; n++ {
+		read, err := io.ReadFull(input, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return total, fmt.Errorf("failed to read input: %w", err)
+		}
+		if read > 0 || n == 1 {
+			if err := send(n, buf[:read]); err != nil {
+				return total, err
+			}
+			total += int64(read)
+		}
+		if err != nil {
+			return total, nil
+		}
+	}
+}
+
+// appendTimeout bounds the upload of each chunk
+func appendTimeout(opts UploadOptions) time.Duration {
+	if opts.SegmentTimeout > 0 {
+		return opts.SegmentTimeout
+	}
+	return defaultSaveTimeout
+}
+
+func appendS3(input io.Reader, dest *s3Destination, opts UploadOptions) (*UploadResult, error) {
+	sess, err := dest.newSession()
+	if err != nil {
+		return nil, err
+	}
+	svc := s3.New(sess)
+	ctx := context.Background()
+	create := &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(dest.bucket),
+		Key:         aws.String(dest.key),
+		ContentType: aws.String(appendContentType(dest.key)),
+	}
+	if dest.storageClass != "" {
+		create.StorageClass = aws.String(dest.storageClass)
+	}
+	if opts.Destination.CacheControl != "" {
+		create.CacheControl = aws.String(opts.Destination.CacheControl)
+	}
+	upload, err := svc.CreateMultipartUploadWithContext(ctx, create)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+
+	var parts []*s3.CompletedPart
+	total, err := readChunks(input, func(n int, chunk []byte) error {
+		return backoff.Retry(func() error {
+			partCtx, cancel := context.WithTimeout(ctx, appendTimeout(opts))
+			defer cancel()
+			out, err := svc.UploadPartWithContext(partCtx, &s3.UploadPartInput{
+				Bucket:     aws.String(dest.bucket),
+				Key:        aws.String(dest.key),
+				UploadId:   upload.UploadId,
+				PartNumber: aws.Int64(int64(n)),
+				Body:       bytes.NewReader(chunk),
+			})
+			if err != nil {
+				glog.Errorf("failed to upload part %d of %s: %v", n, dest.objectURL(dest.key), err)
+				return err
+			}
+			parts = append(parts, &s3.CompletedPart{ETag: out.ETag, PartNumber: aws.Int64(int64(n))})
+			glog.V(5).Infof("Uploaded part %d of %s: %d bytes", n, dest.objectURL(dest.key), len(chunk))
+			return nil
+		}, SingleRequestRetryBackoff())
+	})
+	if err == nil {
+		_, err = svc.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(dest.bucket),
+			Key:             aws.String(dest.key),
+			UploadId:        upload.UploadId,
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+		})
+	}
+	if err != nil {
+		// don't leave the uploaded parts behind to be billed for
+		_, _ = svc.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{Bucket: aws.String(dest.bucket), Key: aws.String(dest.key), UploadId: upload.UploadId})
+		return nil, fmt.Errorf("append upload failed after %d bytes: %w", total, err)
+	}
+	return &UploadResult{SaveDataOutput: drivers.SaveDataOutput{URL: dest.objectURL(dest.key)}}, nil
+}
+
+func appendGCS(input io.Reader, outputURI *url.URL, opts UploadOptions) (*UploadResult, error) {
+	ctx := context.Background()
+	client, err := newGCSClient(ctx, outputURI.User.Username())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client: %w", err)
+	}
+	defer client.Close()
+	key := strings.TrimPrefix(outputURI.Path, "/")
+	bucket := client.Bucket(outputURI.Host)
+	object := bucket.Object(key)
+
+	total, err := readChunks(input, func(n int, chunk []byte) error {
+		chunkCtx, cancel := context.WithTimeout(ctx, appendTimeout(opts))
+		defer cancel()
+		chunkObject := bucket.Object(fmt.Sprintf("%s.append-%d", key, n)).Retryer(storage.WithPolicy(storage.RetryAlways))
+		w := chunkObject.NewWriter(chunkCtx)
+		if _, err := w.Write(chunk); err != nil {
+			w.Close()
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("failed to upload chunk %d: %w", n, err)
+		}
+		defer func() {
+			if err := chunkObject.Delete(ctx); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+				glog.Errorf("failed to delete chunk %d of %s: %v", n, outputURI.Redacted(), err)
+			}
+		}()
+		// the first chunk replaces any previous object, later ones are composed onto it
+		var attrs *storage.ObjectAttrs
+		if n == 1 {
+			copier := object.CopierFrom(chunkObject)
+			copier.ContentType = appendContentType(key)
+			copier.CacheControl = opts.Destination.CacheControl
+			attrs, err = copier.Run(chunkCtx)
+		} else {
+			composer := object.ComposerFrom(object, chunkObject)
+			composer.ContentType = appendContentType(key)
+			composer.CacheControl = opts.Destination.CacheControl
+			attrs, err = composer.Run(chunkCtx)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to append chunk %d: %w", n, err)
+		}
+		glog.V(5).Infof("Appended chunk %d to %s, now %d bytes", n, outputURI.Redacted(), attrs.Size)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("append upload failed after %d bytes: %w", total, err)
+	}
+	return &UploadResult{SaveDataOutput: drivers.SaveDataOutput{URL: fmt.Sprintf("https://storage.googleapis.com/%s/%s", outputURI.Host, key)}}, nil
+}
+
+// appendContentType is the content type of an appended object, by its extension as the drivers do
+func appendContentType(key string) string {
+	if contentType, err := drivers.TypeByExtension(path.Ext(key)); err == nil {
+		return contentType
+	}
+	return "application/octet-stream"
+}
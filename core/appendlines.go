@@ -0,0 +1,243 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/golang/glog"
+	"github.com/livepeer/go-tools/drivers"
+	"google.golang.org/api/googleapi"
+)
+
+// maxAppendLinesSize bounds the objects AppendLines rewrites, which are held in memory
+const maxAppendLinesSize = 64 * 1024 * 1024
+
+// appendLinesAttempts is how many times AppendLines reads and rewrites an object that other writers keep changing
+const appendLinesAttempts = 10
+
+// ErrAppendConflict is returned by AppendLines uploads when other writers kept changing the object
+var ErrAppendConflict = errors.New("object kept changing while appending")
+
+// errAppendRace is returned by a conditional write that lost to another writer, to read the object again
+var errAppendRace = errors.New("object changed while appending")
+
+// uploadAppendLines adds the input to the end of a small text object, such as a session event log that several
+// components write to, see UploadOptions.AppendLines. The object is read, extended and written back only if
+// nobody wrote it in between, by ETag on S3 and by generation on GCS, otherwise it's read again. Local files
+// are appended to directly.
+func uploadAppendLines(input io.Reader, outputURI *url.URL, opts UploadOptions) (*UploadResult, error) {
+	lines, err := io.ReadAll(io.LimitReader(input, maxAppendLinesSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+	if len(lines) > maxAppendLinesSize {
+		return nil, fmt.Errorf("input is larger than %d bytes", maxAppendLinesSize)
+	}
+	if len(lines) == 0 {
+		return &UploadResult{Skipped: true}, nil
+	}
+
+	var appendOnce func(ctx context.Context) (*UploadResult, error)
+	switch {
+	case outputURI.Scheme == "memory-s3":
+		s3URL, err := url.Parse(memoryS3URL(outputURI))
+		if err != nil {
+			return nil, err
+		}
+		return uploadAppendLines(bytes.NewReader(lines), s3URL, opts)
+	case isS3URL(outputURI) || isSpacesURL(outputURI):
+		dest, err := parseS3URL(outputURI)
+		if err != nil {
+			return nil, err
+		}
+		sess, err := dest.newSession()
+		if err != nil {
+			return nil, err
+		}
+		svc := s3.New(sess)
+		appendOnce = func(ctx context.Context) (*UploadResult, error) {
+			return appendLinesS3(ctx, svc, dest, lines, opts)
+		}
+	case outputURI.Scheme == "gs":
+		client, err := newGCSClient(context.Background(), outputURI.User.Username())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create GCS client: %w", err)
+		}
+		defer client.Close()
+		object := client.Bucket(outputURI.Host).Object(strings.TrimPrefix(outputURI.Path, "/"))
+		appendOnce = func(ctx context.Context) (*UploadResult, error) {
+			return appendLinesGCS(ctx, object, lines, opts)
+		}
+	case outputURI.Scheme == "" || outputURI.Scheme == "file":
+		return appendLinesFile(outputURI.Path, lines)
+	default:
+		return nil, fmt.Errorf("appending lines to %s destinations: %w", outputURI.Scheme, drivers.ErrNotSupported)
+	}
+
+	var out *UploadResult
+	attempt := 0
+	err = backoff.Retry(func() error {
+		attempt++
+		ctx, cancel := context.WithTimeout(context.Background(), appendTimeout(opts))
+		defer cancel()
+		var err error
+		out, err = appendOnce(ctx)
+		if errors.Is(err, errAppendRace) {
+			glog.V(5).Infof("%s changed while appending to it, attempt %d", outputURI.Redacted(), attempt)
+			return err
+		}
+		return backoff.Permanent(err)
+	}, backoff.WithMaxRetries(newExponentialBackOffExecutor(20*time.Millisecond, time.Second, 0), appendLinesAttempts-1))
+	if errors.Is(err, errAppendRace) {
+		return nil, fmt.Errorf("failed to append to %s after %d attempts: %w", outputURI.Redacted(), attempt, ErrAppendConflict)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to append to %s: %w", outputURI.Redacted(), err)
+	}
+	return out, nil
+}
+
+// joinLines appends lines to data, starting them on a new line
+func joinLines(data, lines []byte) ([]byte, error) {
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	if len(data)+len(lines) > maxAppendLinesSize {
+		return nil, fmt.Errorf("object would grow larger than %d bytes", maxAppendLinesSize)
+	}
+	return append(data, lines...), nil
+}
+
+func appendLinesS3(ctx context.Context, svc *s3.S3, dest *s3Destination, lines []byte, opts UploadOptions) (*UploadResult, error) {
+	// a missing object is created only if it's still missing, an existing one replaced only if it's unchanged
+	condition := map[string]string{"If-None-Match": "*"}
+	contentType := appendContentType(dest.key)
+	var data []byte
+	get, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(dest.bucket), Key: aws.String(dest.key)})
+	var reqErr awserr.RequestFailure
+	switch {
+	case errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound:
+	case err != nil:
+		return nil, err
+	default:
+		data, err = io.ReadAll(io.LimitReader(get.Body, maxAppendLinesSize+1))
+		get.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		condition = map[string]string{"If-Match": aws.StringValue(get.ETag)}
+		if get.ContentType != nil {
+			contentType = *get.ContentType
+		}
+	}
+	if data, err = joinLines(data, lines); err != nil {
+		return nil, err
+	}
+
+	put := &s3.PutObjectInput{
+		Bucket:      aws.String(dest.bucket),
+		Key:         aws.String(dest.key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+	}
+	if opts.Destination.CacheControl != "" {
+		put.CacheControl = aws.String(opts.Destination.CacheControl)
+	}
+	out, err := svc.PutObjectWithContext(ctx, put, request.WithSetRequestHeaders(condition))
+	// 409 is returned when a conditional write races with another one in progress
+	if errors.As(err, &reqErr) && (reqErr.StatusCode() == http.StatusPreconditionFailed || reqErr.StatusCode() == http.StatusConflict) {
+		return nil, errAppendRace
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := &UploadResult{SaveDataOutput: drivers.SaveDataOutput{URL: dest.objectURL(dest.key)}}
+	result.UploaderResponseHeaders = http.Header{}
+	if out.VersionId != nil {
+		result.UploaderResponseHeaders.Set("X-Amz-Version-Id", *out.VersionId)
+	}
+	return result, nil
+}
+
+func appendLinesGCS(ctx context.Context, object *storage.ObjectHandle, lines []byte, opts UploadOptions) (*UploadResult, error) {
+	condition := storage.Conditions{DoesNotExist: true}
+	contentType := appendContentType(object.ObjectName())
+	var data []byte
+	reader, err := object.NewReader(ctx)
+	switch {
+	case errors.Is(err, storage.ErrObjectNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		data, err = io.ReadAll(io.LimitReader(reader, maxAppendLinesSize+1))
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		condition = storage.Conditions{GenerationMatch: reader.Attrs.Generation}
+		if reader.Attrs.ContentType != "" {
+			contentType = reader.Attrs.ContentType
+		}
+	}
+	if data, err = joinLines(data, lines); err != nil {
+		return nil, err
+	}
+
+	w := object.If(condition).NewWriter(ctx)
+	w.ContentType = contentType
+	w.CacheControl = opts.Destination.CacheControl
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return nil, err
+	}
+	err = w.Close()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed {
+		return nil, errAppendRace
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &UploadResult{SaveDataOutput: drivers.SaveDataOutput{URL: fmt.Sprintf("https://storage.googleapis.com/%s/%s", object.BucketName(), object.ObjectName())}}, nil
+}
+
+// appendLinesFile appends to a local file in a single write, which other writers appending to it don't interleave with
+func appendLinesFile(fileName string, lines []byte) (*UploadResult, error) {
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	// start on a new line if the file doesn't end with one
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			lines = append([]byte{'\n'}, lines...)
+		}
+	}
+	if _, err := file.Write(lines); err != nil {
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	return &UploadResult{SaveDataOutput: drivers.SaveDataOutput{URL: fileName}}, nil
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendLines(t *testing.T) {
+	opts := UploadOptions{AppendLines: true}
+	u := mustParseURL("memory-s3://appendlines/sessions/123/events.log")
+	_, err := Upload(strings.NewReader("started"), u, opts)
+	require.NoError(t, err)
+	_, err = Upload(strings.NewReader("segment 0\nsegment 1\n"), u, opts)
+	require.NoError(t, err)
+	obj, ok := memoryS3.server.Object("appendlines", "sessions/123/events.log")
+	require.True(t, ok)
+	require.Equal(t, "started\nsegment 0\nsegment 1\n", string(obj.Data))
+
+	// an empty input doesn't write anything
+	out, err := Upload(strings.NewReader(""), u, opts)
+	require.NoError(t, err)
+	require.True(t, out.Skipped)
+
+	fileName := filepath.Join(t.TempDir(), "events.log")
+	fileURI := mustParseURL(filepath.ToSlash(fileName))
+	for _, line := range []string{"started", "stopped\n"} {
+		_, err = Upload(strings.NewReader(line), fileURI, opts)
+		require.NoError(t, err)
+	}
+	data, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	require.Equal(t, "started\nstopped\n", string(data))
+}
+
+func TestAppendLinesConcurrent(t *testing.T) {
+	opts := UploadOptions{AppendLines: true}
+	u := mustParseURL("memory-s3://appendlines/sessions/456/events.log")
+	var wg sync.WaitGroup
+	var expected []string
+	for i := 0; i < 4; i++ {
+		line := fmt.Sprintf("writer %d", i)
+		expected = append(expected, line)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := Upload(strings.NewReader(line+"\n"), u, opts)
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// no writer overwrote the line of another
+	obj, ok := memoryS3.server.Object("appendlines", "sessions/456/events.log")
+	require.True(t, ok)
+	lines := strings.Split(strings.TrimSpace(string(obj.Data)), "\n")
+	sort.Strings(lines)
+	require.Equal(t, expected, lines)
+}
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// Classes of the errors of failed upload attempts, see UploadAttempt
+const (
+	AttemptUnreachable   = "unreachable"
+	AttemptTimeout       = "timeout"
+	AttemptClientError   = "client_error"
+	AttemptServerError   = "server_error"
+	AttemptInjectedFault = "injected_fault"
+	AttemptOther         = "other"
+)
+
+// UploadAttempt is a failed attempt at writing to the primary or the backup destination of an upload
+type UploadAttempt struct {
+	Time time.Time `json:"time"`
+	URI  string    `json:"uri"`
+	// Class sorts the error into one of the Attempt classes, e.g. AttemptTimeout
+	Class string `json:"class"`
+	Error string `json:"error"`
+	// BytesSent is how much of the input had been read for sending when the attempt failed
+	BytesSent int64 `json:"bytes_sent"`
+}
+
+// UploadError is the error of an upload that failed for good, with the history of its attempts across retries
+// and fallbacks, oldest first
+type UploadError struct {
+	Err      error
+	Attempts []UploadAttempt
+}
+
+func (e *UploadError) Error() string { return e.Err.Error() }
+func (e *UploadError) Unwrap() error { return e.Err }
+
+// UploadAttempts returns the history of the failed attempts of an upload from its error, if it has one
+func UploadAttempts(err error) []UploadAttempt {
+	var uploadErr *UploadError
+	if errors.As(err, &uploadErr) {
+		return uploadErr.Attempts
+	}
+	return nil
+}
+
+// attemptHistory collects the failed attempts of an upload, which may run concurrently when hedged
+type attemptHistory struct {
+	mu       sync.Mutex
+	attempts []UploadAttempt
+}
+
+func (h *attemptHistory) add(u *url.URL, err error, bytesSent int64) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.attempts = append(h.attempts, UploadAttempt{Time: time.Now().UTC(), URI: RedactedURI(u), Class: attemptClass(err), Error: err.Error(), BytesSent: bytesSent})
+}
+
+// wrap adds the history to the error of an upload that failed
+func (h *attemptHistory) wrap(err error) error {
+	if h == nil || err == nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return &UploadError{Err: err, Attempts: append([]UploadAttempt(nil), h.attempts...)}
+}
+
+func attemptClass(err error) string {
+	var netErr net.Error
+	var reqErr awserr.RequestFailure
+	switch {
+	case isUnreachable(err):
+		return AttemptUnreachable
+	case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout():
+		return AttemptTimeout
+	case errors.Is(err, ErrInjectedFault):
+		return AttemptInjectedFault
+	case errors.As(err, &reqErr) && reqErr.StatusCode() >= 500:
+		return AttemptServerError
+	case errors.As(err, &reqErr) && reqErr.StatusCode() >= 400:
+		return AttemptClientError
+	}
+	return AttemptOther
+}
@@ -0,0 +1,47 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadAttempts(t *testing.T) {
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "1.ts")
+	require.NoError(t, os.WriteFile(testFile, []byte("segment"), 0644))
+	// files where the directories of both destinations should be, so that uploads to them fail
+	for _, name := range []string{"primary", "backup"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+	primary := "file://" + filepath.ToSlash(dir) + "/primary/"
+	backup := "file://" + filepath.ToSlash(dir) + "/backup/"
+
+	_, _, err := uploadFileWithBackup(mustParseURL(primary+"1.ts"), testFile, nil, time.Second, false, UploadOptions{StorageFallbackURLs: map[string]string{primary: backup}})
+	require.Error(t, err)
+	attempts := UploadAttempts(fmt.Errorf("failed to upload video: %w", err))
+	require.Len(t, attempts, 2)
+	require.Equal(t, primary+"1.ts", attempts[0].URI)
+	require.Equal(t, backup+"1.ts", attempts[1].URI)
+	for _, attempt := range attempts {
+		require.Equal(t, AttemptOther, attempt.Class)
+		require.NotEmpty(t, attempt.Error)
+		require.False(t, attempt.Time.IsZero())
+	}
+
+	require.Nil(t, UploadAttempts(ErrEmptyInput))
+}
+
+func TestAttemptClass(t *testing.T) {
+	require.Equal(t, AttemptTimeout, attemptClass(fmt.Errorf("save: %w", context.DeadlineExceeded)))
+	require.Equal(t, AttemptInjectedFault, attemptClass(fmt.Errorf("SaveData: %w", ErrInjectedFault)))
+	require.Equal(t, AttemptServerError, attemptClass(awserr.NewRequestFailure(awserr.New("SlowDown", "reduce your request rate", nil), 503, "req")))
+	require.Equal(t, AttemptClientError, attemptClass(awserr.NewRequestFailure(awserr.New("AccessDenied", "access denied", nil), 403, "req")))
+	require.Equal(t, AttemptOther, attemptClass(os.ErrPermission))
+}
@@ -0,0 +1,33 @@
+package core
+
+import (
+	"net/url"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// BatchUpload is one of the files uploaded by UploadBatch
+type BatchUpload struct {
+	FileName string
+	URI      *url.URL
+	// Result and Err are set by UploadBatch
+	Result *UploadResult
+	Err    error
+}
+
+// UploadBatch uploads each file to its own destination, running up to concurrency uploads at a time.
+// A failed upload doesn't stop the others, its error is recorded in the BatchUpload. Uploads only start within
+// the UploadOptions.ScheduleWindow.
+func UploadBatch(uploads []*BatchUpload, concurrency int, opts UploadOptions) {
+	errGroup := &errgroup.Group{}
+	errGroup.SetLimit(max(concurrency, 1))
+	for _, upload := range uploads {
+		upload := upload
+		errGroup.Go(func() error {
+			opts.ScheduleWindow.wait()
+			upload.Result, upload.Err = UploadFiles([]string{upload.FileName}, upload.URI, opts)
+			return nil
+		})
+	}
+	_ = errGroup.Wait()
+}
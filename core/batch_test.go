@@ -0,0 +1,33 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadBatch(t *testing.T) {
+	dir := t.TempDir()
+	var uploads []*BatchUpload
+	for i := 0; i < 5; i++ {
+		fileName := filepath.Join(dir, fmt.Sprintf("%d.m3u8", i))
+		require.NoError(t, os.WriteFile(fileName, []byte(fmt.Sprintf("rendition %d", i)), 0644))
+		uploads = append(uploads, &BatchUpload{FileName: fileName, URI: mustParseURL(filepath.Join(dir, "out", filepath.Base(fileName)))})
+	}
+	uploads = append(uploads, &BatchUpload{FileName: filepath.Join(dir, "missing.m3u8"), URI: mustParseURL(filepath.Join(dir, "out", "missing.m3u8"))})
+
+	UploadBatch(uploads, 2, UploadOptions{WriteTimeout: time.Second})
+	for i, upload := range uploads[:5] {
+		require.NoError(t, upload.Err)
+		require.NotNil(t, upload.Result)
+		data, err := os.ReadFile(upload.URI.Path)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("rendition %d", i), string(data))
+	}
+	require.ErrorContains(t, uploads[5].Err, "failed to open input file")
+	require.Nil(t, uploads[5].Result)
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/uuid"
+	"golang.org/x/sync/errgroup"
+)
+
+type BenchOptions struct {
+	// Sizes of the synthetic payloads to upload
+	Sizes []int64
+	// Count is the number of uploads per payload size
+	Count       int
+	Concurrency int
+	// Timeout applies to each upload
+	Timeout time.Duration
+	// Keep leaves the uploaded objects in place instead of deleting them afterwards
+	Keep bool
+}
+
+type BenchResult struct {
+	URI            string  `json:"uri"`
+	Size           int64   `json:"size"`
+	Uploads        int     `json:"uploads"`
+	Errors         int     `json:"errors"`
+	ErrorRate      float64 `json:"error_rate"`
+	ThroughputMbps float64 `json:"throughput_mbps"`
+	LatencyP50Ms   int64   `json:"latency_p50_ms"`
+	LatencyP90Ms   int64   `json:"latency_p90_ms"`
+	LatencyP99Ms   int64   `json:"latency_p99_ms"`
+	LatencyMaxMs   int64   `json:"latency_max_ms"`
+}
+
+// Bench uploads random payloads of each size under a unique prefix of baseURI and reports throughput,
+// latency percentiles and error rates. Throughput is the aggregate over all concurrent uploads.
+func Bench(baseURI *url.URL, opts BenchOptions) ([]BenchResult, error) {
+	prefix := baseURI.JoinPath("catalyst-uploader-bench-" + uuid.New().String())
+	var results []BenchResult
+	for _, size := range opts.Sizes {
+		res, err := benchSize(prefix, size, opts)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func benchSize(prefix *url.URL, size int64, opts BenchOptions) (BenchResult, error) {
+	payload, err := os.CreateTemp("", "bench-*.bin")
+	if err != nil {
+		return BenchResult{}, fmt.Errorf("failed to create payload file: %w", err)
+	}
+	defer os.Remove(payload.Name())
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		return BenchResult{}, err
+	}
+	if _, err := payload.Write(data); err != nil {
+		return BenchResult{}, fmt.Errorf("failed to write payload file: %w", err)
+	}
+	if err := payload.Close(); err != nil {
+		return BenchResult{}, err
+	}
+
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		errCount  int
+	)
+	errGroup := &errgroup.Group{}
+	errGroup.SetLimit(max(opts.Concurrency, 1))
+	start := time.Now()
+	for i := 0; i < opts.Count; i++ {
+		uri := prefix.JoinPath(fmt.Sprintf("%d-%d.bin", size, i))
+		errGroup.Go(func() error {
+			uploadStart := time.Now()
+			_, _, err := uploadFile(uri, payload.Name(), nil, opts.Timeout, false, UploadOptions{})
+			latency := time.Since(uploadStart)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errCount++
+				return nil
+			}
+			latencies = append(latencies, latency)
+			if !opts.Keep {
+				if err := deleteObject(context.Background(), uri, UploadOptions{}); err != nil {
+					glog.Warningf("Failed to delete benchmark payload %s: %v", uri.Redacted(), err)
+				}
+			}
+			return nil
+		})
+	}
+	_ = errGroup.Wait()
+	elapsed := time.Since(start)
+
+	res := BenchResult{
+		URI:     RedactedURI(prefix),
+		Size:    size,
+		Uploads: opts.Count,
+		Errors:  errCount,
+	}
+	if opts.Count > 0 {
+		res.ErrorRate = float64(errCount) / float64(opts.Count)
+	}
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		res.ThroughputMbps = float64(size*int64(len(latencies))*8) / elapsed.Seconds() / 1e6
+		res.LatencyP50Ms = percentile(latencies, 50).Milliseconds()
+		res.LatencyP90Ms = percentile(latencies, 90).Milliseconds()
+		res.LatencyP99Ms = percentile(latencies, 99).Milliseconds()
+		res.LatencyMaxMs = latencies[len(latencies)-1].Milliseconds()
+	}
+	return res, nil
+}
+
+// percentile uses the nearest-rank method on sorted values
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBench(t *testing.T) {
+	dir := t.TempDir()
+	results, err := Bench(mustParseURL(filepath.ToSlash(dir)), BenchOptions{
+		Sizes:       []int64{1024, 64 * 1024},
+		Count:       5,
+		Concurrency: 2,
+		Timeout:     time.Second,
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	for i, size := range []int64{1024, 64 * 1024} {
+		res := results[i]
+		require.Equal(t, size, res.Size)
+		require.Equal(t, 5, res.Uploads)
+		require.Zero(t, res.Errors)
+		require.Zero(t, res.ErrorRate)
+		require.Greater(t, res.ThroughputMbps, 0.0)
+		require.LessOrEqual(t, res.LatencyP50Ms, res.LatencyP90Ms)
+		require.LessOrEqual(t, res.LatencyP99Ms, res.LatencyMaxMs)
+	}
+
+	// the payloads are cleaned up unless asked to keep them
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	files, err := os.ReadDir(filepath.Join(dir, entries[0].Name()))
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestBenchKeep(t *testing.T) {
+	results, err := Bench(mustParseURL("memory-s3://bench-keep/bench"), BenchOptions{
+		Sizes:   []int64{1024},
+		Count:   4,
+		Timeout: time.Second,
+		Keep:    true,
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Zero(t, results[0].Errors)
+	require.Len(t, memoryS3.server.Keys("bench-keep"), 4)
+}
+
+func TestPercentile(t *testing.T) {
+	var values []time.Duration
+	for i := 1; i <= 100; i++ {
+		values = append(values, time.Duration(i)*time.Millisecond)
+	}
+	require.Equal(t, 50*time.Millisecond, percentile(values, 50))
+	require.Equal(t, 99*time.Millisecond, percentile(values, 99))
+	require.Equal(t, time.Millisecond, percentile(values[:1], 50))
+	require.Equal(t, 2*time.Millisecond, percentile(values[:2], 90))
+}
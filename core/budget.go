@@ -0,0 +1,107 @@
+package core
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/golang/glog"
+)
+
+// RetryBudget limits the retries of all uploads to the same destination, so that a broken bucket doesn't
+// keep hundreds of concurrent uploads in their own retry loops. Each destination starts with the given number of
+// retries, which refill over the window. Once they're used up, failed uploads to that destination fail
+// straight away and fall back to the backup storage.
+type RetryBudget struct {
+	retries int
+	window  time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*retryBucket
+	now     func() time.Time
+	// exhausted records the destinations whose budget ran out, so that it's only logged once until it refills
+	exhausted map[string]bool
+}
+
+type retryBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRetryBudget allows retries retries per destination within window. A nil budget allows any number.
+func NewRetryBudget(retries int, window time.Duration) *RetryBudget {
+	return &RetryBudget{
+		retries:   retries,
+		window:    window,
+		buckets:   map[string]*retryBucket{},
+		now:       time.Now,
+		exhausted: map[string]bool{},
+	}
+}
+
+// take uses up one retry of the destination of u, returning false if there's none left
+func (b *RetryBudget) take(u *url.URL) bool {
+	if b == nil {
+		return true
+	}
+	key := retryBudgetKey(u)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	bucket, ok := b.buckets[key]
+	if !ok {
+		bucket = &retryBucket{tokens: float64(b.retries), last: now}
+		b.buckets[key] = bucket
+	}
+	if b.window > 0 {
+		bucket.tokens += now.Sub(bucket.last).Seconds() / b.window.Seconds() * float64(b.retries)
+		bucket.tokens = min(bucket.tokens, float64(b.retries))
+	}
+	bucket.last = now
+	if bucket.tokens < 1 {
+		if !b.exhausted[key] {
+			glog.Warningf("Retry budget for %s exhausted, failing uploads to it without retrying", key)
+			b.exhausted[key] = true
+		}
+		return false
+	}
+	b.exhausted[key] = false
+	bucket.tokens--
+	return true
+}
+
+// retryBudgetKey is the destination that uploads to u share a budget with: the storage host and, for S3 URLs
+// where the host is the region or endpoint, the bucket
+func retryBudgetKey(u *url.URL) string {
+	key := u.Scheme + "://" + u.Host
+	if isS3URL(u) || isSpacesURL(u) {
+		bucket, _, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+		key += "/" + bucket
+	}
+	return key
+}
+
+// budgetBackOff stops retrying once the budget of the destination is used up
+type budgetBackOff struct {
+	backoff.BackOff
+	budget *RetryBudget
+	u      *url.URL
+}
+
+func (b *budgetBackOff) NextBackOff() time.Duration {
+	next := b.BackOff.NextBackOff()
+	if next == backoff.Stop || !b.budget.take(b.u) {
+		return backoff.Stop
+	}
+	return next
+}
+
+// withRetryBudget limits the retries of policy for uploads to u by budget
+func withRetryBudget(policy backoff.BackOff, budget *RetryBudget, u *url.URL) backoff.BackOff {
+	if budget == nil {
+		return policy
+	}
+	return &budgetBackOff{BackOff: policy, budget: budget, u: u}
+}
@@ -0,0 +1,52 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryBudget(t *testing.T) {
+	now := time.Now()
+	budget := NewRetryBudget(2, time.Minute)
+	budget.now = func() time.Time { return now }
+
+	broken := mustParseURL("s3://key:secret@eu-west-1/broken/hls/0.ts")
+	require.True(t, budget.take(broken))
+	require.True(t, budget.take(mustParseURL("s3://key:secret@eu-west-1/broken/hls/1.ts")))
+	require.False(t, budget.take(broken))
+	// other buckets have their own budget
+	require.True(t, budget.take(mustParseURL("s3://key:secret@eu-west-1/working/hls/0.ts")))
+
+	now = now.Add(30 * time.Second)
+	require.True(t, budget.take(broken))
+	require.False(t, budget.take(broken))
+
+	var nilBudget *RetryBudget
+	require.True(t, nilBudget.take(broken))
+}
+
+func TestWithRetryBudget(t *testing.T) {
+	budget := NewRetryBudget(3, time.Hour)
+	u := mustParseURL("gs://bucket/hls/0.ts")
+	attempts := 0
+	for i := 0; i < 2; i++ {
+		_ = backoff.Retry(func() error {
+			attempts++
+			return errors.New("broken bucket")
+		}, withRetryBudget(&backoff.ZeroBackOff{}, budget, u))
+	}
+	// two uploads share 3 retries
+	require.Equal(t, 5, attempts)
+
+	policy := &backoff.ZeroBackOff{}
+	require.Same(t, policy, withRetryBudget(policy, nil, u))
+}
+
+func TestRetryBudgetKey(t *testing.T) {
+	require.Equal(t, "s3://eu-west-1/bucket", retryBudgetKey(mustParseURL("s3://key:secret@eu-west-1/bucket/hls/0.ts")))
+	require.Equal(t, "gs://bucket", retryBudgetKey(mustParseURL("gs://bucket/hls/0.ts")))
+}
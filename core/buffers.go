@@ -0,0 +1,32 @@
+package core
+
+import (
+	"io"
+	"sync"
+)
+
+// copyBufferSize is the size of the buffers copies of uploaded data go through
+const copyBufferSize = 128 * 1024
+
+// copyBuffers are reused across copies, so that many concurrent uploads don't each allocate their own buffers
+var copyBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, copyBufferSize)
+		return &buf
+	},
+}
+
+func getCopyBuffer() *[]byte {
+	return copyBuffers.Get().(*[]byte)
+}
+
+func putCopyBuffer(buf *[]byte) {
+	copyBuffers.Put(buf)
+}
+
+// copyBuffered is io.Copy with a buffer from copyBuffers
+func copyBuffered(dst io.Writer, src io.Reader) (int64, error) {
+	buf := getCopyBuffer()
+	defer putCopyBuffer(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
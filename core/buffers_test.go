@@ -0,0 +1,24 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyBuffered(t *testing.T) {
+	data := strings.Repeat("segment data ", copyBufferSize/4)
+	var out bytes.Buffer
+	// a writer without ReadFrom, so that the copy goes through the pooled buffer
+	n, err := copyBuffered(struct{ io.Writer }{&out}, strings.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), n)
+	require.Equal(t, data, out.String())
+
+	buf := getCopyBuffer()
+	require.Len(t, *buf, copyBufferSize)
+	putCopyBuffer(buf)
+}
@@ -0,0 +1,109 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// checkPayloadSize is the size of the object written by Check
+const checkPayloadSize = 1024
+
+type CheckStep struct {
+	Step      string `json:"step"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Check verifies that the storage at uri is usable: that the URL parses, that the storage driver accepts its
+// credentials, and that a small object can be written under it, read back and deleted. The steps run in that
+// order and stop at the first failure, except that a written object is always deleted. The returned error is
+// the first failure.
+func Check(uri string, timeout time.Duration, opts UploadOptions) ([]CheckStep, error) {
+	var steps []CheckStep
+	var firstErr error
+	step := func(name string, fn func(ctx context.Context) error) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		start := time.Now()
+		err := fn(ctx)
+		res := CheckStep{Step: name, LatencyMs: time.Since(start).Milliseconds()}
+		if err != nil {
+			res.Error = err.Error()
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s failed: %w", name, err)
+			}
+		}
+		steps = append(steps, res)
+		return err
+	}
+
+	var objectURI *url.URL
+	if err := step("parse", func(ctx context.Context) error {
+		u, err := ParseOutputURI(uri)
+		if err != nil {
+			return err
+		}
+		objectURI = u.JoinPath("catalyst-uploader-check-" + uuid.New().String() + ".bin")
+		return nil
+	}); err != nil {
+		return steps, firstErr
+	}
+	if err := step("credentials", func(ctx context.Context) error {
+		_, err := newSession(objectURI, opts)
+		return err
+	}); err != nil {
+		return steps, firstErr
+	}
+
+	payload := make([]byte, checkPayloadSize)
+	if _, err := rand.Read(payload); err != nil {
+		return steps, err
+	}
+	payloadFile, err := os.CreateTemp("", "check-*.bin")
+	if err != nil {
+		return steps, fmt.Errorf("failed to create payload file: %w", err)
+	}
+	defer os.Remove(payloadFile.Name())
+	_, err = payloadFile.Write(payload)
+	if closeErr := payloadFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return steps, fmt.Errorf("failed to write payload file: %w", err)
+	}
+
+	if err := step("write", func(ctx context.Context) error {
+		_, _, err := uploadFile(objectURI, payloadFile.Name(), nil, timeout, false, opts)
+		return err
+	}); err != nil {
+		return steps, firstErr
+	}
+	_ = step("read", func(ctx context.Context) error {
+		reader, err := ReadRange(ctx, objectURI, 0, -1, opts)
+		if err != nil {
+			return err
+		}
+		defer reader.Body.Close()
+		data, err := io.ReadAll(reader.Body)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(data, payload) {
+			return errors.New("read data doesn't match the written data")
+		}
+		return nil
+	})
+	_ = step("delete", func(ctx context.Context) error {
+		return deleteObject(ctx, objectURI, opts)
+	})
+	return steps, firstErr
+}
@@ -0,0 +1,47 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	for _, uri := range []string{filepath.ToSlash(dir), "memory-s3://check/prefix"} {
+		steps, err := Check(uri, time.Second, UploadOptions{})
+		require.NoError(t, err)
+		var names []string
+		for _, step := range steps {
+			require.Empty(t, step.Error)
+			names = append(names, step.Step)
+		}
+		require.Equal(t, []string{"parse", "credentials", "write", "read", "delete"}, names)
+	}
+
+	// the check object is cleaned up
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	require.Empty(t, memoryS3.server.Keys("check"))
+}
+
+func TestCheckFailures(t *testing.T) {
+	steps, err := Check("s3://KEY@eu-west-1/bucket/prefix", time.Second, UploadOptions{})
+	require.ErrorContains(t, err, "credentials failed")
+	require.Len(t, steps, 2)
+	require.NotEmpty(t, steps[1].Error)
+
+	// a bad read still cleans up the check object
+	faults, err := ParseFaultProfile("truncate=1")
+	require.NoError(t, err)
+	steps, err = Check("memory-s3://check-truncated/prefix", time.Second, UploadOptions{FaultInjection: faults})
+	require.ErrorContains(t, err, "read failed")
+	require.Len(t, steps, 5)
+	require.Equal(t, "delete", steps[4].Step)
+	require.Empty(t, steps[4].Error)
+	require.Empty(t, memoryS3.server.Keys("check-truncated"))
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/golang/glog"
+)
+
+// cleanupTimeout bounds the cleanup of each failed upload
+const cleanupTimeout = 30 * time.Second
+
+// multipartUploads collects the multipart uploads that the failed attempts of a segment upload started and
+// couldn't abort, since their parts are kept (and billed) until they are. Uploads started by other processes
+// and the objects at the destinations are never touched: S3 and GCS writes are atomic, and files are written
+// under a temporary name, so a failed attempt leaves the previous object in place.
+type multipartUploads struct {
+	mu      sync.Mutex
+	uploads []multipartUpload
+}
+
+type multipartUpload struct {
+	svc      *s3.S3
+	bucket   string
+	key      string
+	uploadID string
+}
+
+// add records an upload left behind. A nil multipartUploads doesn't record anything.
+func (m *multipartUploads) add(svc *s3.S3, bucket, key, uploadID string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.uploads = append(m.uploads, multipartUpload{svc: svc, bucket: bucket, key: key, uploadID: uploadID})
+}
+
+func (m *multipartUploads) list() []multipartUpload {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]multipartUpload(nil), m.uploads...)
+}
+
+// cleanupFailedUpload aborts the multipart uploads that the attempts of a segment upload that failed for good left
+// behind. It returns the cleanup status that is reported with the upload error.
+func cleanupFailedUpload(uploads *multipartUploads) string {
+	left := uploads.list()
+	if len(left) == 0 {
+		return "no partial upload left"
+	}
+	var errs []error
+	for _, upload := range left {
+		ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		_, err := upload.svc.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(upload.bucket),
+			Key:      aws.String(upload.key),
+			UploadId: aws.String(upload.uploadID),
+		})
+		cancel()
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchUpload {
+			err = nil
+		}
+		if err != nil {
+			glog.Errorf("failed to abort multipart upload %s of %s: %v", upload.uploadID, upload.key, err)
+			errs = append(errs, fmt.Errorf("%s: %w", upload.key, err))
+		} else {
+			glog.Infof("Aborted multipart upload %s of %s", upload.uploadID, upload.key)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return "partial upload cleanup failed: " + err.Error()
+	}
+	return "partial upload cleaned up"
+}
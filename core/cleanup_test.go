@@ -0,0 +1,69 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/livepeer/catalyst-uploader/fakes3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanupFailedUpload(t *testing.T) {
+	srv := fakes3.New()
+	defer srv.Close()
+	dest, err := parseS3URL(mustParseURL(srv.URL("bucket", "hls/0.ts")))
+	require.NoError(t, err)
+	sess, err := dest.newSession()
+	require.NoError(t, err)
+	svc := s3.New(sess)
+	var uploadIDs []string
+	for i := 0; i < 2; i++ {
+		upload, err := svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{Bucket: aws.String("bucket"), Key: aws.String("hls/0.ts")})
+		require.NoError(t, err)
+		uploadIDs = append(uploadIDs, aws.StringValue(upload.UploadId))
+	}
+	_, err = svc.PutObject(&s3.PutObjectInput{Bucket: aws.String("bucket"), Key: aws.String("hls/0.ts")})
+	require.NoError(t, err)
+
+	require.Equal(t, "no partial upload left", cleanupFailedUpload(&multipartUploads{}))
+
+	// only the upload the failed attempts left behind is aborted, the object and other uploads are kept
+	left := &multipartUploads{}
+	left.add(svc, "bucket", "hls/0.ts", uploadIDs[0])
+	require.Equal(t, "partial upload cleaned up", cleanupFailedUpload(left))
+	_, ok := srv.Object("bucket", "hls/0.ts")
+	require.True(t, ok)
+	require.Len(t, srv.Uploads("bucket"), 1)
+}
+
+func TestCleanupFailedSegment(t *testing.T) {
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "input.ts")
+	require.NoError(t, os.WriteFile(testFile, []byte("test data"), 0644))
+	output := filepath.Join(dir, "out", "0.ts")
+	require.NoError(t, os.MkdirAll(filepath.Dir(output), 0755))
+	require.NoError(t, os.WriteFile(output, []byte("good"), 0644))
+	backup := filepath.Join(dir, "backup")
+	require.NoError(t, os.MkdirAll(backup, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(backup, "0.ts"), []byte("good backup"), 0644))
+
+	opts := UploadOptions{
+		FaultInjection:      &FaultProfile{ErrorRate: 1},
+		RetryBudget:         NewRetryBudget(0, time.Minute),
+		StorageFallbackURLs: map[string]string{filepath.ToSlash(filepath.Join(dir, "out")): filepath.ToSlash(backup)},
+	}
+	_, err := uploadSegment(mustParseURL(filepath.ToSlash(output)), testFile, opts)
+	require.ErrorIs(t, err, ErrInjectedFault)
+	require.ErrorContains(t, err, "no partial upload left")
+	// the failed re-upload leaves the previous segments in place
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+	require.Equal(t, "good", string(data))
+	data, err = os.ReadFile(filepath.Join(backup, "0.ts"))
+	require.NoError(t, err)
+	require.Equal(t, "good backup", string(data))
+}
@@ -0,0 +1,45 @@
+package core
+
+import "github.com/livepeer/go-tools/drivers"
+
+// Object metadata holding the CorrelationIDs of the upload that wrote it
+const (
+	sessionIDMetadataKey  = "Session-Id"
+	playbackIDMetadataKey = "Playback-Id"
+)
+
+// CorrelationIDs identify the stream session uploads belong to, so that the logs, events and objects of the
+// Catalyst components handling a stream can be joined on them. They are added to the metadata of uploaded objects,
+// to done markers and timed metadata events, and to the log lines of failed attempts.
+type CorrelationIDs struct {
+	SessionID  string `json:"session_id,omitempty"`
+	PlaybackID string `json:"playback_id,omitempty"`
+}
+
+// LogFields formats the IDs that are set to be appended to log lines, e.g. " sessionID=abc playbackID=def", or
+// returns an empty string if none is
+func (c CorrelationIDs) LogFields() string {
+	var fields string
+	if c.SessionID != "" {
+		fields += " sessionID=" + c.SessionID
+	}
+	if c.PlaybackID != "" {
+		fields += " playbackID=" + c.PlaybackID
+	}
+	return fields
+}
+
+// withCorrelationIDs adds the IDs that are set to the metadata of an upload
+func withCorrelationIDs(fields *drivers.FileProperties, ids CorrelationIDs) *drivers.FileProperties {
+	if ids.SessionID == "" && ids.PlaybackID == "" {
+		return fields
+	}
+	idFields := copyFileProperties(fields)
+	if ids.SessionID != "" {
+		idFields.Metadata[sessionIDMetadataKey] = ids.SessionID
+	}
+	if ids.PlaybackID != "" {
+		idFields.Metadata[playbackIDMetadataKey] = ids.PlaybackID
+	}
+	return idFields
+}
@@ -0,0 +1,33 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelationIDs(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "1.ts")
+	require.NoError(t, os.WriteFile(input, []byte("segment"), 0644))
+	ids := CorrelationIDs{SessionID: "session-1", PlaybackID: "abcd1234"}
+	_, err := UploadFiles([]string{input}, mustParseURL("memory-s3://correlation/hls/abcd1234/1.ts"), UploadOptions{CorrelationIDs: ids, DoneMarker: true, SegmentTimeout: time.Second})
+	require.NoError(t, err)
+
+	obj, ok := memoryS3.server.Object("correlation", "hls/abcd1234/1.ts")
+	require.True(t, ok)
+	require.Equal(t, "session-1", obj.Metadata[sessionIDMetadataKey])
+	require.Equal(t, "abcd1234", obj.Metadata[playbackIDMetadataKey])
+	done, ok := memoryS3.server.Object("correlation", "hls/abcd1234/1.ts.done")
+	require.True(t, ok)
+	var marker doneMarker
+	require.NoError(t, json.Unmarshal(done.Data, &marker))
+	require.Equal(t, ids, marker.CorrelationIDs)
+
+	require.Equal(t, " sessionID=session-1 playbackID=abcd1234", ids.LogFields())
+	require.Equal(t, " playbackID=abcd1234", CorrelationIDs{PlaybackID: "abcd1234"}.LogFields())
+	require.Empty(t, CorrelationIDs{}.LogFields())
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// CredentialSet holds the credentials of one tenant's destinations, which start with one of its Prefixes
+// without scheme and credentials, e.g. eu-west-1/tenant-a-recordings/
+type CredentialSet struct {
+	Tenant   string   `json:"tenant"`
+	Prefixes []string `json:"prefixes"`
+	// User and Password are the credentials part of the destination, e.g. the S3 access key and secret, or the
+	// JSON key of a GCS service account as the user and no password
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// CredentialMap maps destination prefixes and tenants to credentials, so that one uploader deployment can write
+// into the buckets of many tenants without their credentials being passed around in destinations
+type CredentialMap []CredentialSet
+
+// LoadCredentialMap reads a JSON array of CredentialSet from a file
+func LoadCredentialMap(fileName string) (CredentialMap, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var credentials CredentialMap
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		return nil, fmt.Errorf("invalid credential map in %s: %w", fileName, err)
+	}
+	for _, set := range credentials {
+		if len(set.Prefixes) == 0 || set.User == "" {
+			return nil, fmt.Errorf("invalid credential map in %s: the set of tenant %q needs prefixes and a user", fileName, set.Tenant)
+		}
+	}
+	return credentials, nil
+}
+
+// WithCredentials returns a destination, which may be a template, with the credentials of the set whose prefix
+// it starts with, the longest if several do. With a tenant, only that tenant's sets are considered and a
+// destination outside of its prefixes is an error, so that a tenant's uploads can't be written with another
+// tenant's credentials or into another tenant's bucket. Destinations that have credentials keep them, and
+// those matching no set are returned as they are.
+func (m CredentialMap) WithCredentials(destination, tenant string) (string, error) {
+	scheme, rest, ok := strings.Cut(destination, "://")
+	if !ok {
+		// local files have no credentials
+		return destination, nil
+	}
+	authority, _, _ := strings.Cut(rest, "/")
+	at := strings.LastIndex(authority, "@")
+	location := rest[at+1:]
+
+	var match *CredentialSet
+	matchLen := -1
+	tenantKnown := false
+	for i, set := range m {
+		if tenant != "" && set.Tenant != tenant {
+			continue
+		}
+		tenantKnown = true
+		for _, prefix := range set.Prefixes {
+			if strings.HasPrefix(location, prefix) && len(prefix) > matchLen {
+				match, matchLen = &m[i], len(prefix)
+			}
+		}
+	}
+	switch {
+	case tenant != "" && !tenantKnown:
+		return "", fmt.Errorf("no credentials for tenant %q", tenant)
+	case tenant != "" && match == nil:
+		return "", fmt.Errorf("destination %s is outside of the prefixes of tenant %q", location, tenant)
+	case match == nil || at >= 0:
+		return destination, nil
+	}
+	userinfo := escapeUserinfo(match.User)
+	if match.Password != "" {
+		userinfo += ":" + escapeUserinfo(match.Password)
+	}
+	return scheme + "://" + userinfo + "@" + location, nil
+}
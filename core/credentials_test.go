@@ -0,0 +1,49 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentialMap(t *testing.T) {
+	credentials := CredentialMap{
+		{Tenant: "a", Prefixes: []string{"eu-west-1/tenant-a/"}, User: "KEY_A", Password: "SECRET/A"},
+		{Tenant: "b", Prefixes: []string{"eu-west-1/tenant-b/", "tenant-b-gcs/"}, User: "KEY_B", Password: "SECRET_B"},
+		{Tenant: "b", Prefixes: []string{"eu-west-1/tenant-b/vip/"}, User: "KEY_VIP", Password: "SECRET_VIP"},
+	}
+
+	out, err := credentials.WithCredentials("s3://eu-west-1/tenant-a/rec/{basename}", "")
+	require.NoError(t, err)
+	require.Equal(t, "s3://KEY_A:SECRET%2FA@eu-west-1/tenant-a/rec/{basename}", out)
+	out, err = credentials.WithCredentials("s3://eu-west-1/tenant-b/vip/index.m3u8", "b")
+	require.NoError(t, err)
+	require.Equal(t, "s3://KEY_VIP:SECRET_VIP@eu-west-1/tenant-b/vip/index.m3u8", out)
+	out, err = credentials.WithCredentials("s3://OWN:KEY@eu-west-1/tenant-a/index.m3u8", "a")
+	require.NoError(t, err)
+	require.Equal(t, "s3://OWN:KEY@eu-west-1/tenant-a/index.m3u8", out)
+	out, err = credentials.WithCredentials("s3://eu-west-1/other/index.m3u8", "")
+	require.NoError(t, err)
+	require.Equal(t, "s3://eu-west-1/other/index.m3u8", out)
+
+	_, err = credentials.WithCredentials("s3://eu-west-1/tenant-b/index.m3u8", "a")
+	require.ErrorContains(t, err, `outside of the prefixes of tenant "a"`)
+	_, err = credentials.WithCredentials("s3://OWN:KEY@eu-west-1/tenant-b/index.m3u8", "a")
+	require.Error(t, err)
+	_, err = credentials.WithCredentials("s3://eu-west-1/tenant-c/index.m3u8", "c")
+	require.ErrorContains(t, err, `no credentials for tenant "c"`)
+}
+
+func TestLoadCredentialMap(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "credentials.json")
+	require.NoError(t, os.WriteFile(fileName, []byte(`[{"tenant": "a", "prefixes": ["eu-west-1/tenant-a/"], "user": "KEY", "password": "SECRET"}]`), 0600))
+	credentials, err := LoadCredentialMap(fileName)
+	require.NoError(t, err)
+	require.Equal(t, CredentialMap{{Tenant: "a", Prefixes: []string{"eu-west-1/tenant-a/"}, User: "KEY", Password: "SECRET"}}, credentials)
+
+	require.NoError(t, os.WriteFile(fileName, []byte(`[{"tenant": "a", "user": "KEY"}]`), 0600))
+	_, err = LoadCredentialMap(fileName)
+	require.Error(t, err)
+}
@@ -0,0 +1,233 @@
+package core
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/golang/glog"
+	"github.com/livepeer/go-tools/drivers"
+)
+
+// ErrUploadMismatch is returned when an upload doesn't read back with the size and checksum of its input, see
+// DeletePolicy
+var ErrUploadMismatch = errors.New("uploaded object doesn't match its input")
+
+// DeletePolicy deletes the input files of UploadFiles once their upload is verified, so that local disks don't
+// fill up. Uploads are verified by the size and MD5 checksum the storage reports, or by reading them back if it
+// reports none, and a mismatch fails the upload with ErrUploadMismatch. Inputs are deleted right away, or once
+// KeepFor has passed, by a later Sweep, which needs the files to be unchanged since they were uploaded.
+type DeletePolicy struct {
+	KeepFor time.Duration
+	// StateDir keeps the inputs waiting for KeepFor to pass
+	StateDir string
+}
+
+// pendingDeletion is an input waiting for its grace period to pass, persisted in DeletePolicy.StateDir
+type pendingDeletion struct {
+	FileName   string    `json:"file_name"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mod_time"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// schedule deletes the verified inputs of an upload, or records them for Sweep if they are to be kept for a while
+func (p *DeletePolicy) schedule(fileNames []string) {
+	for _, fileName := range fileNames {
+		if p.KeepFor <= 0 {
+			if err := os.Remove(fileName); err != nil {
+				glog.Errorf("Failed to delete uploaded input %s: %v", fileName, err)
+				continue
+			}
+			glog.V(5).Infof("Deleted uploaded input %s", fileName)
+			continue
+		}
+		if err := p.record(fileName); err != nil {
+			glog.Errorf("Not deleting uploaded input %s: %v", fileName, err)
+		}
+	}
+}
+
+func (p *DeletePolicy) record(fileName string) error {
+	fileName, err := filepath.Abs(fileName)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(p.StateDir, 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	data, err := json.Marshal(pendingDeletion{FileName: fileName, Size: info.Size(), ModTime: info.ModTime(), UploadedAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(fileName))
+	entry := filepath.Join(p.StateDir, hex.EncodeToString(sum[:8])+".json")
+	// written to a temp file and renamed so that a concurrent Sweep never reads half an entry
+	if err := os.WriteFile(entry+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(entry+".tmp", entry)
+}
+
+// Sweep deletes the inputs whose grace period has passed. An entry is claimed by removing it, so that uploaders
+// sweeping at the same time don't race, and inputs that changed since their upload are left alone.
+func (p *DeletePolicy) Sweep() {
+	entries, err := filepath.Glob(filepath.Join(p.StateDir, "*.json"))
+	if err != nil {
+		glog.Errorf("Failed to list inputs to delete: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		data, err := os.ReadFile(entry)
+		if err != nil {
+			continue
+		}
+		var pending pendingDeletion
+		if err := json.Unmarshal(data, &pending); err != nil {
+			glog.Warningf("Removing invalid entry %s", entry)
+			_ = os.Remove(entry)
+			continue
+		}
+		if time.Since(pending.UploadedAt) < p.KeepFor || os.Remove(entry) != nil {
+			continue
+		}
+		info, err := os.Stat(pending.FileName)
+		if err != nil {
+			continue
+		}
+		if info.Size() != pending.Size || !info.ModTime().Equal(pending.ModTime) {
+			glog.Warningf("Not deleting %s, it changed since it was uploaded", pending.FileName)
+			continue
+		}
+		if err := os.Remove(pending.FileName); err != nil {
+			glog.Errorf("Failed to delete uploaded input %s: %v", pending.FileName, err)
+			continue
+		}
+		glog.V(5).Infof("Deleted %s, uploaded at %s", pending.FileName, pending.UploadedAt.Format(time.RFC3339))
+	}
+}
+
+// verifyUpload checks that the object at u has the size and MD5 checksum of fileName
+func verifyUpload(u *url.URL, fileName string, opts UploadOptions) error {
+	ctx, cancel := context.WithTimeout(context.Background(), max(opts.SegmentTimeout, defaultSaveTimeout))
+	defer cancel()
+	checksum, size, err := fileMD5(fileName)
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %w", fileName, err)
+	}
+	storedSize, storedChecksum, err := storedMD5(ctx, u)
+	if errors.Is(err, drivers.ErrNotSupported) {
+		storedSize, storedChecksum, err = readBackMD5(ctx, u, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to verify %s: %w", u.Redacted(), err)
+	}
+	if storedSize != size {
+		return fmt.Errorf("%w: %s has %d bytes instead of %d", ErrUploadMismatch, u.Redacted(), storedSize, size)
+	}
+	if storedChecksum == "" {
+		// multipart uploads aren't checksummed as a whole
+		storedSize, storedChecksum, err = readBackMD5(ctx, u, opts)
+		if err != nil {
+			return fmt.Errorf("failed to verify %s: %w", u.Redacted(), err)
+		}
+	}
+	if storedChecksum != checksum {
+		return fmt.Errorf("%w: MD5 of %s is %s instead of %s", ErrUploadMismatch, u.Redacted(), storedChecksum, checksum)
+	}
+	return nil
+}
+
+func fileMD5(fileName string) (string, int64, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+	hash := md5.New()
+	size, err := copyBuffered(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// storedMD5 returns the size and MD5 checksum of the object at u reported by the storage, without reading it.
+// The checksum is empty for objects without one, such as multipart uploads to S3.
+func storedMD5(ctx context.Context, u *url.URL) (int64, string, error) {
+	switch {
+	case u.Scheme == "memory-s3":
+		s3URL, err := url.Parse(memoryS3URL(u))
+		if err != nil {
+			return 0, "", err
+		}
+		return storedMD5(ctx, s3URL)
+	case isS3URL(u) || isSpacesURL(u):
+		dest, err := parseS3URL(u)
+		if err != nil {
+			return 0, "", err
+		}
+		sess, err := dest.newSession()
+		if err != nil {
+			return 0, "", err
+		}
+		head, err := s3.New(sess).HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: aws.String(dest.bucket), Key: aws.String(dest.key)})
+		var reqErr awserr.RequestFailure
+		if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound {
+			return 0, "", os.ErrNotExist
+		}
+		if err != nil {
+			return 0, "", err
+		}
+		etag := strings.Trim(aws.StringValue(head.ETag), `"`)
+		if strings.Contains(etag, "-") {
+			etag = ""
+		}
+		return aws.Int64Value(head.ContentLength), etag, nil
+	case u.Scheme == "gs":
+		client, err := newGCSClient(ctx, u.User.Username())
+		if err != nil {
+			return 0, "", fmt.Errorf("failed to create GCS client: %w", err)
+		}
+		defer client.Close()
+		attrs, err := client.Bucket(u.Host).Object(strings.TrimPrefix(u.Path, "/")).Attrs(ctx)
+		if err != nil {
+			return 0, "", err
+		}
+		// composed objects have no MD5
+		return attrs.Size, hex.EncodeToString(attrs.MD5), nil
+	}
+	return 0, "", drivers.ErrNotSupported
+}
+
+// readBackMD5 reads the object at u to compute its size and MD5 checksum
+func readBackMD5(ctx context.Context, u *url.URL, opts UploadOptions) (int64, string, error) {
+	reader, err := ReadRange(ctx, u, 0, -1, opts)
+	if err != nil {
+		return 0, "", err
+	}
+	defer reader.Body.Close()
+	hash := md5.New()
+	size, err := copyBuffered(hash, reader.Body)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteInputsAfterUpload(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "rec.mp4")
+	require.NoError(t, os.WriteFile(input, []byte("recording"), 0644))
+
+	opts := UploadOptions{SegmentTimeout: time.Second, DisableThumbs: []string{"/"}, DeleteInputs: &DeletePolicy{}}
+	_, err := UploadFiles([]string{input}, mustParseURL("memory-s3://delete/rec/rec.mp4"), opts)
+	require.NoError(t, err)
+	_, err = os.Stat(input)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// with a grace period the file is only deleted by a later sweep
+	require.NoError(t, os.WriteFile(input, []byte("recording"), 0644))
+	policy := &DeletePolicy{KeepFor: time.Hour, StateDir: filepath.Join(dir, "state")}
+	opts.DeleteInputs = policy
+	_, err = UploadFiles([]string{input}, mustParseURL(filepath.ToSlash(filepath.Join(dir, "out", "rec.mp4"))), opts)
+	require.NoError(t, err)
+	policy.Sweep()
+	require.FileExists(t, input)
+	policy.KeepFor = 0
+	policy.Sweep()
+	_, err = os.Stat(input)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	entries, err := os.ReadDir(policy.StateDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestVerifyUpload(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "index.m3u8")
+	require.NoError(t, os.WriteFile(input, []byte("#EXTM3U"), 0644))
+	_, err := UploadFiles([]string{input}, mustParseURL("memory-s3://verify/hls/index.m3u8"), UploadOptions{WriteTimeout: time.Second})
+	require.NoError(t, err)
+	require.NoError(t, verifyUpload(mustParseURL("memory-s3://verify/hls/index.m3u8"), input, UploadOptions{}))
+
+	require.NoError(t, os.WriteFile(input, []byte("#EXTM3U\n"), 0644))
+	require.ErrorIs(t, verifyUpload(mustParseURL("memory-s3://verify/hls/index.m3u8"), input, UploadOptions{}), ErrUploadMismatch)
+	other := filepath.Join(dir, "other.m3u8")
+	require.NoError(t, os.WriteFile(other, []byte("#EXTM3X\n"), 0644))
+	require.ErrorIs(t, verifyUpload(mustParseURL(filepath.ToSlash(other)), input, UploadOptions{}), ErrUploadMismatch)
+}
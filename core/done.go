@@ -0,0 +1,50 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// doneMarker is the content of the marker object written next to a completed upload, see UploadOptions.DoneMarker
+type doneMarker struct {
+	URI         string    `json:"uri"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	CompletedAt time.Time `json:"completed_at"`
+	CorrelationIDs
+}
+
+// doneMarkerURI is where the marker of an upload is written, its URI with a .done suffix
+func doneMarkerURI(u *url.URL) *url.URL {
+	marker := *u
+	marker.Path += ".done"
+	marker.RawPath = ""
+	return &marker
+}
+
+// writeDoneMarker writes the marker of a completed upload of fileName next to where it was written, which is the
+// backup if the upload fell back to it
+func writeDoneMarker(outputURI *url.URL, fileName string, result *UploadResult, opts UploadOptions) error {
+	if !opts.DoneMarker {
+		return nil
+	}
+	checksum, size, err := fileSHA256(fileName)
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %w", fileName, err)
+	}
+	location := result.location(outputURI)
+	data, err := json.Marshal(doneMarker{URI: locationString(location), Size: size, SHA256: checksum, CompletedAt: time.Now().UTC(), CorrelationIDs: opts.CorrelationIDs})
+	if err != nil {
+		return err
+	}
+	markerURI := doneMarkerURI(location)
+	if err := uploadJSON(markerURI, data, opts); err != nil {
+		return fmt.Errorf("failed to write done marker %s: %w", markerURI.Redacted(), err)
+	}
+	glog.V(5).Infof("Wrote done marker %s", markerURI.Redacted())
+	return nil
+}
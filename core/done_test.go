@@ -0,0 +1,40 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoneMarker(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.mp4")
+	require.NoError(t, os.WriteFile(input, []byte("recording"), 0644))
+	output := filepath.ToSlash(filepath.Join(dir, "out", "rec.mp4"))
+
+	_, err := UploadFiles([]string{input}, mustParseURL(output), UploadOptions{DoneMarker: true, SegmentTimeout: time.Second})
+	require.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(dir, "out", "rec.mp4.done"))
+	require.NoError(t, err)
+	var marker doneMarker
+	require.NoError(t, json.Unmarshal(data, &marker))
+	require.True(t, strings.HasSuffix(marker.URI, "/out/rec.mp4"), marker.URI)
+	require.Equal(t, int64(9), marker.Size)
+	require.Equal(t, "3ebb153fb24e4411400e94a9a92b0ec458c3a8473e51e03cd37d4a34c99dfda6", marker.SHA256)
+	require.WithinDuration(t, time.Now(), marker.CompletedAt, time.Minute)
+
+	// manifests get a marker after their final write
+	_, err = Upload(strings.NewReader("#EXTM3U\n"), mustParseURL(filepath.ToSlash(filepath.Join(dir, "out", "index.m3u8"))), UploadOptions{DoneMarker: true, WriteTimeout: time.Second})
+	require.NoError(t, err)
+	require.FileExists(t, filepath.Join(dir, "out", "index.m3u8.done"))
+
+	// no marker unless enabled
+	_, err = UploadFiles([]string{input}, mustParseURL(filepath.ToSlash(filepath.Join(dir, "out", "other.mp4"))), UploadOptions{SegmentTimeout: time.Second})
+	require.NoError(t, err)
+	require.NoFileExists(t, filepath.Join(dir, "out", "other.mp4.done"))
+}
@@ -0,0 +1,142 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/livepeer/catalyst-uploader/fakes3"
+	"github.com/livepeer/go-tools/drivers"
+)
+
+// memoryS3 is the in-process fake S3 server backing memory-s3:// destinations. It is started on first use
+// and lets the S3 upload path be exercised locally without any credentials.
+var memoryS3 struct {
+	once   sync.Once
+	server *fakes3.Server
+}
+
+// AvailableDrivers are the storage drivers of go-tools plus the ones implemented in this repo
+var AvailableDrivers = append(append([]drivers.OSDriver{}, drivers.AvailableDrivers...),
+	&BunnyOS{},
+	&SpacesOS{},
+	&SMBOS{},
+	&SSHOS{},
+	&GrantOS{},
+)
+
+// DescribeDriversJSON is drivers.DescribeDriversJson for AvailableDrivers
+func DescribeDriversJSON() []byte {
+	var descrs []drivers.OSDriverDescr
+	for _, driver := range AvailableDrivers {
+		descrs = append(descrs, drivers.OSDriverDescr{UriSchemes: driver.UriSchemes(), Description: driver.Description()})
+	}
+	b, _ := json.Marshal(struct {
+		Handlers []drivers.OSDriverDescr `json:"storage_drivers"`
+	}{descrs})
+	return b
+}
+
+// newSession creates a session for the object at u, wrapped according to the fault injection and
+// record/replay options
+func newSession(u *url.URL, opts UploadOptions) (drivers.OSSession, error) {
+	if opts.Replay != nil {
+		return opts.Replay.session(u), nil
+	}
+	driver, err := parseOSURL(u, opts)
+	if err != nil {
+		return nil, err
+	}
+	session := opts.FaultInjection.wrap(driver.NewSession(""))
+	return opts.Record.wrap(u, session), nil
+}
+
+// deleteObject deletes the object at u. The file system driver resolves deletes against the session path
+// rather than the URL it was created from, so local files are removed directly.
+func deleteObject(ctx context.Context, u *url.URL, opts UploadOptions) error {
+	if opts.Replay == nil && (u.Scheme == "" || u.Scheme == "file") {
+		return os.Remove(u.Path)
+	}
+	session, err := newSession(u, opts)
+	if err != nil {
+		return err
+	}
+	return session.DeleteFile(ctx, "")
+}
+
+// parseOSURL wraps drivers.ParseOSURL with the schemes implemented in this repo
+func parseOSURL(u *url.URL, opts UploadOptions) (drivers.OSDriver, error) {
+	if u.Scheme == "memory-s3" {
+		s3URL, err := url.Parse(memoryS3URL(u))
+		if err != nil {
+			return nil, err
+		}
+		return customS3Driver(s3URL)
+	}
+	if isSpacesURL(u) {
+		driver, err := customS3Driver(spacesS3URL(u))
+		if err != nil {
+			return nil, err
+		}
+		return &SpacesOS{OSDriver: driver}, nil
+	}
+	if isS3URL(u) {
+		return customS3Driver(u)
+	}
+	if u.Scheme == "gs" {
+		return NewGCSDriver(u)
+	}
+	if isSMBURL(u) {
+		return NewSMBDriver(u, opts.SMBCredentials)
+	}
+	if isSSHURL(u) {
+		return NewSSHDriver(u, opts.SSH)
+	}
+	if isBunnyURL(u) {
+		return NewBunnyDriver(u)
+	}
+	if u.Scheme == "grant" {
+		return NewGrantDriver(u, opts.Grant)
+	}
+	if isWindowsPathURI(u) {
+		// drivers.ParseOSURL would take the drive letter for a scheme, and the file system driver builds its
+		// paths from the String() of its URL, which keeps an opaque path as given
+		return &localOS{OSDriver: drivers.NewFSDriver(&url.URL{Opaque: uriString(u)}), dir: u.Path}, nil
+	}
+	driver, err := drivers.ParseOSURL(uriString(u), true)
+	if err == nil && (u.Scheme == "" || u.Scheme == "file") {
+		return &localOS{OSDriver: driver, dir: u.Path}, nil
+	}
+	return driver, err
+}
+
+// memoryS3URL translates memory-s3://bucket/key into the s3+http:// URL of the in-process fake S3 server
+func memoryS3URL(u *url.URL) string {
+	memoryS3.once.Do(func() {
+		memoryS3.server = fakes3.New()
+	})
+	return memoryS3.server.URL(u.Host, u.Path)
+}
+
+// sessionKey is the key of the object name of a session created at sessionPath on a driver whose URL has the
+// key prefix driverPrefix. It is the one key resolution of the drivers implemented in this repo, and the one
+// keyPrefixOS gives the go-tools drivers, so that an object lands at the same location whatever the storage:
+// the three are resolved with joinKey, in that order.
+func sessionKey(driverPrefix, sessionPath, name string) string {
+	return joinKey(joinKey(driverPrefix, sessionPath), name)
+}
+
+// joinKey resolves the key of name under a key prefix: slash separated without duplicate slashes, so that an
+// empty name is the prefix itself, and with a trailing slash kept, so that a directory prefix doesn't match the
+// objects next to it
+func joinKey(prefix, name string) string {
+	key := strings.TrimPrefix(path.Join(prefix, name), "/")
+	if key != "" && (strings.HasSuffix(name, "/") || name == "" && strings.HasSuffix(prefix, "/")) {
+		key += "/"
+	}
+	return key
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// segmentDuration reads the media duration of a .ts or .mp4 segment: the span of the presentation timestamps
+// of its longest elementary stream, or the duration in the movie header. Fragmented MP4s without one and other
+// extensions have no known duration.
+func segmentDuration(fileName, ext string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	switch ext {
+	case ".ts":
+		d, err = tsDuration(fileName)
+	case ".mp4":
+		d, err = mp4Duration(fileName)
+	default:
+		return 0, fmt.Errorf("no duration for %s segments", ext)
+	}
+	if err == nil && d <= 0 {
+		err = errors.New("no duration")
+	}
+	return d, err
+}
+
+func tsDuration(fileName string) (time.Duration, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	// first and span of the 90kHz PTS of each PID, in units after the first one so that wrapping is handled
+	first := map[uint16]uint64{}
+	span := map[uint16]uint64{}
+	err = readTSPackets(file, func(n int, pid uint16, unitStart bool, payload []byte) error {
+		if !unitStart || !isPESStart(payload) || len(payload) < 14 || payload[7]&0x80 == 0 {
+			return nil
+		}
+		pts := pesPTS(payload[9:])
+		start, ok := first[pid]
+		if !ok {
+			first[pid] = pts
+			return nil
+		}
+		span[pid] = max(span[pid], (pts-start)&(1<<33-1))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	var longest uint64
+	for _, s := range span {
+		longest = max(longest, s)
+	}
+	return time.Duration(longest) * time.Second / 90000, nil
+}
+
+// pesPTS reads the 33 bit PTS of a PES header from the 5 bytes following its header length
+func pesPTS(b []byte) uint64 {
+	return uint64(b[0]>>1&0x7)<<30 | uint64(b[1])<<22 | uint64(b[2]>>1)<<15 | uint64(b[3])<<7 | uint64(b[4]>>1)
+}
+
+func mp4Duration(fileName string) (time.Duration, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	boxes, err := readMP4Boxes(file, info.Size())
+	if err != nil {
+		return 0, err
+	}
+	for _, box := range boxes {
+		if box.boxType != "moov" {
+			continue
+		}
+		moov := make([]byte, box.size)
+		if _, err := file.ReadAt(moov, box.offset); err != nil {
+			return 0, err
+		}
+		// the children of the moov box follow its 8 byte header
+		for b := moov[8:]; len(b) >= 8; {
+			size := int(binary.BigEndian.Uint32(b))
+			if size < 8 || size > len(b) {
+				return 0, errors.New("invalid moov box")
+			}
+			if string(b[4:8]) == "mvhd" {
+				return mvhdDuration(b[:size])
+			}
+			b = b[size:]
+		}
+	}
+	return 0, errors.New("no movie header")
+}
+
+// mvhdDuration reads the duration of a movie header box, whose fields depend on its version
+func mvhdDuration(mvhd []byte) (time.Duration, error) {
+	var timescale, duration uint64
+	switch {
+	case len(mvhd) >= 32 && mvhd[8] == 0:
+		timescale, duration = uint64(binary.BigEndian.Uint32(mvhd[20:])), uint64(binary.BigEndian.Uint32(mvhd[24:]))
+	case len(mvhd) >= 40 && mvhd[8] == 1:
+		timescale, duration = uint64(binary.BigEndian.Uint32(mvhd[28:])), binary.BigEndian.Uint64(mvhd[32:])
+	default:
+		return 0, errors.New("invalid movie header")
+	}
+	if timescale == 0 {
+		return 0, errors.New("movie header without timescale")
+	}
+	return time.Duration(float64(duration) / float64(timescale) * float64(time.Second)), nil
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testPTSTS builds a segment with a PES packet for each PTS on PID 0x100
+func testPTSTS(pts ...uint64) []byte {
+	data := testTS(0, 1)
+	for _, p := range pts {
+		pes := append([]byte{0, 0, 1, 0xe0, 0, 0, 0x80, 0x80, 5}, testPESPTS(p)...)
+		data = append(data, testTSPacket(0x100, true, pes)...)
+	}
+	return data
+}
+
+func TestSegmentDuration(t *testing.T) {
+	dir := t.TempDir()
+	mvhd := testMP4Box("mvhd", 100)
+	binary.BigEndian.PutUint32(mvhd[20:], 1000)
+	binary.BigEndian.PutUint32(mvhd[24:], 2500)
+	tests := []struct {
+		name     string
+		data     []byte
+		duration time.Duration
+	}{
+		{"seg.ts", testPTSTS(90000, 135000, 270000), 2 * time.Second},
+		// timestamps wrapping around 2^33 within the segment
+		{"wrap.ts", testPTSTS(1<<33-45000, 45000), time.Second},
+		{"single.ts", testPTSTS(90000), 0},
+		{"seg.mp4", concatBytes(testMP4Box("ftyp", 8), testMP4Container("moov", mvhd), testMP4Box("mdat", 100)), 2500 * time.Millisecond},
+		{"fragment.mp4", concatBytes(testMP4Box("moof", 100), testMP4Box("mdat", 100)), 0},
+		{"seg.m4s", testMP4Box("moof", 100), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileName := filepath.Join(dir, tt.name)
+			require.NoError(t, os.WriteFile(fileName, tt.data, 0644))
+			d, err := segmentDuration(fileName, filepath.Ext(tt.name))
+			if tt.duration == 0 {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.duration, d)
+		})
+	}
+}
+
+func TestMediaDuration(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "seg.ts")
+	require.NoError(t, os.WriteFile(fileName, testPTSTS(0, 180000), 0644))
+
+	d, err := mediaDuration(fileName, ".ts", false, UploadOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 2*time.Second, d)
+	// transmuxed inputs are read as .ts whatever the destination
+	d, err = mediaDuration(fileName, ".m4s", true, UploadOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 2*time.Second, d)
+	_, err = mediaDuration(fileName, ".m4s", false, UploadOptions{})
+	require.Error(t, err)
+	// a given duration takes precedence
+	d, err = mediaDuration(fileName, ".m4s", false, UploadOptions{SegmentDuration: 6 * time.Second})
+	require.NoError(t, err)
+	require.Equal(t, 6*time.Second, d)
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type putEventsEntry struct {
+	EventBusName string
+	Source       string
+	DetailType   string
+	Detail       string
+}
+
+func TestEventBridge(t *testing.T) {
+	var mu sync.Mutex
+	var entries []putEventsEntry
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "AWSEvents.PutEvents", r.Header.Get("X-Amz-Target"))
+		var input struct{ Entries []putEventsEntry }
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&input))
+		mu.Lock()
+		entries = append(entries, input.Entries...)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		_, _ = w.Write([]byte(`{"FailedEntryCount":0,"Entries":[{"EventId":"1"}]}`))
+	}))
+	defer srv.Close()
+
+	_, err := ParseEventBridge("eventbridge://us-east-1/recordings", "")
+	require.Error(t, err)
+	bus, err := ParseEventBridge("eventbridge+http://key:secret@"+strings.TrimPrefix(srv.URL, "http://")+"/recordings", "Recording Uploaded")
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.mp4")
+	require.NoError(t, os.WriteFile(input, []byte("recording"), 0644))
+	_, err = UploadFiles([]string{input}, mustParseURL(filepath.ToSlash(filepath.Join(dir, "out", "rec.mp4"))), UploadOptions{EventBridge: bus, SegmentTimeout: time.Second})
+	require.NoError(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, entries, 1)
+	require.Equal(t, "recordings", entries[0].EventBusName)
+	require.Equal(t, "livepeer.catalyst-uploader", entries[0].Source)
+	require.Equal(t, "Recording Uploaded", entries[0].DetailType)
+	var event uploadEvent
+	require.NoError(t, json.Unmarshal([]byte(entries[0].Detail), &event))
+	require.Equal(t, EventUploadCompleted, event.Type)
+	require.True(t, strings.HasSuffix(event.URI, "/out/rec.mp4"), event.URI)
+}
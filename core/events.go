@@ -0,0 +1,152 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Types of the events posted to webhooks, see UploadOptions.EventWebhook and TimedMetadataWebhook
+const (
+	EventUploadCompleted = "upload.completed"
+	EventUploadFailed    = "upload.failed"
+	EventTimedMetadata   = "timed-metadata"
+)
+
+// cloudEventTypePrefix makes event types unique among the producers of CloudEvents
+const cloudEventTypePrefix = "com.livepeer.catalyst-uploader."
+
+// webhookTimeout bounds each webhook request
+const webhookTimeout = 10 * time.Second
+
+// uploadEvent is the JSON posted to UploadOptions.EventWebhook when an upload completes or fails
+type uploadEvent struct {
+	Type string    `json:"type"`
+	URI  string    `json:"uri"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size,omitempty"`
+	// Fallback is set when the data lives at BackupURI because the primary storage failed
+	Fallback  bool   `json:"fallback,omitempty"`
+	BackupURI string `json:"backup_uri,omitempty"`
+	Error     string `json:"error,omitempty"`
+	CorrelationIDs
+}
+
+// cloudEvent is the envelope of events posted with UploadOptions.CloudEvents, in the structured content mode of
+// the CloudEvents 1.0 HTTP binding. The CorrelationIDs are extension attributes.
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+	SessionID       string          `json:"sessionid,omitempty"`
+	PlaybackID      string          `json:"playbackid,omitempty"`
+}
+
+// eventSource is the CloudEvents source of the events of this host
+func eventSource() string {
+	source := "/catalyst-uploader"
+	if host, err := os.Hostname(); err == nil {
+		source += "/" + url.PathEscape(host)
+	}
+	return source
+}
+
+// newCloudEvent wraps the JSON data of an event about subject in a CloudEvents envelope
+func newCloudEvent(eventType, subject string, data []byte, ids CorrelationIDs) ([]byte, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return json.Marshal(cloudEvent{
+		SpecVersion:     "1.0",
+		ID:              hex.EncodeToString(id),
+		Source:          eventSource(),
+		Type:            cloudEventTypePrefix + eventType,
+		Subject:         subject,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		Data:            data,
+		SessionID:       ids.SessionID,
+		PlaybackID:      ids.PlaybackID,
+	})
+}
+
+// postEvent posts the JSON data of an event about subject to a webhook, in a CloudEvents envelope if
+// UploadOptions.CloudEvents is set
+func postEvent(webhook, eventType, subject string, data []byte, opts UploadOptions) error {
+	contentType := "application/json"
+	if opts.CloudEvents {
+		var err error
+		if data, err = newCloudEvent(eventType, subject, data, opts.CorrelationIDs); err != nil {
+			return err
+		}
+		contentType = "application/cloudevents+json"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("status %s: %s", resp.Status, msg)
+	}
+	return nil
+}
+
+// notifyUpload posts the event of an upload that completed, or failed with uploadErr, to UploadOptions.EventWebhook,
+// and puts the events of completed uploads on UploadOptions.EventBridge. Events that can't be sent are only logged.
+func notifyUpload(outputURI *url.URL, fileName string, result *UploadResult, uploadErr error, opts UploadOptions) {
+	if opts.EventWebhook == "" && (opts.EventBridge == nil || uploadErr != nil) {
+		return
+	}
+	event := uploadEvent{Type: EventUploadCompleted, URI: RedactedURI(outputURI), Time: time.Now().UTC(), CorrelationIDs: opts.CorrelationIDs}
+	if info, err := os.Stat(fileName); err == nil {
+		event.Size = info.Size()
+	}
+	if result != nil && result.Fallback {
+		event.Fallback = true
+		event.BackupURI = RedactedURI(result.BackupURI)
+	}
+	if uploadErr != nil {
+		event.Type = EventUploadFailed
+		event.Error = uploadErr.Error()
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		glog.Errorf("Failed to encode %s event for %s: %v", event.Type, outputURI.Redacted(), err)
+		return
+	}
+	if opts.EventWebhook != "" {
+		if err := postEvent(opts.EventWebhook, event.Type, event.URI, data, opts); err != nil {
+			logRepeatedError("event-webhook", opts, "Failed to post %s event for %s: %v", event.Type, outputURI.Redacted(), err)
+		}
+	}
+	if opts.EventBridge != nil && uploadErr == nil {
+		if err := opts.EventBridge.put(data); err != nil {
+			logRepeatedError("eventbridge", opts, "Failed to put %s event for %s on EventBridge: %v", event.Type, outputURI.Redacted(), err)
+		}
+	}
+}
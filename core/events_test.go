@@ -0,0 +1,74 @@
+package core
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type postedEvent struct {
+	contentType string
+	body        []byte
+}
+
+func newEventServer(t *testing.T) (*httptest.Server, func() []postedEvent) {
+	var mu sync.Mutex
+	var events []postedEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, postedEvent{contentType: r.Header.Get("Content-Type"), body: body})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []postedEvent {
+		mu.Lock()
+		defer mu.Unlock()
+		return events
+	}
+}
+
+func TestUploadEvents(t *testing.T) {
+	srv, posted := newEventServer(t)
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.mp4")
+	require.NoError(t, os.WriteFile(input, []byte("recording"), 0644))
+	output := filepath.ToSlash(filepath.Join(dir, "out", "rec.mp4"))
+	opts := UploadOptions{EventWebhook: srv.URL, SegmentTimeout: time.Second, CorrelationIDs: CorrelationIDs{SessionID: "session-1"}}
+
+	_, err := UploadFiles([]string{input}, mustParseURL(output), opts)
+	require.NoError(t, err)
+	require.Len(t, posted(), 1)
+	require.Equal(t, "application/json", posted()[0].contentType)
+	var event uploadEvent
+	require.NoError(t, json.Unmarshal(posted()[0].body, &event))
+	require.Equal(t, EventUploadCompleted, event.Type)
+	require.True(t, strings.HasSuffix(event.URI, "/out/rec.mp4"), event.URI)
+	require.Equal(t, int64(9), event.Size)
+	require.Equal(t, "session-1", event.SessionID)
+
+	opts.CloudEvents = true
+	_, err = UploadFiles([]string{input}, mustParseURL(output), opts)
+	require.NoError(t, err)
+	require.Len(t, posted(), 2)
+	require.Equal(t, "application/cloudevents+json", posted()[1].contentType)
+	var envelope cloudEvent
+	require.NoError(t, json.Unmarshal(posted()[1].body, &envelope))
+	require.Equal(t, "1.0", envelope.SpecVersion)
+	require.Equal(t, "com.livepeer.catalyst-uploader.upload.completed", envelope.Type)
+	require.NotEmpty(t, envelope.ID)
+	require.True(t, strings.HasPrefix(envelope.Source, "/catalyst-uploader"), envelope.Source)
+	require.Equal(t, event.URI, envelope.Subject)
+	require.Equal(t, "session-1", envelope.SessionID)
+	require.NoError(t, json.Unmarshal(envelope.Data, &event))
+	require.Equal(t, EventUploadCompleted, event.Type)
+}
@@ -0,0 +1,227 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/golang/glog"
+)
+
+// mp4Box is a top level box of an MP4 file
+type mp4Box struct {
+	boxType string
+	offset  int64
+	size    int64
+}
+
+// readMP4Boxes lists the top level boxes of an MP4 file of the given size, checking that they add up to it
+func readMP4Boxes(r io.ReaderAt, size int64) ([]mp4Box, error) {
+	var boxes []mp4Box
+	header := make([]byte, 16)
+	for offset := int64(0); offset < size; {
+		if size-offset < 8 {
+			return nil, fmt.Errorf("%d trailing bytes after the last box", size-offset)
+		}
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return nil, err
+		}
+		box := mp4Box{boxType: string(header[4:8]), offset: offset, size: int64(binary.BigEndian.Uint32(header))}
+		headerSize := int64(8)
+		switch box.size {
+		case 0:
+			// the box extends to the end of the file
+			box.size = size - offset
+		case 1:
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, fmt.Errorf("%s box at %d: %w", box.boxType, offset, err)
+			}
+			box.size = int64(binary.BigEndian.Uint64(header[8:]))
+			headerSize = 16
+		}
+		if box.size < headerSize {
+			return nil, fmt.Errorf("%s box at %d has invalid size %d", box.boxType, offset, box.size)
+		}
+		if offset+box.size > size {
+			return nil, fmt.Errorf("%s box at %d is truncated: %d of %d bytes", box.boxType, offset, size-offset, box.size)
+		}
+		boxes = append(boxes, box)
+		offset += box.size
+	}
+	return boxes, nil
+}
+
+// faststartMP4 writes the MP4 file inFileName to outFileName with the moov box moved in front of the media
+// data, so that players can start playback before the whole file is downloaded. It returns false without
+// writing anything if the file is already laid out that way or has no moov box, e.g. fragmented files.
+func faststartMP4(inFileName, outFileName string) (bool, error) {
+	in, err := os.Open(inFileName)
+	if err != nil {
+		return false, err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return false, err
+	}
+	boxes, err := readMP4Boxes(in, info.Size())
+	if err != nil {
+		return false, err
+	}
+
+	moovIndex, firstMdatIndex := -1, -1
+	for i, box := range boxes {
+		switch box.boxType {
+		case "moov":
+			moovIndex = i
+		case "mdat":
+			if firstMdatIndex < 0 {
+				firstMdatIndex = i
+			}
+		}
+	}
+	if moovIndex < 0 || firstMdatIndex < 0 || moovIndex < firstMdatIndex {
+		return false, nil
+	}
+	moovBox := boxes[moovIndex]
+	moov := make([]byte, moovBox.size)
+	if _, err := in.ReadAt(moov, moovBox.offset); err != nil {
+		return false, fmt.Errorf("failed to read moov box: %w", err)
+	}
+	if binary.BigEndian.Uint32(moov) == 0 {
+		// the moov box extended to the end of the file, which it won't anymore
+		if moovBox.size > math.MaxUint32 {
+			return false, errors.New("moov box is too large")
+		}
+		binary.BigEndian.PutUint32(moov, uint32(moovBox.size))
+	}
+	// the data from the first mdat box up to the moov box moves back by the size of the moov box
+	if err := shiftChunkOffsets(moov, boxes[firstMdatIndex].offset, moovBox.offset, moovBox.size); err != nil {
+		return false, err
+	}
+
+	out, err := os.Create(outFileName)
+	if err != nil {
+		return false, err
+	}
+	defer out.Close()
+	for i, box := range boxes {
+		if i == firstMdatIndex {
+			if _, err := out.Write(moov); err != nil {
+				return false, err
+			}
+		}
+		if i == moovIndex {
+			continue
+		}
+		if _, err := copyBuffered(out, io.NewSectionReader(in, box.offset, box.size)); err != nil {
+			return false, err
+		}
+	}
+	return true, out.Close()
+}
+
+// shiftChunkOffsets adds shift to the chunk offsets in [from, to) in the stco and co64 boxes of a moov box
+func shiftChunkOffsets(moov []byte, from, to, shift int64) error {
+	found := false
+	var walk func(data []byte) error
+	walk = func(data []byte) error {
+		for len(data) >= 8 {
+			size := int64(binary.BigEndian.Uint32(data))
+			headerSize := int64(8)
+			if size == 1 && len(data) >= 16 {
+				size, headerSize = int64(binary.BigEndian.Uint64(data[8:])), 16
+			}
+			if size < headerSize || size > int64(len(data)) {
+				return errors.New("invalid box in moov")
+			}
+			box, body := data[:size], data[headerSize:size]
+			switch string(box[4:8]) {
+			case "trak", "mdia", "minf", "stbl":
+				if err := walk(body); err != nil {
+					return err
+				}
+			case "stco", "co64":
+				found = true
+				if err := shiftChunkOffsetTable(string(box[4:8]), body, from, to, shift); err != nil {
+					return err
+				}
+			}
+			data = data[size:]
+		}
+		return nil
+	}
+	// skip the header of the moov box itself
+	headerSize := 8
+	if binary.BigEndian.Uint32(moov) == 1 {
+		headerSize = 16
+	}
+	if err := walk(moov[headerSize:]); err != nil {
+		return err
+	}
+	if !found {
+		return errors.New("no chunk offsets in moov")
+	}
+	return nil
+}
+
+func shiftChunkOffsetTable(boxType string, body []byte, from, to, shift int64) error {
+	entrySize := 4
+	if boxType == "co64" {
+		entrySize = 8
+	}
+	// version and flags, then the entry count
+	if len(body) < 8 {
+		return fmt.Errorf("%s box is too short", boxType)
+	}
+	count := int(binary.BigEndian.Uint32(body[4:]))
+	entries := body[8:]
+	if count > len(entries)/entrySize {
+		return fmt.Errorf("%s box is too short for %d entries", boxType, count)
+	}
+	for i := 0; i < count; i++ {
+		entry := entries[i*entrySize:]
+		if entrySize == 8 {
+			if offset := int64(binary.BigEndian.Uint64(entry)); offset >= from && offset < to {
+				binary.BigEndian.PutUint64(entry, uint64(offset+shift))
+			}
+			continue
+		}
+		offset := int64(binary.BigEndian.Uint32(entry))
+		if offset < from || offset >= to {
+			continue
+		}
+		if offset+shift > math.MaxUint32 {
+			return errors.New("chunk offsets don't fit in stco after moving moov")
+		}
+		binary.BigEndian.PutUint32(entry, uint32(offset+shift))
+	}
+	return nil
+}
+
+// faststartInput returns a copy of an .mp4 file with its moov box in front of the media data, and a function
+// removing the copy. If the file is already laid out that way, or can't be rewritten, the file itself is
+// returned and uploaded as it is.
+func faststartInput(fileName string) (string, func()) {
+	noop := func() {}
+	outFile, err := os.CreateTemp("", "faststart-*.mp4")
+	if err != nil {
+		glog.Errorf("Failed to create faststart file, uploading %s as it is: %v", fileName, err)
+		return fileName, noop
+	}
+	outFile.Close()
+	remove := func() { os.Remove(outFile.Name()) }
+	rewritten, err := faststartMP4(fileName, outFile.Name())
+	if err != nil {
+		glog.Errorf("Faststart failed, uploading %s as it is: %v", fileName, err)
+	}
+	if err != nil || !rewritten {
+		remove()
+		return fileName, noop
+	}
+	glog.V(5).Infof("Moved moov box of %s to the front", fileName)
+	return outFile.Name(), remove
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testMP4Container builds a box holding the given body
+func testMP4Container(boxType string, body ...[]byte) []byte {
+	box := testMP4Box(boxType, 0)
+	box = append(box, concatBytes(body...)...)
+	binary.BigEndian.PutUint32(box, uint32(len(box)))
+	return box
+}
+
+// testChunkOffsets builds an stco or co64 box
+func testChunkOffsets(boxType string, offsets ...int64) []byte {
+	body := binary.BigEndian.AppendUint32(make([]byte, 4), uint32(len(offsets)))
+	for _, offset := range offsets {
+		if boxType == "co64" {
+			body = binary.BigEndian.AppendUint64(body, uint64(offset))
+		} else {
+			body = binary.BigEndian.AppendUint32(body, uint32(offset))
+		}
+	}
+	return testMP4Container(boxType, body)
+}
+
+func testTrak(offsetsBox []byte) []byte {
+	return testMP4Container("trak", testMP4Box("tkhd", 84), testMP4Container("mdia", testMP4Container("minf", testMP4Container("stbl", testMP4Box("stsd", 8), offsetsBox))))
+}
+
+// readChunkOffsets returns the offsets of the first stco or co64 box in data
+func readChunkOffsets(t *testing.T, data []byte, boxType string) []int64 {
+	i := bytes.Index(data, []byte(boxType))
+	require.Positive(t, i)
+	body := data[i+4:]
+	count := int(binary.BigEndian.Uint32(body[4:]))
+	var offsets []int64
+	for n := 0; n < count; n++ {
+		if boxType == "co64" {
+			offsets = append(offsets, int64(binary.BigEndian.Uint64(body[8+n*8:])))
+		} else {
+			offsets = append(offsets, int64(binary.BigEndian.Uint32(body[8+n*4:])))
+		}
+	}
+	return offsets
+}
+
+func TestFaststartMP4(t *testing.T) {
+	dir := t.TempDir()
+	ftyp := testMP4Box("ftyp", 16)
+	mdat := testMP4Box("mdat", 64)
+	for i := 8; i < len(mdat); i++ {
+		mdat[i] = byte(i)
+	}
+	// chunks at the start and the middle of the mdat body
+	videoOffset, audioOffset := int64(len(ftyp)+8), int64(len(ftyp)+40)
+	moov := testMP4Container("moov", testMP4Box("mvhd", 100), testTrak(testChunkOffsets("stco", videoOffset)), testTrak(testChunkOffsets("co64", audioOffset)))
+	inFile := filepath.Join(dir, "in.mp4")
+	require.NoError(t, os.WriteFile(inFile, concatBytes(ftyp, mdat, moov), 0644))
+
+	outFile := filepath.Join(dir, "out.mp4")
+	rewritten, err := faststartMP4(inFile, outFile)
+	require.NoError(t, err)
+	require.True(t, rewritten)
+	out, err := os.ReadFile(outFile)
+	require.NoError(t, err)
+	require.Len(t, out, len(ftyp)+len(mdat)+len(moov))
+
+	boxes, err := readMP4Boxes(bytes.NewReader(out), int64(len(out)))
+	require.NoError(t, err)
+	var types []string
+	for _, box := range boxes {
+		types = append(types, box.boxType)
+	}
+	require.Equal(t, []string{"ftyp", "moov", "mdat"}, types)
+	// the chunk offsets still point at the same data
+	require.Equal(t, []int64{videoOffset + int64(len(moov))}, readChunkOffsets(t, out, "stco"))
+	require.Equal(t, []int64{audioOffset + int64(len(moov))}, readChunkOffsets(t, out, "co64"))
+	require.Equal(t, mdat[8], out[videoOffset+int64(len(moov))])
+	require.Equal(t, mdat[40], out[audioOffset+int64(len(moov))])
+
+	// files that are already faststart, or fragmented, are left alone
+	rewritten, err = faststartMP4(outFile, filepath.Join(dir, "again.mp4"))
+	require.NoError(t, err)
+	require.False(t, rewritten)
+	require.NoFileExists(t, filepath.Join(dir, "again.mp4"))
+	fragmentFile := filepath.Join(dir, "fragment.mp4")
+	require.NoError(t, os.WriteFile(fragmentFile, concatBytes(testMP4Box("styp", 8), testMP4Box("moof", 100), testMP4Box("mdat", 100)), 0644))
+	rewritten, err = faststartMP4(fragmentFile, filepath.Join(dir, "fragment-out.mp4"))
+	require.NoError(t, err)
+	require.False(t, rewritten)
+}
+
+func TestUploadFaststart(t *testing.T) {
+	dir := t.TempDir()
+	moov := testMP4Container("moov", testTrak(testChunkOffsets("stco", 24)))
+	inFile := filepath.Join(dir, "in.mp4")
+	require.NoError(t, os.WriteFile(inFile, concatBytes(testMP4Box("ftyp", 16), testMP4Box("mdat", 64), moov), 0644))
+	outFile := filepath.Join(dir, "out.mp4")
+
+	_, err := UploadFiles([]string{inFile}, mustParseURL(filepath.ToSlash(outFile)), UploadOptions{Faststart: true, DisableThumbs: []string{"out"}})
+	require.NoError(t, err)
+	out, err := os.ReadFile(outFile)
+	require.NoError(t, err)
+	require.Equal(t, "moov", string(out[28:32]))
+}
@@ -0,0 +1,161 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-tools/drivers"
+)
+
+var ErrInjectedFault = errors.New("injected fault")
+
+// FaultProfile describes faults randomly injected into storage operations, for testing how callers behave
+// when storage misbehaves. The random source is seeded, so a given profile produces the same sequence of
+// faults for the same sequence of operations.
+type FaultProfile struct {
+	Seed int64
+	// ErrorRate is the probability of an operation failing with ErrInjectedFault
+	ErrorRate float64
+	// DelayRate is the probability of an operation being delayed by up to MaxDelay
+	DelayRate float64
+	MaxDelay  time.Duration
+	// TruncateRate is the probability of an upload silently storing only part of the data
+	TruncateRate float64
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// ParseFaultProfile parses a comma separated list of key=value pairs,
+// e.g. seed=42,error=0.1,delay=0.2,max-delay=2s,truncate=0.05
+func ParseFaultProfile(s string) (*FaultProfile, error) {
+	p := &FaultProfile{MaxDelay: time.Second}
+	for _, pair := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid fault profile entry %q, key=value required", pair)
+		}
+		var err error
+		switch k {
+		case "seed":
+			p.Seed, err = strconv.ParseInt(v, 10, 64)
+		case "error":
+			p.ErrorRate, err = parseProbability(v)
+		case "delay":
+			p.DelayRate, err = parseProbability(v)
+		case "max-delay":
+			p.MaxDelay, err = time.ParseDuration(v)
+		case "truncate":
+			p.TruncateRate, err = parseProbability(v)
+		default:
+			return nil, fmt.Errorf("unknown fault profile key %q", k)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid fault profile value for %s: %w", k, err)
+		}
+	}
+	return p, nil
+}
+
+func parseProbability(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if f < 0 || f > 1 {
+		return 0, fmt.Errorf("probability %v out of range [0, 1]", f)
+	}
+	return f, nil
+}
+
+func (p *FaultProfile) float64() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.rand == nil {
+		p.rand = rand.New(rand.NewSource(p.Seed))
+	}
+	return p.rand.Float64()
+}
+
+// inject delays and/or fails an operation according to the profile
+func (p *FaultProfile) inject(ctx context.Context, op string) error {
+	if p.float64() < p.DelayRate {
+		delay := time.Duration(p.float64() * float64(p.MaxDelay))
+		glog.Warningf("Fault injection: delaying %s by %s", op, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if p.float64() < p.ErrorRate {
+		glog.Warningf("Fault injection: failing %s", op)
+		return fmt.Errorf("%s: %w", op, ErrInjectedFault)
+	}
+	return nil
+}
+
+func (p *FaultProfile) wrap(session drivers.OSSession) drivers.OSSession {
+	if p == nil {
+		return session
+	}
+	return &faultSession{OSSession: session, profile: p}
+}
+
+type faultSession struct {
+	drivers.OSSession
+	profile *FaultProfile
+}
+
+func (s *faultSession) SaveData(ctx context.Context, name string, data io.Reader, fields *drivers.FileProperties, timeout time.Duration) (*drivers.SaveDataOutput, error) {
+	if err := s.profile.inject(ctx, "SaveData"); err != nil {
+		return nil, err
+	}
+	if s.profile.float64() < s.profile.TruncateRate {
+		b, err := io.ReadAll(data)
+		if err != nil {
+			return nil, err
+		}
+		n := int(s.profile.float64() * float64(len(b)))
+		glog.Warningf("Fault injection: truncating SaveData from %d to %d bytes", len(b), n)
+		data = bytes.NewReader(b[:n])
+	}
+	return s.OSSession.SaveData(ctx, name, data, fields, timeout)
+}
+
+func (s *faultSession) ReadData(ctx context.Context, name string) (*drivers.FileInfoReader, error) {
+	if err := s.profile.inject(ctx, "ReadData"); err != nil {
+		return nil, err
+	}
+	return s.OSSession.ReadData(ctx, name)
+}
+
+func (s *faultSession) ReadDataRange(ctx context.Context, name, byteRange string) (*drivers.FileInfoReader, error) {
+	if err := s.profile.inject(ctx, "ReadDataRange"); err != nil {
+		return nil, err
+	}
+	return s.OSSession.ReadDataRange(ctx, name, byteRange)
+}
+
+func (s *faultSession) DeleteFile(ctx context.Context, name string) error {
+	if err := s.profile.inject(ctx, "DeleteFile"); err != nil {
+		return err
+	}
+	return s.OSSession.DeleteFile(ctx, name)
+}
+
+func (s *faultSession) ListFiles(ctx context.Context, prefix, delim string) (drivers.PageInfo, error) {
+	if err := s.profile.inject(ctx, "ListFiles"); err != nil {
+		return nil, err
+	}
+	return s.OSSession.ListFiles(ctx, prefix, delim)
+}
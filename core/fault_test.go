@@ -0,0 +1,64 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFaultProfile(t *testing.T) {
+	p, err := ParseFaultProfile("seed=42,error=0.1,delay=0.2,max-delay=2s,truncate=0.05")
+	require.NoError(t, err)
+	require.Equal(t, int64(42), p.Seed)
+	require.Equal(t, 0.1, p.ErrorRate)
+	require.Equal(t, 0.2, p.DelayRate)
+	require.Equal(t, 2*time.Second, p.MaxDelay)
+	require.Equal(t, 0.05, p.TruncateRate)
+
+	_, err = ParseFaultProfile("error=2")
+	require.ErrorContains(t, err, "out of range")
+	_, err = ParseFaultProfile("explode=0.5")
+	require.ErrorContains(t, err, "unknown fault profile key")
+	_, err = ParseFaultProfile("seed")
+	require.ErrorContains(t, err, "key=value required")
+}
+
+func TestFaultProfileIsDeterministic(t *testing.T) {
+	outcomes := func() []bool {
+		p, err := ParseFaultProfile("seed=7,error=0.5")
+		require.NoError(t, err)
+		var res []bool
+		for i := 0; i < 20; i++ {
+			res = append(res, p.inject(context.Background(), "test") == nil)
+		}
+		return res
+	}
+	first := outcomes()
+	require.Equal(t, first, outcomes())
+	require.Contains(t, first, true)
+	require.Contains(t, first, false)
+}
+
+func TestFaultInjectionInUpload(t *testing.T) {
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "input.txt")
+	require.NoError(t, os.WriteFile(testFile, []byte("test data"), 0644))
+	output := filepath.ToSlash(filepath.Join(dir, "out", "file.txt"))
+
+	_, _, err := uploadFileWithBackup(mustParseURL(output), testFile, nil, 0, false, UploadOptions{
+		FaultInjection: &FaultProfile{ErrorRate: 1},
+	})
+	require.ErrorIs(t, err, ErrInjectedFault)
+
+	_, _, err = uploadFileWithBackup(mustParseURL(output), testFile, nil, 0, false, UploadOptions{
+		FaultInjection: &FaultProfile{TruncateRate: 1},
+	})
+	require.NoError(t, err)
+	b, err := os.ReadFile(output)
+	require.NoError(t, err)
+	require.Less(t, len(b), len("test data"))
+}
@@ -0,0 +1,61 @@
+package core
+
+import (
+	"runtime"
+	"sort"
+)
+
+// Features lists the optional capabilities of this build, so that fleet tooling can tell what each node can do.
+// The ones relying on ffmpeg are only listed when it is installed.
+func Features() []string {
+	features := []string{
+		"append",
+		"cdn-purge",
+		"cloudevents",
+		"content-disposition",
+		"done-marker",
+		"event-webhook",
+		"eventbridge",
+		"faststart",
+		"from-url",
+		"glob",
+		"grant",
+		"header-rules",
+		"idempotency-key",
+		"influx",
+		"manifest-history",
+		"preserve-metadata",
+		"quota",
+		"range-split",
+		"redis-state",
+		"resume",
+		"retry-budget",
+		"tar",
+		"timed-metadata",
+		"update-metadata",
+		"validate-segments",
+	}
+	if runtime.GOOS != "windows" {
+		features = append(features, "follow")
+	}
+	if ffmpegInstalled() {
+		features = append(features, "thumbnails", "transmux", "waveform")
+	}
+	sort.Strings(features)
+	return features
+}
+
+// DriverSchemes lists the URI schemes of AvailableDrivers. Paths without a scheme are listed as file.
+func DriverSchemes() []string {
+	var schemes []string
+	for _, driver := range AvailableDrivers {
+		for _, scheme := range driver.UriSchemes() {
+			if scheme == "" {
+				scheme = "file"
+			}
+			schemes = append(schemes, scheme)
+		}
+	}
+	sort.Strings(schemes)
+	return schemes
+}
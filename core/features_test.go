@@ -0,0 +1,24 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeatures(t *testing.T) {
+	features := Features()
+	require.Contains(t, features, "append")
+	require.IsNonDecreasing(t, features)
+	if ffmpegInstalled() {
+		require.Contains(t, features, "thumbnails")
+	} else {
+		require.NotContains(t, features, "thumbnails")
+	}
+
+	schemes := DriverSchemes()
+	require.Contains(t, schemes, "s3")
+	require.Contains(t, schemes, "spaces")
+	require.Contains(t, schemes, "file")
+	require.NotContains(t, schemes, "")
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// ffmpegSlotWait bounds how long an ffmpeg invocation waits for a free slot
+	ffmpegSlotWait = time.Minute
+	// ffmpegSlotPoll is how often busy slots are tried again
+	ffmpegSlotPoll = 50 * time.Millisecond
+)
+
+// ffmpegInstalled reports whether ffmpeg is in the PATH. Without it thumbnails are extracted with nativeThumb,
+// which only decodes JPEG keyframes.
+var ffmpegInstalled = sync.OnceValue(lookFFmpeg)
+
+func lookFFmpeg() bool {
+	_, err := exec.LookPath("ffmpeg")
+	return err == nil
+}
+
+// ffmpegMissingWarning logs that ffmpeg is missing once per process rather than for every segment
+var ffmpegMissingWarning sync.Once
+
+// FFmpegLimiter limits how many ffmpeg processes run at once on the host, across all uploader processes, so
+// that many concurrent uploads don't starve the transcoder. Each running ffmpeg holds a lock on one of the
+// slot files in a shared directory. A nil limiter doesn't limit anything.
+type FFmpegLimiter struct {
+	dir   string
+	slots int
+}
+
+// NewFFmpegLimiter creates a limiter allowing slots concurrent ffmpeg processes, coordinated through lock files
+// in dir. Every process sharing the limit must use the same dir and slots.
+func NewFFmpegLimiter(dir string, slots int) (*FFmpegLimiter, error) {
+	if slots < 1 {
+		return nil, errors.New("ffmpeg concurrency must be at least 1")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create ffmpeg lock directory: %w", err)
+	}
+	return &FFmpegLimiter{dir: dir, slots: slots}, nil
+}
+
+// acquire waits for a free slot and returns the function releasing it
+func (l *FFmpegLimiter) acquire() (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	start := time.Now()
+	for {
+		file, err := lockAnySlot(l.dir, "ffmpeg", l.slots)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock ffmpeg slot: %w", err)
+		}
+		if file != nil {
+			if waited := time.Since(start); waited > time.Second {
+				glog.V(5).Infof("Waited %s for an ffmpeg slot", waited)
+			}
+			return func() { unlockSlotFile(file) }, nil
+		}
+		if time.Since(start) > ffmpegSlotWait {
+			return nil, fmt.Errorf("timed out after %s waiting for one of %d ffmpeg slots", ffmpegSlotWait, l.slots)
+		}
+		time.Sleep(ffmpegSlotPoll)
+	}
+}
+
+// lockAnySlot locks the first free one of the lock files <prefix>-0.lock to <prefix>-<slots-1>.lock in dir,
+// returning nil if they are all held
+func lockAnySlot(dir, prefix string, slots int) (*os.File, error) {
+	for i := 0; i < slots; i++ {
+		file, err := lockSlotFile(filepath.Join(dir, fmt.Sprintf("%s-%d.lock", prefix, i)))
+		if err != nil || file != nil {
+			return file, err
+		}
+	}
+	return nil, nil
+}
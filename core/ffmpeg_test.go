@@ -0,0 +1,50 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFFmpegLimiter(t *testing.T) {
+	dir := t.TempDir()
+	limiter, err := NewFFmpegLimiter(dir, 2)
+	require.NoError(t, err)
+	// another process sharing the limit, with its own lock file handles
+	other, err := NewFFmpegLimiter(dir, 2)
+	require.NoError(t, err)
+
+	release1, err := limiter.acquire()
+	require.NoError(t, err)
+	release2, err := other.acquire()
+	require.NoError(t, err)
+
+	acquired := make(chan func())
+	go func() {
+		release, err := limiter.acquire()
+		require.NoError(t, err)
+		acquired <- release
+	}()
+	select {
+	case <-acquired:
+		require.Fail(t, "acquired a third slot of two")
+	case <-time.After(200 * time.Millisecond):
+	}
+	release1()
+	select {
+	case release3 := <-acquired:
+		release3()
+	case <-time.After(time.Second):
+		require.Fail(t, "released slot wasn't acquired")
+	}
+	release2()
+
+	_, err = NewFFmpegLimiter(dir, 0)
+	require.Error(t, err)
+
+	var unlimited *FFmpegLimiter
+	release, err := unlimited.acquire()
+	require.NoError(t, err)
+	release()
+}
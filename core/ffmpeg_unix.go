@@ -0,0 +1,30 @@
+//go:build !windows
+
+package core
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockSlotFile takes an exclusive lock on the file without waiting, returning nil if another holds it
+func lockSlotFile(fileName string) (*os.File, error) {
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+// unlockSlotFile releases the lock, which closing the file does
+func unlockSlotFile(file *os.File) {
+	file.Close()
+}
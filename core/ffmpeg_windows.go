@@ -0,0 +1,30 @@
+package core
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockSlotFile takes an exclusive lock on the file without waiting, returning nil if another holds it
+func lockSlotFile(fileName string) (*os.File, error) {
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = windows.LockFileEx(windows.Handle(file.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &windows.Overlapped{})
+	if err != nil {
+		file.Close()
+		if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+// unlockSlotFile releases the lock, which closing the file does
+func unlockSlotFile(file *os.File) {
+	file.Close()
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// FollowFIFO uploads what each writer of a named pipe writes as a complete new version of the output, reopening
+// the pipe for the next writer, until ctx is cancelled. Failed uploads are logged and don't stop following, as
+// the next version replaces the output anyway. Each version is uploaded with the options live has when it starts.
+// With a seq, each write is instead uploaded as a new segment, to the output with {seq} replaced by its number.
+func FollowFIFO(ctx context.Context, fifoName string, outputURI *url.URL, seq *Sequence, live *LiveOptions) error {
+	for {
+		inputFile, err := os.CreateTemp("", "upload-*"+filepath.Ext(outputURI.Path))
+		if err != nil {
+			return fmt.Errorf("failed to write to temp file: %w", err)
+		}
+		inputFileName := inputFile.Name()
+		err = readFIFO(ctx, fifoName, inputFile)
+		inputFile.Close()
+		if ctx.Err() != nil {
+			os.Remove(inputFileName)
+			return nil
+		}
+		if err != nil {
+			os.Remove(inputFileName)
+			return fmt.Errorf("failed to read %s: %w", fifoName, err)
+		}
+
+		if info, err := os.Stat(inputFileName); err == nil && info.Size() == 0 {
+			glog.V(5).Infof("Skipping empty write to %s", fifoName)
+		} else if err := uploadFIFOWrite(inputFileName, outputURI, seq, live.Get()); err != nil {
+			glog.Errorf("Failed to upload %s from %s: %v", outputURI.Redacted(), fifoName, err)
+		}
+		os.Remove(inputFileName)
+	}
+}
+
+// uploadFIFOWrite uploads one write to a followed pipe, as the next segment if seq is set
+func uploadFIFOWrite(fileName string, outputURI *url.URL, seq *Sequence, opts UploadOptions) error {
+	if seq != nil {
+		n, err := seq.Next()
+		if err != nil {
+			return err
+		}
+		outputURI = expandSeq(outputURI, n)
+	}
+	// the write is removed once uploaded anyway
+	opts.DeleteInputs = nil
+	_, err := UploadFiles([]string{fileName}, outputURI, opts)
+	return err
+}
+
+// LiveOptions holds the options of a long running uploader, which can be replaced while it runs, e.g. when its
+// config is reloaded. Uploads in progress keep the options they started with.
+type LiveOptions struct {
+	mu   sync.RWMutex
+	opts UploadOptions
+}
+
+func NewLiveOptions(opts UploadOptions) *LiveOptions {
+	return &LiveOptions{opts: opts}
+}
+
+func (l *LiveOptions) Get() UploadOptions {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.opts
+}
+
+func (l *LiveOptions) Set(opts UploadOptions) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.opts = opts
+}
@@ -0,0 +1,80 @@
+//go:build !windows
+
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFollowFIFO(t *testing.T) {
+	dir := t.TempDir()
+	fifoName := filepath.Join(dir, "index.m3u8")
+	require.NoError(t, syscall.Mkfifo(fifoName, 0644))
+	outputFile := filepath.Join(dir, "out", "index.m3u8")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	live := NewLiveOptions(UploadOptions{WriteTimeout: time.Second})
+	go func() {
+		done <- FollowFIFO(ctx, fifoName, mustParseURL(outputFile), nil, live)
+	}()
+
+	for i, version := range []string{"#EXTM3U\n1.ts\n", "#EXTM3U\n1.ts\n2.ts\n"} {
+		require.NoError(t, os.WriteFile(fifoName, []byte(version), 0644))
+		require.Eventually(t, func() bool {
+			data, _ := os.ReadFile(outputFile)
+			return string(data) == version
+		}, 5*time.Second, 10*time.Millisecond)
+		if i == 0 {
+			require.NoFileExists(t, outputFile+".done")
+			// options replaced while following apply to the next version
+			live.Set(UploadOptions{WriteTimeout: time.Second, DoneMarker: true})
+		}
+	}
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(outputFile + ".done")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// cancelling stops following while waiting for the next writer
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("FollowFIFO didn't return after cancellation")
+	}
+}
+
+func TestFollowFIFOSeq(t *testing.T) {
+	dir := t.TempDir()
+	fifoName := filepath.Join(dir, "segment.ts")
+	require.NoError(t, syscall.Mkfifo(fifoName, 0644))
+	outputURI := mustParseURL(filepath.Join(dir, "out", "{seq}.ts"))
+	seq, err := OpenSequence(filepath.Join(dir, "seq"), outputURI)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = FollowFIFO(ctx, fifoName, outputURI, seq, NewLiveOptions(UploadOptions{WriteTimeout: time.Second}))
+	}()
+
+	// each write is uploaded as the next segment
+	for i, segment := range []string{"segment 0", "segment 1"} {
+		require.NoError(t, os.WriteFile(fifoName, []byte(segment), 0644))
+		outputFile := filepath.Join(dir, "out", strconv.Itoa(i)+".ts")
+		require.Eventually(t, func() bool {
+			data, _ := os.ReadFile(outputFile)
+			return string(data) == segment
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+}
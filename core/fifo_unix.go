@@ -0,0 +1,36 @@
+//go:build !windows
+
+package core
+
+import (
+	"context"
+	"io"
+	"os"
+	"syscall"
+)
+
+// readFIFO waits for a writer to open the named pipe and copies what it writes until it closes the pipe
+func readFIFO(ctx context.Context, fifoName string, w io.Writer) error {
+	opened := make(chan struct{})
+	defer close(opened)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// opening the pipe for writing unblocks the open for reading below
+			if file, err := os.OpenFile(fifoName, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+				file.Close()
+			}
+		case <-opened:
+		}
+	}()
+
+	file, err := os.Open(fifoName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stop := context.AfterFunc(ctx, func() { file.Close() })
+	defer stop()
+	_, err = copyBuffered(w, file)
+	return err
+}
@@ -0,0 +1,11 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+func readFIFO(ctx context.Context, fifoName string, w io.Writer) error {
+	return errors.New("following named pipes is not supported on Windows")
+}
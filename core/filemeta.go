@@ -0,0 +1,129 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-tools/drivers"
+)
+
+// mtimeMetadataKey is the object metadata holding the modification time of the uploaded file, see
+// UploadOptions.PreserveFileMetadata
+const mtimeMetadataKey = "Mtime"
+
+// withFileMetadata adds the modification time of the input file to the metadata of an upload, and its
+// Content-Type by the file's extension, which matters when the destination has none of its own
+func withFileMetadata(fields *drivers.FileProperties, opts UploadOptions) *drivers.FileProperties {
+	if !opts.PreserveFileMetadata || opts.sourceFile == "" {
+		return fields
+	}
+	info, err := os.Stat(opts.sourceFile)
+	if err != nil {
+		glog.Warningf("Not preserving the metadata of %s: %v", opts.sourceFile, err)
+		return fields
+	}
+	fileFields := copyFileProperties(fields)
+	fileFields.Metadata[mtimeMetadataKey] = info.ModTime().UTC().Format(time.RFC3339Nano)
+	if contentType, err := drivers.TypeByExtension(filepath.Ext(opts.sourceFile)); err == nil {
+		fileFields.ContentType = contentType
+	}
+	return fileFields
+}
+
+// mediaExtensions are the extensions of the types the uploader deals with, which the system's MIME types may lack
+var mediaExtensions = map[string]string{
+	"application/json":      ".json",
+	"application/x-mpegurl": ".m3u8",
+	"image/jpeg":            ".jpg",
+	"image/png":             ".png",
+	"video/iso.segment":     ".m4s",
+	"video/mp2t":            ".ts",
+	"video/mp4":             ".mp4",
+}
+
+// extensionByType returns the file extension for a Content-Type, or an empty string if it's unknown
+func extensionByType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	if ext, ok := mediaExtensions[mediaType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// DownloadFile writes the object at u to fileName, or to a file named after the object in fileName if that is
+// a directory. The file is written under a temporary name and renamed once complete. With preserveMetadata,
+// the modification time recorded by UploadOptions.PreserveFileMetadata is restored, and a file named after an
+// object without an extension gets the one of its Content-Type. It returns the name of the written file.
+func DownloadFile(ctx context.Context, u *url.URL, fileName string, preserveMetadata bool, opts UploadOptions) (string, error) {
+	var props *drivers.FileProperties
+	if preserveMetadata {
+		var exists bool
+		var err error
+		props, exists, err = headObject(ctx, u)
+		switch {
+		case errors.Is(err, drivers.ErrNotSupported):
+			glog.Warningf("Metadata of %s destinations can't be read, downloading %s without it", u.Scheme, u.Redacted())
+		case err != nil:
+			return "", fmt.Errorf("failed to read metadata: %w", err)
+		case !exists:
+			return "", fmt.Errorf("failed to read %s: %w", u.Redacted(), os.ErrNotExist)
+		}
+	}
+
+	if info, err := os.Stat(fileName); err == nil && info.IsDir() {
+		name := path.Base(u.Path)
+		if name == "/" || name == "." {
+			return "", fmt.Errorf("no file name in %s", u.Redacted())
+		}
+		if path.Ext(name) == "" && props != nil {
+			name += extensionByType(props.ContentType)
+		}
+		fileName = filepath.Join(fileName, name)
+	}
+
+	reader, err := ReadRange(ctx, u, 0, -1, opts)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Body.Close()
+	file, err := os.CreateTemp(filepath.Dir(fileName), "."+filepath.Base(fileName)+".*.part")
+	if err != nil {
+		return "", fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	_, err = copyBuffered(file, reader.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", u.Redacted(), err)
+	}
+	if err := os.Rename(file.Name(), fileName); err != nil {
+		return "", err
+	}
+
+	if props != nil && props.Metadata[mtimeMetadataKey] != "" {
+		mtime, err := time.Parse(time.RFC3339Nano, props.Metadata[mtimeMetadataKey])
+		if err != nil {
+			return fileName, fmt.Errorf("invalid modification time in metadata: %w", err)
+		}
+		if err := os.Chtimes(fileName, time.Time{}, mtime); err != nil {
+			return fileName, fmt.Errorf("failed to restore modification time: %w", err)
+		}
+	}
+	return fileName, nil
+}
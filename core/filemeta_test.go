@@ -0,0 +1,44 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreserveFileMetadata(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "720p.mp4")
+	require.NoError(t, os.WriteFile(input, []byte("rendition"), 0644))
+	mtime := time.Date(2024, 3, 1, 12, 30, 5, 123000000, time.UTC)
+	require.NoError(t, os.Chtimes(input, mtime, mtime))
+
+	u := mustParseURL("memory-s3://filemeta/vod/123/720p")
+	_, err := UploadFiles([]string{input}, u, UploadOptions{PreserveFileMetadata: true, WriteTimeout: time.Second})
+	require.NoError(t, err)
+	obj, ok := memoryS3.server.Object("filemeta", "vod/123/720p")
+	require.True(t, ok)
+	require.Equal(t, "2024-03-01T12:30:05.123Z", obj.Metadata[mtimeMetadataKey])
+	require.Equal(t, "video/mp4", obj.ContentType)
+
+	restored := filepath.Join(dir, "restored")
+	require.NoError(t, os.Mkdir(restored, 0755))
+	fileName, err := DownloadFile(context.Background(), u, restored, true, UploadOptions{})
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(restored, "720p.mp4"), fileName)
+	data, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	require.Equal(t, "rendition", string(data))
+	info, err := os.Stat(fileName)
+	require.NoError(t, err)
+	require.True(t, mtime.Equal(info.ModTime()))
+
+	// without the option the file is downloaded as it is named
+	fileName, err = DownloadFile(context.Background(), u, restored, false, UploadOptions{})
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(restored, "720p"), fileName)
+}
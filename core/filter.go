@@ -0,0 +1,76 @@
+package core
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// tempFileSuffixes mark files that encoders and downloaders are still writing
+var tempFileSuffixes = []string{".tmp", ".part", ".partial", "~"}
+
+// FileFilter selects the files of glob inputs and tar streams that are uploaded, by their relative path. Hidden
+// files and directories, whose names start with a dot, and temporary files ending in .tmp, .part, .partial or ~
+// are skipped unless IncludeHidden is set, so that files an encoder is still writing are never uploaded.
+type FileFilter struct {
+	// Include, if set, only selects files matching one of the patterns
+	Include []string
+	// Exclude skips files matching one of the patterns, even if they match Include
+	Exclude []string
+	// IncludeHidden also selects hidden and temporary files
+	IncludeHidden bool
+}
+
+// Validate checks the syntax of the patterns. They use the path.Match syntax plus ** matching any number of
+// directories, and patterns without a / match the base name of files in any directory, e.g. *.m4s.
+func (f FileFilter) Validate() error {
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		for _, part := range strings.Split(pattern, "/") {
+			if _, err := path.Match(part, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Match reports whether the file at a slash separated relative path is selected
+func (f FileFilter) Match(relativePath string) bool {
+	elems := strings.Split(path.Clean(relativePath), "/")
+	if !f.IncludeHidden && isHiddenOrTemp(elems) {
+		return false
+	}
+	if len(f.Include) > 0 && !matchAnyFilter(f.Include, elems) {
+		return false
+	}
+	return !matchAnyFilter(f.Exclude, elems)
+}
+
+func isHiddenOrTemp(elems []string) bool {
+	for _, elem := range elems {
+		if strings.HasPrefix(elem, ".") && elem != "." && elem != ".." {
+			return true
+		}
+	}
+	for _, suffix := range tempFileSuffixes {
+		if strings.HasSuffix(elems[len(elems)-1], suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAnyFilter(patterns []string, elems []string) bool {
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, elems[len(elems)-1]); ok {
+				return true
+			}
+			continue
+		}
+		if matchGlob(strings.Split(path.Clean(pattern), "/"), elems) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileFilter(t *testing.T) {
+	var filter FileFilter
+	require.True(t, filter.Match("720p/1.m4s"))
+	require.False(t, filter.Match("720p/1.m4s.part"))
+	require.False(t, filter.Match("720p/.1.m4s"))
+	require.False(t, filter.Match(".cache/1.m4s"))
+	require.False(t, filter.Match("index.m3u8.tmp"))
+	filter.IncludeHidden = true
+	require.True(t, filter.Match("720p/1.m4s.part"))
+
+	filter = FileFilter{Include: []string{"*.m4s", "audio/**"}, Exclude: []string{"720p/*"}}
+	require.NoError(t, filter.Validate())
+	require.True(t, filter.Match("1080p/1.m4s"))
+	require.True(t, filter.Match("audio/en/init.mp4"))
+	require.False(t, filter.Match("1080p/init.mp4"))
+	require.False(t, filter.Match("720p/1.m4s"))
+
+	require.ErrorContains(t, FileFilter{Exclude: []string{"720p/["}}.Validate(), "invalid pattern")
+}
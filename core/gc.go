@@ -0,0 +1,74 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// ExpiredObject is an object deleted by CollectGarbage, or that failed to be
+type ExpiredObject struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	// Deleted is unset for dry runs, and Error is set when the deletion failed
+	Deleted bool   `json:"deleted"`
+	Error   string `json:"error,omitempty"`
+}
+
+// GCOptions configure CollectGarbage
+type GCOptions struct {
+	// OlderThan is how long ago objects must have been last modified to be deleted
+	OlderThan time.Duration
+	// Filter selects the objects that may be deleted
+	Filter FileFilter
+	// DryRun only reports the objects that would be deleted
+	DryRun bool
+	// Parallel is the number of objects deleted at once
+	Parallel int
+}
+
+// CollectGarbage deletes the objects under prefix that were last modified more than OlderThan ago, for storages
+// without lifecycle rules to expire recordings. Objects whose storage doesn't report when they were modified are
+// never deleted. It returns the expired objects sorted by key. Failed deletions don't stop the others.
+func CollectGarbage(ctx context.Context, prefix *url.URL, gcOpts GCOptions, opts UploadOptions) ([]*ExpiredObject, error) {
+	if gcOpts.OlderThan <= 0 {
+		return nil, fmt.Errorf("invalid age %s, expected a positive duration", gcOpts.OlderThan)
+	}
+	objects, err := listObjects(ctx, prefix, opts)
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-gcOpts.OlderThan)
+	var expired []*ExpiredObject
+	for _, obj := range objects {
+		if obj.LastModified.IsZero() || !obj.LastModified.Before(cutoff) || !gcOpts.Filter.Match(obj.Key) {
+			continue
+		}
+		expired = append(expired, &ExpiredObject{Key: obj.Key, Size: obj.Size, LastModified: obj.LastModified})
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].Key < expired[j].Key })
+	if gcOpts.DryRun {
+		return expired, nil
+	}
+
+	errGroup := &errgroup.Group{}
+	errGroup.SetLimit(max(gcOpts.Parallel, 1))
+	for _, obj := range expired {
+		obj := obj
+		errGroup.Go(func() error {
+			if err := deleteObject(ctx, prefix.JoinPath(obj.Key), opts); err != nil {
+				obj.Error = err.Error()
+			} else {
+				obj.Deleted = true
+			}
+			return nil
+		})
+	}
+	_ = errGroup.Wait()
+	return expired, nil
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-200 * time.Hour)
+	for name, mtime := range map[string]time.Time{"123/720p/1.ts": old, "123/index.m3u8": old, "123/keep.json": old, "456/index.m3u8": time.Now()} {
+		fileName := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(fileName), 0755))
+		require.NoError(t, os.WriteFile(fileName, []byte("data"), 0644))
+		require.NoError(t, os.Chtimes(fileName, mtime, mtime))
+	}
+	prefix := &url.URL{Scheme: "file", Path: dir}
+	gcOpts := GCOptions{OlderThan: 168 * time.Hour, Filter: FileFilter{Exclude: []string{"*.json"}}, DryRun: true}
+
+	expired, err := CollectGarbage(context.Background(), prefix, gcOpts, UploadOptions{})
+	require.NoError(t, err)
+	require.Len(t, expired, 2)
+	require.Equal(t, "123/720p/1.ts", expired[0].Key)
+	require.Equal(t, "123/index.m3u8", expired[1].Key)
+	require.False(t, expired[0].Deleted)
+	require.FileExists(t, filepath.Join(dir, "123", "index.m3u8"))
+
+	gcOpts.DryRun = false
+	gcOpts.Parallel = 2
+	expired, err = CollectGarbage(context.Background(), prefix, gcOpts, UploadOptions{})
+	require.NoError(t, err)
+	require.Len(t, expired, 2)
+	for _, obj := range expired {
+		require.True(t, obj.Deleted, obj.Key)
+		require.NoFileExists(t, filepath.Join(dir, filepath.FromSlash(obj.Key)))
+	}
+	require.FileExists(t, filepath.Join(dir, "123", "keep.json"))
+	require.FileExists(t, filepath.Join(dir, "456", "index.m3u8"))
+
+	_, err = CollectGarbage(context.Background(), prefix, GCOptions{}, UploadOptions{})
+	require.Error(t, err)
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// InputFile is a file to upload with its path relative to the glob pattern it matched, which is the key it
+// gets under a destination that isn't a template
+type InputFile struct {
+	Path         string
+	RelativePath string
+}
+
+// IsGlob reports whether an input file name is a glob pattern, see ExpandInputGlobs
+func IsGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// HasGlob reports whether any of the input file names is a glob pattern
+func HasGlob(patterns []string) bool {
+	for _, pattern := range patterns {
+		if IsGlob(pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpandInputGlobs expands the glob patterns among the input file names, in order, into the regular files they
+// match in lexical order. Patterns use the path.Match syntax, plus ** matching any number of directories, e.g.
+// renditions/**/*.m4s. The relative path of each match starts after the pattern's leading directories without
+// wildcards, so that renditions/720p/1.m4s is 720p/1.m4s. Inputs that aren't patterns are kept as they are
+// with their base name as the relative path. Patterns that match nothing are an error, while the files they
+// match are only kept if selected by the filter.
+func ExpandInputGlobs(patterns []string, filter FileFilter) ([]InputFile, error) {
+	var files []InputFile
+	for _, pattern := range patterns {
+		if !IsGlob(pattern) {
+			files = append(files, InputFile{Path: pattern, RelativePath: filepath.Base(pattern)})
+			continue
+		}
+		matches, err := expandGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %q", pattern)
+		}
+		for _, match := range matches {
+			if filter.Match(match.RelativePath) {
+				files = append(files, match)
+			}
+		}
+	}
+	return files, nil
+}
+
+func expandGlob(pattern string) ([]InputFile, error) {
+	parts := strings.Split(path.Clean(filepath.ToSlash(pattern)), "/")
+	base := 0
+	for base < len(parts)-1 && !IsGlob(parts[base]) {
+		base++
+	}
+	root := filepath.FromSlash(strings.Join(parts[:base], "/"))
+	switch {
+	case base == 0:
+		root = "."
+	case root == "":
+		// the pattern is absolute and its first element has wildcards
+		root = string(filepath.Separator)
+	}
+	parts = parts[base:]
+	for _, part := range parts {
+		if _, err := path.Match(part, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	recursive := false
+	for _, part := range parts {
+		recursive = recursive || part == "**"
+	}
+
+	var files []InputFile
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			// without ** nothing deeper than the pattern can match
+			if !recursive && strings.Count(rel, "/")+1 >= len(parts) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && matchGlob(parts, strings.Split(rel, "/")) {
+			files = append(files, InputFile{Path: p, RelativePath: rel})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand %q: %w", pattern, err)
+	}
+	return files, nil
+}
+
+// matchGlob matches the elements of a path against those of a pattern, where ** matches any number of elements
+func matchGlob(pattern, elems []string) bool {
+	if len(pattern) == 0 {
+		return len(elems) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(elems); i++ {
+			if matchGlob(pattern[1:], elems[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(elems) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], elems[0])
+	return ok && matchGlob(pattern[1:], elems[1:])
+}
+
+// BatchDestination is where an input file is uploaded in a batch: the destination template expanded for it,
+// or its relative path joined onto the destination if that isn't a template
+func BatchDestination(destination string, file InputFile, index int) (*url.URL, error) {
+	return relativeDestination(destination, file.RelativePath, index)
+}
@@ -0,0 +1,49 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandInputGlobs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"720p/1.m4s", "720p/init.mp4", "1080p/1.m4s", "1080p/audio/1.m4s", "top.m4s"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+	relativePaths := func(files []InputFile) []string {
+		var paths []string
+		for _, file := range files {
+			paths = append(paths, file.RelativePath)
+		}
+		return paths
+	}
+
+	files, err := ExpandInputGlobs([]string{filepath.Join(dir, "**", "*.m4s")}, FileFilter{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"1080p/1.m4s", "1080p/audio/1.m4s", "720p/1.m4s", "top.m4s"}, relativePaths(files))
+	require.Equal(t, filepath.Join(dir, "1080p", "1.m4s"), files[0].Path)
+
+	files, err = ExpandInputGlobs([]string{filepath.Join(dir, "*", "*.m4s"), filepath.Join(dir, "720p", "init.mp4")}, FileFilter{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"1080p/1.m4s", "720p/1.m4s", "init.mp4"}, relativePaths(files))
+
+	_, err = ExpandInputGlobs([]string{filepath.Join(dir, "**", "*.ts")}, FileFilter{})
+	require.ErrorContains(t, err, "no files match")
+	_, err = ExpandInputGlobs([]string{filepath.Join(dir, "[")}, FileFilter{})
+	require.ErrorContains(t, err, "invalid pattern")
+}
+
+func TestBatchDestination(t *testing.T) {
+	file := InputFile{Path: "renditions/720p/1.m4s", RelativePath: "720p/1.m4s"}
+	uri, err := BatchDestination("s3://key:secret@eu-west-1/bucket/vod/123/", file, 0)
+	require.NoError(t, err)
+	require.Equal(t, "/bucket/vod/123/720p/1.m4s", uri.Path)
+
+	uri, err = BatchDestination("s3://key:secret@eu-west-1/bucket/flat/{index}-{basename}", file, 2)
+	require.NoError(t, err)
+	require.Equal(t, "/bucket/flat/2-1.m4s", uri.Path)
+}
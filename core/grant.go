@@ -0,0 +1,110 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/livepeer/go-tools/drivers"
+)
+
+// postPolicyTimeFormat is the format of the expiration of POST policies
+const postPolicyTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// ParseGrantCondition parses a condition of a POST policy given as JSON, e.g. ["content-length-range", 0, 1048576]
+func ParseGrantCondition(s string) (interface{}, error) {
+	var condition interface{}
+	if err := json.Unmarshal([]byte(s), &condition); err != nil {
+		return nil, fmt.Errorf("invalid policy condition %q: %w", s, err)
+	}
+	switch condition.(type) {
+	case []interface{}, map[string]interface{}:
+		return condition, nil
+	}
+	return nil, fmt.Errorf("invalid policy condition %q: expected a JSON array or object", s)
+}
+
+// GrantPostPolicy signs a POST policy giving write access to the keys under u, an S3 or S3-compatible URL with
+// credentials, for ttl, and returns it as the drivers.OSInfo of an S3 session, which other nodes turn back into a
+// session uploading with the policy with drivers.NewSession. The keys must start with the path of u after the
+// bucket, as a directory, and the uploads set their Content-Type and the public-read ACL, the fields the POST
+// uploads of the drivers send. conditions are added to the policy as given.
+func GrantPostPolicy(u *url.URL, ttl time.Duration, conditions []interface{}) (*drivers.OSInfo, error) {
+	if ttl <= 0 {
+		return nil, errors.New("grant TTL must be positive")
+	}
+	dest, err := parseS3URL(u)
+	if err != nil {
+		return nil, fmt.Errorf("grants are only supported for S3 destinations: %w", err)
+	}
+	key := joinKey(dest.key, "")
+	keyCondition := key
+	if keyCondition != "" && !strings.HasSuffix(keyCondition, "/") {
+		keyCondition += "/"
+	}
+
+	now := time.Now().UTC()
+	date := now.Format("20060102")
+	xAmzDate := now.Format("20060102T150405Z")
+	credential := fmt.Sprintf("%s/%s/%s/s3/aws4_request", dest.accessKey, date, dest.region)
+	policy, err := json.Marshal(map[string]interface{}{
+		"expiration": now.Add(ttl).Format(postPolicyTimeFormat),
+		"conditions": append([]interface{}{
+			map[string]string{"bucket": dest.bucket},
+			map[string]string{"acl": "public-read"},
+			[]string{"starts-with", "$Content-Type", ""},
+			[]string{"starts-with", "$key", keyCondition},
+			map[string]string{"x-amz-algorithm": "AWS4-HMAC-SHA256"},
+			map[string]string{"x-amz-credential": credential},
+			map[string]string{"x-amz-date": xAmzDate},
+		}, conditions...),
+	})
+	if err != nil {
+		return nil, err
+	}
+	encodedPolicy := base64.StdEncoding.EncodeToString(policy)
+
+	signingKey := sigV4SigningKey(dest.secretKey, date, dest.region, "s3")
+	return &drivers.OSInfo{
+		StorageType: drivers.OSInfo_S3,
+		S3Info: &drivers.S3OSInfo{
+			Host:       dest.postURL(),
+			Bucket:     dest.bucket,
+			Key:        strings.TrimSuffix(key, "/"),
+			Policy:     encodedPolicy,
+			Signature:  hex.EncodeToString(hmacSHA256(signingKey, encodedPolicy)),
+			Credential: credential,
+			XAmzDate:   xAmzDate,
+		},
+	}, nil
+}
+
+// postURL is the host of the bucket in the drivers.S3OSInfo of its sessions, where the POST uploads go
+func (d *s3Destination) postURL() string {
+	if d.endpoint != "" {
+		return d.endpoint + "/" + d.bucket
+	}
+	return fmt.Sprintf("https://%s.s3.amazonaws.com", d.bucket)
+}
+
+// sigV4SigningKey derives the key signing the requests of a day to a service in a region with SigV4
+func sigV4SigningKey(secretKey, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	for _, s := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	return key
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
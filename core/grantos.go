@@ -0,0 +1,102 @@
+package core
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/livepeer/go-tools/drivers"
+)
+
+// LoadGrant reads the drivers.OSInfo of a POST policy, as printed by the grant subcommand, from r
+func LoadGrant(r io.Reader) (*drivers.OSInfo, error) {
+	var info drivers.OSInfo
+	if err := json.NewDecoder(r).Decode(&info); err != nil {
+		return nil, fmt.Errorf("failed to parse grant: %w", err)
+	}
+	if (info.StorageType != drivers.OSInfo_S3 && info.StorageType != drivers.OSInfo_GOOGLE) || info.S3Info == nil {
+		return nil, fmt.Errorf("unsupported grant storage type %d", info.StorageType)
+	}
+	if info.S3Info.Host == "" || info.S3Info.Policy == "" || info.S3Info.Signature == "" {
+		return nil, errors.New("grant is missing its host, policy or signature")
+	}
+	return &info, nil
+}
+
+// LoadGrantFD reads a grant from the file descriptor fd, e.g. a pipe set up by the parent process, so that it
+// never shows in the command line or the environment
+func LoadGrantFD(fd int) (*drivers.OSInfo, error) {
+	file := os.NewFile(uintptr(fd), fmt.Sprintf("fd %d", fd))
+	if file == nil {
+		return nil, fmt.Errorf("invalid grant file descriptor %d", fd)
+	}
+	defer file.Close()
+	return LoadGrant(file)
+}
+
+// checkGrantExpiry fails once the POST policy of a grant has expired, rather than letting the storage reject the
+// uploads. Policies whose expiration can't be read are left to the storage.
+func checkGrantExpiry(info *drivers.OSInfo) error {
+	data, err := base64.StdEncoding.DecodeString(info.S3Info.Policy)
+	if err != nil {
+		return nil
+	}
+	var policy struct {
+		Expiration string `json:"expiration"`
+	}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil
+	}
+	expiration, err := time.Parse(time.RFC3339, policy.Expiration)
+	if err != nil {
+		return nil
+	}
+	if time.Now().After(expiration) {
+		return fmt.Errorf("grant expired at %s", expiration.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// GrantOS is a driver uploading with the POST policy another node granted us, rather than with the keys of the
+// bucket. URLs have the form grant:///path/to/file, where the path is under the key prefix of the grant, and the
+// sessions are those drivers.NewSession creates from the grant. Objects can only be written: the policy gives
+// no access to read, list or delete them.
+type GrantOS struct {
+	info *drivers.OSInfo
+	path string
+}
+
+func NewGrantDriver(u *url.URL, info *drivers.OSInfo) (*GrantOS, error) {
+	if info == nil {
+		return nil, errors.New("a grant is required with grant:// OS")
+	}
+	if err := checkGrantExpiry(info); err != nil {
+		return nil, err
+	}
+	return &GrantOS{info: info, path: joinKey(info.S3Info.Key, strings.Trim(u.Path, "/"))}, nil
+}
+
+func (g *GrantOS) NewSession(path string) drivers.OSSession {
+	s3Info := *g.info.S3Info
+	s3Info.Key = joinKey(g.path, strings.Trim(path, "/"))
+	return drivers.NewSession(&drivers.OSInfo{StorageType: g.info.StorageType, S3Info: &s3Info})
+}
+
+func (g *GrantOS) Description() string {
+	return "Driver uploading with a POST policy granted by another node."
+}
+
+func (g *GrantOS) UriSchemes() []string {
+	return []string{"grant"}
+}
+
+func (g *GrantOS) Publish(ctx context.Context) (string, error) {
+	return "", drivers.ErrNotSupported
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-tools/drivers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadWithGrant(t *testing.T) {
+	s3URL, err := url.Parse(memoryS3URL(mustParseURL("memory-s3://granted/hls/123")))
+	require.NoError(t, err)
+	grant, err := GrantPostPolicy(s3URL, time.Hour, nil)
+	require.NoError(t, err)
+	// the grant goes through JSON between nodes
+	data, err := json.Marshal(grant)
+	require.NoError(t, err)
+	grant, err = LoadGrant(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	manifest := []byte("#EXTM3U\n")
+	_, err = Upload(bytes.NewReader(manifest), mustParseURL("grant:///720p/index.m3u8"), UploadOptions{Grant: grant, WriteTimeout: time.Second})
+	require.NoError(t, err)
+	obj, ok := memoryS3.server.Object("granted", "hls/123/720p/index.m3u8")
+	require.True(t, ok)
+	require.Equal(t, manifest, obj.Data)
+	require.Equal(t, "application/x-mpegurl", obj.ContentType)
+
+	_, err = Upload(bytes.NewReader(manifest), mustParseURL("grant:///index.m3u8"), UploadOptions{WriteTimeout: time.Second})
+	require.ErrorContains(t, err, "grant is required")
+
+	expired := *grant.S3Info
+	policy, err := json.Marshal(map[string]string{"expiration": time.Now().Add(-time.Minute).UTC().Format(postPolicyTimeFormat)})
+	require.NoError(t, err)
+	expired.Policy = base64.StdEncoding.EncodeToString(policy)
+	_, err = Upload(bytes.NewReader(manifest), mustParseURL("grant:///expired.m3u8"), UploadOptions{Grant: &drivers.OSInfo{StorageType: drivers.OSInfo_S3, S3Info: &expired}, WriteTimeout: time.Second})
+	require.ErrorContains(t, err, "grant expired")
+	_, ok = memoryS3.server.Object("granted", "hls/123/expired.m3u8")
+	require.False(t, ok)
+
+	_, err = LoadGrant(bytes.NewReader([]byte(`{"storageType": 0}`)))
+	require.Error(t, err)
+}
@@ -0,0 +1,116 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/golang/glog"
+)
+
+// EndpointHealth records the primary destinations that recently failed in StateDir, shared by all uploader
+// processes using it, so that successive invocations go straight to the backup of a destination known to be down
+// instead of each waiting for it to time out. A destination is taken to be down for DownFor after an upload to it
+// failed, see markDown, after which the next upload tries it again, and it's up again as soon as an upload to it succeeds.
+// Destinations are told apart like for RetryBudget, by storage host and S3 bucket.
+type EndpointHealth struct {
+	StateDir string
+	DownFor  time.Duration
+}
+
+// endpointFailure is a failed destination persisted in EndpointHealth.StateDir
+type endpointFailure struct {
+	Endpoint string    `json:"endpoint"`
+	FailedAt time.Time `json:"failed_at"`
+	Error    string    `json:"error"`
+}
+
+func (h *EndpointHealth) entry(endpoint string) string {
+	sum := sha256.Sum256([]byte(endpoint))
+	return filepath.Join(h.StateDir, hex.EncodeToString(sum[:8])+".json")
+}
+
+// down returns the failure of the destination of u if it failed within DownFor
+func (h *EndpointHealth) down(u *url.URL) *endpointFailure {
+	if h == nil || h.DownFor <= 0 {
+		return nil
+	}
+	endpoint := retryBudgetKey(u)
+	data, err := os.ReadFile(h.entry(endpoint))
+	if err != nil {
+		return nil
+	}
+	var failure endpointFailure
+	if err := json.Unmarshal(data, &failure); err != nil || failure.Endpoint != endpoint || time.Since(failure.FailedAt) >= h.DownFor {
+		return nil
+	}
+	return &failure
+}
+
+// markDown records that an upload to u failed with err, if err shows the destination is failing: it can't be
+// reached, timed out or answered with a server error, e.g. the 503 SlowDown of S3. Client errors such as denied
+// access or an invalid key are the request's, so they leave the destination up.
+func (h *EndpointHealth) markDown(u *url.URL, uploadErr error) {
+	if h == nil || h.DownFor <= 0 || !isEndpointFailure(uploadErr) {
+		return
+	}
+	endpoint := retryBudgetKey(u)
+	if err := h.write(endpoint, endpointFailure{Endpoint: endpoint, FailedAt: time.Now().UTC(), Error: uploadErr.Error()}); err != nil {
+		glog.Errorf("Failed to record that %s is down: %v", endpoint, err)
+	}
+}
+
+func (h *EndpointHealth) write(endpoint string, failure endpointFailure) error {
+	if err := os.MkdirAll(h.StateDir, 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	data, err := json.Marshal(failure)
+	if err != nil {
+		return err
+	}
+	// written to a temp file and renamed so that concurrent uploaders never read half an entry
+	tmp, err := os.CreateTemp(h.StateDir, ".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), h.entry(endpoint))
+}
+
+// markUp forgets a failure of the destination of u once an upload to it succeeded
+func (h *EndpointHealth) markUp(u *url.URL) {
+	if h == nil || h.DownFor <= 0 {
+		return
+	}
+	endpoint := retryBudgetKey(u)
+	if err := os.Remove(h.entry(endpoint)); err == nil {
+		glog.Infof("%s is up again", endpoint)
+	}
+}
+
+// isEndpointFailure reports whether the error of an upload is a failure of its destination, see markDown
+func isEndpointFailure(err error) bool {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == "SlowDown" {
+		return true
+	}
+	switch attemptClass(err) {
+	case AttemptUnreachable, AttemptTimeout, AttemptServerError:
+		return true
+	}
+	return false
+}
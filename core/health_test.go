@@ -0,0 +1,77 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndpointHealth(t *testing.T) {
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "input.ts")
+	require.NoError(t, os.WriteFile(testFile, []byte("segment"), 0644))
+	// the primary refuses connections until a server is started at its address
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+	primary := "bunny+http://zone:key@" + addr + "/"
+	backup := "file://" + filepath.ToSlash(filepath.Join(dir, "backup")) + "/"
+	health := &EndpointHealth{StateDir: filepath.Join(dir, "health"), DownFor: time.Minute}
+	opts := UploadOptions{StorageFallbackURLs: map[string]string{primary: backup}, EndpointHealth: health}
+
+	out, _, err := uploadFileWithBackup(mustParseURL(primary+"1.ts"), testFile, nil, time.Second, false, opts)
+	require.NoError(t, err)
+	require.True(t, out.Fallback)
+	require.NotNil(t, health.down(mustParseURL(primary)))
+
+	// the primary is skipped while it's down, even if it works again
+	fake, files := newFakeBunny(t)
+	srv := httptest.NewUnstartedServer(fake.Config.Handler)
+	srv.Listener.Close()
+	srv.Listener, err = net.Listen("tcp", addr)
+	require.NoError(t, err)
+	srv.Start()
+	t.Cleanup(srv.Close)
+	out, _, err = uploadFileWithBackup(mustParseURL(primary+"2.ts"), testFile, nil, time.Second, false, opts)
+	require.NoError(t, err)
+	require.True(t, out.Fallback)
+	require.NotContains(t, files, "2.ts")
+	require.FileExists(t, filepath.Join(dir, "backup", "2.ts"))
+
+	// and tried again once DownFor has passed
+	opts.EndpointHealth = &EndpointHealth{StateDir: health.StateDir, DownFor: time.Nanosecond}
+	out, _, err = uploadFileWithBackup(mustParseURL(primary+"3.ts"), testFile, nil, time.Second, false, opts)
+	require.NoError(t, err)
+	require.False(t, out.Fallback)
+	require.Contains(t, files, "3.ts")
+	require.Nil(t, health.down(mustParseURL(primary)))
+
+	// a client error falls back without marking the primary down
+	denied := "bunny+http://zone:wrong@" + addr + "/"
+	opts = UploadOptions{StorageFallbackURLs: map[string]string{denied: backup}, EndpointHealth: health}
+	out, _, err = uploadFileWithBackup(mustParseURL(denied+"4.ts"), testFile, nil, time.Second, false, opts)
+	require.NoError(t, err)
+	require.True(t, out.Fallback)
+	require.Nil(t, health.down(mustParseURL(denied)))
+}
+
+func TestIsEndpointFailure(t *testing.T) {
+	require.True(t, isEndpointFailure(&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}))
+	require.True(t, isEndpointFailure(&net.DNSError{Err: "no such host", Name: "storage.example.com", IsNotFound: true}))
+	require.True(t, isEndpointFailure(fmt.Errorf("save: %w", context.DeadlineExceeded)))
+	require.True(t, isEndpointFailure(awserr.NewRequestFailure(awserr.New("InternalError", "internal error", nil), 500, "req")))
+	require.True(t, isEndpointFailure(awserr.NewRequestFailure(awserr.New("SlowDown", "reduce your request rate", nil), 503, "req")))
+	require.False(t, isEndpointFailure(awserr.NewRequestFailure(awserr.New("AccessDenied", "access denied", nil), 403, "req")))
+	require.False(t, isEndpointFailure(awserr.NewRequestFailure(awserr.New("NoSuchBucket", "no such bucket", nil), 404, "req")))
+	require.False(t, isEndpointFailure(context.Canceled))
+}
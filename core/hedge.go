@@ -0,0 +1,106 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-tools/drivers"
+)
+
+// hedgedAttempt is the outcome of the primary or the backup upload of uploadHedged
+type hedgedAttempt struct {
+	out     *drivers.SaveDataOutput
+	written int64
+	err     error
+	backup  bool
+}
+
+// uploadHedged uploads to the primary and, once that has read nothing more of its input for
+// UploadOptions.HedgeDelay or has failed, to the backup as well, so that a primary brownout doesn't hold up
+// manifest writes. The first upload to complete is kept and the other one is canceled. It is waited for before
+// returning, so that it neither holds on to the limiter slot of the upload nor reads its file once the caller
+// deletes it, but it may still leave an object at the slower destination.
+func uploadHedged(primaryURI, backupURI *url.URL, fileName string, fields *drivers.FileProperties, writeTimeout time.Duration, withRetries bool, opts UploadOptions) (*UploadResult, int64, error) {
+	parent := opts.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	var running sync.WaitGroup
+	defer func() {
+		cancel()
+		running.Wait()
+	}()
+	attempts := make(chan hedgedAttempt, 2)
+	upload := func(u *url.URL, backup bool, opts UploadOptions) {
+		defer running.Done()
+		opts.ctx = ctx
+		out, written, err := uploadFile(u, fileName, fields, writeTimeout, withRetries, opts)
+		attempts <- hedgedAttempt{out: out, written: written, err: err, backup: backup}
+	}
+	var lastProgress atomic.Int64
+	lastProgress.Store(time.Now().UnixNano())
+	primaryOpts := opts
+	primaryOpts.onProgress = func() { lastProgress.Store(time.Now().UnixNano()) }
+	running.Add(1)
+	go upload(primaryURI, false, primaryOpts)
+
+	timer := time.NewTimer(opts.HedgeDelay)
+	defer timer.Stop()
+	hedged := false
+	pending := 1
+	var primaryErr, backupErr error
+	var bytesWritten int64
+	for {
+		select {
+		case attempt := <-attempts:
+			pending--
+			if !attempt.backup {
+				if attempt.err == nil {
+					opts.EndpointHealth.markUp(primaryURI)
+				} else {
+					opts.EndpointHealth.markDown(primaryURI, attempt.err)
+				}
+			}
+			if attempt.err == nil {
+				if attempt.backup {
+					return &UploadResult{SaveDataOutput: *attempt.out, Fallback: true, BackupURI: backupURI}, attempt.written, nil
+				}
+				return &UploadResult{SaveDataOutput: *attempt.out}, attempt.written, nil
+			}
+			bytesWritten = attempt.written
+			if attempt.backup {
+				backupErr = attempt.err
+			} else {
+				primaryErr = attempt.err
+			}
+			if !hedged {
+				glog.Warningf("Primary upload failed, uploading to backupURL=%s primaryErr=%q", backupURI.Redacted(), primaryErr)
+				hedged = true
+				pending++
+				running.Add(1)
+				go upload(backupURI, true, opts)
+			} else if pending == 0 {
+				return nil, bytesWritten, fmt.Errorf("upload file errors: primary: %w; backup: %w", primaryErr, backupErr)
+			}
+		case <-timer.C:
+			if hedged {
+				continue
+			}
+			if idle := time.Since(time.Unix(0, lastProgress.Load())); idle < opts.HedgeDelay {
+				timer.Reset(opts.HedgeDelay - idle)
+				continue
+			}
+			glog.Warningf("Primary upload to %s made no progress for %s, also uploading to backupURL=%s", primaryURI.Redacted(), opts.HedgeDelay, backupURI.Redacted())
+			hedged = true
+			pending++
+			running.Add(1)
+			go upload(backupURI, true, opts)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadHedged(t *testing.T) {
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "index.m3u8")
+	require.NoError(t, os.WriteFile(testFile, []byte("#EXTM3U\n"), 0644))
+	backup := "file://" + filepath.ToSlash(filepath.Join(dir, "backup")) + "/"
+
+	// a primary in a brownout, which accepts connections but never answers
+	stalled := make(chan struct{})
+	canceled := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-stalled:
+		case <-r.Context().Done():
+			close(canceled)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(stalled) })
+	primary := "bunny+http://zone:key@" + strings.TrimPrefix(srv.URL, "http://") + "/"
+	opts := UploadOptions{StorageFallbackURLs: map[string]string{primary: backup}, HedgeDelay: 100 * time.Millisecond}
+	start := time.Now()
+	out, _, err := uploadFileWithBackup(mustParseURL(primary+"hls/index.m3u8"), testFile, nil, time.Minute, false, opts)
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), 5*time.Second)
+	require.True(t, out.Fallback)
+	require.Equal(t, backup+"hls/index.m3u8", out.BackupURI.String())
+	data, err := os.ReadFile(filepath.Join(dir, "backup", "hls", "index.m3u8"))
+	require.NoError(t, err)
+	require.Equal(t, "#EXTM3U\n", string(data))
+	// the primary upload was stopped rather than left running
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the primary upload wasn't canceled")
+	}
+
+	// a primary completing in time isn't hedged
+	fake, files := newFakeBunny(t)
+	primary = "bunny+http://zone:key@" + strings.TrimPrefix(fake.URL, "http://") + "/"
+	opts.StorageFallbackURLs = map[string]string{primary: backup}
+	out, _, err = uploadFileWithBackup(mustParseURL(primary+"hls/live.m3u8"), testFile, nil, time.Minute, false, opts)
+	require.NoError(t, err)
+	require.False(t, out.Fallback)
+	require.Equal(t, []byte("#EXTM3U\n"), files["hls/live.m3u8"])
+	require.NoFileExists(t, filepath.Join(dir, "backup", "hls", "live.m3u8"))
+}
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// manifestHistoryTimeFormat names manifest versions so that they sort by the time they were written
+const manifestHistoryTimeFormat = "20060102T150405.000Z"
+
+// manifestHistoryDir is where the versions of a manifest are kept, e.g. hls/index.m3u8.history/ for hls/index.m3u8
+func manifestHistoryDir(manifestURI *url.URL) *url.URL {
+	dir := *manifestURI
+	dir.Path += ".history/"
+	dir.RawPath = ""
+	return &dir
+}
+
+// manifestHistoryURI is where the version of a manifest written at t is kept
+func manifestHistoryURI(manifestURI *url.URL, t time.Time) *url.URL {
+	return manifestHistoryDir(manifestURI).JoinPath(t.UTC().Format(manifestHistoryTimeFormat) + path.Ext(manifestURI.Path))
+}
+
+// writeManifestHistory writes the manifest in fileName to a timestamped key next to the live one and deletes
+// all but the latest opts.ManifestHistory versions. Failures are only logged, since the live manifest was written.
+func writeManifestHistory(manifestURI *url.URL, fileName string, opts UploadOptions) {
+	if opts.ManifestHistory <= 0 {
+		return
+	}
+	versionURI := manifestHistoryURI(manifestURI, time.Now())
+	if _, _, err := uploadFileWithBackup(versionURI, fileName, manifestFileProperties(), opts.WriteTimeout, false, opts); err != nil {
+		glog.Errorf("Failed to write manifest version %s: %v", versionURI.Redacted(), err)
+		return
+	}
+	if err := pruneManifestHistory(manifestURI, opts.ManifestHistory, opts); err != nil {
+		glog.Errorf("Failed to delete old versions of %s: %v", manifestURI.Redacted(), err)
+	}
+}
+
+// pruneManifestHistory deletes all but the latest keep versions of a manifest
+func pruneManifestHistory(manifestURI *url.URL, keep int, opts UploadOptions) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSaveTimeout)
+	defer cancel()
+	dir := manifestHistoryDir(manifestURI)
+	session, err := newSession(dir, opts)
+	if err != nil {
+		return err
+	}
+	// without a delimiter, since S3 sessions list the directory key itself as a prefix rather than its contents
+	page, err := session.ListFiles(ctx, "", "")
+	if err != nil {
+		return fmt.Errorf("failed to list versions: %w", err)
+	}
+	var versions []string
+	for {
+		for _, file := range page.Files() {
+			// some drivers list names relative to the directory, others whole keys
+			if name := path.Base(file.Name); strings.HasSuffix(name, path.Ext(manifestURI.Path)) {
+				versions = append(versions, name)
+			}
+		}
+		if !page.HasNextPage() {
+			break
+		}
+		if page, err = page.NextPage(); err != nil {
+			return fmt.Errorf("failed to list versions: %w", err)
+		}
+	}
+	if len(versions) <= keep {
+		return nil
+	}
+	sort.Strings(versions)
+	for _, name := range versions[:len(versions)-keep] {
+		if err := deleteObject(ctx, dir.JoinPath(name), opts); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestHistoryURI(t *testing.T) {
+	u := manifestHistoryURI(mustParseURL("s3://key:secret@eu-west-1/bucket/hls/index.m3u8"), time.Date(2024, 3, 1, 12, 30, 5, 123e6, time.UTC))
+	require.Equal(t, "s3://key:secret@eu-west-1/bucket/hls/index.m3u8.history/20240301T123005.123Z.m3u8", u.String())
+}
+
+func TestManifestHistory(t *testing.T) {
+	dir := t.TempDir()
+	output := mustParseURL(filepath.ToSlash(filepath.Join(dir, "hls", "index.m3u8")))
+	opts := UploadOptions{WriteTimeout: time.Second, ManifestHistory: 3}
+	var manifests []string
+	for i := 0; i < 5; i++ {
+		manifest := "#EXTM3U\n" + strings.Repeat("#EXTINF:2.000,\nseg.ts\n", i)
+		manifests = append(manifests, manifest)
+		_, err := Upload(strings.NewReader(manifest), output, opts)
+		require.NoError(t, err)
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "hls", "index.m3u8.history"))
+	require.NoError(t, err)
+	var versions []string
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, "hls", "index.m3u8.history", entry.Name()))
+		require.NoError(t, err)
+		versions = append(versions, entry.Name())
+		require.Contains(t, manifests[2:], string(data))
+	}
+	require.Len(t, versions, 3)
+	require.True(t, sort.StringsAreSorted(versions))
+	data, err := os.ReadFile(filepath.Join(dir, "hls", "index.m3u8.history", versions[2]))
+	require.NoError(t, err)
+	require.Equal(t, manifests[4], string(data))
+}
+
+func TestManifestHistoryMemoryS3(t *testing.T) {
+	output := mustParseURL("memory-s3://history-test/hls/index.m3u8")
+	opts := UploadOptions{WriteTimeout: time.Second, ManifestHistory: 2}
+	for i := 0; i < 4; i++ {
+		_, err := Upload(strings.NewReader("#EXTM3U\n"+strings.Repeat("seg.ts\n", i)), output, opts)
+		require.NoError(t, err)
+		time.Sleep(2 * time.Millisecond)
+	}
+	session, err := newSession(manifestHistoryDir(output), opts)
+	require.NoError(t, err)
+	page, err := session.ListFiles(context.Background(), "", "")
+	require.NoError(t, err)
+	require.Len(t, page.Files(), 2)
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/golang/glog"
+	"github.com/livepeer/go-tools/drivers"
+)
+
+// idempotencyMetadataKey is the object metadata holding the idempotency key of the upload that wrote it
+const idempotencyMetadataKey = "Idempotency-Key"
+
+// supportsIdempotencyKeys reports whether objects at u can carry the idempotency key in their metadata
+func supportsIdempotencyKeys(u *url.URL) bool {
+	return u.Scheme == "memory-s3" || isS3URL(u) || isSpacesURL(u) || u.Scheme == "gs"
+}
+
+// alreadyUploaded reports whether the object at u was written by an upload with the same idempotency key,
+// in which case a retried upload can be skipped
+func alreadyUploaded(u *url.URL, opts UploadOptions) (bool, error) {
+	if opts.IdempotencyKey == "" || opts.Replay != nil {
+		return false, nil
+	}
+	if !supportsIdempotencyKeys(u) {
+		glog.Warningf("Idempotency keys aren't supported for %s objects, uploading %s unconditionally", u.Scheme, u.Redacted())
+		return false, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSaveTimeout)
+	defer cancel()
+	key, err := storedIdempotencyKey(ctx, u)
+	if err != nil {
+		return false, fmt.Errorf("failed to check idempotency key of %s: %w", u.Redacted(), err)
+	}
+	if key != opts.IdempotencyKey {
+		return false, nil
+	}
+	glog.Infof("%s was already uploaded with idempotency key %s", u.Redacted(), opts.IdempotencyKey)
+	return true, nil
+}
+
+// storedIdempotencyKey returns the idempotency key in the metadata of the object at u, or an empty string if
+// the object doesn't exist or has none
+func storedIdempotencyKey(ctx context.Context, u *url.URL) (string, error) {
+	props, exists, err := headObject(ctx, u)
+	if !exists {
+		return "", err
+	}
+	return props.Metadata[idempotencyMetadataKey], nil
+}
+
+// headObject returns the content type and metadata of the object at u and whether it exists, for the storages
+// whose properties can be read without fetching the object
+func headObject(ctx context.Context, u *url.URL) (*drivers.FileProperties, bool, error) {
+	switch {
+	case u.Scheme == "memory-s3":
+		s3URL, err := url.Parse(memoryS3URL(u))
+		if err != nil {
+			return nil, false, err
+		}
+		return headObject(ctx, s3URL)
+	case isS3URL(u) || isSpacesURL(u):
+		dest, err := parseS3URL(u)
+		if err != nil {
+			return nil, false, err
+		}
+		sess, err := dest.newSession()
+		if err != nil {
+			return nil, false, err
+		}
+		head, err := s3.New(sess).HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: aws.String(dest.bucket), Key: aws.String(dest.key)})
+		var reqErr awserr.RequestFailure
+		if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		return &drivers.FileProperties{ContentType: aws.StringValue(head.ContentType), Metadata: aws.StringValueMap(head.Metadata)}, true, nil
+	case u.Scheme == "gs":
+		client, err := newGCSClient(ctx, u.User.Username())
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to create GCS client: %w", err)
+		}
+		defer client.Close()
+		attrs, err := client.Bucket(u.Host).Object(strings.TrimPrefix(u.Path, "/")).Attrs(ctx)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		return &drivers.FileProperties{ContentType: attrs.ContentType, Metadata: attrs.Metadata}, true, nil
+	}
+	return nil, false, drivers.ErrNotSupported
+}
+
+// withIdempotencyKey adds the idempotency key to the metadata of an upload
+func withIdempotencyKey(fields *drivers.FileProperties, opts UploadOptions) *drivers.FileProperties {
+	if opts.IdempotencyKey == "" {
+		return fields
+	}
+	keyFields := copyFileProperties(fields)
+	keyFields.Metadata[idempotencyMetadataKey] = opts.IdempotencyKey
+	return keyFields
+}
@@ -0,0 +1,45 @@
+package core
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	u := mustParseURL("memory-s3://idempotency/hls/123/index.m3u8")
+	opts := UploadOptions{IdempotencyKey: "attempt-1"}
+	out, err := Upload(strings.NewReader("#EXTM3U"), u, opts)
+	require.NoError(t, err)
+	require.False(t, out.AlreadyUploaded)
+	obj, ok := memoryS3.server.Object("idempotency", "hls/123/index.m3u8")
+	require.True(t, ok)
+	require.Equal(t, "attempt-1", obj.Metadata[idempotencyMetadataKey])
+	require.Equal(t, "max-age=1", obj.CacheControl)
+
+	// a retry with the same key doesn't write again
+	out, err = Upload(strings.NewReader("#EXTM3U\n#EXT-X-ENDLIST"), u, opts)
+	require.NoError(t, err)
+	require.True(t, out.AlreadyUploaded)
+	obj, _ = memoryS3.server.Object("idempotency", "hls/123/index.m3u8")
+	require.Equal(t, "#EXTM3U", string(obj.Data))
+
+	// another key does
+	opts.IdempotencyKey = "attempt-2"
+	out, err = Upload(strings.NewReader("#EXTM3U\n#EXT-X-ENDLIST"), u, opts)
+	require.NoError(t, err)
+	require.False(t, out.AlreadyUploaded)
+	obj, _ = memoryS3.server.Object("idempotency", "hls/123/index.m3u8")
+	require.Equal(t, "#EXTM3U\n#EXT-X-ENDLIST", string(obj.Data))
+
+	// storages without metadata are always written
+	dir := t.TempDir()
+	fileURI := mustParseURL(filepath.ToSlash(filepath.Join(dir, "index.m3u8")))
+	for i := 0; i < 2; i++ {
+		out, err = Upload(strings.NewReader("#EXTM3U"), fileURI, opts)
+		require.NoError(t, err)
+		require.False(t, out.AlreadyUploaded)
+	}
+}
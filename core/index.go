@@ -0,0 +1,178 @@
+package core
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	_ "modernc.org/sqlite"
+)
+
+const indexSchema = `
+CREATE TABLE IF NOT EXISTS uploads (
+	id INTEGER PRIMARY KEY,
+	time TEXT NOT NULL,
+	destination TEXT NOT NULL,
+	key TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	sha256 TEXT NOT NULL,
+	duration_ms INTEGER NOT NULL,
+	fallback INTEGER NOT NULL,
+	url TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS uploads_destination_key ON uploads (destination, key);
+CREATE INDEX IF NOT EXISTS uploads_time ON uploads (time);
+`
+
+// IndexEntry is one completed upload in the UploadIndex
+type IndexEntry struct {
+	Time time.Time `json:"time"`
+	// Destination is the redacted scheme://host of the storage the data was written to, Key the path within it
+	Destination string `json:"destination"`
+	Key         string `json:"key"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	DurationMs  int64  `json:"duration_ms"`
+	Fallback    bool   `json:"fallback"`
+	URL         string `json:"url"`
+}
+
+// UploadIndex records completed uploads in a local SQLite database, as a source of truth on the node to
+// reconcile the bucket against. Several uploader processes can share the same database file.
+type UploadIndex struct {
+	db *sql.DB
+}
+
+func OpenUploadIndex(fileName string) (*UploadIndex, error) {
+	// concurrent uploader processes wait for each other's writes instead of failing with SQLITE_BUSY
+	db, err := sql.Open("sqlite", "file:"+fileName+"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(indexSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create upload index schema: %w", err)
+	}
+	return &UploadIndex{db: db}, nil
+}
+
+func (idx *UploadIndex) Close() error {
+	return idx.db.Close()
+}
+
+func (idx *UploadIndex) Add(entry IndexEntry) error {
+	_, err := idx.db.Exec(`INSERT INTO uploads (time, destination, key, size, sha256, duration_ms, fallback, url) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.Time.UTC().Format(time.RFC3339Nano), entry.Destination, entry.Key, entry.Size, entry.SHA256, entry.DurationMs, entry.Fallback, entry.URL)
+	return err
+}
+
+// IndexQuery filters the entries returned by UploadIndex.Query, zero values match everything
+type IndexQuery struct {
+	Destination string
+	KeyPrefix   string
+	Since       time.Time
+	Until       time.Time
+	Limit       int
+}
+
+// Query returns the matching entries, oldest first
+func (idx *UploadIndex) Query(q IndexQuery) ([]IndexEntry, error) {
+	query := `SELECT time, destination, key, size, sha256, duration_ms, fallback, url FROM uploads WHERE 1=1`
+	var args []interface{}
+	if q.Destination != "" {
+		query += ` AND destination = ?`
+		args = append(args, q.Destination)
+	}
+	if q.KeyPrefix != "" {
+		query += ` AND substr(key, 1, ?) = ?`
+		args = append(args, len(q.KeyPrefix), q.KeyPrefix)
+	}
+	if !q.Since.IsZero() {
+		query += ` AND time >= ?`
+		args = append(args, q.Since.UTC().Format(time.RFC3339Nano))
+	}
+	if !q.Until.IsZero() {
+		query += ` AND time < ?`
+		args = append(args, q.Until.UTC().Format(time.RFC3339Nano))
+	}
+	query += ` ORDER BY time, id`
+	if q.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, q.Limit)
+	}
+
+	rows, err := idx.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []IndexEntry
+	for rows.Next() {
+		var entry IndexEntry
+		var t string
+		if err := rows.Scan(&t, &entry.Destination, &entry.Key, &entry.Size, &entry.SHA256, &entry.DurationMs, &entry.Fallback, &entry.URL); err != nil {
+			return nil, err
+		}
+		if entry.Time, err = time.Parse(time.RFC3339Nano, t); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// splitLocation splits the location of an upload into the redacted storage and the path within it
+func splitLocation(u *url.URL) (destination, key string) {
+	if isWindowsPathURI(u) || u.Scheme == "" || u.Scheme == "file" {
+		return "file://", strings.TrimPrefix(u.Path, "/")
+	}
+	storage := *u
+	storage.Path, storage.RawPath, storage.RawQuery, storage.Fragment = "", "", "", ""
+	return storage.Redacted(), strings.TrimPrefix(u.Path, "/")
+}
+
+// addToIndex records a completed upload, failures are only logged since the upload itself succeeded
+func addToIndex(idx *UploadIndex, outputURI *url.URL, fileName string, result *UploadResult, duration time.Duration) {
+	if idx == nil {
+		return
+	}
+	checksum, size, err := fileSHA256(fileName)
+	if err != nil {
+		glog.Errorf("Failed to checksum %s for the upload index: %v", outputURI.Redacted(), err)
+		return
+	}
+	destination, key := splitLocation(result.location(outputURI))
+	err = idx.Add(IndexEntry{
+		Time:        time.Now(),
+		Destination: destination,
+		Key:         key,
+		Size:        size,
+		SHA256:      checksum,
+		DurationMs:  duration.Milliseconds(),
+		Fallback:    result.Fallback,
+		URL:         result.URL,
+	})
+	if err != nil {
+		glog.Errorf("Failed to add %s to the upload index: %v", outputURI.Redacted(), err)
+	}
+}
+
+func fileSHA256(fileName string) (string, int64, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := copyBuffered(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultInfluxMeasurement is the measurement of the points written by InfluxSink unless another one is configured
+const DefaultInfluxMeasurement = "catalyst_uploader_upload"
+
+// influxTimeout bounds writing a point to InfluxDB or Telegraf
+const influxTimeout = 5 * time.Second
+
+// InfluxSink writes a point in InfluxDB line protocol for every segment and manifest upload, for deployments
+// collecting metrics with InfluxDB or Telegraf rather than Prometheus. The points are tagged with the storage
+// scheme and host, the kind of upload, its status and whether it fell back to the backup, and have the size
+// of the input and the duration of the upload as fields.
+type InfluxSink struct {
+	// udp is the address points are sent to in datagrams, otherwise they are posted to writeURL
+	udp         string
+	writeURL    string
+	token       string
+	user        string
+	password    string
+	measurement string
+}
+
+// ParseInfluxSink parses a udp://host:port URL, e.g. of the socket_listener of Telegraf or the UDP service of
+// InfluxDB 1.x, or the http(s):// URL of a write endpoint such as /write?db=uploads or
+// /api/v2/write?org=livepeer&bucket=uploads. The token of InfluxDB 2.x is given as the password without a user,
+// https://:TOKEN@host/api/v2/write?..., and the credentials of InfluxDB 1.x as user and password.
+func ParseInfluxSink(s, measurement string) (*InfluxSink, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if measurement == "" {
+		measurement = DefaultInfluxMeasurement
+	}
+	sink := &InfluxSink{measurement: measurement}
+	switch u.Scheme {
+	case "udp":
+		if u.Port() == "" {
+			return nil, fmt.Errorf("UDP address %s has no port", u.Host)
+		}
+		sink.udp = u.Host
+	case "http", "https":
+		if u.User != nil {
+			password, _ := u.User.Password()
+			if u.User.Username() == "" {
+				sink.token = password
+			} else {
+				sink.user, sink.password = u.User.Username(), password
+			}
+			u.User = nil
+		}
+		sink.writeURL = u.String()
+	default:
+		return nil, fmt.Errorf("unsupported InfluxDB URL scheme %q, expected udp, http or https", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("InfluxDB URL requires a host")
+	}
+	return sink, nil
+}
+
+// recordUpload writes the point of an upload of the given kind that completed, or failed with uploadErr, after
+// duration. Points that can't be written are only logged.
+func (s *InfluxSink) recordUpload(outputURI *url.URL, fileName, kind string, result *UploadResult, uploadErr error, duration time.Duration, opts UploadOptions) {
+	if s == nil {
+		return
+	}
+	status := "completed"
+	if uploadErr != nil {
+		status = "failed"
+	}
+	var size int64
+	if info, err := os.Stat(fileName); err == nil {
+		size = info.Size()
+	}
+	// line protocol has no empty tag values
+	storage, host := outputURI.Scheme, outputURI.Host
+	if storage == "" {
+		storage = "file"
+	}
+	if host == "" {
+		host = "local"
+	}
+	line := fmt.Sprintf("%s,storage=%s,host=%s,kind=%s,status=%s,fallback=%t bytes=%di,duration_ms=%di %d\n",
+		escapeInflux(s.measurement), escapeInflux(storage), escapeInflux(host), kind, status, result != nil && result.Fallback,
+		size, duration.Milliseconds(), time.Now().UnixNano())
+	if err := s.write([]byte(line)); err != nil {
+		logRepeatedError("influx", opts, "Failed to write metrics of %s to InfluxDB: %v", outputURI.Redacted(), err)
+	}
+}
+
+func (s *InfluxSink) write(data []byte) error {
+	if s.udp != "" {
+		conn, err := net.DialTimeout("udp", s.udp, influxTimeout)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_, err = conn.Write(data)
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), influxTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.writeURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	switch {
+	case s.token != "":
+		req.Header.Set("Authorization", "Token "+s.token)
+	case s.user != "":
+		req.SetBasicAuth(s.user, s.password)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("status %s: %s", resp.Status, msg)
+	}
+	return nil
+}
+
+// escapeInflux escapes a measurement or tag value of the line protocol
+func escapeInflux(s string) string {
+	return strings.NewReplacer(`,`, `\,`, ` `, `\ `, `=`, `\=`).Replace(s)
+}
@@ -0,0 +1,210 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/golang/glog"
+)
+
+// lifecycleRuleID prefixes the IDs of the S3 lifecycle rules managed by ApplyLifecycleRules, so that the bucket's
+// other rules are kept
+const lifecycleRuleID = "catalyst-uploader:"
+
+// LifecycleRule expires the objects under a key prefix of a bucket, with the storage's own lifecycle rules
+type LifecycleRule struct {
+	Prefix          string `json:"prefix"`
+	ExpireAfterDays int64  `json:"expire_after_days"`
+}
+
+// LifecycleRules derives the lifecycle rules of a bucket from the default and configured header rules that
+// expire objects in it, with their expire_after or else the +duration of their Object-Expires metadata. Like
+// header rules, later rules for a prefix override earlier ones. Lifecycle rules only select objects by prefix,
+// so rules limited to extensions are skipped. Expiries are rounded up to whole days.
+func LifecycleRules(bucket *url.URL, rules []HeaderRule) ([]LifecycleRule, error) {
+	location := *bucket
+	location.Scheme, location.User, location.RawQuery = "", nil, ""
+	bucketLocation := strings.TrimSuffix(strings.TrimPrefix(uriString(&location), "//"), "/")
+
+	expiries := map[string]int64{}
+	for _, rule := range append(append([]HeaderRule{}, defaultHeaderRules...), rules...) {
+		expireAfter, err := rule.expiry()
+		if err != nil {
+			return nil, err
+		}
+		if expireAfter == 0 {
+			continue
+		}
+		var prefix string
+		switch {
+		case strings.HasPrefix(rule.Prefix, bucketLocation+"/"):
+			prefix = strings.TrimPrefix(rule.Prefix, bucketLocation+"/")
+		case strings.HasPrefix(bucketLocation, rule.Prefix):
+			// the rule covers the whole bucket
+		default:
+			continue
+		}
+		if len(rule.Extensions) > 0 {
+			glog.Warningf("Skipping the expiry of %s%v, lifecycle rules can't select objects by extension", rule.Prefix, rule.Extensions)
+			continue
+		}
+		expiries[prefix] = int64(math.Ceil(expireAfter.Hours() / 24))
+	}
+
+	var lifecycleRules []LifecycleRule
+	for prefix, days := range expiries {
+		lifecycleRules = append(lifecycleRules, LifecycleRule{Prefix: prefix, ExpireAfterDays: days})
+	}
+	sort.Slice(lifecycleRules, func(i, j int) bool { return lifecycleRules[i].Prefix < lifecycleRules[j].Prefix })
+	return lifecycleRules, nil
+}
+
+// expiry is how long after their upload the objects matching the rule expire, or 0 if they don't
+func (r HeaderRule) expiry() (time.Duration, error) {
+	expireAfter := r.ExpireAfter
+	if expireAfter == "" {
+		// the Object-Expires metadata of storj can also be an absolute time, which lifecycle rules can't express
+		var ok bool
+		if expireAfter, ok = strings.CutPrefix(r.Metadata["Object-Expires"], "+"); !ok {
+			return 0, nil
+		}
+	}
+	d, err := time.ParseDuration(expireAfter)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid expiry %q of rule for %q, expected a positive duration", expireAfter, r.Prefix)
+	}
+	return d, nil
+}
+
+// ApplyLifecycleRules configures rules as the expiry rules of an S3 or GCS bucket. The rules set by an earlier
+// apply are replaced, while the bucket's other rules are kept: on S3 they are told apart by their ID, and on GCS
+// only the delete rules for the same prefixes are replaced.
+func ApplyLifecycleRules(ctx context.Context, bucket *url.URL, rules []LifecycleRule) error {
+	switch {
+	case bucket.Scheme == "memory-s3":
+		s3URL, err := url.Parse(memoryS3URL(bucket))
+		if err != nil {
+			return err
+		}
+		return ApplyLifecycleRules(ctx, s3URL, rules)
+	case isS3URL(bucket) || isSpacesURL(bucket):
+		return applyS3LifecycleRules(ctx, bucket, rules)
+	case bucket.Scheme == "gs":
+		return applyGCSLifecycleRules(ctx, bucket, rules)
+	}
+	return fmt.Errorf("lifecycle rules are only supported on S3 and GCS, not %s", bucket.Scheme)
+}
+
+func applyS3LifecycleRules(ctx context.Context, bucket *url.URL, rules []LifecycleRule) error {
+	dest, err := parseS3URL(bucket)
+	if err != nil {
+		return err
+	}
+	if dest.key != "" {
+		return fmt.Errorf("expected a bucket without a key, got %s", bucket.Redacted())
+	}
+	sess, err := dest.newSession()
+	if err != nil {
+		return err
+	}
+	svc := s3.New(sess)
+	var lifecycleRules []*s3.LifecycleRule
+	current, err := svc.GetBucketLifecycleConfigurationWithContext(ctx, &s3.GetBucketLifecycleConfigurationInput{Bucket: aws.String(dest.bucket)})
+	var awsErr awserr.Error
+	switch {
+	case errors.As(err, &awsErr) && awsErr.Code() == "NoSuchLifecycleConfiguration":
+	case err != nil:
+		return fmt.Errorf("failed to read the lifecycle rules of %s: %w", dest.bucket, err)
+	default:
+		for _, rule := range current.Rules {
+			if !strings.HasPrefix(aws.StringValue(rule.ID), lifecycleRuleID) {
+				lifecycleRules = append(lifecycleRules, rule)
+			}
+		}
+	}
+	for _, rule := range rules {
+		lifecycleRules = append(lifecycleRules, &s3.LifecycleRule{
+			ID:         aws.String(lifecycleRuleID + rule.Prefix),
+			Status:     aws.String(s3.ExpirationStatusEnabled),
+			Filter:     &s3.LifecycleRuleFilter{Prefix: aws.String(rule.Prefix)},
+			Expiration: &s3.LifecycleExpiration{Days: aws.Int64(rule.ExpireAfterDays)},
+		})
+	}
+
+	if len(lifecycleRules) == 0 {
+		_, err = svc.DeleteBucketLifecycleWithContext(ctx, &s3.DeleteBucketLifecycleInput{Bucket: aws.String(dest.bucket)})
+	} else {
+		_, err = svc.PutBucketLifecycleConfigurationWithContext(ctx, &s3.PutBucketLifecycleConfigurationInput{
+			Bucket:                 aws.String(dest.bucket),
+			LifecycleConfiguration: &s3.BucketLifecycleConfiguration{Rules: lifecycleRules},
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to configure the lifecycle rules of %s: %w", dest.bucket, err)
+	}
+	return nil
+}
+
+func applyGCSLifecycleRules(ctx context.Context, bucket *url.URL, rules []LifecycleRule) error {
+	if strings.Trim(bucket.Path, "/") != "" {
+		return fmt.Errorf("expected a bucket without a key, got %s", bucket.Redacted())
+	}
+	client, err := newGCSClient(ctx, bucket.User.Username())
+	if err != nil {
+		return fmt.Errorf("failed to create GCS client: %w", err)
+	}
+	defer client.Close()
+	handle := client.Bucket(bucket.Host)
+	attrs, err := handle.Attrs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read the lifecycle rules of %s: %w", bucket.Host, err)
+	}
+	prefixes := map[string]bool{}
+	for _, rule := range rules {
+		prefixes[rule.Prefix] = true
+	}
+	var lifecycleRules []storage.LifecycleRule
+	for _, rule := range attrs.Lifecycle.Rules {
+		if !isGCSExpiryRule(rule, prefixes) {
+			lifecycleRules = append(lifecycleRules, rule)
+		}
+	}
+	for _, rule := range rules {
+		condition := storage.LifecycleCondition{AgeInDays: rule.ExpireAfterDays}
+		if rule.Prefix != "" {
+			condition.MatchesPrefix = []string{rule.Prefix}
+		}
+		lifecycleRules = append(lifecycleRules, storage.LifecycleRule{
+			Action:    storage.LifecycleAction{Type: storage.DeleteAction},
+			Condition: condition,
+		})
+	}
+	_, err = handle.Update(ctx, storage.BucketAttrsToUpdate{Lifecycle: &storage.Lifecycle{Rules: lifecycleRules}})
+	if err != nil {
+		return fmt.Errorf("failed to configure the lifecycle rules of %s: %w", bucket.Host, err)
+	}
+	return nil
+}
+
+// isGCSExpiryRule reports whether a GCS lifecycle rule deletes objects by age under one of the prefixes
+func isGCSExpiryRule(rule storage.LifecycleRule, prefixes map[string]bool) bool {
+	if rule.Action.Type != storage.DeleteAction || rule.Condition.AgeInDays == 0 ||
+		len(rule.Condition.MatchesPrefix) > 1 || len(rule.Condition.MatchesSuffix) > 0 {
+		return false
+	}
+	prefix := ""
+	if len(rule.Condition.MatchesPrefix) == 1 {
+		prefix = rule.Condition.MatchesPrefix[0]
+	}
+	return prefixes[prefix]
+}
@@ -0,0 +1,174 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// uploadSlotPoll is how often busy upload slots are tried again
+const uploadSlotPoll = 50 * time.Millisecond
+
+// ErrOverloaded is returned for uploads that the UploadLimiter turned away, so that they can be sent again later
+var ErrOverloaded = errors.New("too many uploads in progress")
+
+// UploadLimiter limits how many uploads run at once on the host, in total and to each destination, across all
+// uploader processes, so that a flood of segments during a reconnect storm doesn't exhaust sockets and memory.
+// Like FFmpegLimiter, running uploads hold locks on slot files in a shared directory. Uploads waiting for a slot
+// hold a queue slot; when the queue is full, or a slot doesn't free up in time, uploads fail straight away with
+// ErrOverloaded. A nil limiter doesn't limit anything.
+type UploadLimiter struct {
+	dir            string
+	global         int
+	perDestination int
+	queue          int
+	wait           time.Duration
+}
+
+// NewUploadLimiter creates a limiter allowing global concurrent uploads on the host and perDestination to each
+// destination bucket, with up to queue uploads waiting at most wait for a slot. A zero global, perDestination or
+// queue doesn't limit that. Every process sharing the limits must use the same dir and limits.
+func NewUploadLimiter(dir string, global, perDestination, queue int, wait time.Duration) (*UploadLimiter, error) {
+	if global < 0 || perDestination < 0 || queue < 0 || wait < 0 {
+		return nil, errors.New("upload limits can't be negative")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload lock directory: %w", err)
+	}
+	return &UploadLimiter{dir: dir, global: global, perDestination: perDestination, queue: queue, wait: wait}, nil
+}
+
+// Priority classes of uploads waiting for an UploadLimiter slot, highest first. PriorityAuto picks the class by
+// the upload, see uploadPriority.
+const (
+	PriorityAuto         = "auto"
+	PriorityLiveManifest = "live-manifest"
+	PriorityLiveSegment  = "live-segment"
+	PriorityBackfill     = "backfill"
+)
+
+var priorityClasses = []string{PriorityLiveManifest, PriorityLiveSegment, PriorityBackfill}
+
+// maxWaitingUploads bounds the lock files announcing waiting uploads of each priority class. Further waiting
+// uploads aren't announced and don't hold back lower priority ones.
+const maxWaitingUploads = 64
+
+// uploadPriority is the priority class of an upload to u: UploadOptions.Priority, or with PriorityAuto input
+// files are backfill, segments are live segments and anything else, such as manifests, live manifests
+func uploadPriority(u *url.URL, opts UploadOptions) string {
+	switch {
+	case opts.Priority != "" && opts.Priority != PriorityAuto:
+		return opts.Priority
+	case opts.fileInput:
+		return PriorityBackfill
+	case isSegment(u, opts):
+		return PriorityLiveSegment
+	}
+	return PriorityLiveManifest
+}
+
+// uploadSlots are the lock files <prefix>-0.lock to <prefix>-<n-1>.lock of the limiter's directory
+type uploadSlots struct {
+	prefix string
+	n      int
+}
+
+// acquire waits for a slot for an upload to u of a priority class and returns the function releasing it. While
+// uploads of a higher class are waiting, lower ones don't take free slots, so that latency sensitive uploads
+// get the next ones.
+func (l *UploadLimiter) acquire(u *url.URL, priority string) (release func(), err error) {
+	if l == nil || (l.global == 0 && l.perDestination == 0) {
+		return func() {}, nil
+	}
+	var held []*os.File
+	release = func() {
+		for _, file := range held {
+			unlockSlotFile(file)
+		}
+	}
+	// destination slots are taken before global ones by every upload, so that they can't deadlock
+	var slots []uploadSlots
+	if l.perDestination > 0 {
+		sum := sha256.Sum256([]byte(retryBudgetKey(u)))
+		slots = append(slots, uploadSlots{"upload-" + hex.EncodeToString(sum[:8]), l.perDestination})
+	}
+	if l.global > 0 {
+		slots = append(slots, uploadSlots{"upload", l.global})
+	}
+
+	var queued, waiting *os.File
+	defer func() {
+		if queued != nil {
+			unlockSlotFile(queued)
+		}
+		if waiting != nil {
+			unlockSlotFile(waiting)
+		}
+	}()
+	start := time.Now()
+	for _, slot := range slots {
+		for {
+			var file *os.File
+			if !l.higherPriorityWaiting(priority) {
+				if file, err = lockAnySlot(l.dir, slot.prefix, slot.n); err != nil {
+					release()
+					return nil, fmt.Errorf("failed to lock upload slot: %w", err)
+				}
+			}
+			if file != nil {
+				held = append(held, file)
+				break
+			}
+			if waiting == nil && priority != PriorityBackfill {
+				// failing to announce the wait only means lower priority uploads aren't held back
+				waiting, _ = lockAnySlot(l.dir, "waiting-"+priority, maxWaitingUploads)
+			}
+			if queued == nil && l.queue > 0 {
+				if queued, err = lockAnySlot(l.dir, "queue", l.queue); err != nil || queued == nil {
+					release()
+					if err != nil {
+						return nil, fmt.Errorf("failed to lock upload queue slot: %w", err)
+					}
+					return nil, fmt.Errorf("%w: the queue of %d waiting uploads is full", ErrOverloaded, l.queue)
+				}
+			}
+			if l.wait > 0 && time.Since(start) > l.wait {
+				release()
+				return nil, fmt.Errorf("%w: no upload slot freed up within %s", ErrOverloaded, l.wait)
+			}
+			time.Sleep(uploadSlotPoll)
+		}
+	}
+	if waited := time.Since(start); waited > time.Second {
+		glog.V(5).Infof("Waited %s for an upload slot for %s", waited, u.Redacted())
+	}
+	return release, nil
+}
+
+// higherPriorityWaiting reports whether uploads of a higher priority class than priority are waiting for a slot,
+// which they announce by holding waiting-<class>-N.lock files
+func (l *UploadLimiter) higherPriorityWaiting(priority string) bool {
+	for _, class := range priorityClasses {
+		if class == priority {
+			return false
+		}
+		announcements, _ := filepath.Glob(filepath.Join(l.dir, "waiting-"+class+"-*.lock"))
+		for _, announcement := range announcements {
+			file, err := lockSlotFile(announcement)
+			if err == nil && file == nil {
+				return true
+			}
+			if file != nil {
+				unlockSlotFile(file)
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadLimiter(t *testing.T) {
+	limiter, err := NewUploadLimiter(t.TempDir(), 2, 1, 1, time.Second)
+	require.NoError(t, err)
+	a0 := mustParseURL("s3://key:secret@eu-west-1/a/0.ts")
+	a1 := mustParseURL("s3://key:secret@eu-west-1/a/1.ts")
+	b0 := mustParseURL("s3://key:secret@eu-west-1/b/0.ts")
+
+	releaseA, err := limiter.acquire(a0, PriorityLiveSegment)
+	require.NoError(t, err)
+	releaseB, err := limiter.acquire(b0, PriorityLiveSegment)
+	require.NoError(t, err)
+
+	// a second upload to bucket a waits in the queue, and a third is turned away
+	acquired := make(chan func())
+	go func() {
+		release, err := limiter.acquire(a1, PriorityLiveSegment)
+		require.NoError(t, err)
+		acquired <- release
+	}()
+	require.Eventually(t, func() bool {
+		_, err := limiter.acquire(a1, PriorityLiveSegment)
+		return errors.Is(err, ErrOverloaded)
+	}, time.Second, 10*time.Millisecond)
+
+	releaseA()
+	release := <-acquired
+	release()
+	releaseB()
+
+	// uploads that don't get a slot in time are turned away too
+	limiter.queue, limiter.wait = 0, 100*time.Millisecond
+	releaseA, err = limiter.acquire(a0, PriorityLiveSegment)
+	require.NoError(t, err)
+	_, err = limiter.acquire(a1, PriorityLiveSegment)
+	require.ErrorIs(t, err, ErrOverloaded)
+	releaseA()
+
+	var nilLimiter *UploadLimiter
+	release, err = nilLimiter.acquire(a0, PriorityLiveSegment)
+	require.NoError(t, err)
+	release()
+
+	_, err = NewUploadLimiter(t.TempDir(), -1, 0, 0, 0)
+	require.Error(t, err)
+}
+
+func TestUploadLimiterPriority(t *testing.T) {
+	limiter, err := NewUploadLimiter(t.TempDir(), 1, 0, 0, 0)
+	require.NoError(t, err)
+	segment := mustParseURL("s3://key:secret@eu-west-1/a/0.ts")
+	manifest := mustParseURL("s3://key:secret@eu-west-1/a/index.m3u8")
+
+	release, err := limiter.acquire(segment, PriorityLiveSegment)
+	require.NoError(t, err)
+	backfill := make(chan func())
+	go func() {
+		release, err := limiter.acquire(segment, PriorityBackfill)
+		require.NoError(t, err)
+		backfill <- release
+	}()
+	time.Sleep(2 * uploadSlotPoll)
+	live := make(chan func())
+	go func() {
+		release, err := limiter.acquire(manifest, PriorityLiveManifest)
+		require.NoError(t, err)
+		live <- release
+	}()
+	require.Eventually(t, func() bool { return limiter.higherPriorityWaiting(PriorityBackfill) }, time.Second, 10*time.Millisecond)
+
+	// the manifest gets the freed slot before the backfill upload that waited longer
+	release()
+	release = <-live
+	select {
+	case <-backfill:
+		t.Fatal("backfill upload got a slot while a live manifest held it")
+	case <-time.After(2 * uploadSlotPoll):
+	}
+	release()
+	release = <-backfill
+	release()
+
+	require.Equal(t, PriorityLiveManifest, uploadPriority(manifest, UploadOptions{}))
+	require.Equal(t, PriorityLiveSegment, uploadPriority(segment, UploadOptions{}))
+	require.Equal(t, PriorityBackfill, uploadPriority(segment, UploadOptions{fileInput: true}))
+	require.Equal(t, PriorityBackfill, uploadPriority(manifest, UploadOptions{Priority: PriorityBackfill}))
+}
@@ -0,0 +1,192 @@
+package core
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"google.golang.org/api/iterator"
+)
+
+// StoredObject is an object found under a prefix by listObjects
+type StoredObject struct {
+	// Key is relative to the prefix and slash separated
+	Key  string
+	Size int64
+	// MD5 is the hex checksum of the object if the storage reports it, which S3 doesn't for multipart uploads
+	MD5          string
+	LastModified time.Time
+}
+
+// listObjects lists every object under a prefix, including those in subdirectories, in no particular order.
+// The prefix is a directory: s3://KEY:SECRET@region/bucket/vod/ and s3://KEY:SECRET@region/bucket/vod list the
+// same objects. S3 and GCS are listed with their own APIs, local directories are walked, and other storages are
+// listed through their drivers one directory at a time.
+func listObjects(ctx context.Context, prefix *url.URL, opts UploadOptions) ([]StoredObject, error) {
+	if opts.Replay == nil {
+		switch {
+		case prefix.Scheme == "memory-s3":
+			s3URL, err := url.Parse(memoryS3URL(prefix))
+			if err != nil {
+				return nil, err
+			}
+			return listObjects(ctx, s3URL, opts)
+		case isS3URL(prefix) || isSpacesURL(prefix):
+			return listS3Objects(ctx, prefix)
+		case prefix.Scheme == "gs":
+			return listGCSObjects(ctx, prefix)
+		case prefix.Scheme == "" || prefix.Scheme == "file":
+			return listLocalFiles(prefix.Path)
+		}
+	}
+	return listDriverObjects(ctx, prefix, opts)
+}
+
+// directoryKey is the key prefix of the objects in a directory, ending with a slash unless it's the root
+func directoryKey(key string) string {
+	key = strings.TrimPrefix(key, "/")
+	if key != "" && !strings.HasSuffix(key, "/") {
+		key += "/"
+	}
+	return key
+}
+
+func listS3Objects(ctx context.Context, prefix *url.URL) ([]StoredObject, error) {
+	dest, err := parseS3URL(prefix)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := dest.newSession()
+	if err != nil {
+		return nil, err
+	}
+	keyPrefix := directoryKey(dest.key)
+	var objects []StoredObject
+	err = s3.New(sess).ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(dest.bucket),
+		Prefix: aws.String(keyPrefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range page.Contents {
+			etag := strings.Trim(aws.StringValue(obj.ETag), `"`)
+			if strings.Contains(etag, "-") {
+				etag = ""
+			}
+			objects = append(objects, StoredObject{
+				Key:          strings.TrimPrefix(aws.StringValue(obj.Key), keyPrefix),
+				Size:         aws.Int64Value(obj.Size),
+				MD5:          etag,
+				LastModified: aws.TimeValue(obj.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", prefix.Redacted(), err)
+	}
+	return objects, nil
+}
+
+func listGCSObjects(ctx context.Context, prefix *url.URL) ([]StoredObject, error) {
+	client, err := newGCSClient(ctx, prefix.User.Username())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client: %w", err)
+	}
+	defer client.Close()
+	keyPrefix := directoryKey(prefix.Path)
+	var objects []StoredObject
+	it := client.Bucket(prefix.Host).Objects(ctx, &storage.Query{Prefix: keyPrefix})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", prefix.Redacted(), err)
+		}
+		objects = append(objects, StoredObject{
+			Key:          strings.TrimPrefix(attrs.Name, keyPrefix),
+			Size:         attrs.Size,
+			MD5:          hex.EncodeToString(attrs.MD5),
+			LastModified: attrs.Updated,
+		})
+	}
+}
+
+func listLocalFiles(dir string) ([]StoredObject, error) {
+	var objects []StoredObject
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, StoredObject{Key: filepath.ToSlash(rel), Size: info.Size(), LastModified: info.ModTime()})
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return objects, err
+}
+
+// listDriverObjects lists a prefix through the drivers, descending into the directories they report. The
+// drivers name files and directories by their path under the session's.
+func listDriverObjects(ctx context.Context, prefix *url.URL, opts UploadOptions) ([]StoredObject, error) {
+	dir := *prefix
+	dir.Path = "/" + directoryKey(dir.Path)
+	dir.RawPath = ""
+	session, err := newSession(&dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	var objects []StoredObject
+	pending := []string{""}
+	seen := map[string]bool{"": true}
+	for len(pending) > 0 {
+		subdir := pending[0]
+		pending = pending[1:]
+		page, err := session.ListFiles(ctx, subdir, "/")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", dir.JoinPath(subdir).Redacted(), err)
+		}
+		for {
+			for _, file := range page.Files() {
+				obj := StoredObject{Key: strings.TrimPrefix(path.Clean("/"+file.Name), "/"), LastModified: file.LastModified}
+				if file.Size != nil {
+					obj.Size = *file.Size
+				}
+				objects = append(objects, obj)
+			}
+			for _, subdir := range page.Directories() {
+				// guards against drivers listing a directory as its own subdirectory
+				if !seen[subdir] {
+					seen[subdir] = true
+					pending = append(pending, subdir)
+				}
+			}
+			if !page.HasNextPage() {
+				break
+			}
+			if page, err = page.NextPage(); err != nil {
+				return nil, fmt.Errorf("failed to list %s: %w", dir.Redacted(), err)
+			}
+		}
+	}
+	return objects, nil
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/livepeer/go-tools/drivers"
+)
+
+// localOS is the file system driver of the drivers, but writing files under a temporary name renamed once
+// complete, so that a failed write neither leaves a partial file nor truncates the file it was replacing
+type localOS struct {
+	drivers.OSDriver
+	dir string
+}
+
+func (d *localOS) NewSession(p string) drivers.OSSession {
+	return &localSession{OSSession: d.OSDriver.NewSession(p), dir: path.Join(d.dir, p)}
+}
+
+type localSession struct {
+	drivers.OSSession
+	dir string
+}
+
+func (s *localSession) SaveData(ctx context.Context, name string, data io.Reader, fields *drivers.FileProperties, timeout time.Duration) (*drivers.SaveDataOutput, error) {
+	fileName := path.Join(s.dir, name)
+	if err := os.MkdirAll(path.Dir(fileName), os.ModePerm); err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp(path.Dir(fileName), "."+path.Base(fileName)+".*.part")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	// os.CreateTemp only lets the owner read the file, os.Create as the drivers use it lets everyone
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		return nil, err
+	}
+	_, err = copyBuffered(file, &contextReader{ctx: ctx, Reader: data})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(file.Name(), fileName); err != nil {
+		return nil, err
+	}
+	return &drivers.SaveDataOutput{URL: fileName}, nil
+}
+
+// contextReader fails once its context is done, like the file system sessions of the drivers do between chunks
+type contextReader struct {
+	ctx context.Context
+	io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.Reader.Read(p)
+}
@@ -0,0 +1,43 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalSaveData(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "hls", "0.ts")
+	require.NoError(t, os.MkdirAll(filepath.Dir(output), 0755))
+	require.NoError(t, os.WriteFile(output, []byte("good"), 0644))
+	driver, err := parseOSURL(mustParseURL(filepath.ToSlash(output)), UploadOptions{})
+	require.NoError(t, err)
+	session := driver.NewSession("")
+
+	// a write failing halfway leaves the previous file and no temporary file
+	failing := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("read failed")))
+	_, err = session.SaveData(context.Background(), "", failing, nil, time.Second)
+	require.ErrorContains(t, err, "read failed")
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+	require.Equal(t, "good", string(data))
+	entries, err := os.ReadDir(filepath.Dir(output))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	out, err := session.SaveData(context.Background(), "", strings.NewReader("new"), nil, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, filepath.ToSlash(output), out.URL)
+	data, err = os.ReadFile(output)
+	require.NoError(t, err)
+	require.Equal(t, "new", string(data))
+}
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// repeatedError is the state of an error that may keep repeating, such as every incremental write of a
+// manifest failing during a storage outage
+type repeatedError struct {
+	logged     time.Time
+	suppressed int
+	first      time.Time
+	last       string
+}
+
+var repeatedErrors = struct {
+	sync.Mutex
+	byKey map[string]*repeatedError
+}{byKey: map[string]*repeatedError{}}
+
+// logRepeatedError logs an error identified by key, unless one with the same key was logged less than
+// UploadOptions.LogRepeatInterval ago. Those are only counted, and the next one logged, or the summary of
+// flushRepeatedErrors, reports how many were suppressed and the last of them.
+func logRepeatedError(key string, opts UploadOptions, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if opts.LogRepeatInterval <= 0 {
+		glog.ErrorDepth(1, msg)
+		return
+	}
+	repeatedErrors.Lock()
+	defer repeatedErrors.Unlock()
+	now := time.Now()
+	e := repeatedErrors.byKey[key]
+	if e == nil {
+		e = &repeatedError{}
+		repeatedErrors.byKey[key] = e
+	}
+	if now.Sub(e.logged) < opts.LogRepeatInterval {
+		if e.suppressed == 0 {
+			e.first = now
+		}
+		e.suppressed++
+		e.last = msg
+		return
+	}
+	if e.suppressed > 0 {
+		msg += fmt.Sprintf(" (%d more since %s)", e.suppressed, e.first.Format(time.RFC3339))
+	}
+	glog.ErrorDepth(1, msg)
+	e.logged, e.suppressed, e.last = now, 0, ""
+}
+
+// flushRepeatedErrors logs a summary of the errors identified by key that were suppressed since the last one
+// was logged, e.g. once the writes they are about succeed again or stop, and forgets the key
+func flushRepeatedErrors(key string) {
+	repeatedErrors.Lock()
+	defer repeatedErrors.Unlock()
+	e := repeatedErrors.byKey[key]
+	delete(repeatedErrors.byKey, key)
+	if e != nil && e.suppressed > 0 {
+		glog.ErrorDepth(1, fmt.Sprintf("%d more errors since %s, the last one: %s", e.suppressed, e.first.Format(time.RFC3339), e.last))
+	}
+}
+
+// FlushRepeatedErrors logs the summaries of all the errors suppressed by logRepeatedError, e.g. on exit
+func FlushRepeatedErrors() {
+	repeatedErrors.Lock()
+	keys := make([]string, 0, len(repeatedErrors.byKey))
+	for key := range repeatedErrors.byKey {
+		keys = append(keys, key)
+	}
+	repeatedErrors.Unlock()
+	sort.Strings(keys)
+	for _, key := range keys {
+		flushRepeatedErrors(key)
+	}
+}
@@ -0,0 +1,40 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogRepeatedError(t *testing.T) {
+	opts := UploadOptions{LogRepeatInterval: time.Hour}
+	const key = "write s3://bucket/index.m3u8"
+	defer flushRepeatedErrors(key)
+	for i := 0; i < 5; i++ {
+		logRepeatedError(key, opts, "Failed to write: attempt %d", i)
+	}
+	repeatedErrors.Lock()
+	e := repeatedErrors.byKey[key]
+	require.Equal(t, 4, e.suppressed)
+	require.Equal(t, "Failed to write: attempt 4", e.last)
+	// once the interval has passed the next one is logged with the count of the suppressed ones
+	e.logged = time.Now().Add(-2 * time.Hour)
+	repeatedErrors.Unlock()
+	logRepeatedError(key, opts, "Failed to write: attempt 5")
+	repeatedErrors.Lock()
+	require.Zero(t, repeatedErrors.byKey[key].suppressed)
+	repeatedErrors.Unlock()
+
+	logRepeatedError(key, opts, "Failed to write: attempt 6")
+	flushRepeatedErrors(key)
+	repeatedErrors.Lock()
+	require.NotContains(t, repeatedErrors.byKey, key)
+	repeatedErrors.Unlock()
+
+	// without an interval nothing is tracked
+	logRepeatedError(key, UploadOptions{}, "Failed to write")
+	repeatedErrors.Lock()
+	require.NotContains(t, repeatedErrors.byKey, key)
+	repeatedErrors.Unlock()
+}
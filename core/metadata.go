@@ -0,0 +1,173 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/livepeer/go-tools/drivers"
+)
+
+const (
+	// maxS3CopySize is the largest object a single CopyObject request can copy, larger ones are copied in parts
+	maxS3CopySize = 5 * 1024 * 1024 * 1024
+	// s3CopyPartSize is the part size of multipart copies, which fits objects up to the 5TiB S3 limit in maxS3Parts
+	s3CopyPartSize = 1024 * 1024 * 1024
+)
+
+// MetadataUpdate changes the properties of a stored object. Empty fields leave the current value in place.
+type MetadataUpdate struct {
+	CacheControl       string
+	ContentType        string
+	ContentDisposition string
+	// Metadata is merged into the object's user metadata, e.g. to set the Object-Expires used for expiry
+	Metadata map[string]string
+}
+
+// UpdateMetadata changes the properties of the object at u without uploading it again. S3 objects are copied
+// onto themselves with the new metadata, server side, and GCS objects are patched. Other storages return
+// drivers.ErrNotSupported.
+func UpdateMetadata(ctx context.Context, u *url.URL, update MetadataUpdate) error {
+	switch {
+	case u.Scheme == "memory-s3":
+		s3URL, err := url.Parse(memoryS3URL(u))
+		if err != nil {
+			return err
+		}
+		return UpdateMetadata(ctx, s3URL, update)
+	case isS3URL(u) || isSpacesURL(u):
+		dest, err := parseS3URL(u)
+		if err != nil {
+			return err
+		}
+		return updateS3Metadata(ctx, dest, update, maxS3CopySize, s3CopyPartSize)
+	case u.Scheme == "gs":
+		return updateGCSMetadata(ctx, u, update)
+	}
+	return fmt.Errorf("updating metadata of %s objects: %w", u.Scheme, drivers.ErrNotSupported)
+}
+
+func updateS3Metadata(ctx context.Context, dest *s3Destination, update MetadataUpdate, maxCopySize, copyPartSize int64) error {
+	sess, err := dest.newSession()
+	if err != nil {
+		return err
+	}
+	svc := s3.New(sess)
+	head, err := svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: aws.String(dest.bucket), Key: aws.String(dest.key)})
+	if err != nil {
+		return err
+	}
+
+	// REPLACE drops everything that isn't set on the copy, so the current properties are carried over
+	metadata := head.Metadata
+	if metadata == nil {
+		metadata = map[string]*string{}
+	}
+	for k, v := range update.Metadata {
+		metadata[k] = aws.String(v)
+	}
+	cacheControl, contentType, contentDisposition := head.CacheControl, head.ContentType, head.ContentDisposition
+	if update.CacheControl != "" {
+		cacheControl = aws.String(update.CacheControl)
+	}
+	if update.ContentType != "" {
+		contentType = aws.String(update.ContentType)
+	}
+	if update.ContentDisposition != "" {
+		contentDisposition = aws.String(update.ContentDisposition)
+	}
+	copySource := (&url.URL{Path: dest.bucket + "/" + dest.key}).EscapedPath()
+
+	size := aws.Int64Value(head.ContentLength)
+	if size <= maxCopySize {
+		_, err = svc.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+			Bucket:             aws.String(dest.bucket),
+			Key:                aws.String(dest.key),
+			CopySource:         aws.String(copySource),
+			MetadataDirective:  aws.String(s3.MetadataDirectiveReplace),
+			Metadata:           metadata,
+			CacheControl:       cacheControl,
+			ContentType:        contentType,
+			ContentEncoding:    head.ContentEncoding,
+			ContentDisposition: contentDisposition,
+			StorageClass:       head.StorageClass,
+		})
+		return err
+	}
+
+	upload, err := svc.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:             aws.String(dest.bucket),
+		Key:                aws.String(dest.key),
+		Metadata:           metadata,
+		CacheControl:       cacheControl,
+		ContentType:        contentType,
+		ContentEncoding:    head.ContentEncoding,
+		ContentDisposition: contentDisposition,
+		StorageClass:       head.StorageClass,
+	})
+	if err != nil {
+		return err
+	}
+	var parts []*s3.CompletedPart
+	for offset, partNumber := int64(0), int64(1); offset < size; offset, partNumber = offset+copyPartSize, partNumber+1 {
+		part, err := svc.UploadPartCopyWithContext(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(dest.bucket),
+			Key:             aws.String(dest.key),
+			UploadId:        upload.UploadId,
+			PartNumber:      aws.Int64(partNumber),
+			CopySource:      aws.String(copySource),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, min(offset+copyPartSize, size)-1)),
+		})
+		if err != nil {
+			_, _ = svc.AbortMultipartUploadWithContext(context.Background(), &s3.AbortMultipartUploadInput{Bucket: aws.String(dest.bucket), Key: aws.String(dest.key), UploadId: upload.UploadId})
+			return fmt.Errorf("failed to copy part %d: %w", partNumber, err)
+		}
+		parts = append(parts, &s3.CompletedPart{ETag: part.CopyPartResult.ETag, PartNumber: aws.Int64(partNumber)})
+	}
+	_, err = svc.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(dest.bucket),
+		Key:             aws.String(dest.key),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	return err
+}
+
+// updateGCSMetadata patches objects at gs://KEY_JSON@bucket/key URLs
+func updateGCSMetadata(ctx context.Context, u *url.URL, update MetadataUpdate) error {
+	client, err := newGCSClient(ctx, u.User.Username())
+	if err != nil {
+		return fmt.Errorf("failed to create GCS client: %w", err)
+	}
+	defer client.Close()
+	obj := client.Bucket(u.Host).Object(strings.TrimPrefix(u.Path, "/"))
+	var attrs storage.ObjectAttrsToUpdate
+	if update.CacheControl != "" {
+		attrs.CacheControl = update.CacheControl
+	}
+	if update.ContentType != "" {
+		attrs.ContentType = update.ContentType
+	}
+	if update.ContentDisposition != "" {
+		attrs.ContentDisposition = update.ContentDisposition
+	}
+	if len(update.Metadata) > 0 {
+		current, err := obj.Attrs(ctx)
+		if err != nil {
+			return err
+		}
+		attrs.Metadata = map[string]string{}
+		for k, v := range current.Metadata {
+			attrs.Metadata[k] = v
+		}
+		for k, v := range update.Metadata {
+			attrs.Metadata[k] = v
+		}
+	}
+	_, err = obj.Update(ctx, attrs)
+	return err
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/livepeer/catalyst-uploader/fakes3"
+	"github.com/livepeer/go-tools/drivers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateMetadata(t *testing.T) {
+	srv := fakes3.New()
+	defer srv.Close()
+	u := mustParseURL(srv.URL("bucket", "rec/output.mp4"))
+	data := make([]byte, 1000)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	fileName := filepath.Join(t.TempDir(), "output.mp4")
+	require.NoError(t, os.WriteFile(fileName, data, 0644))
+	_, _, err = uploadFile(u, fileName, &drivers.FileProperties{CacheControl: "max-age=1", Metadata: map[string]string{"Stream": "123"}}, time.Second, false, UploadOptions{})
+	require.NoError(t, err)
+
+	uploaded, _ := srv.Object("bucket", "rec/output.mp4")
+	dest, err := parseS3URL(u)
+	require.NoError(t, err)
+	for _, maxCopySize := range []int64{maxS3CopySize, 100} {
+		// a max copy size below the object size exercises the multipart copy
+		update := MetadataUpdate{CacheControl: "max-age=86400", Metadata: map[string]string{"Object-Expires": "+168h"}}
+		require.NoError(t, updateS3Metadata(context.Background(), dest, update, maxCopySize, 300))
+
+		obj, ok := srv.Object("bucket", "rec/output.mp4")
+		require.True(t, ok)
+		require.Equal(t, data, obj.Data)
+		require.Equal(t, "max-age=86400", obj.CacheControl)
+		require.Equal(t, uploaded.ContentType, obj.ContentType)
+		require.Equal(t, map[string]string{"Stream": "123", "Object-Expires": "+168h"}, obj.Metadata)
+	}
+
+	require.NoError(t, UpdateMetadata(context.Background(), u, MetadataUpdate{ContentType: "video/mp4", ContentDisposition: "attachment"}))
+	obj, _ := srv.Object("bucket", "rec/output.mp4")
+	require.Equal(t, "video/mp4", obj.ContentType)
+	require.Equal(t, "attachment", obj.ContentDisposition)
+	require.Equal(t, "max-age=86400", obj.CacheControl)
+}
+
+func TestUpdateMetadataNotSupported(t *testing.T) {
+	err := UpdateMetadata(context.Background(), mustParseURL("/tmp/out.mp4"), MetadataUpdate{CacheControl: "no-cache"})
+	require.True(t, errors.Is(err, drivers.ErrNotSupported))
+}
@@ -0,0 +1,61 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/livepeer/go-tools/drivers"
+)
+
+// ErrObjectExists is returned for uploads with NoClobber to an object that already exists
+var ErrObjectExists = errors.New("object already exists")
+
+// checkNoClobber fails with ErrObjectExists if opts.NoClobber is set and the object at u exists, so that e.g.
+// misrouted live segments can't overwrite VOD assets
+func checkNoClobber(u *url.URL, opts UploadOptions) error {
+	if !opts.NoClobber || opts.Replay != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSaveTimeout)
+	defer cancel()
+	exists, err := objectExists(ctx, u, opts)
+	if err != nil {
+		return fmt.Errorf("failed to check whether %s exists: %w", u.Redacted(), err)
+	}
+	if exists {
+		return fmt.Errorf("not uploading %s: %w", u.Redacted(), ErrObjectExists)
+	}
+	return nil
+}
+
+// objectExists reports whether there is an object at u. Storages without a way to read the metadata of an
+// object are checked by starting to read it.
+func objectExists(ctx context.Context, u *url.URL, opts UploadOptions) (bool, error) {
+	if u.Scheme == "" || u.Scheme == "file" {
+		_, err := os.Stat(u.Path)
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	_, exists, err := headObject(ctx, u)
+	if !errors.Is(err, drivers.ErrNotSupported) {
+		return exists, err
+	}
+	session, err := newSession(u, opts)
+	if err != nil {
+		return false, err
+	}
+	reader, err := session.ReadData(ctx, "")
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	reader.Body.Close()
+	return true, nil
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNoClobber(t *testing.T) {
+	opts := UploadOptions{NoClobber: true}
+	for _, u := range []string{
+		"memory-s3://noclobber/vod/123/index.m3u8",
+		filepath.ToSlash(filepath.Join(t.TempDir(), "vod", "index.m3u8")),
+	} {
+		uri := mustParseURL(u)
+		_, err := Upload(strings.NewReader("#EXTM3U"), uri, opts)
+		require.NoError(t, err, u)
+
+		_, err = Upload(strings.NewReader("#EXTM3U\n#EXT-X-ENDLIST"), uri, opts)
+		require.ErrorIs(t, err, ErrObjectExists, u)
+		segmentURI := mustParseURL(strings.TrimSuffix(u, "index.m3u8") + "0.ts")
+		_, err = Upload(strings.NewReader("segment"), segmentURI, opts)
+		require.NoError(t, err, u)
+		_, err = Upload(strings.NewReader("misrouted"), segmentURI, opts)
+		require.ErrorIs(t, err, ErrObjectExists, u)
+	}
+	obj, ok := memoryS3.server.Object("noclobber", "vod/123/0.ts")
+	require.True(t, ok)
+	require.Equal(t, "segment", string(obj.Data))
+
+	// a retry with the same idempotency key isn't an overwrite
+	opts.IdempotencyKey = "attempt-1"
+	u := mustParseURL("memory-s3://noclobber/vod/456/index.m3u8")
+	_, err := Upload(strings.NewReader("#EXTM3U"), u, opts)
+	require.NoError(t, err)
+	out, err := Upload(strings.NewReader("#EXTM3U"), u, opts)
+	require.NoError(t, err)
+	require.True(t, out.AlreadyUploaded)
+}
+
+func TestObjectExists(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "0.ts")
+	exists, err := objectExists(context.Background(), mustParseURL(filepath.ToSlash(fileName)), UploadOptions{})
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.NoError(t, os.WriteFile(fileName, []byte("segment"), 0644))
+	exists, err = objectExists(context.Background(), mustParseURL(filepath.ToSlash(fileName)), UploadOptions{})
+	require.NoError(t, err)
+	require.True(t, exists)
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DestinationOptions are upload settings given as query parameters of the destination URL, so that they can
+// be tuned per stream, e.g. s3://KEY:SECRET@region/bucket/key?partSize=16MiB&concurrency=4&storageClass=STANDARD_IA&cacheControl=max-age=60&ipFamily=4.
+// The multipart and storage class settings only apply to S3 destinations.
+type DestinationOptions struct {
+	// PartSize and Concurrency configure S3 multipart uploads, zero uses the defaults
+	PartSize    int64
+	Concurrency int
+	// StorageClass is the S3 storage class of the uploaded objects
+	StorageClass string
+	// CacheControl replaces the Cache-Control the uploader sets by default
+	CacheControl string
+	// IPFamily overrides the IP family of connections to the destination host, see TransportOptions
+	IPFamily string
+}
+
+// ParseDestinationOptions removes the DestinationOptions parameters from the destination and returns them.
+// Other query parameters are left in place.
+func ParseDestinationOptions(destination string) (string, DestinationOptions, error) {
+	var opts DestinationOptions
+	// the query is split off the string rather than parsed with the URL, which would escape template placeholders
+	base, rawQuery, ok := strings.Cut(destination, "?")
+	if !ok || windowsDrivePath.MatchString(destination) {
+		return destination, opts, nil
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", opts, fmt.Errorf("invalid destination query: %w", err)
+	}
+	if s := query.Get("partSize"); s != "" {
+		if opts.PartSize, err = ParseByteSize(s); err != nil {
+			return "", opts, fmt.Errorf("invalid partSize: %w", err)
+		}
+	}
+	if s := query.Get("concurrency"); s != "" {
+		if opts.Concurrency, err = strconv.Atoi(s); err != nil || opts.Concurrency < 1 {
+			return "", opts, fmt.Errorf("invalid concurrency %q", s)
+		}
+	}
+	opts.StorageClass = query.Get("storageClass")
+	opts.CacheControl = query.Get("cacheControl")
+	opts.IPFamily = query.Get("ipFamily")
+	if !validIPFamily(opts.IPFamily) {
+		return "", opts, fmt.Errorf("invalid ipFamily %q, expected auto, 4 or 6", opts.IPFamily)
+	}
+	for _, param := range []string{"partSize", "concurrency", "storageClass", "cacheControl", "ipFamily"} {
+		query.Del(param)
+	}
+	if len(query) == 0 {
+		return base, opts, nil
+	}
+	return base + "?" + query.Encode(), opts, nil
+}
+
+// s3Tuned reports whether the options need the multipart settings of uploadS3File
+func (o DestinationOptions) s3Tuned() bool {
+	return o.PartSize > 0 || o.Concurrency > 0 || o.StorageClass != ""
+}
@@ -0,0 +1,84 @@
+package core
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// PaceRealtime paces segment uploads to the media's real-time bitrate, see UploadOptions.Pace
+const PaceRealtime = "realtime"
+
+// pacedChunkSize is the most a paced read returns at once, so that bytes are sent smoothly rather than in bursts
+const pacedChunkSize = 32 * 1024
+
+// pacer spreads bytes over time at a fixed rate. It is shared by the parts of an upload sent in parallel, which
+// together keep to the rate.
+type pacer struct {
+	bytesPerSecond float64
+	start          time.Time
+	sent           atomic.Int64
+}
+
+func newPacer(bytesPerSecond float64) *pacer {
+	return &pacer{bytesPerSecond: bytesPerSecond, start: time.Now()}
+}
+
+// wait sleeps until the bytes sent so far are due at the pacer's rate
+func (p *pacer) wait() {
+	due := p.start.Add(time.Duration(float64(p.sent.Load()) / p.bytesPerSecond * float64(time.Second)))
+	if d := time.Until(due); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// pacedReader reads at the rate of its pacer
+type pacedReader struct {
+	io.Reader
+	pacer *pacer
+}
+
+func (r *pacedReader) Read(p []byte) (int, error) {
+	if len(p) > pacedChunkSize {
+		p = p[:pacedChunkSize]
+	}
+	r.pacer.wait()
+	n, err := r.Reader.Read(p)
+	r.pacer.sent.Add(int64(n))
+	return n, err
+}
+
+// pacingTransport sends request bodies at the rate of its pacer. It paces uploadS3File, where reads of the
+// file don't match what is sent as the S3 SDK reads each part to sign it first.
+type pacingTransport struct {
+	http.RoundTripper
+	pacer *pacer
+}
+
+func (t *pacingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return t.RoundTripper.RoundTrip(req)
+	}
+	paced := req.Clone(req.Context())
+	paced.Body = &pacedBody{pacedReader: pacedReader{Reader: req.Body, pacer: t.pacer}, closer: req.Body}
+	return t.RoundTripper.RoundTrip(paced)
+}
+
+// pacedSession copies an S3 session to send request bodies at bytesPerSecond
+func pacedSession(sess *session.Session, bytesPerSecond float64) *session.Session {
+	transport := &pacingTransport{RoundTripper: http.DefaultTransport, pacer: newPacer(bytesPerSecond)}
+	return sess.Copy(&aws.Config{HTTPClient: &http.Client{Transport: transport}})
+}
+
+type pacedBody struct {
+	pacedReader
+	closer io.Closer
+}
+
+func (b *pacedBody) Close() error {
+	return b.closer.Close()
+}
@@ -0,0 +1,39 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPacedReader(t *testing.T) {
+	data := bytes.Repeat([]byte{1}, 100*1024)
+	start := time.Now()
+	read, err := io.ReadAll(&pacedReader{Reader: bytes.NewReader(data), pacer: newPacer(200 * 1024)})
+	require.NoError(t, err)
+	require.Equal(t, data, read)
+	elapsed := time.Since(start)
+	require.GreaterOrEqual(t, elapsed, 450*time.Millisecond)
+	require.Less(t, elapsed, 2*time.Second)
+}
+
+func TestPacingTransport(t *testing.T) {
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+	data := bytes.Repeat([]byte{1}, 128*1024)
+	client := &http.Client{Transport: &pacingTransport{RoundTripper: http.DefaultTransport, pacer: newPacer(256 * 1024)}}
+	start := time.Now()
+	resp, err := client.Post(server.URL, "video/mp2t", bytes.NewReader(data))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, data, received)
+	require.GreaterOrEqual(t, time.Since(start), 350*time.Millisecond)
+}
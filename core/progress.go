@@ -0,0 +1,186 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// progressLogger counts how much of an input has been read for uploading. If logTenths is set it logs the
+// progress whenever another tenth of an input of known size has been read.
+type progressLogger struct {
+	name      string
+	total     int64
+	logTenths bool
+	// partSize is the size of the parts of multipart uploads, 0 otherwise
+	partSize atomic.Int64
+	read     atomic.Int64
+	logged   atomic.Int64
+	// onRead, if set, is called on each read, see UploadOptions.onProgress
+	onRead func()
+}
+
+func newProgressLogger(name string, total int64, logTenths bool) *progressLogger {
+	return &progressLogger{name: name, total: total, logTenths: logTenths}
+}
+
+func (p *progressLogger) add(n int) {
+	read := p.read.Add(int64(n))
+	if p.onRead != nil {
+		p.onRead()
+	}
+	if !p.logTenths || p.total <= 0 {
+		return
+	}
+	tenths := min(read*10/p.total, 10)
+	if last := p.logged.Load(); tenths > last && p.logged.CompareAndSwap(last, tenths) {
+		glog.V(5).Infof("Uploading %s: %d of %d bytes (%d%%)", p.name, min(read, p.total), p.total, tenths*10)
+	}
+}
+
+// heartbeat logs the progress of an upload attempt every interval until stopped, so that slow uploads can be
+// told apart from hung ones. A zero interval disables it.
+func (p *progressLogger) heartbeat(interval time.Duration, attempt int) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				glog.Info(p.heartbeatMessage(attempt))
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+func (p *progressLogger) heartbeatMessage(attempt int) string {
+	read := p.read.Load()
+	msg := fmt.Sprintf("Still uploading %s, attempt %d: %d", p.name, attempt, read)
+	if p.total > 0 {
+		msg += fmt.Sprintf(" of %d", p.total)
+	}
+	msg += " bytes read"
+	if partSize := p.partSize.Load(); partSize > 0 && p.total > 0 {
+		parts := (p.total + partSize - 1) / partSize
+		msg += fmt.Sprintf(", part %d of %d", min(read/partSize+1, parts), parts)
+	}
+	return msg
+}
+
+type progressReader struct {
+	io.Reader
+	progress *progressLogger
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.progress.add(n)
+	return n, err
+}
+
+// progressFile counts reads of a file that is uploaded in parts read concurrently with ReadAt
+type progressFile struct {
+	*os.File
+	progress *progressLogger
+}
+
+func (f *progressFile) Read(b []byte) (int, error) {
+	n, err := f.File.Read(b)
+	f.progress.add(n)
+	return n, err
+}
+
+func (f *progressFile) ReadAt(b []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(b, off)
+	f.progress.add(n)
+	return n, err
+}
+
+// barInterval is how often the progress bar is redrawn
+const barInterval = 200 * time.Millisecond
+
+// ProgressBar draws a live progress bar of uploads with their throughput and ETA, for interactive use on a
+// terminal. Concurrent uploads take turns drawing on the same line.
+type ProgressBar struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewProgressBar(w io.Writer) *ProgressBar {
+	return &ProgressBar{w: w}
+}
+
+// bar draws the progress of an upload attempt on b until stopped, when the final state is drawn and the line
+// ended. A nil b draws nothing.
+func (p *progressLogger) bar(b *ProgressBar) (stop func()) {
+	if b == nil {
+		return func() {}
+	}
+	start := time.Now()
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(barInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				b.draw(p.barLine(time.Since(start)), false)
+			case <-done:
+				b.draw(p.barLine(time.Since(start)), true)
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+func (b *ProgressBar) draw(line string, final bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// \r and erasing the line redraw it in place
+	fmt.Fprint(b.w, "\r\033[K"+line)
+	if final {
+		fmt.Fprintln(b.w)
+	}
+}
+
+// barWidth is the number of characters of the bar itself
+const barWidth = 30
+
+func (p *progressLogger) barLine(elapsed time.Duration) string {
+	read := p.read.Load()
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(read) / elapsed.Seconds()
+	}
+	if p.total <= 0 {
+		return fmt.Sprintf("%s %s %s/s", p.name, formatByteSize(float64(read)), formatByteSize(rate))
+	}
+	read = min(read, p.total)
+	filled := int(read * barWidth / p.total)
+	eta := "--:--"
+	if rate > 0 {
+		remaining := time.Duration(float64(p.total-read) / rate * float64(time.Second)).Round(time.Second)
+		eta = fmt.Sprintf("%02d:%02d", int(remaining.Minutes()), int(remaining.Seconds())%60)
+	}
+	return fmt.Sprintf("%s [%s%s] %3d%% %s/s ETA %s", p.name, strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled),
+		read*100/p.total, formatByteSize(rate), eta)
+}
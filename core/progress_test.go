@@ -0,0 +1,71 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressLogger(t *testing.T) {
+	progress := newProgressLogger("test", 100, true)
+	reader := &progressReader{Reader: bytes.NewReader(make([]byte, 100)), progress: progress}
+	buf := make([]byte, 7)
+	for i := 0; i < 3; i++ {
+		_, err := io.ReadFull(reader, buf)
+		require.NoError(t, err)
+	}
+	require.Equal(t, int64(21), progress.read.Load())
+	require.Equal(t, int64(2), progress.logged.Load())
+
+	_, err := io.Copy(io.Discard, reader)
+	require.NoError(t, err)
+	require.Equal(t, int64(100), progress.read.Load())
+	require.Equal(t, int64(10), progress.logged.Load())
+
+	// inputs of unknown size are counted without logging
+	progress = newProgressLogger("test", 0, true)
+	progress.add(10)
+	require.Equal(t, int64(0), progress.logged.Load())
+}
+
+func TestHeartbeatMessage(t *testing.T) {
+	progress := newProgressLogger("s3://bucket/rec.mp4", 100, false)
+	progress.add(10)
+	require.Zero(t, progress.logged.Load())
+	require.Equal(t, "Still uploading s3://bucket/rec.mp4, attempt 2: 10 of 100 bytes read", progress.heartbeatMessage(2))
+	progress.partSize.Store(40)
+	progress.add(40)
+	require.Equal(t, "Still uploading s3://bucket/rec.mp4, attempt 1: 50 of 100 bytes read, part 2 of 3", progress.heartbeatMessage(1))
+	progress.add(50)
+	require.Equal(t, "Still uploading s3://bucket/rec.mp4, attempt 1: 100 of 100 bytes read, part 3 of 3", progress.heartbeatMessage(1))
+
+	require.Equal(t, "Still uploading stdin, attempt 1: 0 bytes read", newProgressLogger("stdin", 0, false).heartbeatMessage(1))
+
+	// stopping a disabled heartbeat is a no-op
+	progress.heartbeat(0, 1)()
+	stop := progress.heartbeat(time.Millisecond, 1)
+	time.Sleep(5 * time.Millisecond)
+	stop()
+}
+
+func TestProgressBar(t *testing.T) {
+	progress := newProgressLogger("rec.mp4", 4*1024*1024, false)
+	progress.add(1024 * 1024)
+	require.Equal(t, "rec.mp4 [=======                       ]  25% 512.0 KiB/s ETA 00:06", progress.barLine(2*time.Second))
+	require.Equal(t, "rec.mp4 [                              ]   0% 0 B/s ETA --:--", newProgressLogger("rec.mp4", 10, false).barLine(0))
+	stdin := newProgressLogger("stdin", 0, false)
+	stdin.add(1024 * 1024)
+	require.Equal(t, "stdin 1.0 MiB 1.0 MiB/s", stdin.barLine(time.Second))
+
+	var out bytes.Buffer
+	progress.bar(nil)()
+	stop := progress.bar(NewProgressBar(&out))
+	progress.add(3 * 1024 * 1024)
+	stop()
+	require.Contains(t, out.String(), "\r\033[Krec.mp4 [==============================] 100%")
+	require.True(t, strings.HasSuffix(out.String(), "\n"))
+}
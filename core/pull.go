@@ -0,0 +1,183 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/golang/glog"
+)
+
+// errSourceChanged is returned when a pulled source can't be resumed where it stopped
+var errSourceChanged = errors.New("source changed or doesn't support range requests, can't resume")
+
+// PullRetryBackoff is the retry policy of PullSource, which starts over whenever the pull makes progress
+func PullRetryBackoff() backoff.BackOff {
+	return newExponentialBackOffExecutor(time.Second, 30*time.Second, 5*time.Minute)
+}
+
+// PullSource streams the body of an HTTP(S) source, e.g. for imports that don't go through stdin. Failed
+// requests and connections dropped in the middle of the body are retried according to retries, resuming with a
+// Range request from the last byte read. The source must support range requests and still have the ETag, or
+// Last-Modified time, it had when the pull started, otherwise reading fails rather than mixing two versions.
+func PullSource(ctx context.Context, source *url.URL, retries backoff.BackOff) io.ReadCloser {
+	return &pullReader{ctx: ctx, source: source, retries: backoff.WithContext(retries, ctx), size: -1}
+}
+
+type pullReader struct {
+	ctx     context.Context
+	source  *url.URL
+	retries backoff.BackOff
+	body    io.ReadCloser
+	// offset is how much of the source was read, of size bytes or -1 if unknown
+	offset int64
+	size   int64
+	// validator is the ETag or Last-Modified time the source must still have to resume
+	validator string
+}
+
+func (r *pullReader) Read(p []byte) (int, error) {
+	for {
+		if r.body == nil {
+			if err := r.open(); err != nil {
+				if retryErr := r.retry(err); retryErr != nil {
+					return 0, retryErr
+				}
+				continue
+			}
+		}
+		n, err := r.body.Read(p)
+		r.offset += int64(n)
+		if n > 0 {
+			// retries are only given up on when they stop making progress
+			r.retries.Reset()
+		}
+		if err == io.EOF && r.size >= 0 && r.offset < r.size {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+		r.body.Close()
+		r.body = nil
+		if n > 0 {
+			// the error is retried on the next Read
+			return n, nil
+		}
+		if retryErr := r.retry(err); retryErr != nil {
+			return 0, retryErr
+		}
+	}
+}
+
+// retry waits before the source is requested again after err, or returns the error to give up with
+func (r *pullReader) retry(err error) error {
+	var permanent *backoff.PermanentError
+	if errors.As(err, &permanent) {
+		return permanent.Err
+	}
+	wait := r.retries.NextBackOff()
+	if wait == backoff.Stop {
+		return fmt.Errorf("failed to pull %s after %d bytes: %w", r.source.Redacted(), r.offset, err)
+	}
+	glog.Warningf("Pulling %s failed after %d bytes, retrying in %s: %v", r.source.Redacted(), r.offset, wait, err)
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-r.ctx.Done():
+		return r.ctx.Err()
+	}
+}
+
+// open requests the source from the current offset
+func (r *pullReader) open() error {
+	if r.offset > 0 && r.validator == "" {
+		return backoff.Permanent(fmt.Errorf("failed to pull %s: %w", r.source.Redacted(), errSourceChanged))
+	}
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, r.source.String(), nil)
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+	if r.offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", r.offset))
+		// with a changed source, the whole new version is returned rather than a range of it
+		req.Header.Set("If-Range", r.validator)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	switch {
+	case r.offset == 0 && resp.StatusCode == http.StatusOK:
+		r.size = resp.ContentLength
+		r.validator = resp.Header.Get("ETag")
+		if r.validator == "" || strings.HasPrefix(r.validator, "W/") {
+			r.validator = resp.Header.Get("Last-Modified")
+		}
+	case r.offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		start, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != r.offset {
+			resp.Body.Close()
+			return backoff.Permanent(fmt.Errorf("failed to pull %s: %w", r.source.Redacted(), errSourceChanged))
+		}
+		r.size = size
+	case r.offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && resp.Header.Get("Content-Range") == fmt.Sprintf("bytes */%d", r.offset):
+		// everything was read before the previous attempt stopped
+		resp.Body.Close()
+		resp.Body = http.NoBody
+	case r.offset > 0 && resp.StatusCode == http.StatusOK:
+		resp.Body.Close()
+		return backoff.Permanent(fmt.Errorf("failed to pull %s: %w", r.source.Redacted(), errSourceChanged))
+	default:
+		resp.Body.Close()
+		err := fmt.Errorf("GET %s: %s", r.source.Redacted(), resp.Status)
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusRequestTimeout {
+			return backoff.Permanent(err)
+		}
+		return err
+	}
+	r.body = resp.Body
+	return nil
+}
+
+// parseContentRange returns the first byte and the size of a Content-Range header such as "bytes 100-199/200".
+// The size is -1 if the server doesn't know it.
+func parseContentRange(s string) (start, size int64, ok bool) {
+	s, ok = strings.CutPrefix(s, "bytes ")
+	if !ok {
+		return 0, 0, false
+	}
+	byteRange, total, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, 0, false
+	}
+	first, _, ok := strings.Cut(byteRange, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	size = -1
+	if total != "*" {
+		if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	return start, size, true
+}
+
+func (r *pullReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	return r.body.Close()
+}
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// flakySource serves data, dropping the connection after dropAfter bytes of the first request
+type flakySource struct {
+	mu        sync.Mutex
+	data      []byte
+	etag      string
+	dropAfter int
+	requests  []string
+}
+
+func (s *flakySource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Header.Get("Range"))
+	first := len(s.requests) == 1
+	data, etag := s.data, s.etag
+	s.mu.Unlock()
+	w.Header().Set("ETag", etag)
+	if first && s.dropAfter > 0 {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		_, _ = w.Write(data[:s.dropAfter])
+		// returning with less than Content-Length written ends the response early
+		return
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+func TestPullSource(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100000)
+	source := &flakySource{data: data, etag: `"v1"`, dropAfter: 12345}
+	srv := httptest.NewServer(source)
+	defer srv.Close()
+
+	retries := newExponentialBackOffExecutor(time.Millisecond, time.Millisecond, time.Second)
+	r := PullSource(context.Background(), mustParseURL(srv.URL+"/source.mp4"), retries)
+	pulled, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, data, pulled)
+	require.Equal(t, []string{"", "bytes=12345-"}, source.requests)
+}
+
+func TestPullSourceChanged(t *testing.T) {
+	source := &flakySource{data: bytes.Repeat([]byte("a"), 100000), etag: `"v1"`, dropAfter: 100}
+	srv := httptest.NewServer(source)
+	defer srv.Close()
+
+	r := PullSource(context.Background(), mustParseURL(srv.URL+"/source.mp4"), newExponentialBackOffExecutor(time.Millisecond, time.Millisecond, time.Second))
+	buf := make([]byte, 100)
+	_, err := io.ReadFull(r, buf)
+	require.NoError(t, err)
+	// a new version of the source isn't spliced onto what was read of the old one
+	source.mu.Lock()
+	source.data, source.etag = bytes.Repeat([]byte("b"), 100000), `"v2"`
+	source.mu.Unlock()
+	_, err = io.ReadAll(r)
+	require.ErrorIs(t, err, errSourceChanged)
+}
+
+func TestPullSourceNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	r := PullSource(context.Background(), mustParseURL(srv.URL+"/missing.mp4"), newExponentialBackOffExecutor(time.Millisecond, time.Millisecond, time.Minute))
+	start := time.Now()
+	_, err := io.ReadAll(r)
+	require.ErrorContains(t, err, "404")
+	require.Less(t, time.Since(start), time.Second)
+}
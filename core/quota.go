@@ -0,0 +1,245 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const quotaSchema = `
+CREATE TABLE IF NOT EXISTS quota_usage (
+	name TEXT PRIMARY KEY,
+	bytes INTEGER NOT NULL,
+	uploads INTEGER NOT NULL,
+	updated TEXT NOT NULL
+);
+`
+
+// ErrQuotaExceeded is returned for uploads under a quota whose soft limit was reached, when it is enforced
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// QuotaRule counts the bytes uploaded to destinations starting with one of its Prefixes, without scheme and
+// credentials, e.g. those of a tenant, under its Name
+type QuotaRule struct {
+	Name     string   `json:"name"`
+	Prefixes []string `json:"prefixes"`
+	// SoftLimit, e.g. 500GiB, is the number of bytes after which uploads are warned about or refused, see
+	// UploadQuota. Empty to only count.
+	SoftLimit string `json:"soft_limit"`
+	softLimit int64
+}
+
+// LoadQuotaRules reads a JSON array of QuotaRule from a file
+func LoadQuotaRules(fileName string) ([]QuotaRule, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var rules []QuotaRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid quotas in %s: %w", fileName, err)
+	}
+	for i, rule := range rules {
+		if rule.Name == "" || len(rule.Prefixes) == 0 {
+			return nil, fmt.Errorf("invalid quotas in %s: quotas need a name and prefixes", fileName)
+		}
+		if rule.SoftLimit != "" {
+			if rules[i].softLimit, err = ParseByteSize(rule.SoftLimit); err != nil {
+				return nil, fmt.Errorf("invalid soft limit of quota %q: %w", rule.Name, err)
+			}
+		}
+	}
+	return rules, nil
+}
+
+func (r QuotaRule) matches(u *url.URL) bool {
+	location := storageLocation(u)
+	for _, prefix := range r.Prefixes {
+		if strings.HasPrefix(location, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// QuotaUsage is what was uploaded under a quota
+type QuotaUsage struct {
+	Name      string    `json:"name"`
+	Bytes     int64     `json:"bytes"`
+	Uploads   int64     `json:"uploads"`
+	SoftLimit int64     `json:"soft_limit,omitempty"`
+	Exceeded  bool      `json:"exceeded"`
+	Updated   time.Time `json:"updated"`
+}
+
+// UploadQuota counts the bytes of completed segment and file uploads under quota rules, in a SQLite database
+// that several uploader processes can share, so that storage used by each customer can be tracked. Once a
+// quota reaches its soft limit, further uploads under it are logged as exceeding it, or refused with
+// ErrQuotaExceeded if the quota is enforced. The upload that crosses the limit completes. A nil UploadQuota
+// counts nothing.
+type UploadQuota struct {
+	db      *sql.DB
+	rules   []QuotaRule
+	enforce bool
+}
+
+// OpenUploadQuota opens the database of usage counters, creating it if needed
+func OpenUploadQuota(fileName string, rules []QuotaRule, enforce bool) (*UploadQuota, error) {
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create quota directory: %w", err)
+	}
+	// concurrent uploader processes wait for each other's writes instead of failing with SQLITE_BUSY
+	db, err := sql.Open("sqlite", "file:"+fileName+"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(quotaSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create quota schema: %w", err)
+	}
+	return &UploadQuota{db: db, rules: rules, enforce: enforce}, nil
+}
+
+func (q *UploadQuota) Close() error {
+	return q.db.Close()
+}
+
+// check fails uploads to u with ErrQuotaExceeded if an enforced quota they fall under reached its soft limit
+func (q *UploadQuota) check(u *url.URL) error {
+	if q == nil {
+		return nil
+	}
+	for _, rule := range q.rules {
+		if rule.softLimit == 0 || !rule.matches(u) {
+			continue
+		}
+		var used int64
+		err := q.db.QueryRow(`SELECT bytes FROM quota_usage WHERE name = ?`, rule.Name).Scan(&used)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to read the usage of quota %q: %w", rule.Name, err)
+		}
+		if used < rule.softLimit {
+			continue
+		}
+		if q.enforce {
+			return fmt.Errorf("not uploading %s: %w: %q used %d of %d bytes", u.Redacted(), ErrQuotaExceeded, rule.Name, used, rule.softLimit)
+		}
+		glog.Warningf("Quota %q used %d of %d bytes, uploading %s anyway", rule.Name, used, rule.softLimit, u.Redacted())
+	}
+	return nil
+}
+
+// add counts a completed upload of size bytes to u under the quotas it falls under. Failures are only logged
+// since the upload itself succeeded.
+func (q *UploadQuota) add(u *url.URL, size int64) {
+	if q == nil {
+		return
+	}
+	counted := map[string]bool{}
+	for _, rule := range q.rules {
+		// quotas spread over several rules count each upload once
+		if counted[rule.Name] || !rule.matches(u) {
+			continue
+		}
+		counted[rule.Name] = true
+		_, err := q.db.Exec(`INSERT INTO quota_usage (name, bytes, uploads, updated) VALUES (?, ?, 1, ?)
+			ON CONFLICT (name) DO UPDATE SET bytes = bytes + excluded.bytes, uploads = uploads + 1, updated = excluded.updated`,
+			rule.Name, size, time.Now().UTC().Format(time.RFC3339Nano))
+		if err != nil {
+			glog.Errorf("Failed to count %s under quota %q: %v", u.Redacted(), rule.Name, err)
+		}
+	}
+}
+
+// addFileToQuota counts a completed upload of fileName to u
+func addFileToQuota(q *UploadQuota, u *url.URL, fileName string) {
+	if q == nil {
+		return
+	}
+	info, err := os.Stat(fileName)
+	if err != nil {
+		glog.Errorf("Failed to count %s under its quotas: %v", u.Redacted(), err)
+		return
+	}
+	q.add(u, info.Size())
+}
+
+// Usage returns the usage of every quota, including those no longer configured, sorted by name
+func (q *UploadQuota) Usage() ([]QuotaUsage, error) {
+	rows, err := q.db.Query(`SELECT name, bytes, uploads, updated FROM quota_usage`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	usage := map[string]*QuotaUsage{}
+	for rows.Next() {
+		var u QuotaUsage
+		var updated string
+		if err := rows.Scan(&u.Name, &u.Bytes, &u.Uploads, &updated); err != nil {
+			return nil, err
+		}
+		if u.Updated, err = time.Parse(time.RFC3339Nano, updated); err != nil {
+			return nil, err
+		}
+		usage[u.Name] = &u
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, rule := range q.rules {
+		u, ok := usage[rule.Name]
+		if !ok {
+			u = &QuotaUsage{Name: rule.Name}
+			usage[rule.Name] = u
+		}
+		if rule.softLimit > 0 {
+			u.SoftLimit = rule.softLimit
+		}
+	}
+	var out []QuotaUsage
+	for _, u := range usage {
+		u.Exceeded = u.SoftLimit > 0 && u.Bytes >= u.SoftLimit
+		out = append(out, *u)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// Reset sets the counters of a quota back to zero, e.g. at the start of a billing period
+func (q *UploadQuota) Reset(name string) error {
+	_, err := q.db.Exec(`DELETE FROM quota_usage WHERE name = ?`, name)
+	return err
+}
+
+// WriteQuotaMetrics writes quota usage in the Prometheus text format, e.g. for the textfile collector of the
+// node exporter
+func WriteQuotaMetrics(w io.Writer, usage []QuotaUsage) error {
+	var b strings.Builder
+	b.WriteString("# HELP catalyst_uploader_quota_bytes Bytes uploaded under the quota.\n# TYPE catalyst_uploader_quota_bytes counter\n")
+	for _, u := range usage {
+		fmt.Fprintf(&b, "catalyst_uploader_quota_bytes{quota=%s} %d\n", strconv.Quote(u.Name), u.Bytes)
+	}
+	b.WriteString("# HELP catalyst_uploader_quota_uploads Uploads counted under the quota.\n# TYPE catalyst_uploader_quota_uploads counter\n")
+	for _, u := range usage {
+		fmt.Fprintf(&b, "catalyst_uploader_quota_uploads{quota=%s} %d\n", strconv.Quote(u.Name), u.Uploads)
+	}
+	b.WriteString("# HELP catalyst_uploader_quota_soft_limit_bytes Soft limit of the quota.\n# TYPE catalyst_uploader_quota_soft_limit_bytes gauge\n")
+	for _, u := range usage {
+		if u.SoftLimit > 0 {
+			fmt.Fprintf(&b, "catalyst_uploader_quota_soft_limit_bytes{quota=%s} %d\n", strconv.Quote(u.Name), u.SoftLimit)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
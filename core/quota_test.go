@@ -0,0 +1,58 @@
+package core
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadQuota(t *testing.T) {
+	dir := t.TempDir()
+	rulesFile := filepath.Join(dir, "quotas.json")
+	require.NoError(t, os.WriteFile(rulesFile, []byte(`[
+		{"name": "tenant-a", "prefixes": ["quota/a/", "quota/shared/a/"], "soft_limit": "10"},
+		{"name": "tenant-b", "prefixes": ["quota/b/"]}
+	]`), 0644))
+	rules, err := LoadQuotaRules(rulesFile)
+	require.NoError(t, err)
+	quota, err := OpenUploadQuota(filepath.Join(dir, "quota", "usage.db"), rules, true)
+	require.NoError(t, err)
+	defer quota.Close()
+
+	opts := UploadOptions{SegmentTimeout: time.Second, DisableThumbs: []string{"/"}, Quota: quota}
+	for _, dest := range []string{"memory-s3://quota/a/0.ts", "memory-s3://quota/shared/a/0.ts", "memory-s3://quota/b/0.ts"} {
+		_, err = Upload(bytes.NewReader([]byte("segment")), mustParseURL(dest), opts)
+		require.NoError(t, err)
+	}
+	// tenant-a crossed its soft limit with its last upload and further ones are refused
+	_, err = Upload(bytes.NewReader([]byte("segment")), mustParseURL("memory-s3://quota/a/1.ts"), opts)
+	require.ErrorIs(t, err, ErrQuotaExceeded)
+	_, err = Upload(bytes.NewReader([]byte("segment")), mustParseURL("memory-s3://quota/b/1.ts"), opts)
+	require.NoError(t, err)
+
+	usage, err := quota.Usage()
+	require.NoError(t, err)
+	require.Len(t, usage, 2)
+	require.Equal(t, "tenant-a", usage[0].Name)
+	require.Equal(t, int64(14), usage[0].Bytes)
+	require.Equal(t, int64(2), usage[0].Uploads)
+	require.Equal(t, int64(10), usage[0].SoftLimit)
+	require.True(t, usage[0].Exceeded)
+	require.Equal(t, int64(14), usage[1].Bytes)
+	require.False(t, usage[1].Exceeded)
+
+	var metrics strings.Builder
+	require.NoError(t, WriteQuotaMetrics(&metrics, usage))
+	require.Contains(t, metrics.String(), `catalyst_uploader_quota_bytes{quota="tenant-a"} 14`)
+	require.Contains(t, metrics.String(), `catalyst_uploader_quota_soft_limit_bytes{quota="tenant-a"} 10`)
+	require.NotContains(t, metrics.String(), `catalyst_uploader_quota_soft_limit_bytes{quota="tenant-b"}`)
+
+	require.NoError(t, quota.Reset("tenant-a"))
+	_, err = Upload(bytes.NewReader([]byte("segment")), mustParseURL("memory-s3://quota/a/1.ts"), opts)
+	require.NoError(t, err)
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/golang/glog"
+	"golang.org/x/sync/errgroup"
+)
+
+// uploadS3Ranges uploads a large local file as a multipart upload whose parts are read from their own ranges of
+// the file by concurrent workers, see UploadOptions.RangeSplitSize. Each part is read once into memory and sent
+// from there, so that reading a part from disk overlaps sending the others rather than waiting on the signer
+// and the request body to read it again. The incomplete upload is aborted if a part fails.
+func uploadS3Ranges(ctx context.Context, svc *s3.S3, params *s3manager.UploadInput, leftovers *multipartUploads, file *os.File, size int64, concurrency int, partSize int64, progress *progressLogger) (http.Header, error) {
+	parts := (size + partSize - 1) / partSize
+	if parts > maxS3Parts {
+		return nil, fmt.Errorf("file is larger than %d parts of %d bytes", maxS3Parts, partSize)
+	}
+	create, err := svc.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:             params.Bucket,
+		Key:                params.Key,
+		ContentType:        params.ContentType,
+		CacheControl:       params.CacheControl,
+		ContentDisposition: params.ContentDisposition,
+		StorageClass:       params.StorageClass,
+		Metadata:           params.Metadata,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+	uploadID := create.UploadId
+
+	completed := make([]*s3.CompletedPart, parts)
+	next := make(chan int64)
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.Go(func() error {
+		defer close(next)
+		for n := int64(1); n <= parts; n++ {
+			select {
+			case next <- n:
+			case <-groupCtx.Done():
+				return nil
+			}
+		}
+		return nil
+	})
+	for i := 0; i < int(min(int64(concurrency), parts)); i++ {
+		group.Go(func() error {
+			buf := make([]byte, partSize)
+			for n := range next {
+				offset := (n - 1) * partSize
+				data := buf[:min(partSize, size-offset)]
+				if _, err := file.ReadAt(data, offset); err != nil {
+					return fmt.Errorf("failed to read part %d: %w", n, err)
+				}
+				if progress != nil {
+					progress.add(len(data))
+				}
+				out, err := svc.UploadPartWithContext(groupCtx, &s3.UploadPartInput{
+					Bucket:     params.Bucket,
+					Key:        params.Key,
+					UploadId:   uploadID,
+					PartNumber: aws.Int64(n),
+					Body:       bytes.NewReader(data),
+				})
+				if err != nil {
+					return fmt.Errorf("failed to upload part %d: %w", n, err)
+				}
+				completed[n-1] = &s3.CompletedPart{ETag: out.ETag, PartNumber: aws.Int64(n)}
+			}
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		abortS3Upload(svc, params, uploadID, leftovers)
+		return nil, err
+	}
+
+	out, err := svc.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          params.Bucket,
+		Key:             params.Key,
+		UploadId:        uploadID,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		abortS3Upload(svc, params, uploadID, leftovers)
+		return nil, fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	glog.V(5).Infof("Uploaded %d bytes to %s in %d ranges read by %d workers", size, aws.StringValue(params.Key), parts, concurrency)
+	respHeaders := http.Header{}
+	if out.ETag != nil {
+		respHeaders.Set("Etag", *out.ETag)
+	}
+	if out.VersionId != nil {
+		respHeaders.Set("X-Amz-Version-Id", *out.VersionId)
+	}
+	return respHeaders, nil
+}
+
+// abortS3Upload discards the parts of a failed multipart upload, with a context of its own since the upload's
+// may be what failed it. Uploads that can't be aborted are added to leftovers, to be tried again once the upload
+// failed for good.
+func abortS3Upload(svc *s3.S3, params *s3manager.UploadInput, uploadID *string, leftovers *multipartUploads) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSaveTimeout)
+	defer cancel()
+	_, err := svc.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   params.Bucket,
+		Key:      params.Key,
+		UploadId: uploadID,
+	})
+	if err != nil {
+		glog.Errorf("Failed to abort multipart upload of %s: %v", aws.StringValue(params.Key), err)
+		leftovers.add(svc, aws.StringValue(params.Bucket), aws.StringValue(params.Key), aws.StringValue(uploadID))
+	}
+}
@@ -0,0 +1,39 @@
+package core
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/livepeer/catalyst-uploader/fakes3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRangeSplitS3Upload(t *testing.T) {
+	srv := fakes3.New()
+	defer srv.Close()
+	srv.EnableVersioning("bucket")
+
+	dir := t.TempDir()
+	data := make([]byte, 3*minS3PartSize+10)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	testFile := filepath.Join(dir, "input.mp4")
+	require.NoError(t, os.WriteFile(testFile, data, 0644))
+
+	opts := UploadOptions{fileInput: true, RangeSplitSize: minS3PartSize, Destination: DestinationOptions{PartSize: minS3PartSize, Concurrency: 3, CacheControl: "max-age=60"}}
+	out, written, err := uploadFileWithBackup(mustParseURL(srv.URL("bucket", "vod/archive.mp4")), testFile, nil, time.Minute, false, opts)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), written)
+	require.Equal(t, srv.Server.URL+"/bucket/vod/archive.mp4", out.URL)
+
+	obj, ok := srv.Object("bucket", "vod/archive.mp4")
+	require.True(t, ok)
+	require.Equal(t, data, obj.Data)
+	require.Equal(t, "video/mp4", obj.ContentType)
+	require.Equal(t, "max-age=60", obj.CacheControl)
+	require.Equal(t, obj.VersionID, out.VersionID())
+	require.Empty(t, srv.Uploads("bucket"))
+}
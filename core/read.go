@@ -0,0 +1,180 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/livepeer/go-tools/drivers"
+)
+
+// ReadRange reads length bytes of the object at u starting at offset, or up to the end of the object if length
+// is negative, so that e.g. clipping only fetches the part of a recording it needs. Local files are read from
+// the offset and S3 and GCS objects are fetched with range requests. Storages that don't support range requests
+// are read from the start, discarding the bytes before offset.
+func ReadRange(ctx context.Context, u *url.URL, offset, length int64, opts UploadOptions) (*drivers.FileInfoReader, error) {
+	if offset < 0 || length == 0 {
+		return nil, fmt.Errorf("invalid range: offset %d, length %d", offset, length)
+	}
+	if opts.Replay == nil && (u.Scheme == "" || u.Scheme == "file") {
+		return readFileRange(u.Path, offset, length)
+	}
+	if opts.Replay == nil && u.Scheme == "gs" {
+		return readGCSRange(ctx, u, offset, length)
+	}
+
+	session, err := newSession(u, opts)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := session.ReadDataRange(ctx, "", byteRange(offset, length))
+	if errors.Is(err, drivers.ErrNotSupported) {
+		reader, err = session.ReadData(ctx, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if reader.ContentRange == "" {
+		// the whole object was returned
+		return discardRange(reader, offset, length)
+	}
+	return reader, nil
+}
+
+// byteRange formats an HTTP Range header value
+func byteRange(offset, length int64) string {
+	if length < 0 {
+		return fmt.Sprintf("bytes=%d-", offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+}
+
+var byteRangeRegexp = regexp.MustCompile(`^bytes=(\d+)-(\d*)$`)
+
+// parseByteRange parses the single ranges produced by byteRange, length is -1 for open ended ranges
+func parseByteRange(s string) (offset, length int64, err error) {
+	if s == "" {
+		return 0, -1, nil
+	}
+	m := byteRangeRegexp.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, 0, fmt.Errorf("unsupported byte range %q", s)
+	}
+	offset, _ = strconv.ParseInt(m[1], 10, 64)
+	if m[2] == "" {
+		return offset, -1, nil
+	}
+	end, _ := strconv.ParseInt(m[2], 10, 64)
+	if end < offset {
+		return 0, 0, fmt.Errorf("unsupported byte range %q", s)
+	}
+	return offset, end - offset + 1, nil
+}
+
+type rangeReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// seekRange narrows a reader of a whole object whose body can seek to the range
+func seekRange(reader *drivers.FileInfoReader, offset, length int64) (*drivers.FileInfoReader, error) {
+	seeker, ok := reader.Body.(io.Seeker)
+	if !ok || reader.Size == nil {
+		reader.Body.Close()
+		return nil, drivers.ErrNotSupported
+	}
+	size := *reader.Size
+	if offset > 0 && offset >= size {
+		reader.Body.Close()
+		return nil, fmt.Errorf("range starts at %d beyond the end of the %d byte object", offset, size)
+	}
+	if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+		reader.Body.Close()
+		return nil, err
+	}
+	return limitRange(reader, offset, length, size), nil
+}
+
+// discardRange narrows a reader of a whole object to the range by reading and discarding the bytes before it
+func discardRange(reader *drivers.FileInfoReader, offset, length int64) (*drivers.FileInfoReader, error) {
+	if _, err := io.CopyN(io.Discard, reader.Body, offset); err != nil {
+		reader.Body.Close()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("range starts at %d beyond the end of the object", offset)
+		}
+		return nil, err
+	}
+	size := int64(-1)
+	if reader.Size != nil {
+		size = *reader.Size
+	}
+	return limitRange(reader, offset, length, size), nil
+}
+
+// limitRange limits a reader positioned at offset to length bytes of an object of the given size, -1 if unknown
+func limitRange(reader *drivers.FileInfoReader, offset, length, size int64) *drivers.FileInfoReader {
+	n := length
+	if size >= 0 && (n < 0 || offset+n > size) {
+		n = size - offset
+	}
+	if n >= 0 {
+		reader.Body = rangeReadCloser{Reader: io.LimitReader(reader.Body, n), Closer: reader.Body}
+		reader.Size = &n
+	} else {
+		reader.Size = nil
+	}
+	if size >= 0 {
+		reader.ContentRange = fmt.Sprintf("bytes %d-%d/%d", offset, offset+n-1, size)
+	}
+	return reader
+}
+
+func readFileRange(fileName string, offset, length int64) (*drivers.FileInfoReader, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	size := info.Size()
+	return seekRange(&drivers.FileInfoReader{
+		FileInfo: drivers.FileInfo{Name: fileName, LastModified: info.ModTime(), Size: &size},
+		Body:     file,
+	}, offset, length)
+}
+
+// readGCSRange reads from gs://KEY_JSON@bucket/key URLs, which the drivers can't read ranges of
+func readGCSRange(ctx context.Context, u *url.URL, offset, length int64) (*drivers.FileInfoReader, error) {
+	client, err := newGCSClient(ctx, u.User.Username())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client: %w", err)
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	r, err := client.Bucket(u.Host).Object(key).NewRangeReader(ctx, offset, length)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	n := r.Remain()
+	return &drivers.FileInfoReader{
+		FileInfo:     drivers.FileInfo{Name: key, LastModified: r.Attrs.LastModified, Size: &n},
+		Body:         rangeReadCloser{Reader: r, Closer: closerFunc(func() error { r.Close(); return client.Close() })},
+		ContentType:  r.Attrs.ContentType,
+		ContentRange: fmt.Sprintf("bytes %d-%d/%d", r.Attrs.StartOffset, r.Attrs.StartOffset+n-1, r.Attrs.Size),
+	}, nil
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/livepeer/catalyst-uploader/fakes3"
+	"github.com/livepeer/go-tools/drivers"
+	"github.com/stretchr/testify/require"
+)
+
+func readAllRange(t *testing.T, reader *drivers.FileInfoReader) string {
+	defer reader.Body.Close()
+	data, err := io.ReadAll(reader.Body)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), *reader.Size)
+	return string(data)
+}
+
+func TestReadRangeFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "rec.mp4")
+	require.NoError(t, os.WriteFile(fileName, []byte("0123456789"), 0644))
+	u := mustParseURL(fileName)
+
+	reader, err := ReadRange(context.Background(), u, 2, 3, UploadOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "bytes 2-4/10", reader.ContentRange)
+	require.Equal(t, "234", readAllRange(t, reader))
+
+	reader, err = ReadRange(context.Background(), u, 7, -1, UploadOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "789", readAllRange(t, reader))
+
+	// ranges past the end are cut short
+	reader, err = ReadRange(context.Background(), u, 8, 100, UploadOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "89", readAllRange(t, reader))
+
+	_, err = ReadRange(context.Background(), u, 10, 1, UploadOptions{})
+	require.ErrorContains(t, err, "beyond the end")
+	_, err = ReadRange(context.Background(), u, 0, 0, UploadOptions{})
+	require.ErrorContains(t, err, "invalid range")
+}
+
+func TestReadRangeS3(t *testing.T) {
+	srv := fakes3.New()
+	defer srv.Close()
+	u := mustParseURL(srv.URL("bucket", "rec/output.mp4"))
+	fileName := filepath.Join(t.TempDir(), "output.mp4")
+	require.NoError(t, os.WriteFile(fileName, []byte("0123456789"), 0644))
+	_, _, err := uploadFile(u, fileName, nil, time.Second, false, UploadOptions{})
+	require.NoError(t, err)
+
+	reader, err := ReadRange(context.Background(), u, 3, 4, UploadOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "bytes 3-6/10", reader.ContentRange)
+	require.Equal(t, "3456", readAllRange(t, reader))
+}
+
+func TestReadRangeWithoutRangeSupport(t *testing.T) {
+	// the fake Bunny server ignores Range headers and always returns the whole file
+	srv, files := newFakeBunny(t)
+	files["rec/output.mp4"] = []byte("0123456789")
+	u := mustParseURL("bunny+http://zone:key@" + strings.TrimPrefix(srv.URL, "http://") + "/rec/output.mp4")
+
+	reader, err := ReadRange(context.Background(), u, 5, 2, UploadOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "bytes 5-6/10", reader.ContentRange)
+	require.Equal(t, "56", readAllRange(t, reader))
+}
+
+func TestReadRangeSSH(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test SFTP server serves the local file system, which has no POSIX paths on Windows")
+	}
+	addr, sshConfig := newTestSFTPServer(t)
+	fileName := filepath.Join(t.TempDir(), "output.mp4")
+	require.NoError(t, os.WriteFile(fileName, []byte("0123456789"), 0644))
+
+	reader, err := ReadRange(context.Background(), mustParseURL("scp://archive@"+addr+filepath.ToSlash(fileName)), 1, 2, UploadOptions{SSH: sshConfig})
+	require.NoError(t, err)
+	require.Equal(t, "12", readAllRange(t, reader))
+}
+
+func TestParseByteRange(t *testing.T) {
+	offset, length, err := parseByteRange(byteRange(10, 5))
+	require.NoError(t, err)
+	require.Equal(t, []int64{10, 5}, []int64{offset, length})
+	offset, length, err = parseByteRange(byteRange(10, -1))
+	require.NoError(t, err)
+	require.Equal(t, []int64{10, -1}, []int64{offset, length})
+	_, _, err = parseByteRange("bytes=0-1,5-6")
+	require.Error(t, err)
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+)
+
+// Differences found by Reconcile
+const (
+	// ReconcileMissing is a local file without an object at the destination
+	ReconcileMissing = "missing"
+	// ReconcileSizeMismatch and ReconcileChecksumMismatch are local files whose object differs
+	ReconcileSizeMismatch     = "size_mismatch"
+	ReconcileChecksumMismatch = "checksum_mismatch"
+	// ReconcileExtra is an object without a local file. Extra objects are reported but never deleted.
+	ReconcileExtra = "extra"
+)
+
+// ReconcileOptions configure Reconcile
+type ReconcileOptions struct {
+	// Filter selects the local files that are compared
+	Filter FileFilter
+	// Checksum also compares the MD5 checksum of local files with the one the storage reports, when it reports one
+	Checksum bool
+	// Fix uploads the missing and mismatched files again, Parallel at a time
+	Fix      bool
+	Parallel int
+}
+
+// ReconcileDifference is a file or object that differs between the local directory and the destination
+type ReconcileDifference struct {
+	Key        string `json:"key"`
+	Status     string `json:"status"`
+	LocalSize  *int64 `json:"local_size,omitempty"`
+	RemoteSize *int64 `json:"remote_size,omitempty"`
+	// Fixed is set when the file was uploaded again, and Error when that failed
+	Fixed bool   `json:"fixed,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Reconcile compares the files under localDir with the objects under prefix, after a storage incident, and returns
+// the differences sorted by key. Local files are compared by size, and by checksum with ReconcileOptions.Checksum.
+// With ReconcileOptions.Fix the missing and mismatched files are uploaded again.
+func Reconcile(ctx context.Context, localDir string, prefix *url.URL, reconcileOpts ReconcileOptions, opts UploadOptions) ([]*ReconcileDifference, error) {
+	localFiles, err := listLocalFiles(localDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", localDir, err)
+	}
+	remoteObjects, err := listObjects(ctx, prefix, opts)
+	if err != nil {
+		return nil, err
+	}
+	remote := map[string]StoredObject{}
+	for _, obj := range remoteObjects {
+		remote[obj.Key] = obj
+	}
+
+	var diffs []*ReconcileDifference
+	var uploads []*BatchUpload
+	fixed := map[*BatchUpload]*ReconcileDifference{}
+	for _, file := range localFiles {
+		if !reconcileOpts.Filter.Match(file.Key) {
+			continue
+		}
+		obj, ok := remote[file.Key]
+		delete(remote, file.Key)
+		diff := &ReconcileDifference{Key: file.Key, LocalSize: &file.Size}
+		switch {
+		case !ok:
+			diff.Status = ReconcileMissing
+		case obj.Size != file.Size:
+			diff.Status = ReconcileSizeMismatch
+			diff.RemoteSize = &obj.Size
+		case reconcileOpts.Checksum && obj.MD5 != "":
+			checksum, _, err := fileMD5(filepath.Join(localDir, filepath.FromSlash(file.Key)))
+			if err != nil {
+				return nil, fmt.Errorf("failed to hash %s: %w", file.Key, err)
+			}
+			if checksum == obj.MD5 {
+				continue
+			}
+			diff.Status = ReconcileChecksumMismatch
+			diff.RemoteSize = &obj.Size
+		default:
+			continue
+		}
+		diffs = append(diffs, diff)
+		if reconcileOpts.Fix {
+			upload := &BatchUpload{FileName: filepath.Join(localDir, filepath.FromSlash(file.Key)), URI: prefix.JoinPath(file.Key)}
+			uploads = append(uploads, upload)
+			fixed[upload] = diff
+		}
+	}
+	for _, obj := range remote {
+		obj := obj
+		if !reconcileOpts.Filter.Match(obj.Key) {
+			continue
+		}
+		diffs = append(diffs, &ReconcileDifference{Key: obj.Key, Status: ReconcileExtra, RemoteSize: &obj.Size})
+	}
+
+	if len(uploads) > 0 {
+		UploadBatch(uploads, reconcileOpts.Parallel, opts)
+		for _, upload := range uploads {
+			if upload.Err != nil {
+				fixed[upload].Error = upload.Err.Error()
+			} else {
+				fixed[upload].Fixed = true
+			}
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Key < diffs[j].Key })
+	return diffs, nil
+}
@@ -0,0 +1,52 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"720p/1.ts": "segment", "720p/index.m3u8": "#EXTM3U", "1080p/index.m3u8": "#EXTM3U", ".encoder.lock": ""}
+	for name, data := range files {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(data), 0644))
+	}
+	opts := UploadOptions{WriteTimeout: time.Second, SegmentTimeout: time.Second, DisableThumbs: []string{"/"}}
+	for key, data := range map[string]string{"720p/1.ts": "segmen", "720p/index.m3u8": "#EXTM3X", "old.m3u8": "#EXTM3U"} {
+		_, err := Upload(strings.NewReader(data), mustParseURL("memory-s3://reconcile/rec/123/"+key), opts)
+		require.NoError(t, err)
+	}
+	prefix := mustParseURL("memory-s3://reconcile/rec/123")
+
+	diffs, err := Reconcile(context.Background(), dir, prefix, ReconcileOptions{}, opts)
+	require.NoError(t, err)
+	require.Len(t, diffs, 3)
+	require.Equal(t, "1080p/index.m3u8", diffs[0].Key)
+	require.Equal(t, ReconcileMissing, diffs[0].Status)
+	require.Equal(t, "720p/1.ts", diffs[1].Key)
+	require.Equal(t, ReconcileSizeMismatch, diffs[1].Status)
+	require.Equal(t, int64(6), *diffs[1].RemoteSize)
+	require.Equal(t, "old.m3u8", diffs[2].Key)
+	require.Equal(t, ReconcileExtra, diffs[2].Status)
+
+	diffs, err = Reconcile(context.Background(), dir, prefix, ReconcileOptions{Checksum: true, Fix: true, Parallel: 2}, opts)
+	require.NoError(t, err)
+	require.Len(t, diffs, 4)
+	require.Equal(t, "720p/index.m3u8", diffs[2].Key)
+	require.Equal(t, ReconcileChecksumMismatch, diffs[2].Status)
+	for _, diff := range diffs[:3] {
+		require.True(t, diff.Fixed, diff.Key)
+	}
+
+	diffs, err = Reconcile(context.Background(), dir, prefix, ReconcileOptions{Checksum: true}, opts)
+	require.NoError(t, err)
+	require.Len(t, diffs, 1)
+	require.Equal(t, ReconcileExtra, diffs[0].Status)
+}
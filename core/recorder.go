@@ -0,0 +1,206 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-tools/drivers"
+)
+
+// RecordedCall is one storage operation as written by a Recorder, one JSON object per line
+type RecordedCall struct {
+	Time       time.Time               `json:"time"`
+	Op         string                  `json:"op"`
+	URI        string                  `json:"uri"`
+	Name       string                  `json:"name,omitempty"`
+	Size       int64                   `json:"size,omitempty"`
+	SHA256     string                  `json:"sha256,omitempty"`
+	Fields     *drivers.FileProperties `json:"fields,omitempty"`
+	DurationMs int64                   `json:"duration_ms"`
+	Error      string                  `json:"error,omitempty"`
+	OutputURL  string                  `json:"output_url,omitempty"`
+	Headers    http.Header             `json:"headers,omitempty"`
+}
+
+// Recorder writes the storage operations (uploads and deletes) of wrapped sessions to a file, so that
+// provider specific failures can be reproduced with a Replayer without sharing credentials.
+type Recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+func (r *Recorder) record(call RecordedCall) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(call); err != nil {
+		glog.Errorf("Failed to record storage call: %v", err)
+	}
+}
+
+func (r *Recorder) wrap(u *url.URL, session drivers.OSSession) drivers.OSSession {
+	if r == nil {
+		return session
+	}
+	return &recordingSession{OSSession: session, recorder: r, uri: RedactedURI(u)}
+}
+
+type recordingSession struct {
+	drivers.OSSession
+	recorder *Recorder
+	uri      string
+}
+
+func (s *recordingSession) SaveData(ctx context.Context, name string, data io.Reader, fields *drivers.FileProperties, timeout time.Duration) (*drivers.SaveDataOutput, error) {
+	call := RecordedCall{Time: time.Now(), Op: "SaveData", URI: s.uri, Name: name, Fields: fields}
+	hash := sha256.New()
+	counter := &ByteCounter{}
+	out, err := s.OSSession.SaveData(ctx, name, io.TeeReader(data, io.MultiWriter(hash, counter)), fields, timeout)
+	call.DurationMs = time.Since(call.Time).Milliseconds()
+	call.Size = counter.Count
+	call.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	if err != nil {
+		call.Error = err.Error()
+	}
+	if out != nil {
+		call.OutputURL = out.URL
+		call.Headers = out.UploaderResponseHeaders
+	}
+	s.recorder.record(call)
+	return out, err
+}
+
+func (s *recordingSession) DeleteFile(ctx context.Context, name string) error {
+	call := RecordedCall{Time: time.Now(), Op: "DeleteFile", URI: s.uri, Name: name}
+	err := s.OSSession.DeleteFile(ctx, name)
+	call.DurationMs = time.Since(call.Time).Milliseconds()
+	if err != nil {
+		call.Error = err.Error()
+	}
+	s.recorder.record(call)
+	return err
+}
+
+// Replayer serves storage operations from a file written by a Recorder instead of contacting the storage.
+// Calls are matched by operation and redacted URI, in the order they were recorded.
+type Replayer struct {
+	mu    sync.Mutex
+	calls []*RecordedCall
+	used  []bool
+}
+
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	rp := &Replayer{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var call RecordedCall
+		if err := json.Unmarshal(scanner.Bytes(), &call); err != nil {
+			return nil, fmt.Errorf("failed to parse recorded call: %w", err)
+		}
+		rp.calls = append(rp.calls, &call)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	rp.used = make([]bool, len(rp.calls))
+	return rp, nil
+}
+
+// OpenReplayer loads a recording from a file
+func OpenReplayer(fileName string) (*Replayer, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return NewReplayer(f)
+}
+
+func (rp *Replayer) next(op, uri string) (*RecordedCall, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	for i, call := range rp.calls {
+		if !rp.used[i] && call.Op == op && call.URI == uri {
+			rp.used[i] = true
+			return call, nil
+		}
+	}
+	return nil, fmt.Errorf("no recorded %s call left for %s", op, uri)
+}
+
+func (rp *Replayer) session(u *url.URL) drivers.OSSession {
+	return &replaySession{replayer: rp, uri: RedactedURI(u)}
+}
+
+// replaySession only implements the operations a Recorder records, everything else is not supported
+type replaySession struct {
+	replayer *Replayer
+	uri      string
+}
+
+func (s *replaySession) SaveData(ctx context.Context, name string, data io.Reader, fields *drivers.FileProperties, timeout time.Duration) (*drivers.SaveDataOutput, error) {
+	call, err := s.replayer.next("SaveData", s.uri)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	if _, err := copyBuffered(hash, data); err != nil {
+		return nil, err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); call.SHA256 != "" && sum != call.SHA256 {
+		glog.Warningf("Replayed upload to %s has different content than the recording: sha256=%s recorded=%s", s.uri, sum, call.SHA256)
+	}
+	if call.Error != "" {
+		return nil, errors.New(call.Error)
+	}
+	return &drivers.SaveDataOutput{URL: call.OutputURL, UploaderResponseHeaders: call.Headers}, nil
+}
+
+func (s *replaySession) DeleteFile(ctx context.Context, name string) error {
+	call, err := s.replayer.next("DeleteFile", s.uri)
+	if err != nil {
+		return err
+	}
+	if call.Error != "" {
+		return errors.New(call.Error)
+	}
+	return nil
+}
+
+func (s *replaySession) OS() drivers.OSDriver     { return nil }
+func (s *replaySession) EndSession()              {}
+func (s *replaySession) GetInfo() *drivers.OSInfo { return nil }
+func (s *replaySession) IsExternal() bool         { return false }
+func (s *replaySession) IsOwn(url string) bool    { return false }
+
+func (s *replaySession) ListFiles(ctx context.Context, prefix, delim string) (drivers.PageInfo, error) {
+	return nil, drivers.ErrNotSupported
+}
+
+func (s *replaySession) ReadData(ctx context.Context, name string) (*drivers.FileInfoReader, error) {
+	return nil, drivers.ErrNotSupported
+}
+
+func (s *replaySession) ReadDataRange(ctx context.Context, name, byteRange string) (*drivers.FileInfoReader, error) {
+	return nil, drivers.ErrNotSupported
+}
+
+func (s *replaySession) Presign(name string, expire time.Duration) (string, error) {
+	return "", drivers.ErrNotSupported
+}
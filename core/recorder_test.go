@@ -0,0 +1,47 @@
+package core
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "input.txt")
+	require.NoError(t, os.WriteFile(testFile, []byte("test"), 0644))
+	output := mustParseURL(filepath.ToSlash(filepath.Join(dir, "out", "file.txt")))
+	failing := mustParseURL(filepath.ToSlash(filepath.Join(dir, "out", "failing.txt")))
+
+	var recording bytes.Buffer
+	recorder := NewRecorder(&recording)
+	out, written, err := uploadFileWithBackup(output, testFile, nil, 0, false, UploadOptions{Record: recorder})
+	require.NoError(t, err)
+	require.Equal(t, int64(4), written)
+	require.False(t, out.Fallback)
+	_, _, err = uploadFileWithBackup(failing, testFile, nil, 0, false, UploadOptions{
+		Record:         recorder,
+		FaultInjection: &FaultProfile{ErrorRate: 1},
+	})
+	require.ErrorIs(t, err, ErrInjectedFault)
+
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "out")))
+	replayer, err := NewReplayer(&recording)
+	require.NoError(t, err)
+	opts := UploadOptions{Replay: replayer}
+
+	replayed, _, err := uploadFileWithBackup(output, testFile, nil, 0, false, opts)
+	require.NoError(t, err)
+	require.Equal(t, out.URL, replayed.URL)
+	_, err = os.Stat(filepath.Join(dir, "out", "file.txt"))
+	require.True(t, os.IsNotExist(err), "replay must not write to storage")
+
+	_, _, err = uploadFileWithBackup(failing, testFile, nil, 0, false, opts)
+	require.ErrorContains(t, err, "injected fault")
+
+	_, _, err = uploadFileWithBackup(output, testFile, nil, 0, false, opts)
+	require.ErrorContains(t, err, "no recorded SaveData call left")
+}
@@ -0,0 +1,158 @@
+package core
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisTimeout bounds publishing the state of a stream to Redis
+const redisTimeout = 5 * time.Second
+
+// DefaultStreamStatePrefix is the prefix of the Redis keys of StreamState unless another one is configured
+const DefaultStreamStatePrefix = "catalyst-uploader:"
+
+// StreamState publishes the upload state of a stream to Redis keys that expire after TTL, which health checks
+// poll to detect stalled recordings: <prefix><stream>:last_segment when a segment is uploaded and
+// <prefix><stream>:last_manifest_write when a manifest is written, each holding the JSON of a streamStateEntry.
+// Only the SET command is used, so that any Redis compatible server will do.
+type StreamState struct {
+	addr     string
+	tls      bool
+	user     string
+	password string
+	db       int
+	prefix   string
+	stream   string
+	ttl      time.Duration
+}
+
+// streamStateEntry is the value of the Redis keys of StreamState
+type streamStateEntry struct {
+	URI  string    `json:"uri"`
+	Time time.Time `json:"time"`
+}
+
+// ParseStreamState parses a redis://[user:password@]host[:port][/db] URL, or rediss:// for TLS, into the state of
+// stream published under prefix with the given TTL
+func ParseStreamState(redisURL, prefix, stream string, ttl time.Duration) (*StreamState, error) {
+	u, err := url.Parse(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("unsupported Redis URL scheme %q, expected redis or rediss", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("Redis URL requires a host")
+	}
+	if stream == "" {
+		return nil, errors.New("stream state requires a stream ID")
+	}
+	if ttl <= 0 {
+		return nil, errors.New("stream state TTL must be positive")
+	}
+	s := &StreamState{addr: u.Host, tls: u.Scheme == "rediss", prefix: prefix, stream: stream, ttl: ttl}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		s.user = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if s.db, err = strconv.Atoi(db); err != nil || s.db < 0 {
+			return nil, fmt.Errorf("invalid Redis database %q", db)
+		}
+	}
+	return s, nil
+}
+
+// segmentUploaded records the upload of the segment at u
+func (s *StreamState) segmentUploaded(u *url.URL, opts UploadOptions) {
+	s.publish("last_segment", u, opts)
+}
+
+// manifestWritten records a write of the manifest at u
+func (s *StreamState) manifestWritten(u *url.URL, opts UploadOptions) {
+	s.publish("last_manifest_write", u, opts)
+}
+
+// publish sets a key of the stream, logging failures rather than failing the upload
+func (s *StreamState) publish(name string, u *url.URL, opts UploadOptions) {
+	if s == nil {
+		return
+	}
+	key := s.prefix + s.stream + ":" + name
+	value, err := json.Marshal(streamStateEntry{URI: RedactedURI(u), Time: time.Now().UTC()})
+	if err == nil {
+		err = s.set(key, string(value))
+	}
+	if err != nil {
+		logRepeatedError("redis-state", opts, "Failed to publish %s to Redis: %v", key, err)
+	}
+}
+
+func (s *StreamState) set(key, value string) error {
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	var conn net.Conn
+	var err error
+	if s.tls {
+		host, _, _ := net.SplitHostPort(s.addr)
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", s.addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(redisTimeout)); err != nil {
+		return err
+	}
+	var commands [][]string
+	switch {
+	case s.user != "" && s.password != "":
+		commands = append(commands, []string{"AUTH", s.user, s.password})
+	case s.password != "":
+		commands = append(commands, []string{"AUTH", s.password})
+	}
+	if s.db != 0 {
+		commands = append(commands, []string{"SELECT", strconv.Itoa(s.db)})
+	}
+	commands = append(commands, []string{"SET", key, value, "PX", strconv.FormatInt(s.ttl.Milliseconds(), 10)})
+
+	// commands are pipelined and their replies read in order
+	w := bufio.NewWriter(conn)
+	for _, args := range commands {
+		fmt.Fprintf(w, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	for _, args := range commands {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, "-") {
+			return fmt.Errorf("%s failed: %s", args[0], strings.TrimPrefix(line, "-"))
+		}
+		if !strings.HasPrefix(line, "+") {
+			return fmt.Errorf("unexpected reply to %s: %q", args[0], line)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis records the commands it receives, replying OK to all but AUTH with the wrong password
+type fakeRedis struct {
+	mu       sync.Mutex
+	commands [][]string
+}
+
+func (f *fakeRedis) serve(t *testing.T, l net.Listener, password string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+				require.NoError(t, err)
+				var args []string
+				for i := 0; i < n; i++ {
+					_, _ = r.ReadString('\n')
+					arg, _ := r.ReadString('\n')
+					args = append(args, strings.TrimSuffix(arg, "\r\n"))
+				}
+				f.mu.Lock()
+				f.commands = append(f.commands, args)
+				f.mu.Unlock()
+				if args[0] == "AUTH" && args[len(args)-1] != password {
+					_, _ = conn.Write([]byte("-WRONGPASS invalid username-password pair\r\n"))
+					continue
+				}
+				_, _ = conn.Write([]byte("+OK\r\n"))
+			}
+		}()
+	}
+}
+
+func TestParseStreamState(t *testing.T) {
+	s, err := ParseStreamState("redis://:secret@localhost/2", "uploader:", "abcd", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, "localhost:6379", s.addr)
+	require.Equal(t, "secret", s.password)
+	require.Equal(t, 2, s.db)
+	require.False(t, s.tls)
+
+	_, err = ParseStreamState("http://localhost", "", "abcd", time.Minute)
+	require.Error(t, err)
+	_, err = ParseStreamState("redis://localhost/db", "", "abcd", time.Minute)
+	require.Error(t, err)
+	_, err = ParseStreamState("redis://localhost", "", "", time.Minute)
+	require.Error(t, err)
+}
+
+func TestStreamState(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	redis := &fakeRedis{}
+	go redis.serve(t, l, "secret")
+
+	state, err := ParseStreamState("redis://:secret@"+l.Addr().String()+"/3", DefaultStreamStatePrefix, "abcd", time.Minute)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.ts")
+	require.NoError(t, os.WriteFile(input, []byte("segment"), 0644))
+	opts := UploadOptions{StreamState: state, SegmentTimeout: time.Second, WaitBetweenWrites: time.Hour}
+	_, err = UploadFiles([]string{input}, mustParseURL(filepath.ToSlash(filepath.Join(dir, "out", "0.ts"))), opts)
+	require.NoError(t, err)
+	_, err = Upload(strings.NewReader("#EXTM3U\n"), mustParseURL(filepath.ToSlash(filepath.Join(dir, "out", "index.m3u8"))), opts)
+	require.NoError(t, err)
+
+	redis.mu.Lock()
+	defer redis.mu.Unlock()
+	var sets [][]string
+	for _, args := range redis.commands {
+		switch args[0] {
+		case "AUTH":
+			require.Equal(t, []string{"AUTH", "secret"}, args)
+		case "SELECT":
+			require.Equal(t, []string{"SELECT", "3"}, args)
+		case "SET":
+			sets = append(sets, args)
+		}
+	}
+	require.Len(t, sets, 2)
+	require.Equal(t, "catalyst-uploader:abcd:last_segment", sets[0][1])
+	require.Equal(t, "catalyst-uploader:abcd:last_manifest_write", sets[1][1])
+	require.Equal(t, []string{"PX", "60000"}, sets[0][3:])
+	var entry streamStateEntry
+	require.NoError(t, json.Unmarshal([]byte(sets[0][2]), &entry))
+	require.True(t, strings.HasSuffix(entry.URI, "/out/0.ts"), entry.URI)
+	require.WithinDuration(t, time.Now(), entry.Time, time.Minute)
+
+	// a rejected password is only logged
+	state.password = "wrong"
+	state.segmentUploaded(mustParseURL("s3://bucket/0.ts"), UploadOptions{})
+}
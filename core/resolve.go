@@ -0,0 +1,247 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ParseResolveOverrides parses curl style host:port:addr entries into a map of host:port to the address to
+// connect to instead of resolving the host. IPv6 addresses are given in brackets, e.g. host:443:[::1].
+func ParseResolveOverrides(entries []string) (map[string]string, error) {
+	overrides := map[string]string{}
+	for _, entry := range entries {
+		host, rest, ok := strings.Cut(entry, ":")
+		port, addr, ok2 := strings.Cut(rest, ":")
+		if !ok || !ok2 || host == "" {
+			return nil, fmt.Errorf("invalid resolve override %q, expected host:port:addr", entry)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			return nil, fmt.Errorf("invalid port in resolve override %q", entry)
+		}
+		addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+		if net.ParseIP(addr) == nil {
+			return nil, fmt.Errorf("invalid address in resolve override %q", entry)
+		}
+		overrides[net.JoinHostPort(strings.ToLower(host), port)] = addr
+	}
+	return overrides, nil
+}
+
+const (
+	// defaultFallbackDelay is the delay before the other IP family is tried when the dialer doesn't set one,
+	// the same as net.Dialer's
+	defaultFallbackDelay = 300 * time.Millisecond
+	// minDialTimeout is the least time dialSerial gives an address, the same as net.Dialer's
+	minDialTimeout = 2 * time.Second
+)
+
+// resolvingDialer dials the overridden address of a host:port if there is one, and otherwise the addresses of
+// the host, which are cached for cacheTTL if set and dialed as net.Dialer dials the addresses it resolves
+type resolvingDialer struct {
+	dialer    *net.Dialer
+	overrides map[string]string
+	cacheTTL  time.Duration
+	lookup    func(ctx context.Context, host string) ([]string, error)
+
+	mu    sync.Mutex
+	cache map[string]dnsCacheEntry
+}
+
+type dnsCacheEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+func newResolvingDialer(dialer *net.Dialer, overrides map[string]string, cacheTTL time.Duration) *resolvingDialer {
+	return &resolvingDialer{
+		dialer:    dialer,
+		overrides: overrides,
+		cacheTTL:  cacheTTL,
+		lookup:    net.DefaultResolver.LookupHost,
+		cache:     map[string]dnsCacheEntry{},
+	}
+}
+
+func (d *resolvingDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return d.dialer.DialContext(ctx, network, addr)
+	}
+	if override, ok := d.overrides[net.JoinHostPort(strings.ToLower(host), port)]; ok {
+		return d.dialer.DialContext(ctx, network, net.JoinHostPort(override, port))
+	}
+	if d.cacheTTL <= 0 || net.ParseIP(host) != nil {
+		return d.dialer.DialContext(ctx, network, addr)
+	}
+	addrs, err := d.lookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	primaries, fallbacks := partitionAddrs(network, addrs)
+	if len(primaries) == 0 {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: &net.AddrError{Err: "no suitable address found", Addr: host}}
+	}
+	if d.dialer.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.dialer.Timeout)
+		defer cancel()
+	}
+	conn, err := d.dialParallel(ctx, network, port, primaries, fallbacks)
+	if err != nil {
+		// the cached addresses may be stale, look the host up again next time
+		d.mu.Lock()
+		delete(d.cache, host)
+		d.mu.Unlock()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// partitionAddrs keeps the addresses of the network's IP family, e.g. only the IPv4 ones for tcp4, and splits
+// them as net.Dialer does into the family of the first address and the other one to fall back to
+func partitionAddrs(network string, addrs []string) (primaries, fallbacks []string) {
+	var primaryIsIPv4 bool
+	for _, a := range addrs {
+		ip := net.ParseIP(a)
+		if ip == nil {
+			continue
+		}
+		isIPv4 := ip.To4() != nil
+		if (strings.HasSuffix(network, "4") && !isIPv4) || (strings.HasSuffix(network, "6") && isIPv4) {
+			continue
+		}
+		if len(primaries) == 0 {
+			primaryIsIPv4 = isIPv4
+		}
+		if isIPv4 == primaryIsIPv4 {
+			primaries = append(primaries, a)
+		} else {
+			fallbacks = append(fallbacks, a)
+		}
+	}
+	return primaries, fallbacks
+}
+
+// dialParallel races the fallback addresses against the primary ones once the dialer's FallbackDelay has passed
+// without a connection, or as soon as the primary ones have all failed, like the Happy Eyeballs of net.Dialer.
+// The connection that loses the race is closed.
+func (d *resolvingDialer) dialParallel(ctx context.Context, network, port string, primaries, fallbacks []string) (net.Conn, error) {
+	if len(fallbacks) == 0 {
+		return d.dialSerial(ctx, network, port, primaries)
+	}
+	if d.dialer.FallbackDelay < 0 {
+		return d.dialSerial(ctx, network, port, append(append([]string(nil), primaries...), fallbacks...))
+	}
+	fallbackDelay := d.dialer.FallbackDelay
+	if fallbackDelay == 0 {
+		fallbackDelay = defaultFallbackDelay
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan dialResult, 2)
+	dial := func(addrs []string) {
+		conn, err := d.dialSerial(ctx, network, port, addrs)
+		results <- dialResult{conn, err}
+	}
+	go dial(primaries)
+	pending := 1
+	fallbackTimer := time.NewTimer(fallbackDelay)
+	defer fallbackTimer.Stop()
+	startFallback := func() {
+		if fallbacks != nil {
+			go dial(fallbacks)
+			fallbacks = nil
+			pending++
+		}
+	}
+
+	var firstErr error
+	for {
+		select {
+		case <-fallbackTimer.C:
+			startFallback()
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if res := <-results; res.conn != nil {
+							_ = res.conn.Close()
+						}
+					}
+				}(pending)
+				return res.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			startFallback()
+			if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+// dialSerial dials the addresses in turn until one connects. Like net.Dialer, each address gets an equal share
+// of the time left before the deadline of ctx, but at least minDialTimeout, so that an address that doesn't
+// answer can't use up the time of the others.
+func (d *resolvingDialer) dialSerial(ctx context.Context, network, port string, addrs []string) (net.Conn, error) {
+	// the timeout of the dialer is the deadline of ctx
+	dialer := *d.dialer
+	dialer.Timeout = 0
+	var firstErr error
+	for i, a := range addrs {
+		if err := ctx.Err(); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			break
+		}
+		dialCtx, cancel := ctx, context.CancelFunc(func() {})
+		if deadline, ok := ctx.Deadline(); ok {
+			remaining := time.Until(deadline)
+			timeout := remaining / time.Duration(len(addrs)-i)
+			if timeout < minDialTimeout {
+				timeout = min(minDialTimeout, remaining)
+			}
+			dialCtx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		conn, err := dialer.DialContext(dialCtx, network, net.JoinHostPort(a, port))
+		cancel()
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+func (d *resolvingDialer) lookupHost(ctx context.Context, host string) ([]string, error) {
+	d.mu.Lock()
+	entry, ok := d.cache[host]
+	d.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.addrs, nil
+	}
+	addrs, err := d.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	d.cache[host] = dnsCacheEntry{addrs: addrs, expires: time.Now().Add(d.cacheTTL)}
+	d.mu.Unlock()
+	return addrs, nil
+}
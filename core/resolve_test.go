@@ -0,0 +1,163 @@
+package core
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseResolveOverrides(t *testing.T) {
+	overrides, err := ParseResolveOverrides([]string{"S3.example.com:443:203.0.113.7", "storage.example.com:8443:[2001:db8::1]"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"s3.example.com:443":       "203.0.113.7",
+		"storage.example.com:8443": "2001:db8::1",
+	}, overrides)
+
+	for _, entry := range []string{"s3.example.com", "s3.example.com:443", ":443:203.0.113.7", "s3.example.com:https:203.0.113.7", "s3.example.com:443:s3.example.org"} {
+		_, err := ParseResolveOverrides([]string{entry})
+		require.Error(t, err, entry)
+	}
+}
+
+func TestResolveOverride(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Host))
+	}))
+	t.Cleanup(srv.Close)
+	srvURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	opts := DefaultTransportOptions()
+	opts.Resolve = map[string]string{"storage.invalid:" + srvURL.Port(): srvURL.Hostname()}
+	client := &http.Client{Transport: newSharedTransport(opts)}
+	resp, err := client.Get("http://storage.invalid:" + srvURL.Port() + "/bucket/key")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestDNSCache(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+
+	dialer := newResolvingDialer(&net.Dialer{}, nil, time.Minute)
+	lookups := 0
+	addrs := []string{"127.0.0.1"}
+	dialer.lookup = func(ctx context.Context, host string) ([]string, error) {
+		lookups++
+		return addrs, nil
+	}
+	for i := 0; i < 3; i++ {
+		conn, err := dialer.DialContext(context.Background(), "tcp", "storage.invalid:"+port)
+		require.NoError(t, err)
+		_ = conn.Close()
+	}
+	require.Equal(t, 1, lookups)
+
+	// addresses that can't be connected to are looked up again
+	_ = listener.Close()
+	_, err = dialer.DialContext(context.Background(), "tcp", "storage.invalid:"+port)
+	require.Error(t, err)
+	require.NotContains(t, dialer.cache, "storage.invalid")
+}
+
+// acceptingListener listens on 127.0.0.1 and closes the connections it accepts
+func acceptingListener(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+	return port
+}
+
+func TestPartitionAddrs(t *testing.T) {
+	addrs := []string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2"}
+	for _, tt := range []struct {
+		network              string
+		primaries, fallbacks []string
+	}{
+		{"tcp", []string{"2001:db8::1", "2001:db8::2"}, []string{"192.0.2.1", "192.0.2.2"}},
+		{"tcp4", []string{"192.0.2.1", "192.0.2.2"}, nil},
+		{"tcp6", []string{"2001:db8::1", "2001:db8::2"}, nil},
+	} {
+		primaries, fallbacks := partitionAddrs(tt.network, addrs)
+		require.Equal(t, tt.primaries, primaries, tt.network)
+		require.Equal(t, tt.fallbacks, fallbacks, tt.network)
+	}
+}
+
+func TestDNSCacheNetwork(t *testing.T) {
+	port := acceptingListener(t)
+	dialer := newResolvingDialer(&net.Dialer{}, nil, time.Minute)
+	dialer.lookup = func(ctx context.Context, host string) ([]string, error) {
+		return []string{"2001:db8::1", "127.0.0.1"}, nil
+	}
+
+	// tcp4 skips the IPv6 address
+	conn, err := dialer.DialContext(context.Background(), "tcp4", "storage.invalid:"+port)
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1:"+port, conn.RemoteAddr().String())
+	_ = conn.Close()
+
+	dialer.lookup = func(ctx context.Context, host string) ([]string, error) {
+		return []string{"127.0.0.1"}, nil
+	}
+	_, err = dialer.DialContext(context.Background(), "tcp6", "ipv4-only.invalid:"+port)
+	require.ErrorContains(t, err, "no suitable address found")
+}
+
+func TestDNSCacheFallback(t *testing.T) {
+	port := acceptingListener(t)
+	// 192.0.2.0/24 and 2001:db8::/32 are reserved for documentation, so dialing them either fails or hangs
+	for _, tt := range []struct {
+		name  string
+		addrs []string
+	}{
+		// the IPv4 address is raced against the IPv6 one after the fallback delay
+		{"fallback", []string{"2001:db8::1", "2001:db8::2", "127.0.0.1"}},
+		// and the timeout is split between the addresses of the same family
+		{"split timeout", []string{"192.0.2.1", "127.0.0.1"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer := newResolvingDialer(&net.Dialer{Timeout: 6 * time.Second, FallbackDelay: 50 * time.Millisecond}, nil, time.Minute)
+			dialer.lookup = func(ctx context.Context, host string) ([]string, error) {
+				return tt.addrs, nil
+			}
+			start := time.Now()
+			conn, err := dialer.DialContext(context.Background(), "tcp", "storage.invalid:"+port)
+			require.NoError(t, err)
+			require.Equal(t, "127.0.0.1:"+port, conn.RemoteAddr().String())
+			require.Less(t, time.Since(start), 4*time.Second)
+			_ = conn.Close()
+		})
+	}
+}
@@ -0,0 +1,348 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/golang/glog"
+	"github.com/livepeer/go-tools/drivers"
+)
+
+// resumePartSize is the default part size of resumable uploads, which fits sources of up to 640GiB in
+// maxS3Parts. It's also how much of the source a restart may have to read again.
+const resumePartSize = 64 * 1024 * 1024
+
+// SupportsResume reports whether uploads to u can be resumed, see UploadOptions.ResumeDir
+func SupportsResume(u *url.URL) bool {
+	return u.Scheme == "memory-s3" || isS3URL(u) || isSpacesURL(u)
+}
+
+// resumeState is the progress of a resumable upload, persisted after each part
+type resumeState struct {
+	UploadID string `json:"upload_id"`
+	PartSize int64  `json:"part_size"`
+	// Validator identifies the version of the source the parts were read from
+	Validator string `json:"validator"`
+	// ETags of the uploaded parts, in order
+	Parts []string `json:"parts"`
+}
+
+// resumeSource is the input of a resumable upload, which can be read again from an offset
+type resumeSource interface {
+	// open reads the source from offset, failing with errSourceChanged if it no longer has validator
+	open(offset int64, validator string) (io.ReadCloser, error)
+	// validator identifies the version of the source read by the last open, once reading started
+	validator() string
+}
+
+// ResumeUploadFile uploads a large local file, e.g. for a VOD import, as a multipart upload that continues
+// where it stopped if the uploader is restarted, see UploadOptions.ResumeDir. The file must be unchanged, by
+// size and modification time, for a previous upload to be resumed.
+func ResumeUploadFile(fileName string, outputURI *url.URL, opts UploadOptions) (*UploadResult, error) {
+	fileName, err := filepath.Abs(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return uploadResumable(&fileResumeSource{fileName: fileName}, "file://"+fileName, outputURI, opts)
+}
+
+// ResumePull uploads an HTTP(S) source like PullSource, as a multipart upload that continues where it stopped
+// if the uploader is restarted, see UploadOptions.ResumeDir. The source is read again with a Range request,
+// which requires it to have the same ETag or Last-Modified time.
+func ResumePull(ctx context.Context, source *url.URL, outputURI *url.URL, opts UploadOptions) (*UploadResult, error) {
+	return uploadResumable(&pullResumeSource{ctx: ctx, source: source}, locationString(source), outputURI, opts)
+}
+
+func uploadResumable(src resumeSource, sourceID string, outputURI *url.URL, opts UploadOptions) (*UploadResult, error) {
+	if outputURI.Scheme == "memory-s3" {
+		s3URL, err := url.Parse(memoryS3URL(outputURI))
+		if err != nil {
+			return nil, err
+		}
+		return uploadResumable(src, sourceID, s3URL, opts)
+	}
+	if !SupportsResume(outputURI) {
+		return nil, fmt.Errorf("resumable uploads to %s destinations: %w", outputURI.Scheme, drivers.ErrNotSupported)
+	}
+	if opts.ResumeDir == "" {
+		return nil, errors.New("resumable uploads need a directory to keep their progress in")
+	}
+	if err := os.MkdirAll(opts.ResumeDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create resume directory: %w", err)
+	}
+	sum := sha256.Sum256([]byte(sourceID + "\n" + locationString(outputURI)))
+	stateFile := filepath.Join(opts.ResumeDir, hex.EncodeToString(sum[:8])+".json")
+
+	dest, err := parseS3URL(outputURI)
+	if err != nil {
+		return nil, err
+	}
+	dest.storageClass = opts.Destination.StorageClass
+	sess, err := dest.newSession()
+	if err != nil {
+		return nil, err
+	}
+	upload := &resumableUpload{svc: s3.New(sess), uri: outputURI, dest: dest, stateFile: stateFile, opts: opts}
+
+	result, err := upload.run(src)
+	if errors.Is(err, errSourceChanged) && len(upload.state.Parts) > 0 {
+		// what was uploaded is of another version of the source, start over
+		glog.Warningf("Source of %s changed since its upload started, uploading it again", outputURI.Redacted())
+		upload.abort()
+		result, err = upload.run(src)
+	}
+	return result, err
+}
+
+type resumableUpload struct {
+	svc       *s3.S3
+	uri       *url.URL
+	dest      *s3Destination
+	stateFile string
+	state     resumeState
+	opts      UploadOptions
+}
+
+// run uploads the source, resuming a previous upload if its state was saved
+func (u *resumableUpload) run(src resumeSource) (*UploadResult, error) {
+	ctx := context.Background()
+	if err := u.loadState(ctx); err != nil {
+		return nil, err
+	}
+	if u.state.UploadID == "" {
+		if err := checkNoClobber(u.uri, u.opts); err != nil {
+			return nil, err
+		}
+		create := &s3.CreateMultipartUploadInput{
+			Bucket:      aws.String(u.dest.bucket),
+			Key:         aws.String(u.dest.key),
+			ContentType: aws.String(appendContentType(u.dest.key)),
+		}
+		if u.dest.storageClass != "" {
+			create.StorageClass = aws.String(u.dest.storageClass)
+		}
+		if u.opts.Destination.CacheControl != "" {
+			create.CacheControl = aws.String(u.opts.Destination.CacheControl)
+		}
+		out, err := u.svc.CreateMultipartUploadWithContext(ctx, create)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start multipart upload: %w", err)
+		}
+		u.state = resumeState{UploadID: aws.StringValue(out.UploadId), PartSize: resumePartSize}
+		if u.opts.Destination.PartSize > 0 {
+			u.state.PartSize = max(u.opts.Destination.PartSize, minS3PartSize)
+		}
+	} else {
+		glog.Infof("Resuming upload of %s after %d parts", u.dest.objectURL(u.dest.key), len(u.state.Parts))
+	}
+
+	offset := int64(len(u.state.Parts)) * u.state.PartSize
+	input, err := src.open(offset, u.state.Validator)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+	buf := make([]byte, u.state.PartSize)
+	for {
+		n, readErr := io.ReadFull(input, buf)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("failed to read source after %d bytes: %w", offset, readErr)
+		}
+		if n > 0 || len(u.state.Parts) == 0 {
+			u.state.Validator = src.validator()
+			if err := u.uploadPart(ctx, buf[:n]); err != nil {
+				return nil, err
+			}
+			offset += int64(n)
+		}
+		if readErr != nil {
+			break
+		}
+	}
+
+	var parts []*s3.CompletedPart
+	for i, etag := range u.state.Parts {
+		parts = append(parts, &s3.CompletedPart{ETag: aws.String(etag), PartNumber: aws.Int64(int64(i + 1))})
+	}
+	out, err := u.svc.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(u.dest.bucket),
+		Key:             aws.String(u.dest.key),
+		UploadId:        aws.String(u.state.UploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	if err := os.Remove(u.stateFile); err != nil && !os.IsNotExist(err) {
+		glog.Errorf("Failed to remove the progress of %s: %v", u.dest.objectURL(u.dest.key), err)
+	}
+	glog.Infof("Completed resumable upload of %s: %d bytes", u.dest.objectURL(u.dest.key), offset)
+	result := &UploadResult{SaveDataOutput: drivers.SaveDataOutput{URL: u.dest.objectURL(u.dest.key)}}
+	result.UploaderResponseHeaders = http.Header{}
+	if out.VersionId != nil {
+		result.UploaderResponseHeaders.Set("X-Amz-Version-Id", *out.VersionId)
+	}
+	return result, nil
+}
+
+// uploadPart uploads the next part and saves the progress
+func (u *resumableUpload) uploadPart(ctx context.Context, data []byte) error {
+	n := int64(len(u.state.Parts) + 1)
+	if n > maxS3Parts {
+		return fmt.Errorf("source is larger than %d parts of %d bytes", maxS3Parts, u.state.PartSize)
+	}
+	err := backoff.Retry(func() error {
+		partCtx, cancel := context.WithTimeout(ctx, appendTimeout(u.opts))
+		defer cancel()
+		out, err := u.svc.UploadPartWithContext(partCtx, &s3.UploadPartInput{
+			Bucket:     aws.String(u.dest.bucket),
+			Key:        aws.String(u.dest.key),
+			UploadId:   aws.String(u.state.UploadID),
+			PartNumber: aws.Int64(n),
+			Body:       bytes.NewReader(data),
+		})
+		if err != nil {
+			glog.Errorf("failed to upload part %d of %s: %v", n, u.dest.objectURL(u.dest.key), err)
+			return err
+		}
+		u.state.Parts = append(u.state.Parts, aws.StringValue(out.ETag))
+		return nil
+	}, SingleRequestRetryBackoff())
+	if err != nil {
+		return fmt.Errorf("failed to upload part %d: %w", n, err)
+	}
+	glog.V(5).Infof("Uploaded part %d of %s: %d bytes", n, u.dest.objectURL(u.dest.key), len(data))
+	return u.saveState()
+}
+
+// loadState reads the progress of a previous upload, keeping the parts that S3 still has
+func (u *resumableUpload) loadState(ctx context.Context) error {
+	u.state = resumeState{}
+	data, err := os.ReadFile(u.stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read upload progress: %w", err)
+	}
+	var state resumeState
+	if err := json.Unmarshal(data, &state); err != nil || state.UploadID == "" || state.PartSize <= 0 {
+		glog.Warningf("Ignoring invalid upload progress in %s", u.stateFile)
+		return nil
+	}
+	uploaded := map[int64]string{}
+	err = u.svc.ListPartsPagesWithContext(ctx, &s3.ListPartsInput{
+		Bucket:   aws.String(u.dest.bucket),
+		Key:      aws.String(u.dest.key),
+		UploadId: aws.String(state.UploadID),
+	}, func(page *s3.ListPartsOutput, _ bool) bool {
+		for _, part := range page.Parts {
+			uploaded[aws.Int64Value(part.PartNumber)] = aws.StringValue(part.ETag)
+		}
+		return true
+	})
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound {
+		glog.Warningf("Multipart upload of %s expired, starting over", u.dest.objectURL(u.dest.key))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list uploaded parts: %w", err)
+	}
+	for i, etag := range state.Parts {
+		if uploaded[int64(i+1)] != etag {
+			state.Parts = state.Parts[:i]
+			break
+		}
+	}
+	u.state = state
+	return nil
+}
+
+// saveState persists the progress, written to a temp file and renamed so that a crash doesn't truncate it
+func (u *resumableUpload) saveState() error {
+	data, err := json.Marshal(u.state)
+	if err != nil {
+		return err
+	}
+	tmp := u.stateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to save upload progress: %w", err)
+	}
+	if err := os.Rename(tmp, u.stateFile); err != nil {
+		return fmt.Errorf("failed to save upload progress: %w", err)
+	}
+	return nil
+}
+
+// abort discards the multipart upload and its progress
+func (u *resumableUpload) abort() {
+	_, _ = u.svc.AbortMultipartUploadWithContext(context.Background(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(u.dest.bucket),
+		Key:      aws.String(u.dest.key),
+		UploadId: aws.String(u.state.UploadID),
+	})
+	_ = os.Remove(u.stateFile)
+	u.state = resumeState{}
+}
+
+type fileResumeSource struct {
+	fileName string
+	version  string
+}
+
+func (s *fileResumeSource) open(offset int64, validator string) (io.ReadCloser, error) {
+	file, err := os.Open(s.fileName)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	s.version = strconv.FormatInt(info.Size(), 10) + "-" + strconv.FormatInt(info.ModTime().UnixNano(), 10)
+	if offset > 0 && s.version != validator {
+		file.Close()
+		return nil, fmt.Errorf("failed to read %s: %w", s.fileName, errSourceChanged)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func (s *fileResumeSource) validator() string {
+	return s.version
+}
+
+type pullResumeSource struct {
+	ctx    context.Context
+	source *url.URL
+	reader *pullReader
+}
+
+func (s *pullResumeSource) open(offset int64, validator string) (io.ReadCloser, error) {
+	s.reader = &pullReader{ctx: s.ctx, source: s.source, retries: backoff.WithContext(PullRetryBackoff(), s.ctx), size: -1, offset: offset, validator: validator}
+	return s.reader, nil
+}
+
+func (s *pullResumeSource) validator() string {
+	return s.reader.validator
+}
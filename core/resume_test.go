@@ -0,0 +1,105 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testResumeSource serves data, failing after failAfter bytes if set
+type testResumeSource struct {
+	data      []byte
+	version   string
+	failAfter int64
+	opened    []int64
+}
+
+func (s *testResumeSource) open(offset int64, validator string) (io.ReadCloser, error) {
+	s.opened = append(s.opened, offset)
+	if offset > 0 && validator != s.version {
+		return nil, errSourceChanged
+	}
+	var r io.Reader = bytes.NewReader(s.data[offset:])
+	if s.failAfter > 0 {
+		r = io.MultiReader(io.LimitReader(r, s.failAfter-offset), &failingReader{})
+	}
+	return io.NopCloser(r), nil
+}
+
+func (s *testResumeSource) validator() string {
+	return s.version
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestResumableUpload(t *testing.T) {
+	data := make([]byte, 2*minS3PartSize+1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	opts := UploadOptions{ResumeDir: t.TempDir(), Destination: DestinationOptions{PartSize: minS3PartSize}, SegmentTimeout: 10 * time.Second}
+	u := mustParseURL("memory-s3://resume/vod/import.mp4")
+
+	// the first attempt stops in the middle of the second part
+	source := &testResumeSource{data: data, version: "v1", failAfter: minS3PartSize + 100}
+	_, err := uploadResumable(source, "test://import.mp4", u, opts)
+	require.ErrorContains(t, err, "connection reset")
+	_, ok := memoryS3.server.Object("resume", "vod/import.mp4")
+	require.False(t, ok)
+	require.Len(t, memoryS3.server.Uploads("resume"), 1)
+
+	// the next one only reads what wasn't uploaded
+	source = &testResumeSource{data: data, version: "v1"}
+	_, err = uploadResumable(source, "test://import.mp4", u, opts)
+	require.NoError(t, err)
+	require.Equal(t, []int64{minS3PartSize}, source.opened)
+	obj, ok := memoryS3.server.Object("resume", "vod/import.mp4")
+	require.True(t, ok)
+	require.Equal(t, data, obj.Data)
+	require.Empty(t, memoryS3.server.Uploads("resume"))
+	files, err := os.ReadDir(opts.ResumeDir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestResumableUploadSourceChanged(t *testing.T) {
+	data := bytes.Repeat([]byte("a"), 2*minS3PartSize)
+	opts := UploadOptions{ResumeDir: t.TempDir(), Destination: DestinationOptions{PartSize: minS3PartSize}, SegmentTimeout: 10 * time.Second}
+	u := mustParseURL("memory-s3://resume/vod/changed.mp4")
+	_, err := uploadResumable(&testResumeSource{data: data, version: "v1", failAfter: minS3PartSize + 100}, "test://changed.mp4", u, opts)
+	require.Error(t, err)
+
+	// parts of another version of the source aren't kept
+	changed := bytes.Repeat([]byte("b"), 2*minS3PartSize)
+	source := &testResumeSource{data: changed, version: "v2"}
+	_, err = uploadResumable(source, "test://changed.mp4", u, opts)
+	require.NoError(t, err)
+	require.Equal(t, []int64{minS3PartSize, 0}, source.opened)
+	obj, _ := memoryS3.server.Object("resume", "vod/changed.mp4")
+	require.Equal(t, changed, obj.Data)
+	require.Empty(t, memoryS3.server.Uploads("resume"))
+}
+
+func TestResumeUploadFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "import.mp4")
+	require.NoError(t, os.WriteFile(fileName, []byte("small import"), 0644))
+	opts := UploadOptions{ResumeDir: t.TempDir()}
+	_, err := ResumeUploadFile(fileName, mustParseURL("memory-s3://resume/vod/small.mp4"), opts)
+	require.NoError(t, err)
+	obj, ok := memoryS3.server.Object("resume", "vod/small.mp4")
+	require.True(t, ok)
+	require.Equal(t, "small import", string(obj.Data))
+
+	_, err = ResumeUploadFile(fileName, mustParseURL(filepath.ToSlash(filepath.Join(t.TempDir(), "out.mp4"))), opts)
+	require.Error(t, err)
+}
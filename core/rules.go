@@ -0,0 +1,128 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/livepeer/go-tools/drivers"
+)
+
+// HeaderRule sets the headers and metadata of uploads to matching destinations. A rule matches if the
+// destination, without scheme and credentials, starts with Prefix and has one of Extensions. Empty conditions
+// match everything.
+type HeaderRule struct {
+	Prefix       string   `json:"prefix"`
+	Extensions   []string `json:"extensions"`
+	CacheControl string   `json:"cache_control"`
+	ContentType  string   `json:"content_type"`
+	// ContentDisposition, e.g. attachment; filename="clip.mp4", is only set on S3 and GCS objects
+	ContentDisposition string            `json:"content_disposition"`
+	Metadata           map[string]string `json:"metadata"`
+	// ExpireAfter, e.g. 168h, doesn't change uploads but is the expiry configured by `lifecycle apply`, see
+	// LifecycleRules
+	ExpireAfter string `json:"expire_after"`
+}
+
+// defaultHeaderRules apply before the configured ones
+var defaultHeaderRules = []HeaderRule{
+	// While we wait for storj to implement an easier method for global object deletion we are hacking something
+	// here to allow us to have recording objects deleted after 7 days.
+	{Prefix: "gateway.storjshare.io/catalyst-recordings-com", Metadata: map[string]string{"Object-Expires": "+168h"}},
+}
+
+// LoadHeaderRules reads a JSON array of HeaderRule from a file
+func LoadHeaderRules(fileName string) ([]HeaderRule, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var rules []HeaderRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid header rules in %s: %w", fileName, err)
+	}
+	return rules, nil
+}
+
+func (r HeaderRule) matches(u *url.URL) bool {
+	if !strings.HasPrefix(storageLocation(u), r.Prefix) {
+		return false
+	}
+	if len(r.Extensions) == 0 {
+		return true
+	}
+	ext := path.Ext(u.Path)
+	for _, e := range r.Extensions {
+		if strings.EqualFold(ext, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// storageLocation is a destination without scheme and credentials, e.g. eu-west-1/bucket/vod/123/rec.mp4, which
+// the prefixes of rules are matched against
+func storageLocation(u *url.URL) string {
+	location := *u
+	location.Scheme, location.User = "", nil
+	return strings.TrimPrefix(uriString(&location), "//")
+}
+
+// applyHeaderRules returns fields with the settings of the default and the configured rules matching u applied
+// in order, so that later rules override earlier ones. fields isn't modified.
+func applyHeaderRules(u *url.URL, fields *drivers.FileProperties, rules []HeaderRule) *drivers.FileProperties {
+	var ruleFields *drivers.FileProperties
+	for _, rule := range append(append([]HeaderRule{}, defaultHeaderRules...), rules...) {
+		if !rule.matches(u) {
+			continue
+		}
+		if ruleFields == nil {
+			ruleFields = copyFileProperties(fields)
+		}
+		if rule.CacheControl != "" {
+			ruleFields.CacheControl = rule.CacheControl
+		}
+		if rule.ContentType != "" {
+			ruleFields.ContentType = rule.ContentType
+		}
+		for k, v := range rule.Metadata {
+			ruleFields.Metadata[k] = v
+		}
+	}
+	if ruleFields == nil {
+		return fields
+	}
+	return ruleFields
+}
+
+// contentDisposition is the Content-Disposition of uploads to u, from the options or else the last matching rule
+func contentDisposition(u *url.URL, opts UploadOptions) string {
+	if opts.ContentDisposition != "" {
+		return opts.ContentDisposition
+	}
+	var disposition string
+	for _, rule := range opts.HeaderRules {
+		if rule.ContentDisposition != "" && rule.matches(u) {
+			disposition = rule.ContentDisposition
+		}
+	}
+	return disposition
+}
+
+// copyFileProperties returns a copy of fields, which may be nil, with its own metadata map
+func copyFileProperties(fields *drivers.FileProperties) *drivers.FileProperties {
+	fieldsCopy := drivers.FileProperties{}
+	if fields != nil {
+		fieldsCopy = *fields
+	}
+	fieldsCopy.Metadata = map[string]string{}
+	if fields != nil {
+		for k, v := range fields.Metadata {
+			fieldsCopy.Metadata[k] = v
+		}
+	}
+	return &fieldsCopy
+}
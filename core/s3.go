@@ -0,0 +1,251 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/livepeer/go-tools/drivers"
+)
+
+const (
+	// minS3PartSize is the smallest part size S3 accepts for all but the last part of a multipart upload
+	minS3PartSize = 5 * 1024 * 1024
+	// maxS3Parts is the most parts a multipart upload can have
+	maxS3Parts = 10000
+	// defaultSaveTimeout matches the timeout the drivers apply when none is given
+	defaultSaveTimeout = 10 * time.Second
+)
+
+// s3Destination is an s3://, s3+http:// or s3+https:// URL broken down the same way
+// drivers.ParseOSURL does it. It lets us talk to S3 directly for the things the drivers
+// don't expose, such as tuning multipart uploads.
+type s3Destination struct {
+	// endpoint is empty for AWS and the scheme://host of the service otherwise
+	endpoint  string
+	region    string
+	bucket    string
+	key       string
+	accessKey string
+	secretKey string
+	// maxRetries overrides the SDK's default number of retries of throttled or failed requests if set
+	maxRetries int
+	// storageClass of the uploaded objects, the bucket's default if empty
+	storageClass string
+	// contentDisposition of the uploaded objects, if set
+	contentDisposition string
+	// multipartUploads collects the multipart uploads that failed and couldn't be aborted, if set
+	multipartUploads *multipartUploads
+}
+
+func isS3URL(u *url.URL) bool {
+	return u.Scheme == "s3" || u.Scheme == "s3+http" || u.Scheme == "s3+https"
+}
+
+func parseS3URL(u *url.URL) (*s3Destination, error) {
+	if isSpacesURL(u) {
+		dest, err := parseS3URL(spacesS3URL(u))
+		if err != nil {
+			return nil, err
+		}
+		dest.maxRetries = spacesMaxRetries
+		return dest, nil
+	}
+	if !isS3URL(u) {
+		return nil, fmt.Errorf("not an S3 URL: %s", u.Redacted())
+	}
+	secret, ok := u.User.Password()
+	if !ok {
+		return nil, errors.New("password is required with s3:// OS")
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	if bucket == "" {
+		return nil, errors.New("S3 bucket not found in URL path")
+	}
+	dest := &s3Destination{
+		region:    u.Host,
+		bucket:    bucket,
+		key:       key,
+		accessKey: u.User.Username(),
+		secretKey: secret,
+	}
+	if u.Scheme != "s3" {
+		dest.endpoint = strings.TrimPrefix(u.Scheme, "s3+") + "://" + u.Host
+		dest.region = customS3Region(u.Hostname())
+	}
+	return dest, nil
+}
+
+// customS3Driver creates the driver of an s3:// or s3+http(s):// URL. drivers.ParseOSURL keeps the rest of the
+// path as the key prefix of the driver, but its sessions append their path to the prefix without a separator, so
+// the driver is created for the bucket alone and the sessions are put under the prefix with joinKey, like those
+// of the drivers of this repo.
+func customS3Driver(u *url.URL) (drivers.OSDriver, error) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	bucketURL := *u
+	bucketURL.Path = "/" + bucket
+	bucketURL.RawPath = ""
+	driver, err := drivers.ParseOSURL(bucketURL.String(), true)
+	if err != nil {
+		return nil, err
+	}
+	return &keyPrefixOS{OSDriver: driver, keyPrefix: key}, nil
+}
+
+// keyPrefixOS puts the sessions of a driver under a key prefix
+type keyPrefixOS struct {
+	drivers.OSDriver
+	keyPrefix string
+}
+
+func (d *keyPrefixOS) NewSession(p string) drivers.OSSession {
+	return d.OSDriver.NewSession(joinKey(d.keyPrefix, p))
+}
+
+// customS3Region derives a region from an S3-compatible host in the same way the drivers do
+func customS3Region(hostname string) string {
+	parts := strings.Split(hostname, ".")
+	if len(parts) >= 4 {
+		return parts[1]
+	}
+	return "us-east-1"
+}
+
+func (d *s3Destination) newSession() (*session.Session, error) {
+	cfg := aws.NewConfig().
+		WithRegion(d.region).
+		WithCredentials(credentials.NewStaticCredentials(d.accessKey, d.secretKey, ""))
+	if d.endpoint != "" {
+		cfg = cfg.
+			WithEndpoint(d.endpoint).
+			WithS3ForcePathStyle(true).
+			WithDisableSSL(strings.HasPrefix(d.endpoint, "http://"))
+	}
+	if d.maxRetries > 0 {
+		cfg = cfg.WithMaxRetries(d.maxRetries)
+	}
+	return session.NewSession(cfg)
+}
+
+// objectURL returns the URL of the object in the same format as the drivers' SaveDataOutput
+func (d *s3Destination) objectURL(key string) string {
+	if d.endpoint == "" {
+		return fmt.Sprintf("https://%s.s3.amazonaws.com/%s", d.bucket, key)
+	}
+	return fmt.Sprintf("%s/%s/%s", d.endpoint, d.bucket, key)
+}
+
+// uploadS3File uploads a local file with explicit multipart settings. Because the body is an *os.File
+// the S3 uploader reads each part straight from disk rather than buffering parts in memory. The part
+// size is raised if the file wouldn't fit in maxS3Parts parts. Reads are counted by progress if set.
+// The session is reused across attempts so that retries don't pay for new connections. Files of at least
+// rangeSplitSize, if set, are uploaded with uploadS3Ranges instead.
+func uploadS3File(ctx context.Context, sess *session.Session, dest *s3Destination, fileName string, fields *drivers.FileProperties, timeout time.Duration, concurrency int, partSize int64, rangeSplitSize int64, progress *progressLogger) (*drivers.SaveDataOutput, int64, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	contentType, err := fileContentType(file, dest.key)
+	if err != nil {
+		return nil, 0, err
+	}
+	var body io.Reader = file
+	if progress != nil {
+		body = &progressFile{File: file, progress: progress}
+	}
+	params := &s3manager.UploadInput{
+		Bucket:      aws.String(dest.bucket),
+		Key:         aws.String(dest.key),
+		Body:        body,
+		ContentType: aws.String(contentType),
+	}
+	if dest.storageClass != "" {
+		params.StorageClass = aws.String(dest.storageClass)
+	}
+	if dest.contentDisposition != "" {
+		params.ContentDisposition = aws.String(dest.contentDisposition)
+	}
+	if fields != nil {
+		if fields.ContentType != "" {
+			params.ContentType = aws.String(fields.ContentType)
+		}
+		if fields.CacheControl != "" {
+			params.CacheControl = aws.String(fields.CacheControl)
+		}
+		if len(fields.Metadata) > 0 {
+			params.Metadata = aws.StringMap(fields.Metadata)
+		}
+	}
+
+	respHeaders := http.Header{}
+	partSize = max(partSize, minS3PartSize, s3PartSizeFor(info.Size()))
+	if progress != nil {
+		progress.partSize.Store(partSize)
+	}
+	if timeout == 0 {
+		timeout = defaultSaveTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if rangeSplitSize > 0 && info.Size() >= rangeSplitSize {
+		respHeaders, err = uploadS3Ranges(ctx, s3.New(sess), params, dest.multipartUploads, file, info.Size(), concurrency, partSize, progress)
+		if err != nil {
+			return nil, 0, err
+		}
+		return &drivers.SaveDataOutput{URL: dest.objectURL(dest.key), UploaderResponseHeaders: respHeaders}, info.Size(), nil
+	}
+	uploader := s3manager.NewUploader(sess, func(u *s3manager.Uploader) {
+		u.Concurrency = concurrency
+		u.PartSize = partSize
+		u.RequestOptions = append(u.RequestOptions, request.WithGetResponseHeaders(&respHeaders))
+		// aborted below, with a context of its own rather than the one that may have failed the upload
+		u.LeavePartsOnError = true
+	})
+	if _, err := uploader.UploadWithContext(ctx, params); err != nil {
+		var failure s3manager.MultiUploadFailure
+		if errors.As(err, &failure) {
+			abortS3Upload(s3.New(sess), params, aws.String(failure.UploadID()), dest.multipartUploads)
+		}
+		return nil, 0, err
+	}
+	return &drivers.SaveDataOutput{URL: dest.objectURL(dest.key), UploaderResponseHeaders: respHeaders}, info.Size(), nil
+}
+
+// s3PartSizeFor returns the smallest part size, in whole MiB, that uploads size bytes in at most maxS3Parts parts
+func s3PartSizeFor(size int64) int64 {
+	const mib = 1024 * 1024
+	minPartSize := (size + maxS3Parts - 1) / maxS3Parts
+	return (minPartSize + mib - 1) / mib * mib
+}
+
+// fileContentType mirrors the drivers' content type detection: by extension first, then by sniffing the content
+func fileContentType(file *os.File, key string) (string, error) {
+	if contentType, err := drivers.TypeByExtension(path.Ext(key)); err == nil {
+		return contentType, nil
+	}
+	head := make([]byte, 512)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return http.DetectContentType(head[:n]), nil
+}
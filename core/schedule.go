@@ -0,0 +1,65 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// ScheduleWindow is a daily time window, in the host's local time, outside of which batches don't start
+// uploading files, so that archive pushes only use bandwidth during off-peak hours. Files being uploaded when the
+// window closes complete. A nil window is always open.
+type ScheduleWindow struct {
+	// start and end are offsets from midnight. Windows ending before they start span midnight.
+	start, end time.Duration
+}
+
+// ParseScheduleWindow parses a window such as 01:00-05:00, or 22:00-06:00 spanning midnight
+func ParseScheduleWindow(s string) (*ScheduleWindow, error) {
+	startStr, endStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid schedule window %q, expected HH:MM-HH:MM", s)
+	}
+	var w ScheduleWindow
+	for _, bound := range []struct {
+		s string
+		d *time.Duration
+	}{{startStr, &w.start}, {endStr, &w.end}} {
+		t, err := time.Parse("15:04", strings.TrimSpace(bound.s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule window %q, expected HH:MM-HH:MM", s)
+		}
+		*bound.d = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	if w.start == w.end {
+		return nil, fmt.Errorf("invalid schedule window %q, it is empty", s)
+	}
+	return &w, nil
+}
+
+// until returns how long after t the window opens, or 0 if it is open at t
+func (w *ScheduleWindow) until(t time.Time) time.Duration {
+	if w == nil {
+		return 0
+	}
+	hour, min, sec := t.Clock()
+	now := time.Duration(hour)*time.Hour + time.Duration(min)*time.Minute + time.Duration(sec)*time.Second
+	if w.start < w.end && now >= w.start && now < w.end || w.start > w.end && (now >= w.start || now < w.end) {
+		return 0
+	}
+	wait := w.start - now
+	if wait < 0 {
+		wait += 24 * time.Hour
+	}
+	return wait
+}
+
+// wait blocks until the window is open
+func (w *ScheduleWindow) wait() {
+	if wait := w.until(time.Now()); wait > 0 {
+		glog.Infof("Outside of the schedule window, pausing uploads until %s", time.Now().Add(wait).Format(time.RFC3339))
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,33 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduleWindow(t *testing.T) {
+	at := func(hour, min int) time.Time { return time.Date(2024, 3, 1, hour, min, 0, 0, time.Local) }
+
+	w, err := ParseScheduleWindow("01:00-05:00")
+	require.NoError(t, err)
+	require.Equal(t, time.Duration(0), w.until(at(1, 0)))
+	require.Equal(t, time.Duration(0), w.until(at(4, 59)))
+	require.Equal(t, 20*time.Hour, w.until(at(5, 0)))
+	require.Equal(t, 30*time.Minute, w.until(at(0, 30)))
+
+	w, err = ParseScheduleWindow("22:00-06:00")
+	require.NoError(t, err)
+	require.Equal(t, time.Duration(0), w.until(at(23, 0)))
+	require.Equal(t, time.Duration(0), w.until(at(5, 0)))
+	require.Equal(t, 16*time.Hour, w.until(at(6, 0)))
+
+	var always *ScheduleWindow
+	require.Equal(t, time.Duration(0), always.until(at(12, 0)))
+
+	for _, invalid := range []string{"", "01:00", "1-5", "01:00-25:00", "02:00-02:00"} {
+		_, err = ParseScheduleWindow(invalid)
+		require.Error(t, err, invalid)
+	}
+}
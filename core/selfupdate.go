@@ -0,0 +1,111 @@
+package core
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// maxUpdateSize bounds the size of the binaries downloaded by SelfUpdate
+const maxUpdateSize = 512 * 1024 * 1024
+
+// ErrBadSignature is returned by SelfUpdate when the downloaded binary isn't signed by the release key
+var ErrBadSignature = errors.New("invalid release signature")
+
+// ParsePublicKey parses a base64 encoded Ed25519 public key, the release signing key of SelfUpdate
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key: expected %d base64 encoded bytes", ed25519.PublicKeySize)
+	}
+	return key, nil
+}
+
+// SelfUpdate replaces the executable at target with the release binary at binaryURL. The binary must be signed
+// by publicKey: its Ed25519 signature is read, base64 encoded, from binaryURL with .sig appended. The new binary
+// is written next to target and renamed over it, so that target is either the old or the new binary and never
+// a partial one.
+func SelfUpdate(ctx context.Context, binaryURL string, publicKey ed25519.PublicKey, target string) error {
+	binary, err := download(ctx, binaryURL, maxUpdateSize)
+	if err != nil {
+		return fmt.Errorf("failed to download release: %w", err)
+	}
+	sig, err := download(ctx, binaryURL+".sig", 1024)
+	if err != nil {
+		return fmt.Errorf("failed to download release signature: %w", err)
+	}
+	sig, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil || !ed25519.Verify(publicKey, binary, sig) {
+		return ErrBadSignature
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), filepath.Base(target)+".update-*")
+	if err != nil {
+		return fmt.Errorf("failed to write release: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(binary)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), info.Mode().Perm()|0o111)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write release: %w", err)
+	}
+
+	if runtime.GOOS == "windows" {
+		// a running executable can't be replaced on Windows, but it can be renamed out of the way
+		old := target + ".old"
+		_ = os.Remove(old)
+		if err := os.Rename(target, old); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", target, err)
+		}
+		if err := os.Rename(tmp.Name(), target); err != nil {
+			_ = os.Rename(old, target)
+			return fmt.Errorf("failed to replace %s: %w", target, err)
+		}
+		return nil
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", target, err)
+	}
+	return nil
+}
+
+// download reads the body of url, failing if it's larger than maxSize
+func download(ctx context.Context, url string, maxSize int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("GET %s: larger than %d bytes", url, maxSize)
+	}
+	return data, nil
+}
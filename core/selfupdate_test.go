@@ -0,0 +1,68 @@
+package core
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfUpdate(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	release := []byte("new release")
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, release))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/catalyst-uploader", "/tampered":
+			_, _ = w.Write(release)
+		case "/catalyst-uploader.sig":
+			_, _ = w.Write([]byte(sig + "\n"))
+		case "/tampered.sig":
+			_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, []byte("old release")))))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	target := filepath.Join(t.TempDir(), "catalyst-uploader")
+	require.NoError(t, os.WriteFile(target, []byte("old release"), 0o755))
+
+	err = SelfUpdate(context.Background(), srv.URL+"/tampered", publicKey, target)
+	require.True(t, errors.Is(err, ErrBadSignature))
+	err = SelfUpdate(context.Background(), srv.URL+"/missing", publicKey, target)
+	require.ErrorContains(t, err, "404")
+	data, err := os.ReadFile(target)
+	require.NoError(t, err)
+	require.Equal(t, "old release", string(data))
+
+	require.NoError(t, SelfUpdate(context.Background(), srv.URL+"/catalyst-uploader", publicKey, target))
+	data, err = os.ReadFile(target)
+	require.NoError(t, err)
+	require.Equal(t, release, data)
+	// the temp file is renamed over the binary
+	entries, err := os.ReadDir(filepath.Dir(target))
+	require.NoError(t, err)
+	for _, entry := range entries {
+		require.NotContains(t, entry.Name(), ".update-")
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	publicKey, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	parsed, err := ParsePublicKey(base64.StdEncoding.EncodeToString(publicKey) + "\n")
+	require.NoError(t, err)
+	require.Equal(t, publicKey, parsed)
+
+	_, err = ParsePublicKey("c2hvcnQ=")
+	require.ErrorContains(t, err, "invalid public key")
+}
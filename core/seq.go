@@ -0,0 +1,87 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// seqToken is replaced with the next number of the stream by -follow, see Sequence
+const seqToken = "{seq}"
+
+// HasSeqToken reports whether a destination numbers the segments of a stream, see Sequence
+func HasSeqToken(s string) bool {
+	return strings.Contains(s, seqToken)
+}
+
+// Sequence numbers the segments of a stream uploaded to a destination containing {seq}, so that callers don't need
+// to number keys themselves. The next number is persisted in a file named after the destination, so numbering
+// continues where it stopped when the uploader is restarted. Numbers are taken before the upload, so a failed
+// upload leaves a gap rather than having its number reused for a different segment.
+type Sequence struct {
+	fileName string
+}
+
+// OpenSequence opens the counter of the stream uploaded to outputURI, keeping its state in dir. Every uploader
+// sharing a stream must use the same dir.
+func OpenSequence(dir string, outputURI *url.URL) (*Sequence, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create sequence directory: %w", err)
+	}
+	sum := sha256.Sum256([]byte(locationString(outputURI)))
+	return &Sequence{fileName: filepath.Join(dir, hex.EncodeToString(sum[:8])+".seq")}, nil
+}
+
+// Next takes the next number of the stream, starting at 0
+func (s *Sequence) Next() (int, error) {
+	lock, err := s.lock()
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock sequence: %w", err)
+	}
+	defer unlockSlotFile(lock)
+
+	next := 0
+	data, err := os.ReadFile(s.fileName)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("failed to read sequence: %w", err)
+	}
+	if err == nil {
+		if next, err = strconv.Atoi(strings.TrimSpace(string(data))); err != nil || next < 0 {
+			return 0, fmt.Errorf("invalid sequence in %s", s.fileName)
+		}
+	}
+	// written to a temp file and renamed, so that a crash doesn't leave the counter truncated
+	tmp := s.fileName + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(next+1)+"\n"), 0644); err != nil {
+		return 0, fmt.Errorf("failed to write sequence: %w", err)
+	}
+	if err := os.Rename(tmp, s.fileName); err != nil {
+		return 0, fmt.Errorf("failed to write sequence: %w", err)
+	}
+	return next, nil
+}
+
+// lock waits for the lock of the counter file, which uploaders following the same stream share
+func (s *Sequence) lock() (*os.File, error) {
+	for {
+		file, err := lockSlotFile(s.fileName + ".lock")
+		if err != nil || file != nil {
+			return file, err
+		}
+		time.Sleep(uploadSlotPoll)
+	}
+}
+
+// expandSeq returns the destination of the segment numbered seq
+func expandSeq(outputURI *url.URL, seq int) *url.URL {
+	u := *outputURI
+	u.Path = strings.ReplaceAll(u.Path, seqToken, strconv.Itoa(seq))
+	u.RawPath = ""
+	return &u
+}
@@ -0,0 +1,37 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSequence(t *testing.T) {
+	dir := t.TempDir()
+	stream := mustParseURL("s3://key:secret@us-east-1/bucket/hls/123/{seq}.ts")
+	seq, err := OpenSequence(dir, stream)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		n, err := seq.Next()
+		require.NoError(t, err)
+		require.Equal(t, i, n)
+	}
+
+	// numbering continues after a restart, whatever the credentials
+	seq, err = OpenSequence(dir, mustParseURL("s3://other:creds@us-east-1/bucket/hls/123/{seq}.ts"))
+	require.NoError(t, err)
+	n, err := seq.Next()
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+
+	// each stream has its own counter
+	seq, err = OpenSequence(dir, mustParseURL("s3://key:secret@us-east-1/bucket/hls/456/{seq}.ts"))
+	require.NoError(t, err)
+	n, err = seq.Next()
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	require.Equal(t, "s3://key:secret@us-east-1/bucket/hls/123/42.ts", expandSeq(stream, 42).String())
+	require.True(t, HasSeqToken("/out/{seq}.ts"))
+	require.False(t, HasSeqToken("/out/{index}.ts"))
+}
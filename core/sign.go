@@ -0,0 +1,103 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/service/cloudfront/sign"
+)
+
+// URLSigner creates links to uploaded objects that stop working at expires, for private content
+type URLSigner interface {
+	// Sign signs the link to the object at location, served from publicURL if a -public-base-url maps it
+	Sign(location *url.URL, publicURL string, expires time.Time) (string, error)
+}
+
+// ParseURLSigner parses a URL signing configuration, with the signing key read from the file in the path:
+//
+//	cloudfront://KEY_PAIR_ID/etc/cloudfront/private_key.pem
+//	gcs:///etc/gcs/service_account.json
+//
+// CloudFront signs the public URL of the object, so its prefix needs a -public-base-url mapping too. GCS signs
+// a V4 link to gs:// objects in the bucket.
+func ParseURLSigner(s string) (URLSigner, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "cloudfront":
+		if u.Host == "" || u.Path == "" {
+			return nil, errors.New("cloudfront signing URL requires a key pair ID and a private key file")
+		}
+		key, err := sign.LoadPEMPrivKeyFile(u.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load cloudfront private key: %w", err)
+		}
+		return &cloudFrontSigner{signer: sign.NewURLSigner(u.Host, key)}, nil
+	case "gcs":
+		if u.Path == "" {
+			return nil, errors.New("gcs signing URL requires a service account key file")
+		}
+		data, err := os.ReadFile(u.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load gcs service account: %w", err)
+		}
+		var account struct {
+			ClientEmail string `json:"client_email"`
+			PrivateKey  string `json:"private_key"`
+		}
+		if err := json.Unmarshal(data, &account); err != nil || account.ClientEmail == "" || account.PrivateKey == "" {
+			return nil, errors.New("failed to load gcs service account: expected a JSON key with client_email and private_key")
+		}
+		return &gcsSigner{accessID: account.ClientEmail, privateKey: []byte(account.PrivateKey)}, nil
+	}
+	return nil, fmt.Errorf("unsupported URL signing scheme %q", u.Scheme)
+}
+
+// SignedURL signs a link to the object at u, valid for opts.SignedURLTTL, with the signer configured for its
+// prefix in opts.URLSigners. Returns false if no signer is configured for u.
+func SignedURL(u *url.URL, opts UploadOptions) (string, bool, error) {
+	prefix, ok := longestPrefix(locationString(u), opts.URLSigners)
+	if !ok {
+		return "", false, nil
+	}
+	publicURL, _ := PublicURL(u, opts.PublicBaseURLs)
+	signed, err := opts.URLSigners[prefix].Sign(u, publicURL, time.Now().Add(opts.SignedURLTTL))
+	return signed, true, err
+}
+
+type cloudFrontSigner struct {
+	signer *sign.URLSigner
+}
+
+func (s *cloudFrontSigner) Sign(location *url.URL, publicURL string, expires time.Time) (string, error) {
+	if publicURL == "" {
+		return "", fmt.Errorf("no public base URL is configured for %s", location.Redacted())
+	}
+	return s.signer.Sign(publicURL, expires)
+}
+
+type gcsSigner struct {
+	accessID   string
+	privateKey []byte
+}
+
+func (s *gcsSigner) Sign(location *url.URL, _ string, expires time.Time) (string, error) {
+	if location.Scheme != "gs" {
+		return "", fmt.Errorf("gcs can't sign links to %s", location.Redacted())
+	}
+	return storage.SignedURL(location.Host, strings.TrimPrefix(location.Path, "/"), &storage.SignedURLOptions{
+		GoogleAccessID: s.accessID,
+		PrivateKey:     s.privateKey,
+		Method:         "GET",
+		Expires:        expires,
+		Scheme:         storage.SigningSchemeV4,
+	})
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPrivateKey(t *testing.T) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+}
+
+// writeTestSigningKeys writes a CloudFront private key and a GCS service account to dir
+func writeTestSigningKeys(t *testing.T, dir string) (keyFile, accountFile string) {
+	keyFile = filepath.Join(dir, "private_key.pem")
+	require.NoError(t, os.WriteFile(keyFile, []byte(newTestPrivateKey(t)), 0600))
+	accountFile = filepath.Join(dir, "service_account.json")
+	account, err := json.Marshal(map[string]string{"client_email": "uploader@project.iam.gserviceaccount.com", "private_key": newTestPrivateKey(t)})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(accountFile, account, 0600))
+	return keyFile, accountFile
+}
+
+func TestParseURLSigner(t *testing.T) {
+	dir := t.TempDir()
+	keyFile, accountFile := writeTestSigningKeys(t, dir)
+
+	for _, s := range []string{
+		"cloudfront://APKAEIBAERJR2EXAMPLE" + keyFile,
+		"gcs://" + accountFile,
+	} {
+		_, err := ParseURLSigner(s)
+		require.NoError(t, err, s)
+	}
+	for _, s := range []string{
+		"cloudfront://" + keyFile,
+		"cloudfront://APKAEIBAERJR2EXAMPLE",
+		"cloudfront://APKAEIBAERJR2EXAMPLE" + accountFile,
+		"gcs://",
+		"gcs://" + keyFile,
+		"gcs://" + filepath.Join(dir, "missing.json"),
+		"akamai://" + keyFile,
+	} {
+		_, err := ParseURLSigner(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestSignedURL(t *testing.T) {
+	keyFile, accountFile := writeTestSigningKeys(t, t.TempDir())
+
+	cloudFront, err := ParseURLSigner("cloudfront://APKAEIBAERJR2EXAMPLE" + keyFile)
+	require.NoError(t, err)
+	gcs, err := ParseURLSigner("gcs://" + accountFile)
+	require.NoError(t, err)
+	opts := UploadOptions{
+		PublicBaseURLs: map[string]string{"s3://us-east-1/bucket/": "https://cdn.example.com/"},
+		URLSigners: map[string]URLSigner{
+			"s3://us-east-1/bucket/": cloudFront,
+			"s3://us-east-1/other/":  cloudFront,
+			"gs://bucket/":           gcs,
+		},
+		SignedURLTTL: time.Hour,
+	}
+
+	signed, ok, err := SignedURL(mustParseURL("s3://key:secret@us-east-1/bucket/hls/123/index.m3u8"), opts)
+	require.NoError(t, err)
+	require.True(t, ok)
+	u, err := url.Parse(signed)
+	require.NoError(t, err)
+	require.Equal(t, "cdn.example.com", u.Host)
+	require.Equal(t, "/hls/123/index.m3u8", u.Path)
+	require.Equal(t, "APKAEIBAERJR2EXAMPLE", u.Query().Get("Key-Pair-Id"))
+	require.NotEmpty(t, u.Query().Get("Signature"))
+	require.NotEmpty(t, u.Query().Get("Expires"))
+
+	signed, ok, err = SignedURL(mustParseURL("gs://bucket/hls/123/index.m3u8"), opts)
+	require.NoError(t, err)
+	require.True(t, ok)
+	u, err = url.Parse(signed)
+	require.NoError(t, err)
+	require.Equal(t, "storage.googleapis.com", u.Host)
+	require.Equal(t, "/bucket/hls/123/index.m3u8", u.Path)
+	require.NotEmpty(t, u.Query().Get("X-Goog-Expires"))
+	require.NotEmpty(t, u.Query().Get("X-Goog-Signature"))
+
+	// CloudFront signs the public URL
+	_, ok, err = SignedURL(mustParseURL("s3://key:secret@us-east-1/other/index.m3u8"), opts)
+	require.True(t, ok)
+	require.Error(t, err)
+
+	_, ok, err = SignedURL(mustParseURL("s3://key:secret@us-west-1/bucket/index.m3u8"), opts)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
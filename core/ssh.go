@@ -0,0 +1,300 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/livepeer/go-tools/drivers"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const sshDefaultPort = "22"
+
+// SSHConfig configures authentication for scp:// and sftp:// destinations
+type SSHConfig struct {
+	// KeyFile is the private key to authenticate with, defaults to ~/.ssh/id_ed25519 or ~/.ssh/id_rsa
+	KeyFile string
+	// KnownHostsFile is used to verify host keys, defaults to ~/.ssh/known_hosts
+	KnownHostsFile string
+}
+
+// SSHOS is a driver for servers that only expose SSH. URLs have the form scp://USER@host[:port]/absolute/path,
+// a password in the URL is used instead of the private key. Files are transferred over SFTP, which OpenSSH
+// servers enable by default, streaming the data rather than staging it locally first.
+type SSHOS struct {
+	addr   string
+	path   string
+	config *ssh.ClientConfig
+}
+
+func NewSSHDriver(u *url.URL, sshConfig *SSHConfig) (*SSHOS, error) {
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("user is required with %s:// OS", u.Scheme)
+	}
+	if sshConfig == nil {
+		sshConfig = &SSHConfig{}
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), sshDefaultPort)
+	}
+
+	var auth ssh.AuthMethod
+	if password, ok := u.User.Password(); ok {
+		auth = ssh.Password(password)
+	} else {
+		signer, err := loadSSHKey(sshConfig.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		auth = ssh.PublicKeys(signer)
+	}
+	knownHostsFile := sshConfig.KnownHostsFile
+	if knownHostsFile == "" {
+		knownHostsFile = filepath.Join(userHomeDir(), ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known hosts: %w", err)
+	}
+	return &SSHOS{
+		addr: addr,
+		path: "/" + strings.Trim(u.Path, "/"),
+		config: &ssh.ClientConfig{
+			User:            u.User.Username(),
+			Auth:            []ssh.AuthMethod{auth},
+			HostKeyCallback: hostKeyCallback,
+		},
+	}, nil
+}
+
+func isSSHURL(u *url.URL) bool {
+	return u.Scheme == "scp" || u.Scheme == "sftp"
+}
+
+func loadSSHKey(keyFile string) (ssh.Signer, error) {
+	keyFiles := []string{keyFile}
+	if keyFile == "" {
+		keyFiles = []string{
+			filepath.Join(userHomeDir(), ".ssh", "id_ed25519"),
+			filepath.Join(userHomeDir(), ".ssh", "id_rsa"),
+		}
+	}
+	for _, keyFile := range keyFiles {
+		key, err := os.ReadFile(keyFile)
+		if errors.Is(err, os.ErrNotExist) && len(keyFiles) > 1 {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SSH key: %w", err)
+		}
+		return ssh.ParsePrivateKey(key)
+	}
+	return nil, errors.New("no SSH key found")
+}
+
+func userHomeDir() string {
+	home, _ := os.UserHomeDir()
+	return home
+}
+
+func (s *SSHOS) NewSession(path string) drivers.OSSession {
+	return &sshSession{os: s, path: strings.Trim(path, "/")}
+}
+
+func (s *SSHOS) Description() string {
+	return "SSH (SFTP) driver."
+}
+
+func (s *SSHOS) UriSchemes() []string {
+	return []string{"scp", "sftp"}
+}
+
+func (s *SSHOS) Publish(ctx context.Context) (string, error) {
+	return "", drivers.ErrNotSupported
+}
+
+// connect opens an SFTP connection. close must be called when done.
+func (s *SSHOS) connect(ctx context.Context) (*sftp.Client, func(), error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, s.addr, s.config)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	return sftpClient, func() {
+		sftpClient.Close()
+		client.Close()
+	}, nil
+}
+
+type sshSession struct {
+	os   *SSHOS
+	path string
+}
+
+func (s *sshSession) name(name string) string {
+	return "/" + sessionKey(s.os.path, s.path, name)
+}
+
+func (s *sshSession) fileURL(name string) string {
+	return fmt.Sprintf("scp://%s@%s%s", s.os.config.User, s.os.addr, name)
+}
+
+// SaveData writes to a temporary file that is renamed once complete, so that partially transferred
+// files never show up under their final name
+func (s *sshSession) SaveData(ctx context.Context, name string, data io.Reader, fields *drivers.FileProperties, timeout time.Duration) (*drivers.SaveDataOutput, error) {
+	if timeout == 0 {
+		timeout = defaultSaveTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	client, closeClient, err := s.os.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closeClient()
+
+	fileName := s.name(name)
+	if err := client.MkdirAll(path.Dir(fileName)); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	tmpName := fileName + ".part"
+	file, err := client.Create(tmpName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.ReadFrom(data); err != nil {
+		file.Close()
+		_ = client.Remove(tmpName)
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		_ = client.Remove(tmpName)
+		return nil, err
+	}
+	if err := client.PosixRename(tmpName, fileName); err != nil {
+		// without the OpenSSH extension, rename fails if the target exists
+		if err := client.Remove(fileName); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if err := client.Rename(tmpName, fileName); err != nil {
+			return nil, err
+		}
+	}
+	return &drivers.SaveDataOutput{URL: s.fileURL(fileName)}, nil
+}
+
+func (s *sshSession) DeleteFile(ctx context.Context, name string) error {
+	client, closeClient, err := s.os.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeClient()
+	return client.Remove(s.name(name))
+}
+
+func (s *sshSession) ReadData(ctx context.Context, name string) (*drivers.FileInfoReader, error) {
+	client, closeClient, err := s.os.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	file, err := client.Open(s.name(name))
+	if err != nil {
+		closeClient()
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		closeClient()
+		return nil, err
+	}
+	size := info.Size()
+	return &drivers.FileInfoReader{
+		FileInfo: drivers.FileInfo{Name: name, LastModified: info.ModTime(), Size: &size},
+		Body:     &sftpFileReader{File: file, close: closeClient},
+	}, nil
+}
+
+// sftpFileReader closes the connection along with the file
+type sftpFileReader struct {
+	*sftp.File
+	close func()
+}
+
+func (r *sftpFileReader) Close() error {
+	err := r.File.Close()
+	r.close()
+	return err
+}
+
+func (s *sshSession) ListFiles(ctx context.Context, prefix, delim string) (drivers.PageInfo, error) {
+	client, closeClient, err := s.os.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closeClient()
+	entries, err := client.ReadDir(s.name(prefix))
+	if err != nil {
+		return nil, err
+	}
+	page := &singlePage{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			page.directories = append(page.directories, path.Join(prefix, entry.Name())+"/")
+			continue
+		}
+		size := entry.Size()
+		page.files = append(page.files, drivers.FileInfo{Name: path.Join(prefix, entry.Name()), LastModified: entry.ModTime(), Size: &size})
+	}
+	return page, nil
+}
+
+func (s *sshSession) ReadDataRange(ctx context.Context, name, byteRange string) (*drivers.FileInfoReader, error) {
+	offset, length, err := parseByteRange(byteRange)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := s.ReadData(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return seekRange(reader, offset, length)
+}
+
+func (s *sshSession) Presign(name string, expire time.Duration) (string, error) {
+	return "", drivers.ErrNotSupported
+}
+
+func (s *sshSession) OS() drivers.OSDriver     { return s.os }
+func (s *sshSession) EndSession()              {}
+func (s *sshSession) GetInfo() *drivers.OSInfo { return nil }
+func (s *sshSession) IsExternal() bool         { return false }
+
+func (s *sshSession) IsOwn(url string) bool {
+	return strings.HasPrefix(url, s.fileURL(s.os.path))
+}
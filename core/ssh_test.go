@@ -0,0 +1,160 @@
+package core
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// newTestSFTPServer starts an SSH server accepting the returned client key and serving SFTP on the local
+// file system. It returns the server address and an SSHConfig trusting the server.
+func newTestSFTPServer(t *testing.T) (string, *SSHConfig) {
+	dir := t.TempDir()
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	require.NoError(t, err)
+	clientPub, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	clientSSHPub, err := ssh.NewPublicKey(clientPub)
+	require.NoError(t, err)
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(clientSSHPub.Marshal()) {
+				return nil, io.EOF
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, config)
+		}
+	}()
+
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(clientKey)
+	require.NoError(t, err)
+	keyFile := filepath.Join(dir, "id_ed25519")
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}), 0600))
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(listener.Addr().String())}, hostSigner.PublicKey())
+	require.NoError(t, os.WriteFile(knownHostsFile, []byte(line+"\n"), 0600))
+	return listener.Addr().String(), &SSHConfig{KeyFile: keyFile, KnownHostsFile: knownHostsFile}
+}
+
+func serveSFTP(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				_ = req.Reply(ok, nil)
+				if ok {
+					server, err := sftp.NewServer(channel)
+					if err == nil {
+						_ = server.Serve()
+					}
+					channel.Close()
+				}
+			}
+		}()
+	}
+}
+
+func TestSSHUpload(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test SFTP server serves the local file system, which has no POSIX paths on Windows")
+	}
+	addr, sshConfig := newTestSFTPServer(t)
+	archive := filepath.ToSlash(t.TempDir())
+	testFile := filepath.Join(t.TempDir(), "rec.mp4")
+	require.NoError(t, os.WriteFile(testFile, []byte("recording"), 0644))
+
+	for i := 0; i < 2; i++ {
+		// the second upload replaces the file
+		out, written, err := uploadFileWithBackup(mustParseURL("scp://archive@"+addr+archive+"/2023/rec.mp4"), testFile, nil, 10*time.Second, false, UploadOptions{SSH: sshConfig})
+		require.NoError(t, err)
+		require.Equal(t, int64(9), written)
+		require.Equal(t, "scp://archive@"+addr+archive+"/2023/rec.mp4", out.URL)
+	}
+	data, err := os.ReadFile(filepath.Join(archive, "2023", "rec.mp4"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("recording"), data)
+	_, err = os.Stat(filepath.Join(archive, "2023", "rec.mp4.part"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	driver, err := parseOSURL(mustParseURL("sftp://archive@"+addr+archive), UploadOptions{SSH: sshConfig})
+	require.NoError(t, err)
+	sess := driver.NewSession("2023")
+	page, err := sess.ListFiles(context.Background(), "", "/")
+	require.NoError(t, err)
+	require.Len(t, page.Files(), 1)
+	require.Equal(t, "rec.mp4", page.Files()[0].Name)
+
+	reader, err := sess.ReadData(context.Background(), "rec.mp4")
+	require.NoError(t, err)
+	data, err = io.ReadAll(reader.Body)
+	require.NoError(t, err)
+	require.NoError(t, reader.Body.Close())
+	require.Equal(t, []byte("recording"), data)
+
+	require.NoError(t, sess.DeleteFile(context.Background(), "rec.mp4"))
+	_, err = os.Stat(filepath.Join(archive, "2023", "rec.mp4"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestSSHUnknownHost(t *testing.T) {
+	addr, sshConfig := newTestSFTPServer(t)
+	_, otherConfig := newTestSFTPServer(t)
+	sshConfig.KnownHostsFile = otherConfig.KnownHostsFile
+
+	testFile := filepath.Join(t.TempDir(), "rec.mp4")
+	require.NoError(t, os.WriteFile(testFile, []byte("recording"), 0644))
+	_, _, err := uploadFile(mustParseURL("scp://archive@"+addr+"/tmp/rec.mp4"), testFile, nil, 10*time.Second, false, UploadOptions{SSH: sshConfig})
+	require.ErrorContains(t, err, "knownhosts")
+}
+
+func TestNewSSHDriver(t *testing.T) {
+	_, sshConfig := newTestSFTPServer(t)
+	driver, err := NewSSHDriver(mustParseURL("scp://archive@archive.example.com/srv/recordings/"), sshConfig)
+	require.NoError(t, err)
+	require.Equal(t, "archive.example.com:22", driver.addr)
+	require.Equal(t, "/srv/recordings", driver.path)
+
+	_, err = NewSSHDriver(mustParseURL("scp://archive.example.com/srv/recordings/"), sshConfig)
+	require.ErrorContains(t, err, "user is required")
+	_, err = NewSSHDriver(mustParseURL("scp://archive@archive.example.com/srv/recordings/"), &SSHConfig{KeyFile: "/nonexistent", KnownHostsFile: sshConfig.KnownHostsFile})
+	require.ErrorContains(t, err, "failed to read SSH key")
+}
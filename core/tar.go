@@ -0,0 +1,80 @@
+package core
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// UploadTar uploads the regular files of a tar stream selected by UploadOptions.Filter one at a time, in the
+// order they appear in the stream. Each file goes to the destination template expanded for its name, or to its
+// name joined onto the destination if that isn't a template. It stops at the first failed upload, so that a playlist following its segment in
+// the stream is only written once the segment has been. The returned uploads include the failed one.
+func UploadTar(r io.Reader, destination string, opts UploadOptions) ([]*BatchUpload, error) {
+	var uploads []*BatchUpload
+	tr := tar.NewReader(r)
+	for index := 0; ; {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return uploads, nil
+		}
+		if err != nil {
+			return uploads, fmt.Errorf("failed to read tar stream: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg || !opts.Filter.Match(filepath.ToSlash(hdr.Name)) {
+			continue
+		}
+		uri, err := relativeDestination(destination, hdr.Name, index)
+		if err != nil {
+			return uploads, err
+		}
+		upload := &BatchUpload{FileName: hdr.Name, URI: uri}
+		uploads = append(uploads, upload)
+		opts.ScheduleWindow.wait()
+		upload.Result, upload.Err = uploadTarEntry(tr, uri, opts)
+		if upload.Err != nil {
+			return uploads, upload.Err
+		}
+		index++
+	}
+}
+
+// relativeDestination expands the destination template for the index-th file, or joins its relative name onto
+// the destination if that isn't a template. Names escaping the destination are rejected.
+func relativeDestination(destination, name string, index int) (*url.URL, error) {
+	name = path.Clean(filepath.ToSlash(name))
+	if !filepath.IsLocal(name) {
+		return nil, fmt.Errorf("invalid file name: %q", name)
+	}
+	if IsDestinationTemplate(destination) {
+		return ParseOutputURI(ExpandDestinationTemplate(destination, name, index))
+	}
+	uri, err := ParseOutputURI(destination)
+	if err != nil {
+		return nil, err
+	}
+	return uri.JoinPath(name), nil
+}
+
+func uploadTarEntry(r io.Reader, uri *url.URL, opts UploadOptions) (*UploadResult, error) {
+	inputFile, err := os.CreateTemp("", "upload-*"+filepath.Ext(uri.Path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to write to temp file: %w", err)
+	}
+	defer os.Remove(inputFile.Name())
+	_, err = copyBuffered(inputFile, r)
+	if closeErr := inputFile.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write to temp file: %w", err)
+	}
+	// the temp file is removed anyway
+	opts.DeleteInputs = nil
+	return UploadFiles([]string{inputFile.Name()}, uri, opts)
+}
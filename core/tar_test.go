@@ -0,0 +1,75 @@
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTar(t *testing.T, files ...string) *bytes.Buffer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i := 0; i < len(files); i += 2 {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: files[i], Mode: 0644, Size: int64(len(files[i+1])), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(files[i+1]))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return &buf
+}
+
+func TestUploadTar(t *testing.T) {
+	dir := filepath.ToSlash(t.TempDir())
+	opts := UploadOptions{WriteTimeout: time.Second, SegmentTimeout: time.Second, DisableThumbs: []string{"/"}}
+	stream := newTestTar(t, "720p/1.ts", "segment", "720p/index.m3u8", "#EXTM3U")
+
+	uploads, err := UploadTar(stream, dir+"/hls", opts)
+	require.NoError(t, err)
+	require.Len(t, uploads, 2)
+	require.Equal(t, dir+"/hls/720p/1.ts", uploads[0].URI.Path)
+	data, err := os.ReadFile(dir + "/hls/720p/1.ts")
+	require.NoError(t, err)
+	require.Equal(t, "segment", string(data))
+	data, err = os.ReadFile(dir + "/hls/720p/index.m3u8")
+	require.NoError(t, err)
+	require.Equal(t, "#EXTM3U", string(data))
+
+	uploads, err = UploadTar(newTestTar(t, "a.m3u8", "a", "b.m3u8", "b"), dir+"/flat/{index}-{basename}", opts)
+	require.NoError(t, err)
+	require.Len(t, uploads, 2)
+	require.Equal(t, dir+"/flat/1-b.m3u8", uploads[1].URI.Path)
+}
+
+func TestUploadTarStopsAtFailure(t *testing.T) {
+	dir := filepath.ToSlash(t.TempDir())
+	faults, err := ParseFaultProfile("error=1")
+	require.NoError(t, err)
+	opts := UploadOptions{WriteTimeout: time.Second, FaultInjection: faults}
+	uploads, err := UploadTar(newTestTar(t, "720p.m3u8", "#EXTM3U", "index.m3u8", "#EXTM3U"), dir, opts)
+	require.Error(t, err)
+	require.Len(t, uploads, 1)
+	require.Equal(t, err, uploads[0].Err)
+	_, err = os.Stat(dir + "/index.m3u8")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = UploadTar(newTestTar(t, "../escape.ts", "segment"), dir, opts)
+	require.ErrorContains(t, err, "invalid file name")
+}
+
+func TestUploadTarFilter(t *testing.T) {
+	dir := filepath.ToSlash(t.TempDir())
+	opts := UploadOptions{WriteTimeout: time.Second, Filter: FileFilter{Exclude: []string{"*.json"}}}
+	stream := newTestTar(t, "720p/index.m3u8.tmp", "#EXT", "720p/meta.json", "{}", "720p/index.m3u8", "#EXTM3U")
+
+	uploads, err := UploadTar(stream, dir, opts)
+	require.NoError(t, err)
+	require.Len(t, uploads, 1)
+	require.Equal(t, dir+"/720p/index.m3u8", uploads[0].URI.Path)
+	_, err = os.Stat(dir + "/720p/index.m3u8.tmp")
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,169 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+)
+
+const (
+	// thumbWidth and thumbHeight are the box thumbnails are scaled to fit, like the scale filter of thumbArgs
+	thumbWidth  = 426
+	thumbHeight = 240
+
+	// h264StreamType and hevcStreamType are the PMT stream types of the video the transcoder outputs
+	h264StreamType = 0x1b
+	hevcStreamType = 0x24
+)
+
+// errKeyframeFound stops reading a segment once the keyframe of its thumbnail is found
+var errKeyframeFound = errors.New("keyframe found")
+
+// nativeThumb writes the thumbnail of a TS segment to outFile as PNG without ffmpeg, for hosts that don't have
+// it or where it fails: the first keyframe of the video is decoded and scaled to fit thumbWidth x thumbHeight.
+// Only keyframes that are JPEG pictures, e.g. those of MJPEG streams, can be decoded in Go. H.264 and HEVC
+// video needs ffmpeg.
+func nativeThumb(segmentFileName, outFile string) error {
+	keyframe, err := tsJPEGKeyframe(segmentFileName)
+	if err != nil {
+		return err
+	}
+	img, _, err := image.Decode(bytes.NewReader(keyframe))
+	if err != nil {
+		return fmt.Errorf("failed to decode keyframe: %w", err)
+	}
+	file, err := os.Create(outFile)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, scaleToFit(img, thumbWidth, thumbHeight)); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// tsJPEGKeyframe returns the first JPEG picture carried in the PES packets of a TS segment. SCTE-35 and ID3
+// streams are skipped, other streams are recognized by their data rather than their stream type, which isn't
+// standardized for JPEG.
+func tsJPEGKeyframe(fileName string) ([]byte, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var (
+		keyframe   []byte
+		codecs     = map[string]bool{}
+		pmtPIDs    = map[uint16]bool{}
+		pesPackets = map[uint16]*bytes.Buffer{}
+		flushPES   = func(pid uint16) {
+			data := pesData(pesPackets[pid].Bytes())
+			pesPackets[pid].Reset()
+			if bytes.HasPrefix(data, []byte{0xff, 0xd8, 0xff}) {
+				keyframe = data
+			}
+		}
+	)
+	err = readTSPackets(file, func(n int, pid uint16, unitStart bool, payload []byte) error {
+		switch {
+		case pid == 0 && unitStart:
+			if section, err := psiSection(payload, 0x00); err == nil {
+				for _, pmtPID := range patPMTPIDs(section) {
+					pmtPIDs[pmtPID] = true
+				}
+			}
+		case pmtPIDs[pid] && unitStart:
+			section, err := psiSection(payload, 0x02)
+			if err != nil {
+				return nil
+			}
+			streams, err := pmtStreams(section)
+			if err != nil {
+				return nil
+			}
+			for _, stream := range streams {
+				switch stream.streamType {
+				case scte35StreamType, id3StreamType:
+					continue
+				case h264StreamType:
+					codecs["H.264"] = true
+				case hevcStreamType:
+					codecs["HEVC"] = true
+				}
+				if pesPackets[stream.pid] == nil {
+					pesPackets[stream.pid] = &bytes.Buffer{}
+				}
+			}
+		case pesPackets[pid] != nil:
+			if unitStart {
+				flushPES(pid)
+				if keyframe != nil {
+					return errKeyframeFound
+				}
+			}
+			pesPackets[pid].Write(payload)
+		}
+		return nil
+	})
+	if err != nil && err != errKeyframeFound {
+		return nil, err
+	}
+	for pid := range pesPackets {
+		if keyframe == nil {
+			flushPES(pid)
+		}
+	}
+	if keyframe != nil {
+		return keyframe, nil
+	}
+	for _, codec := range []string{"H.264", "HEVC"} {
+		if codecs[codec] {
+			return nil, fmt.Errorf("no JPEG keyframe found, decoding %s keyframes needs ffmpeg", codec)
+		}
+	}
+	return nil, errors.New("no JPEG keyframe found")
+}
+
+// pesData returns the data of a PES packet after its header
+func pesData(pes []byte) []byte {
+	if !isPESStart(pes) || len(pes) < 9 || len(pes) < 9+int(pes[8]) {
+		return nil
+	}
+	return pes[9+int(pes[8]):]
+}
+
+// scaleToFit scales img to the largest size fitting width x height with the same aspect ratio, averaging the
+// pixels of img each pixel of the thumbnail covers
+func scaleToFit(img image.Image, width, height int) *image.RGBA {
+	bounds := img.Bounds()
+	w, h := width, bounds.Dy()*width/bounds.Dx()
+	if h > height {
+		w, h = bounds.Dx()*height/bounds.Dy(), height
+	}
+	w, h = max(w, 1), max(h, 1)
+	thumb := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/h
+		y1 := max(bounds.Min.Y+(y+1)*bounds.Dy()/h, y0+1)
+		for x := 0; x < w; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/w
+			x1 := max(bounds.Min.X+(x+1)*bounds.Dx()/w, x0+1)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa), n+1
+				}
+			}
+			thumb.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return thumb
+}
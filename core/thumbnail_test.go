@@ -0,0 +1,157 @@
+package core
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testMJPEGTS builds a segment with a PAT, a PMT on PID 0x1000 for a private stream on PID 0x100, and a PES
+// packet carrying img as a JPEG picture
+func testMJPEGTS(t *testing.T, img image.Image) []byte {
+	var picture bytes.Buffer
+	require.NoError(t, jpeg.Encode(&picture, img, nil))
+	pat := testPSIPacket(0, 0x00, []byte{0, 1, 0xc1, 0, 0, 0, 1, 0xf0, 0x00})
+	pmt := testPSIPacket(0x1000, 0x02, []byte{0, 1, 0xc1, 0, 0, 0xe1, 0x00, 0xf0, 0, 0x06, 0xe1, 0x00, 0xf0, 0})
+	pes := append([]byte{0, 0, 1, 0xe0, 0, 0, 0x80, 0x80, 5}, testPESPTS(0)...)
+	pes = append(pes, picture.Bytes()...)
+	data := concatBytes(pat, pmt)
+	for i := 0; i < len(pes); i += tsPacketSize - 4 {
+		data = append(data, testTSPacket(0x100, i == 0, pes[i:min(i+tsPacketSize-4, len(pes))])...)
+	}
+	return data
+}
+
+func testThumbImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{R: 200, G: 40, B: 40, A: 255})
+		}
+	}
+	return img
+}
+
+// testFFmpegPath sets a PATH with no ffmpeg, or with an ffmpeg script failing every run if failing is set
+func testFFmpegPath(t *testing.T, failing bool) {
+	dir := t.TempDir()
+	if failing {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte("#!/bin/sh\nexit 1\n"), 0755))
+	}
+	t.Setenv("PATH", dir)
+	installed := ffmpegInstalled
+	ffmpegInstalled = sync.OnceValue(lookFFmpeg)
+	t.Cleanup(func() { ffmpegInstalled = installed })
+}
+
+func TestNativeThumb(t *testing.T) {
+	dir := t.TempDir()
+	segment := filepath.Join(dir, "0.ts")
+	outFile := filepath.Join(dir, "out.png")
+	require.NoError(t, os.WriteFile(segment, testMJPEGTS(t, testThumbImage()), 0644))
+	require.NoError(t, nativeThumb(segment, outFile))
+
+	file, err := os.Open(outFile)
+	require.NoError(t, err)
+	defer file.Close()
+	thumb, err := png.Decode(file)
+	require.NoError(t, err)
+	// 64x48 scaled to fit 426x240
+	require.Equal(t, image.Rect(0, 0, 320, 240), thumb.Bounds())
+	r, g, b, _ := thumb.At(160, 120).RGBA()
+	require.InDelta(t, 200, r>>8, 8)
+	require.InDelta(t, 40, g>>8, 8)
+	require.InDelta(t, 40, b>>8, 8)
+
+	require.NoError(t, os.WriteFile(segment, testTS(500, 3), 0644))
+	require.ErrorContains(t, nativeThumb(segment, outFile), "decoding H.264 keyframes needs ffmpeg")
+}
+
+func TestThumbnailWithoutFFmpeg(t *testing.T) {
+	testFFmpegPath(t, false)
+	require.False(t, ffmpegInstalled())
+	dir := t.TempDir()
+	segmentURI := filepath.ToSlash(filepath.Join(dir, "stream", "session", "0.ts"))
+
+	result, err := Upload(bytes.NewReader(testMJPEGTS(t, testThumbImage())), mustParseURL(segmentURI), UploadOptions{})
+	require.NoError(t, err)
+	require.Empty(t, result.Warnings)
+	require.FileExists(t, filepath.Join(dir, "stream", "session", "latest.png"))
+
+	// segments the keyframes of can't be decoded still warn
+	result, err = Upload(bytes.NewReader(testTS(500, 3)), mustParseURL(segmentURI), UploadOptions{})
+	require.NoError(t, err)
+	require.Len(t, result.Warnings, 1)
+	require.Equal(t, WarningThumbnailFailed, result.Warnings[0].Code)
+	require.Contains(t, result.Warnings[0].Message, "ffmpeg isn't installed")
+
+	// no warning for the segments thumbnails are disabled for
+	result, err = Upload(bytes.NewReader(testTS(500, 3)), mustParseURL(segmentURI), UploadOptions{DisableThumbs: []string{"stream"}})
+	require.NoError(t, err)
+	require.Empty(t, result.Warnings)
+}
+
+func TestThumbnailWhenFFmpegFails(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the failing ffmpeg is a shell script")
+	}
+	testFFmpegPath(t, true)
+	require.True(t, ffmpegInstalled())
+	dir := t.TempDir()
+
+	result, err := Upload(bytes.NewReader(testMJPEGTS(t, testThumbImage())), mustParseURL(filepath.ToSlash(filepath.Join(dir, "stream", "session", "0.ts"))), UploadOptions{})
+	require.NoError(t, err)
+	require.Empty(t, result.Warnings)
+	require.FileExists(t, filepath.Join(dir, "stream", "session", "latest.png"))
+}
+
+func TestThumbnailUploadSettings(t *testing.T) {
+	testFFmpegPath(t, false)
+	segment := testMJPEGTS(t, testThumbImage())
+
+	// the Cache-Control of the segment and the rules for other files don't apply to its thumbnails
+	opts := UploadOptions{
+		Destination: DestinationOptions{CacheControl: "max-age=31536000"},
+		HeaderRules: []HeaderRule{
+			{CacheControl: "public, immutable"},
+			{Extensions: []string{".png"}, Metadata: map[string]string{"kind": "thumbnail"}},
+		},
+	}
+	_, err := Upload(bytes.NewReader(segment), mustParseURL("memory-s3://thumb-settings/stream/session/0.ts"), opts)
+	require.NoError(t, err)
+	obj, ok := memoryS3.server.Object("thumb-settings", "stream/session/0.ts")
+	require.True(t, ok)
+	require.Equal(t, "max-age=31536000", obj.CacheControl)
+	thumb, ok := memoryS3.server.Object("thumb-settings", "stream/session/latest.png")
+	require.True(t, ok)
+	require.Equal(t, "max-age=5", thumb.CacheControl)
+	require.Equal(t, "thumbnail", thumb.Metadata["kind"])
+
+	// and the warnings of the thumbnail uploads are reported with the segment
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	unreachable := "bunny+http://zone:key@" + listener.Addr().String()
+	require.NoError(t, listener.Close())
+	opts = UploadOptions{
+		ThumbsURLReplacement: map[string]string{"stream": "memory-s3://thumb-warnings " + unreachable},
+		StorageFallbackURLs:  map[string]string{unreachable + "/": "memory-s3://thumb-backup/"},
+	}
+	result, err := Upload(bytes.NewReader(segment), mustParseURL("memory-s3://thumb-warnings/stream/session/0.ts"), opts)
+	require.NoError(t, err)
+	require.Len(t, result.Warnings, 2)
+	for _, warning := range result.Warnings {
+		require.Equal(t, WarningFallback, warning.Code)
+	}
+	_, ok = memoryS3.server.Object("thumb-backup", "stream/session/latest.png")
+	require.True(t, ok)
+}
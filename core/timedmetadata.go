@@ -0,0 +1,437 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/golang/glog"
+)
+
+// id3StreamType is the PMT stream type of metadata carried in PES packets, which HLS uses for ID3 tags
+const id3StreamType = 0x15
+
+// TimedMetadata is an SCTE-35 splice information section or an ID3 tag found in a segment
+type TimedMetadata struct {
+	// Type is scte35 or id3
+	Type string `json:"type"`
+	PID  uint16 `json:"pid"`
+	// PTS is the presentation time of an ID3 tag in seconds
+	PTS *float64 `json:"pts,omitempty"`
+
+	// Command is the SCTE-35 splice command, e.g. splice_insert or time_signal
+	Command       string  `json:"command,omitempty"`
+	SpliceEventID *uint32 `json:"splice_event_id,omitempty"`
+	OutOfNetwork  bool    `json:"out_of_network,omitempty"`
+	// SpliceTime is the presentation time of the splice in seconds, with the PTS adjustment applied
+	SpliceTime *float64 `json:"splice_time,omitempty"`
+	// Duration of the break in seconds
+	Duration *float64 `json:"duration,omitempty"`
+
+	Frames []ID3Frame `json:"frames,omitempty"`
+
+	// Data is the whole SCTE-35 section or ID3 tag
+	Data []byte `json:"data"`
+}
+
+// ID3Frame is a frame of an ID3 tag. Text frames have a Value, TXXX frames a Description and Value,
+// PRIV frames an Owner and Data, and other frames only Data.
+type ID3Frame struct {
+	ID          string `json:"id"`
+	Description string `json:"description,omitempty"`
+	Value       string `json:"value,omitempty"`
+	Owner       string `json:"owner,omitempty"`
+	Data        []byte `json:"data,omitempty"`
+}
+
+// timedMetadataSidecar is the JSON written next to segments and sent to the webhook
+type timedMetadataSidecar struct {
+	URI    string          `json:"uri"`
+	Events []TimedMetadata `json:"events"`
+	CorrelationIDs
+}
+
+// extractTimedMetadata returns the SCTE-35 sections and ID3 tags of a TS segment in the order they appear.
+// Sections are expected to fit in one packet. Markers that can't be parsed are logged and skipped.
+func extractTimedMetadata(fileName string) ([]TimedMetadata, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var (
+		events      []TimedMetadata
+		pmtPIDs     = map[uint16]bool{}
+		scte35PIDs  = map[uint16]bool{}
+		id3Packets  = map[uint16]*bytes.Buffer{}
+		flushID3PES = func(pid uint16) {
+			pes := id3Packets[pid]
+			if pes == nil || pes.Len() == 0 {
+				return
+			}
+			event, err := parseID3PES(pes.Bytes())
+			if err != nil {
+				glog.Warningf("Skipping ID3 tag on PID %d in %s: %v", pid, fileName, err)
+			} else {
+				event.PID = pid
+				events = append(events, event)
+			}
+			pes.Reset()
+		}
+	)
+	err = readTSPackets(file, func(n int, pid uint16, unitStart bool, payload []byte) error {
+		switch {
+		case pid == 0 && unitStart:
+			if section, err := psiSection(payload, 0x00); err == nil {
+				for _, pmtPID := range patPMTPIDs(section) {
+					pmtPIDs[pmtPID] = true
+				}
+			}
+		case pmtPIDs[pid] && unitStart:
+			section, err := psiSection(payload, 0x02)
+			if err != nil {
+				return nil
+			}
+			streams, err := pmtStreams(section)
+			if err != nil {
+				return nil
+			}
+			for _, stream := range streams {
+				switch stream.streamType {
+				case scte35StreamType:
+					scte35PIDs[stream.pid] = true
+				case id3StreamType:
+					if id3Packets[stream.pid] == nil {
+						id3Packets[stream.pid] = &bytes.Buffer{}
+					}
+				}
+			}
+		case scte35PIDs[pid] && unitStart:
+			section, err := psiSection(payload, 0xfc)
+			if err != nil {
+				glog.Warningf("Skipping SCTE-35 section in packet %d of %s: %v", n, fileName, err)
+				return nil
+			}
+			event, err := parseSCTE35(section)
+			if err != nil {
+				glog.Warningf("Skipping SCTE-35 section in packet %d of %s: %v", n, fileName, err)
+				return nil
+			}
+			event.PID = pid
+			events = append(events, event)
+		case id3Packets[pid] != nil:
+			if unitStart {
+				flushID3PES(pid)
+			}
+			id3Packets[pid].Write(payload)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for pid := range id3Packets {
+		flushID3PES(pid)
+	}
+	return events, nil
+}
+
+// ptsSeconds converts a 90kHz timestamp to seconds
+func ptsSeconds(pts uint64) *float64 {
+	seconds := float64(pts) / 90000
+	return &seconds
+}
+
+// read33Bits reads a 33 bit timestamp stored in the low bit of b[0] and the following 4 bytes
+func read33Bits(b []byte) uint64 {
+	return uint64(b[0]&1)<<32 | uint64(binary.BigEndian.Uint32(b[1:]))
+}
+
+var spliceCommands = map[byte]string{
+	0x00: "splice_null",
+	0x04: "splice_schedule",
+	0x05: "splice_insert",
+	0x06: "time_signal",
+	0x07: "bandwidth_reservation",
+	0xff: "private_command",
+}
+
+// parseSCTE35 parses a splice_info_section, as defined by SCTE 35
+func parseSCTE35(section []byte) (TimedMetadata, error) {
+	if len(section) < 18 {
+		return TimedMetadata{}, errors.New("section too short")
+	}
+	event := TimedMetadata{Type: "scte35", Data: section}
+	if section[4]&0x80 != 0 {
+		// the command is encrypted
+		event.Command = "encrypted"
+		return event, nil
+	}
+	ptsAdjustment := read33Bits(section[4:])
+	commandType := section[13]
+	if command, ok := spliceCommands[commandType]; ok {
+		event.Command = command
+	} else {
+		event.Command = fmt.Sprintf("0x%02x", commandType)
+	}
+	command := section[14 : len(section)-4]
+
+	// spliceTime parses a splice_time() and returns its size
+	spliceTime := func(b []byte) (int, error) {
+		if len(b) < 1 {
+			return 0, errors.New("splice_time too short")
+		}
+		if b[0]&0x80 == 0 {
+			return 1, nil
+		}
+		if len(b) < 5 {
+			return 0, errors.New("splice_time too short")
+		}
+		event.SpliceTime = ptsSeconds((read33Bits(b) + ptsAdjustment) & (1<<33 - 1))
+		return 5, nil
+	}
+
+	switch commandType {
+	case 0x05:
+		if len(command) < 5 {
+			return event, errors.New("splice_insert too short")
+		}
+		spliceEventID := binary.BigEndian.Uint32(command)
+		event.SpliceEventID = &spliceEventID
+		if command[4]&0x80 != 0 {
+			// the splice event is cancelled
+			return event, nil
+		}
+		if len(command) < 6 {
+			return event, errors.New("splice_insert too short")
+		}
+		flags := command[5]
+		event.OutOfNetwork = flags&0x80 != 0
+		programSplice, durationFlag, immediate := flags&0x40 != 0, flags&0x20 != 0, flags&0x10 != 0
+		rest := command[6:]
+		if programSplice && !immediate {
+			n, err := spliceTime(rest)
+			if err != nil {
+				return event, err
+			}
+			rest = rest[n:]
+		} else if !programSplice {
+			if len(rest) < 1 {
+				return event, errors.New("splice_insert too short")
+			}
+			componentCount := int(rest[0])
+			rest = rest[1:]
+			for i := 0; i < componentCount; i++ {
+				if len(rest) < 1 {
+					return event, errors.New("splice_insert too short")
+				}
+				rest = rest[1:]
+				if !immediate {
+					n, err := spliceTime(rest)
+					if err != nil {
+						return event, err
+					}
+					rest = rest[n:]
+				}
+			}
+		}
+		if durationFlag {
+			if len(rest) < 5 {
+				return event, errors.New("break_duration too short")
+			}
+			event.Duration = ptsSeconds(read33Bits(rest))
+		}
+	case 0x06:
+		if _, err := spliceTime(command); err != nil {
+			return event, err
+		}
+	}
+	return event, nil
+}
+
+// parseID3PES parses the ID3 tag carried in a PES packet
+func parseID3PES(pes []byte) (TimedMetadata, error) {
+	if !isPESStart(pes) || len(pes) < 9 {
+		return TimedMetadata{}, errors.New("not a PES packet")
+	}
+	headerLength := int(pes[8])
+	if len(pes) < 9+headerLength {
+		return TimedMetadata{}, errors.New("PES header too short")
+	}
+	event := TimedMetadata{Type: "id3"}
+	if pes[7]&0x80 != 0 && headerLength >= 5 {
+		event.PTS = ptsSeconds(pesPTS(pes[9:]))
+	}
+	tag := pes[9+headerLength:]
+	if length := int(binary.BigEndian.Uint16(pes[4:])); length > 0 && 6+length < len(pes) {
+		// drop the padding after the PES packet
+		tag = pes[9+headerLength : 6+length]
+	}
+	frames, size, err := parseID3(tag)
+	if err != nil {
+		return event, err
+	}
+	event.Frames = frames
+	event.Data = tag[:size]
+	return event, nil
+}
+
+// synchsafe decodes a 28 bit integer stored in 7 bits of each of 4 bytes
+func synchsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// parseID3 parses the frames of an ID3v2.3 or v2.4 tag and returns them with the size of the tag
+func parseID3(tag []byte) ([]ID3Frame, int, error) {
+	if len(tag) < 10 || string(tag[:3]) != "ID3" {
+		return nil, 0, errors.New("no ID3 tag")
+	}
+	version, flags := tag[3], tag[5]
+	if version != 3 && version != 4 {
+		return nil, 0, fmt.Errorf("unsupported ID3 version 2.%d", version)
+	}
+	size := 10 + synchsafe(tag[6:])
+	if size > len(tag) {
+		return nil, 0, errors.New("ID3 tag truncated")
+	}
+	body := tag[10:size]
+	if flags&0x40 != 0 {
+		// skip the extended header, whose size includes itself in v2.4 but not in v2.3
+		if len(body) < 4 {
+			return nil, 0, errors.New("ID3 extended header truncated")
+		}
+		extendedSize := synchsafe(body)
+		if version == 3 {
+			extendedSize = 4 + int(binary.BigEndian.Uint32(body))
+		}
+		if extendedSize > len(body) {
+			return nil, 0, errors.New("ID3 extended header truncated")
+		}
+		body = body[extendedSize:]
+	}
+
+	var frames []ID3Frame
+	for len(body) >= 10 && body[0] != 0 {
+		id := string(body[:4])
+		frameSize := int(binary.BigEndian.Uint32(body[4:]))
+		if version == 4 {
+			frameSize = synchsafe(body[4:])
+		}
+		if 10+frameSize > len(body) {
+			return nil, 0, fmt.Errorf("ID3 frame %s truncated", id)
+		}
+		frames = append(frames, parseID3Frame(id, body[10:10+frameSize]))
+		body = body[10+frameSize:]
+	}
+	return frames, size, nil
+}
+
+func parseID3Frame(id string, data []byte) ID3Frame {
+	frame := ID3Frame{ID: id}
+	switch {
+	case id == "PRIV":
+		owner, rest, _ := bytes.Cut(data, []byte{0})
+		frame.Owner, frame.Data = string(owner), rest
+	case id == "TXXX" && len(data) > 0:
+		description, value := splitID3Text(data[0], data[1:])
+		frame.Description, frame.Value = decodeID3Text(data[0], description), decodeID3Text(data[0], value)
+	case strings.HasPrefix(id, "T") && len(data) > 0:
+		frame.Value = decodeID3Text(data[0], data[1:])
+	default:
+		frame.Data = data
+	}
+	return frame
+}
+
+// splitID3Text splits text at the first terminator of its encoding
+func splitID3Text(encoding byte, text []byte) ([]byte, []byte) {
+	if encoding == 1 || encoding == 2 {
+		for i := 0; i+1 < len(text); i += 2 {
+			if text[i] == 0 && text[i+1] == 0 {
+				return text[:i], text[i+2:]
+			}
+		}
+		return text, nil
+	}
+	before, after, _ := bytes.Cut(text, []byte{0})
+	return before, after
+}
+
+// decodeID3Text decodes ISO-8859-1, UTF-16 with BOM, UTF-16BE or UTF-8 text, dropping a trailing terminator
+func decodeID3Text(encoding byte, text []byte) string {
+	switch encoding {
+	case 0:
+		text = bytes.TrimRight(text, "\x00")
+		runes := make([]rune, len(text))
+		for i, b := range text {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	case 1, 2:
+		bigEndian := encoding == 2
+		if len(text) >= 2 && encoding == 1 {
+			bigEndian = !(text[0] == 0xff && text[1] == 0xfe)
+			if (text[0] == 0xff && text[1] == 0xfe) || (text[0] == 0xfe && text[1] == 0xff) {
+				text = text[2:]
+			}
+		}
+		units := make([]uint16, 0, len(text)/2)
+		for i := 0; i+1 < len(text); i += 2 {
+			if bigEndian {
+				units = append(units, binary.BigEndian.Uint16(text[i:]))
+			} else {
+				units = append(units, binary.LittleEndian.Uint16(text[i:]))
+			}
+		}
+		for len(units) > 0 && units[len(units)-1] == 0 {
+			units = units[:len(units)-1]
+		}
+		return string(utf16.Decode(units))
+	}
+	return string(bytes.TrimRight(text, "\x00"))
+}
+
+// timedMetadataURI is where the timed metadata of a segment is written, next to it with a .metadata.json extension
+func timedMetadataURI(segmentURI *url.URL) *url.URL {
+	u := *segmentURI
+	u.Path = strings.TrimSuffix(u.Path, path.Ext(u.Path)) + ".metadata.json"
+	u.RawPath = ""
+	return &u
+}
+
+// uploadTimedMetadata extracts the SCTE-35 and ID3 markers of a TS segment and, if there are any, writes them
+// next to the segment and posts them to the webhook if one is configured
+func uploadTimedMetadata(segmentURI *url.URL, segmentFileName string, opts UploadOptions) error {
+	events, err := extractTimedMetadata(segmentFileName)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(timedMetadataSidecar{URI: RedactedURI(segmentURI), Events: events, CorrelationIDs: opts.CorrelationIDs})
+	if err != nil {
+		return err
+	}
+
+	u := timedMetadataURI(segmentURI)
+	if err := uploadJSON(u, data, opts); err != nil {
+		return fmt.Errorf("saving timed metadata failed: %w", err)
+	}
+	glog.V(5).Infof("Wrote %d timed metadata events to %s", len(events), u.Redacted())
+
+	if opts.TimedMetadataWebhook != "" {
+		if err := postEvent(opts.TimedMetadataWebhook, EventTimedMetadata, RedactedURI(segmentURI), data, opts); err != nil {
+			return fmt.Errorf("timed metadata webhook failed: %w", err)
+		}
+	}
+	return nil
+}
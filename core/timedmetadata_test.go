@@ -0,0 +1,124 @@
+package core
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testPESPTS encodes a PTS in the PES header format
+func testPESPTS(pts uint64) []byte {
+	return []byte{0x21 | byte(pts>>29&0x0e), byte(pts >> 22), byte(pts>>14&0xfe) | 1, byte(pts >> 7), byte(pts<<1&0xfe) | 1}
+}
+
+// testID3PES builds a PES packet carrying an ID3v2.4 tag with the given frames
+func testID3PES(pts uint64, frames ...[]byte) []byte {
+	body := concatBytes(frames...)
+	tag := append([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, byte(len(body) >> 7 & 0x7f), byte(len(body) & 0x7f)}, body...)
+	pes := append([]byte{0, 0, 1, 0xbd, 0, 0, 0x84, 0x80, 5}, testPESPTS(pts)...)
+	pes = append(pes, tag...)
+	binary.BigEndian.PutUint16(pes[4:], uint16(len(pes)-6))
+	return pes
+}
+
+func testID3Frame(id string, data []byte) []byte {
+	return append([]byte{id[0], id[1], id[2], id[3], 0, 0, byte(len(data) >> 7 & 0x7f), byte(len(data) & 0x7f), 0, 0}, data...)
+}
+
+// testTimedMetadataTS builds a segment with a video stream on PID 0x100, SCTE-35 on 0x101 and ID3 on 0x102
+func testTimedMetadataTS() []byte {
+	pat := testPSIPacket(0, 0x00, []byte{0, 1, 0xc1, 0, 0, 0, 1, 0xf0, 0x00})
+	pmt := testPSIPacket(0x1000, 0x02, []byte{
+		0, 1, 0xc1, 0, 0, 0xe1, 0x00, 0xf0, 0,
+		0x1b, 0xe1, 0x00, 0xf0, 0,
+		0x86, 0xe1, 0x01, 0xf0, 0,
+		0x15, 0xe1, 0x02, 0xf0, 0,
+	})
+	// splice_insert of event 0x12345678 going out of network at 10s for 30s, with a PTS adjustment of 1s
+	scte35 := testPSIPacket(0x101, 0xfc, []byte{
+		0x00, 0x00, 0x00, 0x01, 0x5f, 0x90, 0xff, 0xff, 0xff, 0xff, 0x05,
+		0x12, 0x34, 0x56, 0x78, 0x7f, 0xef,
+		0xfe, 0x00, 0x0d, 0xbb, 0xa0,
+		0xfe, 0x00, 0x29, 0x32, 0xe0,
+		0x00, 0x01, 0x01, 0x01,
+		0x00, 0x00,
+	})
+	id3 := testID3PES(180000,
+		testID3Frame("TXXX", []byte("\x03ad\x00break")),
+		testID3Frame("PRIV", []byte("com.apple.streaming.transportStreamTimestamp\x00\x00\x00\x00\x00\x00\x00\x00\x01")),
+	)
+	return concatBytes(pat, pmt, testTS(0, 1)[2*tsPacketSize:], scte35, testTSPacket(0x102, true, id3))
+}
+
+func TestExtractTimedMetadata(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "0.ts")
+	require.NoError(t, os.WriteFile(fileName, testTimedMetadataTS(), 0644))
+
+	events, err := extractTimedMetadata(fileName)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+
+	scte35 := events[0]
+	require.Equal(t, "scte35", scte35.Type)
+	require.Equal(t, uint16(0x101), scte35.PID)
+	require.Equal(t, "splice_insert", scte35.Command)
+	require.Equal(t, uint32(0x12345678), *scte35.SpliceEventID)
+	require.True(t, scte35.OutOfNetwork)
+	require.Equal(t, 11.0, *scte35.SpliceTime)
+	require.Equal(t, 30.0, *scte35.Duration)
+
+	id3 := events[1]
+	require.Equal(t, "id3", id3.Type)
+	require.Equal(t, uint16(0x102), id3.PID)
+	require.Equal(t, 2.0, *id3.PTS)
+	require.Equal(t, []ID3Frame{
+		{ID: "TXXX", Description: "ad", Value: "break"},
+		{ID: "PRIV", Owner: "com.apple.streaming.transportStreamTimestamp", Data: []byte{0, 0, 0, 0, 0, 0, 0, 1}},
+	}, id3.Frames)
+	require.Equal(t, "ID3", string(id3.Data[:3]))
+
+	// the markers don't make the segment invalid
+	require.NoError(t, validateSegment(fileName, ".ts"))
+}
+
+func TestDecodeID3Text(t *testing.T) {
+	require.Equal(t, "café", decodeID3Text(0, []byte("caf\xe9\x00")))
+	require.Equal(t, "hi", decodeID3Text(1, []byte{0xff, 0xfe, 'h', 0, 'i', 0, 0, 0}))
+	require.Equal(t, "hi", decodeID3Text(2, []byte{0, 'h', 0, 'i'}))
+	require.Equal(t, "café", decodeID3Text(3, []byte("café")))
+}
+
+func TestUploadTimedMetadata(t *testing.T) {
+	var posted timedMetadataSidecar
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &posted))
+	}))
+	defer webhook.Close()
+
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "0.ts")
+	require.NoError(t, os.WriteFile(fileName, testTimedMetadataTS(), 0644))
+	opts := UploadOptions{TimedMetadataWebhook: webhook.URL}
+	require.NoError(t, uploadTimedMetadata(mustParseURL("memory-s3://timed-metadata/hls/720p/0.ts"), fileName, opts))
+
+	obj, ok := memoryS3.server.Object("timed-metadata", "hls/720p/0.metadata.json")
+	require.True(t, ok)
+	var sidecar timedMetadataSidecar
+	require.NoError(t, json.Unmarshal(obj.Data, &sidecar))
+	require.Len(t, sidecar.Events, 2)
+	require.Equal(t, sidecar, posted)
+
+	// segments without markers get no sidecar
+	require.NoError(t, os.WriteFile(fileName, testTS(500, 3), 0644))
+	require.NoError(t, uploadTimedMetadata(mustParseURL("memory-s3://timed-metadata/hls/720p/1.ts"), fileName, opts))
+	_, ok = memoryS3.server.Object("timed-metadata", "hls/720p/1.metadata.json")
+	require.False(t, ok)
+}
@@ -0,0 +1,129 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// initSegmentName is the CMAF init segment written next to transmuxed segments
+const initSegmentName = "init.mp4"
+
+// transmuxTimeout bounds the ffmpeg remux of a single segment
+const transmuxTimeout = 30 * time.Second
+
+// transmuxTS remuxes an MPEG-TS segment into a CMAF init segment and media segment in dir, without re-encoding.
+// Timestamps are kept so that consecutive segments line up.
+func transmuxTS(fileName, dir string, limiter *FFmpegLimiter) (initFileName, segmentFileName string, err error) {
+	fragmentedFileName := filepath.Join(dir, "fragmented.mp4")
+	args := []string{
+		"-i", fileName,
+		"-c", "copy",
+		"-copyts",
+		"-f", "mp4",
+		"-movflags", "cmaf+frag_keyframe+empty_moov+separate_moof+default_base_moof",
+		"-y",
+		fragmentedFileName,
+	}
+	release, err := limiter.acquire()
+	if err != nil {
+		return "", "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), transmuxTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	var stdErr bytes.Buffer
+	cmd.Stderr = &stdErr
+	err = cmd.Run()
+	release()
+	if err != nil {
+		return "", "", fmt.Errorf("ffmpeg failed [%s]: %w", stdErr.String(), err)
+	}
+
+	initFileName, segmentFileName = filepath.Join(dir, initSegmentName), filepath.Join(dir, "segment.m4s")
+	if err := splitFragmentedMP4(fragmentedFileName, initFileName, segmentFileName); err != nil {
+		return "", "", err
+	}
+	return initFileName, segmentFileName, nil
+}
+
+// splitFragmentedMP4 writes the boxes of a fragmented MP4 file before its first moof, the init segment, and
+// the remaining boxes, the media segment, to separate files
+func splitFragmentedMP4(fileName, initFileName, segmentFileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	boxes, err := readMP4Boxes(file, info.Size())
+	if err != nil {
+		return err
+	}
+	split := -1
+	for i, box := range boxes {
+		if box.boxType == "moof" {
+			split = i
+			break
+		}
+	}
+	if split <= 0 {
+		return fmt.Errorf("%s is not a fragmented MP4 file", fileName)
+	}
+	if err := writeFileSection(initFileName, file, 0, boxes[split].offset); err != nil {
+		return err
+	}
+	return writeFileSection(segmentFileName, file, boxes[split].offset, info.Size()-boxes[split].offset)
+}
+
+func writeFileSection(fileName string, r io.ReaderAt, offset, size int64) error {
+	out, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := copyBuffered(out, io.NewSectionReader(r, offset, size)); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// uploadInitSegment writes the init segment of a transmuxed segment next to it, unless the stored one is the same
+func uploadInitSegment(segmentURI *url.URL, initFileName string, opts UploadOptions) error {
+	initURI := segmentURI.JoinPath("../" + initSegmentName)
+	data, err := os.ReadFile(initFileName)
+	if err != nil {
+		return err
+	}
+	if stored, err := readAll(initURI, opts); err == nil && bytes.Equal(stored, data) {
+		return nil
+	}
+	if _, _, err := uploadFileWithBackup(initURI, initFileName, nil, opts.SegmentTimeout, true, opts); err != nil {
+		return fmt.Errorf("failed to upload init segment %s: %w", initURI.Redacted(), err)
+	}
+	glog.V(5).Infof("Wrote init segment %s", initURI.Redacted())
+	return nil
+}
+
+// readAll reads the whole object at u
+func readAll(u *url.URL, opts UploadOptions) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSaveTimeout)
+	defer cancel()
+	reader, err := ReadRange(ctx, u, 0, -1, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Body.Close()
+	return io.ReadAll(reader.Body)
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitFragmentedMP4(t *testing.T) {
+	dir := t.TempDir()
+	initData := concatBytes(testMP4Box("ftyp", 16), testMP4Container("moov", testMP4Box("mvex", 32)))
+	media := concatBytes(testMP4Box("moof", 100), testMP4Box("mdat", 1000), testMP4Box("moof", 100), testMP4Box("mdat", 500))
+	fileName := filepath.Join(dir, "fragmented.mp4")
+	require.NoError(t, os.WriteFile(fileName, concatBytes(initData, media), 0644))
+
+	initFileName, segmentFileName := filepath.Join(dir, "init.mp4"), filepath.Join(dir, "segment.m4s")
+	require.NoError(t, splitFragmentedMP4(fileName, initFileName, segmentFileName))
+	data, err := os.ReadFile(initFileName)
+	require.NoError(t, err)
+	require.Equal(t, initData, data)
+	data, err = os.ReadFile(segmentFileName)
+	require.NoError(t, err)
+	require.Equal(t, media, data)
+
+	// not fragmented
+	require.NoError(t, os.WriteFile(fileName, concatBytes(testMP4Box("ftyp", 16), testMP4Box("moov", 100), testMP4Box("mdat", 100)), 0644))
+	require.ErrorContains(t, splitFragmentedMP4(fileName, initFileName, segmentFileName), "not a fragmented MP4 file")
+}
+
+func TestUploadInitSegment(t *testing.T) {
+	dir := t.TempDir()
+	initFileName := filepath.Join(dir, "init.mp4")
+	require.NoError(t, os.WriteFile(initFileName, testMP4Container("moov", testMP4Box("mvex", 32)), 0644))
+	var recording bytes.Buffer
+	opts := UploadOptions{Record: NewRecorder(&recording)}
+	segmentURI := mustParseURL("memory-s3://transmux/hls/720p/1.m4s")
+
+	// the init segment is only written when it changes
+	require.NoError(t, uploadInitSegment(segmentURI, initFileName, opts))
+	require.NoError(t, uploadInitSegment(segmentURI.JoinPath("../2.m4s"), initFileName, opts))
+	require.Equal(t, 1, strings.Count(recording.String(), "SaveData"))
+	require.Equal(t, []string{"hls/720p/init.mp4"}, memoryS3.server.Keys("transmux"))
+
+	require.NoError(t, os.WriteFile(initFileName, testMP4Container("moov", testMP4Box("mvex", 40)), 0644))
+	require.NoError(t, uploadInitSegment(segmentURI.JoinPath("../3.m4s"), initFileName, opts))
+	require.Equal(t, 2, strings.Count(recording.String(), "SaveData"))
+}
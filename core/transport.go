@@ -0,0 +1,190 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/golang/glog"
+)
+
+// TransportOptions tune the shared transport, e.g. for high latency links to distant regions
+type TransportOptions struct {
+	// HTTP2 negotiates HTTP/2 with servers that support it
+	HTTP2 bool
+	// MaxIdleConnsPerHost is how many connections to each host are kept alive for reuse
+	MaxIdleConnsPerHost int
+	// DialTimeout bounds establishing a TCP connection
+	DialTimeout time.Duration
+	// KeepAlive is the interval of TCP keep-alive probes, negative to disable them
+	KeepAlive time.Duration
+	// Resolve maps host:port to the address connected to instead of resolving the host, see ParseResolveOverrides
+	Resolve map[string]string
+	// DNSCacheTTL, if set, is how long the addresses of hosts are cached
+	DNSCacheTTL time.Duration
+	// IPFamily restricts connections to IPv4 or IPv6, see IPFamilyAuto. HostIPFamilies overrides it for the
+	// hosts it contains and their subdomains, e.g. for the StorageHost of a destination with the ipFamily option.
+	IPFamily       string
+	HostIPFamilies map[string]string
+}
+
+// IP families of storage connections. IPFamilyAuto, the default, races IPv4 and IPv6 connections to hosts
+// with both (happy eyeballs).
+const (
+	IPFamilyAuto = "auto"
+	IPFamily4    = "4"
+	IPFamily6    = "6"
+)
+
+func validIPFamily(family string) bool {
+	switch family {
+	case "", IPFamilyAuto, IPFamily4, IPFamily6:
+		return true
+	}
+	return false
+}
+
+// DefaultTransportOptions are the settings of http.DefaultTransport, with more idle connections per host than
+// its default of 2 so that the connections of concurrent multipart uploads are kept alive for the next upload
+func DefaultTransportOptions() TransportOptions {
+	return TransportOptions{
+		HTTP2:               true,
+		IPFamily:            IPFamilyAuto,
+		MaxIdleConnsPerHost: 16,
+		DialTimeout:         30 * time.Second,
+		KeepAlive:           30 * time.Second,
+	}
+}
+
+func (o TransportOptions) validate() error {
+	if o.MaxIdleConnsPerHost < 0 {
+		return errors.New("max idle connections per host must not be negative")
+	}
+	if o.DialTimeout < 0 {
+		return errors.New("dial timeout must not be negative")
+	}
+	if !validIPFamily(o.IPFamily) {
+		return fmt.Errorf("invalid IP family %q, expected auto, 4 or 6", o.IPFamily)
+	}
+	for host, family := range o.HostIPFamilies {
+		if !validIPFamily(family) {
+			return fmt.Errorf("invalid IP family %q for %s, expected auto, 4 or 6", family, host)
+		}
+	}
+	return nil
+}
+
+// baseTransport is http.DefaultTransport before UseSharedTransport replaces it
+var baseTransport = http.DefaultTransport.(*http.Transport)
+
+// sharedTransport keeps connections alive and resumes TLS sessions across uploads, retries and fallbacks
+var sharedTransport = newSharedTransport(DefaultTransportOptions())
+
+func newSharedTransport(opts TransportOptions) *http.Transport {
+	transport := baseTransport.Clone()
+	dialer := &net.Dialer{Timeout: opts.DialTimeout, KeepAlive: opts.KeepAlive}
+	transport.DialContext = dialer.DialContext
+	if len(opts.Resolve) > 0 || opts.DNSCacheTTL > 0 {
+		transport.DialContext = newResolvingDialer(dialer, opts.Resolve, opts.DNSCacheTTL).DialContext
+	}
+	if (opts.IPFamily != "" && opts.IPFamily != IPFamilyAuto) || len(opts.HostIPFamilies) > 0 {
+		transport.DialContext = ipFamilyDialer(transport.DialContext, opts.IPFamily, opts.HostIPFamilies)
+	}
+	transport.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	transport.TLSClientConfig = &tls.Config{ClientSessionCache: tls.NewLRUClientSessionCache(0)}
+	transport.ForceAttemptHTTP2 = opts.HTTP2
+	if !opts.HTTP2 {
+		// a non-nil empty map stops the transport from upgrading TLS connections to HTTP/2
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport
+}
+
+// StorageHost returns the host that uploads to u connect to, or its domain where the host depends on the bucket
+func StorageHost(u *url.URL) string {
+	switch {
+	case u.Scheme == "s3":
+		return "amazonaws.com"
+	case isSpacesURL(u):
+		return spacesS3URL(u).Hostname()
+	case u.Scheme == "gs":
+		return "googleapis.com"
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// ipFamilyDialer restricts the TCP connections of dial to the IP family of their host
+func ipFamilyDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error), family string, hostFamilies map[string]string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		hostFamily := family
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			host = strings.ToLower(host)
+			for domain, f := range hostFamilies {
+				if host == domain || strings.HasSuffix(host, "."+domain) {
+					hostFamily = f
+				}
+			}
+		}
+		if network == "tcp" {
+			switch hostFamily {
+			case IPFamily4:
+				network = "tcp4"
+			case IPFamily6:
+				network = "tcp6"
+			}
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// UseSharedTransport makes a transport with the given options the default one. The storage drivers, the S3
+// sessions and GCS clients created here, CDN purges and webhooks all send their requests through
+// http.DefaultTransport, so they then share one pool of connections instead of paying for TLS handshakes on
+// every upload.
+func UseSharedTransport(opts TransportOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	sharedTransport = newSharedTransport(opts)
+	http.DefaultTransport = sharedTransport
+	return nil
+}
+
+// isUnreachable reports whether err is a failure to reach a storage host at all, because its name doesn't
+// resolve or it refuses connections, e.g. for a mistyped endpoint. Retrying the same request doesn't help.
+func isUnreachable(err error) bool {
+	for err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return true
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return true
+		}
+		// the AWS SDK wraps the errors of requests without Unwrap
+		var awsErr interface{ OrigErr() error }
+		if !errors.As(err, &awsErr) {
+			return false
+		}
+		err = awsErr.OrigErr()
+	}
+	return false
+}
+
+// unreachablePermanent stops the retries of a request to a host that can't be reached, so that the upload goes
+// to the backup storage straight away instead of retrying for as long as its policy allows
+func unreachablePermanent(err error) error {
+	if isUnreachable(err) {
+		glog.Warningf("Not retrying, storage host is unreachable: %v", err)
+		return backoff.Permanent(err)
+	}
+	return err
+}
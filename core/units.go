@@ -0,0 +1,56 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var byteSizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	// longest suffixes first so that "MiB" isn't matched as "B"
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"TiB", 1 << 40},
+	{"KB", 1000},
+	{"MB", 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"TB", 1000 * 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+// ParseByteSize parses sizes such as 512, 64KB, 16MiB or 1.5GB. KB/MB/GB are powers of 1000, KiB/MiB/GiB powers of 1024.
+func ParseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	multiplier := int64(1)
+	number := s
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(strings.ToLower(s), strings.ToLower(unit.suffix)) {
+			number = strings.TrimSpace(s[:len(s)-len(unit.suffix)])
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(number, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid byte size %q", s)
+	}
+	return int64(f * float64(multiplier)), nil
+}
+
+// formatByteSize formats n bytes with the largest binary unit that keeps it at least 1, e.g. 1.5 MiB
+func formatByteSize(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f B", n)
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
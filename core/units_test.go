@@ -0,0 +1,37 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseByteSize(t *testing.T) {
+	for input, expected := range map[string]int64{
+		"512":    512,
+		"512B":   512,
+		"64KB":   64000,
+		"64kb":   64000,
+		"16MiB":  16 * 1024 * 1024,
+		"1.5GB":  1500 * 1000 * 1000,
+		"2 GiB":  2 * 1024 * 1024 * 1024,
+		"0":      0,
+		"1TiB":   1 << 40,
+		"10 mib": 10 * 1024 * 1024,
+	} {
+		size, err := ParseByteSize(input)
+		require.NoError(t, err, input)
+		require.Equal(t, expected, size, input)
+	}
+	for _, input := range []string{"", "MB", "-1MB", "12XB"} {
+		_, err := ParseByteSize(input)
+		require.Error(t, err, input)
+	}
+}
+
+func TestFormatByteSize(t *testing.T) {
+	require.Equal(t, "0 B", formatByteSize(0))
+	require.Equal(t, "1023 B", formatByteSize(1023))
+	require.Equal(t, "1.5 KiB", formatByteSize(1536))
+	require.Equal(t, "16.0 MiB", formatByteSize(16*1024*1024))
+}
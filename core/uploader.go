@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/url"
@@ -13,6 +14,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/cenkalti/backoff/v4"
 	"github.com/golang/glog"
 	"github.com/livepeer/go-tools/drivers"
@@ -25,7 +27,7 @@ type ByteCounter struct {
 
 func (bc *ByteCounter) Write(p []byte) (n int, err error) {
 	bc.Count += int64(len(p))
-	return n, nil
+	return len(p), nil
 }
 
 func newExponentialBackOffExecutor(initial, max, totalMax time.Duration) *backoff.ExponentialBackOff {
@@ -49,23 +51,262 @@ func SingleRequestRetryBackoff() backoff.BackOff {
 	return newExponentialBackOffExecutor(5*time.Second, 10*time.Second, 30*time.Second)
 }
 
-var expiryField = map[string]string{
-	"Object-Expires": "+168h", // Objects will be deleted after 7 days
+// lowMemoryPartSize is the multipart part size used in low memory mode, see UploadOptions.LowMemory
+const lowMemoryPartSize = minS3PartSize
+
+// minDerivedSegmentTimeout leaves room for connecting in timeouts derived from short segments, see
+// UploadOptions.SegmentTimeoutFactor
+const minDerivedSegmentTimeout = 5 * time.Second
+
+const (
+	// fileInputConcurrency and fileInputPartSize are the multipart settings for S3 uploads of input files,
+	// see UploadFiles. The part size grows with the file so that it fits in maxS3Parts.
+	fileInputConcurrency = 4
+	fileInputPartSize    = 16 * 1024 * 1024
+)
+
+type UploadOptions struct {
+	// WaitBetweenWrites is the minimum interval between incremental writes of manifests
+	WaitBetweenWrites time.Duration
+	// WriteTimeout applies to each manifest write
+	WriteTimeout time.Duration
+	// SegmentTimeout applies to segment (.ts and .mp4) uploads
+	SegmentTimeout time.Duration
+	// SegmentTimeoutFactor, if set, replaces SegmentTimeout by this multiple of the segment's duration, so that
+	// short parts fail fast while long chunks get room. It's at least minDerivedSegmentTimeout, and segments of
+	// unknown duration keep SegmentTimeout.
+	SegmentTimeoutFactor float64
+	// SegmentDuration, if set, is the duration of segments instead of the one read from their timestamps
+	SegmentDuration time.Duration
+	// StorageFallbackURLs maps primary storage URL prefixes to the backup prefix to use when the primary fails
+	StorageFallbackURLs map[string]string
+	// CorrelationIDs identify the stream session of the uploads in their metadata, events and logs
+	CorrelationIDs
+	// EndpointHealth, if set, sends uploads straight to the backup while their primary is known to be down
+	EndpointHealth *EndpointHealth
+	// HedgeDelay, if set, also starts uploading to the backup once the primary upload has made no progress for
+	// this long, and keeps whichever completes first, see uploadHedged
+	HedgeDelay time.Duration
+	// DisableThumbs is a list of playbackIDs to skip thumbnail generation for
+	DisableThumbs []string
+	// ThumbsURLReplacement maps space separated playbackIDs to a space separated "old new" URL replacement for thumbnails
+	ThumbsURLReplacement map[string]string
+	// LowMemory uploads S3 segments sequentially in small parts read from disk, instead of buffering
+	// several large parts in memory, and uploads thumbnails one at a time
+	LowMemory bool
+	// FaultInjection, if set, randomly delays, fails or truncates storage operations
+	FaultInjection *FaultProfile
+	// Record, if set, records storage operations. Replay, if set, serves them from a previous recording
+	// instead of contacting the storage.
+	Record *Recorder
+	Replay *Replayer
+	// PublicBaseURLs maps storage URL prefixes to the public URL prefix they are served from, see PublicURL
+	PublicBaseURLs map[string]string
+	// CDNPurgers maps storage URL prefixes (without credentials) to the CDN that manifests under them are
+	// purged from after each write. The purged URL is the public URL, so PublicBaseURLs must map the prefix too.
+	CDNPurgers map[string]CDNPurger
+	// URLSigners maps storage URL prefixes (without credentials) to the signer of the links reported for
+	// uploads under them, see SignedURL. The links expire after SignedURLTTL.
+	URLSigners   map[string]URLSigner
+	SignedURLTTL time.Duration
+	// SMBCredentials are used for smb:// destinations without credentials in the URL
+	SMBCredentials *SMBCredentials
+	// SSH configures authentication for scp:// and sftp:// destinations
+	SSH *SSHConfig
+	// Grant is the POST policy grant:// destinations upload with, see the grant subcommand
+	Grant *drivers.OSInfo
+	// Index, if set, records every completed upload
+	Index *UploadIndex
+	// ScheduleWindow, if set, is when batches and tar streams start uploading files
+	ScheduleWindow *ScheduleWindow
+	// Quota, if set, counts the bytes of completed uploads under their quotas and refuses uploads under
+	// enforced quotas that are exhausted
+	Quota *UploadQuota
+	// Destination holds the options given as query parameters of the destination, see ParseDestinationOptions
+	Destination DestinationOptions
+	// ValidateSegments rejects .ts and .mp4 segments that are truncated or malformed with ErrInvalidSegment
+	// instead of uploading them
+	ValidateSegments bool
+	// Faststart moves the moov box of .mp4 uploads in front of the media data, so that they can be played
+	// progressively straight from the storage
+	Faststart bool
+	// TransmuxTS treats uploads to .m4s destinations as MPEG-TS segments that are remuxed to CMAF before
+	// uploading. The init segment is written to init.mp4 next to them whenever it changes.
+	TransmuxTS bool
+	// Waveform writes the audio peaks of each segment next to it as a .waveform.json sidecar
+	Waveform bool
+	// TimedMetadata writes the SCTE-35 and ID3 markers of TS segments next to them as a .metadata.json sidecar,
+	// and posts the same JSON to TimedMetadataWebhook if set
+	TimedMetadata        bool
+	TimedMetadataWebhook string
+	// EventWebhook, if set, is posted an event whenever an upload completes or fails, see EventUploadCompleted
+	EventWebhook string
+	// CloudEvents posts the events of EventWebhook and TimedMetadataWebhook in CloudEvents envelopes
+	CloudEvents bool
+	// EventBridge, if set, is where the events of completed uploads are put
+	EventBridge *EventBridge
+	// StreamState, if set, is where the time of the last segment upload and manifest write of the stream is
+	// published for health checks
+	StreamState *StreamState
+	// Influx, if set, is written a point in InfluxDB line protocol for every segment and manifest upload
+	Influx *InfluxSink
+	// LogRepeatInterval, if set, is how often an error that keeps repeating, such as the incremental writes
+	// of a manifest or the events of uploads failing during an outage, is logged. The ones in between are
+	// counted and summarized instead.
+	LogRepeatInterval time.Duration
+	// EmptyInput is what to do with empty inputs: EmptyInputUpload (the default) writes an empty object,
+	// EmptyInputSkip writes nothing and EmptyInputFail returns ErrEmptyInput
+	EmptyInput string
+	// IdempotencyKey, if set, is recorded in the metadata of uploaded objects. Uploads to an object that already
+	// has the same key are skipped, so that retrying a whole upload doesn't write it again. Only S3 and GCS
+	// objects carry the key, others are always uploaded.
+	IdempotencyKey string
+	// NoClobber fails uploads to objects that already exist with ErrObjectExists, after the IdempotencyKey check
+	NoClobber bool
+	// MinSegmentSize rejects non-empty segments smaller than this many bytes with ErrInvalidSegment
+	MinSegmentSize int64
+	// ManifestBufferSize is the size of the chunks manifests are read in, the default is copyBufferSize.
+	// MaxManifestSize, if set, fails manifests that are larger with ErrManifestTooLarge.
+	ManifestBufferSize int
+	MaxManifestSize    int64
+	// ContentDisposition, e.g. attachment; filename="clip.mp4", is set on uploaded S3 and GCS objects. It takes
+	// precedence over the content disposition of HeaderRules.
+	ContentDisposition string
+	// KeepFailedUploads leaves the S3 multipart uploads of segments that failed uploading for good as they are.
+	// By default those the failed attempts couldn't abort are aborted once more, so that their parts aren't kept.
+	KeepFailedUploads bool
+	// RetryBudget, if set, limits the retries of all uploads to each destination, see RetryBudget
+	RetryBudget *RetryBudget
+	// HeaderRules set the headers and metadata of uploads by destination prefix and extension, see HeaderRule.
+	// The cacheControl option of the destination takes precedence.
+	HeaderRules []HeaderRule
+	// Append uploads the input of Upload in chunks as it arrives instead of through a temp file, assembling them
+	// into one object server side: with a multipart upload on S3 and by composing on GCS, where the object grows
+	// as the input arrives. Other destinations aren't supported.
+	Append bool
+	// AppendLines adds the input of Upload to the end of the object, a small text artifact such as a session
+	// event log that several writers add lines to, failing with ErrAppendConflict if other writers keep changing
+	// it. S3, GCS and local files are supported.
+	AppendLines bool
+	// RangeSplitSize, if set, is the size from which input files are uploaded to S3 by concurrent workers that
+	// each read their own ranges of the file into parts, to saturate fast links. It doesn't apply with LowMemory.
+	RangeSplitSize int64
+	// Filter selects the files of glob inputs and tar streams that are uploaded, see FileFilter
+	Filter FileFilter
+	// DeleteInputs, if set, deletes the input files of UploadFiles once their upload is verified, see DeletePolicy
+	DeleteInputs *DeletePolicy
+	// PreserveFileMetadata records the modification time of input files in the metadata of uploaded S3 and GCS
+	// objects, and sets their Content-Type by the extension of the file, so that DownloadFile can restore them
+	PreserveFileMetadata bool
+	// ResumeDir keeps the progress of ResumeUploadFile and ResumePull uploads, so that they continue where they
+	// stopped when restarted
+	ResumeDir string
+	// ManifestHistory, if set, also writes each version of a manifest to a timestamped key under <manifest>.history/,
+	// keeping the latest ManifestHistory versions
+	ManifestHistory int
+	// DoneMarker writes a .done object with the size, SHA-256 and completion time next to each completed upload,
+	// as an unambiguous completion signal for downstream processing. Incremental manifest writes don't get one.
+	DoneMarker bool
+	// ThumbsHWAccel, if set, is the ffmpeg -hwaccel method thumbnails are decoded with, e.g. vaapi or cuda, on
+	// ThumbsHWAccelDevice if set. Thumbnails are extracted on the CPU if that fails.
+	ThumbsHWAccel       string
+	ThumbsHWAccelDevice string
+	// FFmpegLimiter, if set, limits how many ffmpeg processes run at once on the host
+	FFmpegLimiter *FFmpegLimiter
+	// HeartbeatInterval, if set, is how often the bytes read, current part and attempt of uploads in
+	// progress are logged
+	HeartbeatInterval time.Duration
+	// UploadLimiter, if set, limits the uploads running at once on the host, see UploadLimiter
+	UploadLimiter *UploadLimiter
+	// Priority is the class of the uploads waiting for an UploadLimiter slot, PriorityAuto if empty
+	Priority string
+	// ProgressBar, if set, draws the progress of uploads for interactive use
+	ProgressBar *ProgressBar
+	// Pace, if PaceRealtime, uploads segments at about the bitrate of their media rather than at line rate, so
+	// that archiving doesn't burst alongside live egress. Segments of unknown duration aren't paced.
+	Pace string
+
+	// fileInput is set by UploadFiles, whose input is a complete file of known size
+	fileInput bool
+	// sourceFile is the single input file of UploadFiles whose metadata is kept with PreserveFileMetadata
+	sourceFile string
+	// paceRate is the rate in bytes per second that uploadSegment sets for Pace
+	paceRate float64
+	// onProgress, if set, is called whenever an upload reads more of its input, see uploadHedged
+	onProgress func()
+	// ctx, if set, cancels the uploads of uploadFile, such as the one uploadHedged abandons
+	ctx context.Context
+	// attempts collects the failed attempts of uploadFileWithBackup for its UploadError
+	attempts *attemptHistory
+	// warnings collects the warnings of uploadFileWithBackup for its UploadResult
+	warnings *uploadWarnings
+	// multipartUploads collects the multipart uploads of uploadSegment that failed attempts left behind
+	multipartUploads *multipartUploads
 }
 
-func Upload(input io.Reader, outputURI *url.URL, waitBetweenWrites, writeTimeout time.Duration, storageFallbackURLs map[string]string, segTimeout time.Duration, disableThumbs []string, thumbsURLReplacement map[string]string) (*drivers.SaveDataOutput, error) {
-	ext := filepath.Ext(outputURI.Path)
-	inputFile, err := os.CreateTemp("", "upload-*"+ext)
+// UploadResult is the output of the storage driver for the write that completed the upload
+type UploadResult struct {
+	drivers.SaveDataOutput
+	// Fallback is set when the primary storage failed and the data was written to BackupURI instead
+	Fallback  bool
+	BackupURI *url.URL
+	// Skipped is set when the input was empty and nothing was written, see UploadOptions.EmptyInput
+	Skipped bool
+	// AlreadyUploaded is set when the object had already been written with the same idempotency key and
+	// nothing was written, see UploadOptions.IdempotencyKey
+	AlreadyUploaded bool
+	// Warnings are the non-fatal problems of the upload, such as a fallback or a thumbnail that failed
+	Warnings []UploadWarning
+}
+
+// Policies for empty inputs, see UploadOptions.EmptyInput
+const (
+	EmptyInputUpload = "upload"
+	EmptyInputSkip   = "skip"
+	EmptyInputFail   = "fail"
+)
+
+// ErrEmptyInput is returned for empty inputs with the EmptyInputFail policy
+var ErrEmptyInput = errors.New("empty input")
+
+// ErrManifestTooLarge is returned for manifests larger than UploadOptions.MaxManifestSize
+var ErrManifestTooLarge = errors.New("manifest too large")
+
+// location is where the data was written, either the requested outputURI or the backup
+func (r *UploadResult) location(outputURI *url.URL) *url.URL {
+	if r.Fallback {
+		return r.BackupURI
+	}
+	return outputURI
+}
+
+// VersionID is the version of the written object in buckets with versioning enabled, empty otherwise
+func (r *UploadResult) VersionID() string {
+	return r.UploaderResponseHeaders.Get("X-Amz-Version-Id")
+}
+
+func Upload(input io.Reader, outputURI *url.URL, opts UploadOptions) (*UploadResult, error) {
+	if opts.AppendLines {
+		return uploadAppendLines(input, outputURI, opts)
+	}
+	if opts.Append {
+		if err := checkNoClobber(outputURI, opts); err != nil {
+			return nil, err
+		}
+		return uploadAppend(input, outputURI, opts)
+	}
+	inputFile, err := os.CreateTemp("", "upload-*"+filepath.Ext(outputURI.Path))
 	if err != nil {
 		return nil, fmt.Errorf("failed to write to temp file: %w", err)
 	}
 	inputFileName := inputFile.Name()
 	defer os.Remove(inputFileName)
+	defer inputFile.Close()
 
-	if ext == ".ts" || ext == ".mp4" {
+	if isSegment(outputURI, opts) {
 		// For segments we just write them in one go here and return early.
 		// (Otherwise the incremental write logic below caused issues with clipping since it results in partial segments being written.)
-		_, err = io.Copy(inputFile, input)
+		_, err = copyBuffered(inputFile, input)
 		if err != nil {
 			return nil, fmt.Errorf("failed to write to temp file: %w", err)
 		}
@@ -73,60 +314,80 @@ func Upload(input io.Reader, outputURI *url.URL, waitBetweenWrites, writeTimeout
 			return nil, fmt.Errorf("failed to close input file: %w", err)
 		}
 
-		out, bytesWritten, err := uploadFileWithBackup(outputURI, inputFileName, nil, segTimeout, true, storageFallbackURLs)
-		if err != nil {
-			return nil, fmt.Errorf("failed to upload video %s: (%d bytes) %w", outputURI.Redacted(), bytesWritten, err)
-		}
+		return uploadSegment(outputURI, inputFileName, opts)
+	}
 
-		if err = extractThumb(outputURI, inputFileName, storageFallbackURLs, disableThumbs, thumbsURLReplacement); err != nil {
-			glog.Errorf("extracting thumbnail failed for %s: %v", outputURI.Redacted(), err)
-		}
-		return out, nil
+	// incremental writes would replace the object before writeFinal checks its idempotency key. They don't
+	// record the key, so that an interrupted upload isn't taken for a complete one.
+	if done, err := alreadyUploaded(outputURI, opts); err != nil {
+		return nil, err
+	} else if done {
+		_, _ = io.Copy(io.Discard, input)
+		return &UploadResult{AlreadyUploaded: true}, nil
+	}
+	if err := checkNoClobber(outputURI, opts); err != nil {
+		return nil, err
 	}
+	// the incremental writes below create the object, which writeFinal mustn't take for an existing one
+	opts.NoClobber = false
 
-	// For the manifest files we want a very short cache ttl as the files are updating every few seconds
-	fields := &drivers.FileProperties{CacheControl: "max-age=1"}
+	fields := manifestFileProperties()
 	var lastWrite = time.Now()
-	// Keep the file handle closed while we wait for input data
-	if err := inputFile.Close(); err != nil {
-		return nil, fmt.Errorf("failed to close input file: %w", err)
-	}
+	// The input is appended through one buffered handle and only flushed to the file before it's uploaded,
+	// rather than reopening the file for every chunk of a chatty input
+	writer := bufio.NewWriterSize(inputFile, copyBufferSize)
 
 	scanner := bufio.NewScanner(input)
+	if opts.ManifestBufferSize > 0 {
+		scanner.Buffer(make([]byte, opts.ManifestBufferSize), opts.ManifestBufferSize)
+	} else {
+		buf := getCopyBuffer()
+		defer putCopyBuffer(buf)
+		scanner.Buffer(*buf, copyBufferSize)
+	}
 
 	// We have to use a custom scanner because the default one is designed for text and will
 	// split on and drop newline characters
 	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		// If we have reached the end of the input, return 0 bytes and no error.
-		if atEOF {
+		if atEOF && len(data) == 0 {
 			return 0, nil, nil
 		}
 
-		// Read the entire input as one line by advancing the buffer to its end.
+		// Read the entire input as one line by advancing the buffer to its end. Data read together with
+		// the end of the input is returned too rather than dropped.
 		return len(data), data, nil
 	})
 
+	// during an outage every interval fails the same way, which is only logged every LogRepeatInterval
+	writeErrorsKey := "write " + outputURI.Redacted()
+	defer flushRepeatedErrors(writeErrorsKey)
+	var manifestSize int64
 	for scanner.Scan() {
 		b := scanner.Bytes()
-
-		inputFile, err = os.OpenFile(inputFileName, os.O_WRONLY|os.O_APPEND, 0644)
-		if err != nil {
-			return nil, fmt.Errorf("failed to open file: %w", err)
+		manifestSize += int64(len(b))
+		if opts.MaxManifestSize > 0 && manifestSize > opts.MaxManifestSize {
+			return nil, fmt.Errorf("%w: %s is larger than %d bytes", ErrManifestTooLarge, outputURI.Redacted(), opts.MaxManifestSize)
 		}
-		if _, err := inputFile.Write(b); err != nil {
+
+		if _, err := writer.Write(b); err != nil {
 			return nil, fmt.Errorf("failed to append to input file: %w", err)
 		}
-		if err := inputFile.Close(); err != nil {
-			return nil, fmt.Errorf("failed to close input file: %w", err)
-		}
 
 		// Only write the latest version of the data that's been piped in if enough time has elapsed since the last write
-		if lastWrite.Add(waitBetweenWrites).Before(time.Now()) {
-			if _, _, err := uploadFileWithBackup(outputURI, inputFileName, fields, writeTimeout, false, storageFallbackURLs); err != nil {
+		if lastWrite.Add(opts.WaitBetweenWrites).Before(time.Now()) {
+			if err := writer.Flush(); err != nil {
+				return nil, fmt.Errorf("failed to append to input file: %w", err)
+			}
+			if out, _, err := uploadFileWithBackup(outputURI, inputFileName, fields, opts.WriteTimeout, false, opts); err != nil {
 				// Just log this error, since it'll effectively be retried after the next interval
-				glog.Errorf("Failed to write: %v", err)
+				logRepeatedError(writeErrorsKey, opts, "Failed to write: %v", err)
 			} else {
+				flushRepeatedErrors(writeErrorsKey)
 				glog.V(5).Infof("Wrote %s to storage: %d bytes", outputURI.Redacted(), len(b))
+				writeManifestHistory(out.location(outputURI), inputFileName, opts)
+				purgeCDN(out.location(outputURI), opts)
+				opts.StreamState.manifestWritten(out.location(outputURI), opts)
 			}
 			lastWrite = time.Now()
 		}
@@ -134,78 +395,465 @@ func Upload(input io.Reader, outputURI *url.URL, waitBetweenWrites, writeTimeout
 	if err := scanner.Err(); err != nil {
 		return nil, err
 	}
+	if err := writer.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to append to input file: %w", err)
+	}
+	if err := inputFile.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close input file: %w", err)
+	}
 
 	// We have to do this final write, otherwise there might be final data that's arrived since the last periodic write
-	if _, _, err := uploadFileWithBackup(outputURI, inputFileName, fields, writeTimeout, false, storageFallbackURLs); err != nil {
+	return writeFinal(outputURI, inputFileName, opts)
+}
+
+// UploadFiles uploads files that are already on disk, as if they had been concatenated into Upload's input.
+// The files are complete, so manifests are written once rather than incrementally. They are deleted once
+// uploaded according to UploadOptions.DeleteInputs.
+func UploadFiles(fileNames []string, outputURI *url.URL, opts UploadOptions) (*UploadResult, error) {
+	if len(fileNames) == 0 {
+		return nil, errors.New("no input files")
+	}
+	for _, fileName := range fileNames {
+		if _, err := os.Stat(fileName); err != nil {
+			return nil, fmt.Errorf("failed to open input file: %w", err)
+		}
+	}
+	opts.fileInput = true
+	if len(fileNames) == 1 && !isTransmuxed(outputURI, opts) {
+		opts.sourceFile = fileNames[0]
+	}
+	inputFileName := fileNames[0]
+	if len(fileNames) > 1 {
+		inputFile, err := os.CreateTemp("", "upload-*"+filepath.Ext(outputURI.Path))
+		if err != nil {
+			return nil, fmt.Errorf("failed to write to temp file: %w", err)
+		}
+		inputFileName = inputFile.Name()
+		defer os.Remove(inputFileName)
+		err = concatFiles(inputFile, fileNames)
+		if closeErr := inputFile.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to close input file: %w", closeErr)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	upload := writeFinal
+	if isSegment(outputURI, opts) {
+		upload = uploadSegment
+	}
+	out, err := upload(outputURI, inputFileName, opts)
+	if err == nil && opts.DeleteInputs != nil && !out.Skipped && !out.AlreadyUploaded {
+		opts.DeleteInputs.schedule(fileNames)
+	}
+	return out, err
+}
+
+func concatFiles(w io.Writer, fileNames []string) error {
+	for _, fileName := range fileNames {
+		file, err := os.Open(fileName)
+		if err != nil {
+			return fmt.Errorf("failed to open input file: %w", err)
+		}
+		_, err = copyBuffered(w, file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to copy input file %s: %w", fileName, err)
+		}
+	}
+	return nil
+}
+
+func isSegment(outputURI *url.URL, opts UploadOptions) bool {
+	ext := filepath.Ext(outputURI.Path)
+	return ext == ".ts" || ext == ".mp4" || isTransmuxed(outputURI, opts)
+}
+
+// isTransmuxed reports whether the input for outputURI is an MPEG-TS segment to transmux, see UploadOptions.TransmuxTS
+func isTransmuxed(outputURI *url.URL, opts UploadOptions) bool {
+	return opts.TransmuxTS && filepath.Ext(outputURI.Path) == ".m4s"
+}
+
+// uploadJSON writes a small JSON object, such as a sidecar of an upload, to u
+func uploadJSON(u *url.URL, data []byte, opts UploadOptions) error {
+	file, err := os.CreateTemp("", "upload-*.json")
+	if err != nil {
+		return fmt.Errorf("temp file creation failed: %w", err)
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	_, _, err = uploadFileWithBackup(u, file.Name(), &drivers.FileProperties{ContentType: "application/json"}, 10*time.Second, true, opts)
+	return err
+}
+
+// manifestFileProperties gives manifests a very short cache ttl as the files are updating every few seconds
+func manifestFileProperties() *drivers.FileProperties {
+	return &drivers.FileProperties{CacheControl: "max-age=1"}
+}
+
+// uploadSegment writes a complete segment, retrying failures, and extracts its thumbnails
+func uploadSegment(outputURI *url.URL, fileName string, opts UploadOptions) (*UploadResult, error) {
+	if out, err := skipUpload(outputURI, fileName, true, opts); err != nil || out != nil {
+		return out, err
+	}
+	ext := filepath.Ext(outputURI.Path)
+	transmux := isTransmuxed(outputURI, opts)
+	if opts.ValidateSegments {
+		inputExt := ext
+		if transmux {
+			inputExt = ".ts"
+		}
+		if err := validateSegment(fileName, inputExt); err != nil {
+			return nil, fmt.Errorf("not uploading %s: %w", outputURI.Redacted(), err)
+		}
+	}
+	// thumbnails and waveforms are extracted from the input, which is complete on its own unlike transmuxed segments
+	inputFileName := fileName
+	if opts.Faststart && ext == ".mp4" {
+		var remove func()
+		fileName, remove = faststartInput(fileName)
+		defer remove()
+	}
+	if transmux {
+		dir, err := os.MkdirTemp("", "transmux-*")
+		if err != nil {
+			return nil, fmt.Errorf("temp dir creation failed: %w", err)
+		}
+		defer os.RemoveAll(dir)
+		initFileName, segmentFileName, err := transmuxTS(fileName, dir, opts.FFmpegLimiter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to transmux %s: %w", outputURI.Redacted(), err)
+		}
+		if err := uploadInitSegment(outputURI, initFileName, opts); err != nil {
+			return nil, err
+		}
+		fileName = segmentFileName
+	}
+	// only the segment is paced, not the files extracted from it
+	segmentOpts := opts
+	timeout := opts.SegmentTimeout
+	if opts.Pace == PaceRealtime || opts.SegmentTimeoutFactor > 0 {
+		duration, err := mediaDuration(inputFileName, ext, transmux, opts)
+		if err != nil {
+			glog.V(5).Infof("Duration of %s unknown, not pacing or deriving its timeout: %v", outputURI.Redacted(), err)
+		} else {
+			if opts.Pace == PaceRealtime {
+				segmentOpts.paceRate = segmentPaceRate(fileName, duration)
+			}
+			if opts.SegmentTimeoutFactor > 0 {
+				timeout = max(time.Duration(opts.SegmentTimeoutFactor*float64(duration)), minDerivedSegmentTimeout)
+			}
+		}
+	}
+	segmentOpts.multipartUploads = &multipartUploads{}
+	start := time.Now()
+	out, bytesWritten, err := uploadFileWithBackup(outputURI, fileName, withIdempotencyKey(withFileMetadata(nil, opts), opts), timeout, true, segmentOpts)
+	if err != nil {
+		notifyUpload(outputURI, fileName, nil, err, opts)
+		opts.Influx.recordUpload(outputURI, fileName, "segment", nil, err, time.Since(start), opts)
+		if !opts.KeepFailedUploads {
+			return nil, fmt.Errorf("failed to upload video %s: (%d bytes) %w; %s", outputURI.Redacted(), bytesWritten, err, cleanupFailedUpload(segmentOpts.multipartUploads))
+		}
+		return nil, fmt.Errorf("failed to upload video %s: (%d bytes) %w", outputURI.Redacted(), bytesWritten, err)
+	}
+	if opts.DeleteInputs != nil {
+		if err := verifyUpload(out.location(outputURI), fileName, opts); err != nil {
+			return nil, err
+		}
+	}
+	addToIndex(opts.Index, outputURI, fileName, out, time.Since(start))
+	addFileToQuota(opts.Quota, outputURI, fileName)
+	if err := writeDoneMarker(outputURI, fileName, out, opts); err != nil {
+		return nil, err
+	}
+	notifyUpload(outputURI, fileName, out, nil, opts)
+	opts.Influx.recordUpload(outputURI, fileName, "segment", out, nil, time.Since(start), opts)
+	opts.StreamState.segmentUploaded(out.location(outputURI), opts)
+
+	thumbWarnings, err := extractThumb(outputURI, inputFileName, opts)
+	out.Warnings = append(out.Warnings, thumbWarnings...)
+	if err != nil {
+		glog.Errorf("extracting thumbnail failed for %s: %v", outputURI.Redacted(), err)
+		out.addWarning(WarningThumbnailFailed, "extracting thumbnail failed: %v", err)
+	}
+	if opts.Waveform {
+		if err = uploadWaveform(outputURI, inputFileName, opts); err != nil {
+			glog.Errorf("generating waveform failed for %s: %v", outputURI.Redacted(), err)
+			out.addWarning(WarningWaveformFailed, "generating waveform failed: %v", err)
+		}
+	}
+	if opts.TimedMetadata && (ext == ".ts" || transmux) {
+		if err = uploadTimedMetadata(outputURI, inputFileName, opts); err != nil {
+			glog.Errorf("extracting timed metadata failed for %s: %v", outputURI.Redacted(), err)
+			out.addWarning(WarningTimedMetadataFailed, "extracting timed metadata failed: %v", err)
+		}
+	}
+	return out, nil
+}
+
+// mediaDuration returns the duration of a segment, UploadOptions.SegmentDuration if set or else read from the
+// input, which is a .ts segment if it's transmuxed
+func mediaDuration(inputFileName, ext string, transmux bool, opts UploadOptions) (time.Duration, error) {
+	if opts.SegmentDuration > 0 {
+		return opts.SegmentDuration, nil
+	}
+	if transmux {
+		ext = ".ts"
+	}
+	return segmentDuration(inputFileName, ext)
+}
+
+// segmentPaceRate returns the bitrate of a segment of the given duration in bytes per second
+func segmentPaceRate(fileName string, duration time.Duration) float64 {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return 0
+	}
+	return float64(info.Size()) / duration.Seconds()
+}
+
+// skipUpload returns the result of an upload that has nothing to write, because its input is empty and
+// skipped or because it was already uploaded with the same idempotency key, or an error if its input or its
+// destination is rejected
+func skipUpload(outputURI *url.URL, fileName string, segment bool, opts UploadOptions) (*UploadResult, error) {
+	if skip, err := checkInputSize(outputURI, fileName, segment, opts); err != nil {
+		return nil, err
+	} else if skip {
+		return &UploadResult{Skipped: true}, nil
+	}
+	if done, err := alreadyUploaded(outputURI, opts); err != nil {
+		return nil, err
+	} else if done {
+		return &UploadResult{AlreadyUploaded: true}, nil
+	}
+	if err := opts.Quota.check(outputURI); err != nil {
+		return nil, err
+	}
+	return nil, checkNoClobber(outputURI, opts)
+}
+
+// checkInputSize applies the empty input policy and, for segments, the minimum segment size. It returns true
+// if the upload is to be skipped.
+func checkInputSize(outputURI *url.URL, fileName string, segment bool, opts UploadOptions) (bool, error) {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return false, fmt.Errorf("failed to open input file: %w", err)
+	}
+	if info.Size() == 0 {
+		switch opts.EmptyInput {
+		case EmptyInputSkip:
+			glog.Infof("Not uploading empty input to %s", outputURI.Redacted())
+			return true, nil
+		case EmptyInputFail:
+			return false, fmt.Errorf("not uploading %s: %w", outputURI.Redacted(), ErrEmptyInput)
+		}
+		return false, nil
+	}
+	if segment && info.Size() < opts.MinSegmentSize {
+		return false, fmt.Errorf("not uploading %s: %w: %d bytes is below the minimum segment size of %d bytes", outputURI.Redacted(), ErrInvalidSegment, info.Size(), opts.MinSegmentSize)
+	}
+	return false, nil
+}
+
+// writeFinal writes the complete manifest
+func writeFinal(outputURI *url.URL, fileName string, opts UploadOptions) (*UploadResult, error) {
+	if out, err := skipUpload(outputURI, fileName, false, opts); err != nil || out != nil {
+		return out, err
+	}
+	start := time.Now()
+	out, _, err := uploadFileWithBackup(outputURI, fileName, withIdempotencyKey(withFileMetadata(manifestFileProperties(), opts), opts), opts.WriteTimeout, false, opts)
+	if err != nil {
+		notifyUpload(outputURI, fileName, nil, err, opts)
+		opts.Influx.recordUpload(outputURI, fileName, "manifest", nil, err, time.Since(start), opts)
 		// Don't ignore this error, since there won't be any further attempts to write
 		return nil, fmt.Errorf("failed to write final save: %w", err)
 	}
+	if opts.DeleteInputs != nil {
+		if err := verifyUpload(out.location(outputURI), fileName, opts); err != nil {
+			return nil, err
+		}
+	}
+	addToIndex(opts.Index, outputURI, fileName, out, time.Since(start))
+	addFileToQuota(opts.Quota, outputURI, fileName)
+	if err := writeDoneMarker(outputURI, fileName, out, opts); err != nil {
+		return nil, err
+	}
+	notifyUpload(outputURI, fileName, out, nil, opts)
+	opts.Influx.recordUpload(outputURI, fileName, "manifest", out, nil, time.Since(start), opts)
+	writeManifestHistory(out.location(outputURI), fileName, opts)
+	purgeCDN(out.location(outputURI), opts)
+	opts.StreamState.manifestWritten(out.location(outputURI), opts)
 	glog.Infof("Completed writing %s to storage", outputURI.Redacted())
-	return nil, nil
+	return out, nil
 }
 
-func uploadFileWithBackup(outputURI *url.URL, fileName string, fields *drivers.FileProperties, writeTimeout time.Duration, withRetries bool, storageFallbackURLs map[string]string) (out *drivers.SaveDataOutput, bytesWritten int64, err error) {
+func uploadFileWithBackup(outputURI *url.URL, fileName string, fields *drivers.FileProperties, writeTimeout time.Duration, withRetries bool, opts UploadOptions) (result *UploadResult, bytesWritten int64, err error) {
+	release, err := opts.UploadLimiter.acquire(outputURI, uploadPriority(outputURI, opts))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer release()
+	opts.attempts = &attemptHistory{}
+	opts.warnings = &uploadWarnings{}
+
 	retryPolicy := NoRetries()
 	if withRetries {
-		retryPolicy = UploadRetryBackoff()
+		retryPolicy = withRetryBudget(UploadRetryBackoff(), opts.RetryBudget, outputURI)
 	}
 	err = backoff.Retry(func() error {
-		var primaryErr error
-		out, bytesWritten, primaryErr = uploadFile(outputURI, fileName, fields, writeTimeout, withRetries)
+		if failure := opts.EndpointHealth.down(outputURI); failure != nil {
+			if backupURI, err := buildBackupURI(outputURI, opts.StorageFallbackURLs); err == nil {
+				glog.Warningf("Primary %s is down since %s, uploading to backupURL=%s primaryErr=%q", failure.Endpoint, failure.FailedAt.Format(time.RFC3339), backupURI.Redacted(), failure.Error)
+				out, written, err := uploadFile(backupURI, fileName, fields, writeTimeout, withRetries, opts)
+				bytesWritten = written
+				if err != nil {
+					return fmt.Errorf("upload file errors: primary: down since %s: %s; backup: %w", failure.FailedAt.Format(time.RFC3339), failure.Error, err)
+				}
+				result = &UploadResult{SaveDataOutput: *out, Fallback: true, BackupURI: backupURI}
+				return nil
+			}
+		}
+		if opts.HedgeDelay > 0 {
+			if backupURI, err := buildBackupURI(outputURI, opts.StorageFallbackURLs); err == nil {
+				result, bytesWritten, err = uploadHedged(outputURI, backupURI, fileName, fields, writeTimeout, withRetries, opts)
+				return err
+			}
+		}
+		out, written, primaryErr := uploadFile(outputURI, fileName, fields, writeTimeout, withRetries, opts)
+		bytesWritten = written
 		if primaryErr == nil {
+			opts.EndpointHealth.markUp(outputURI)
+			result = &UploadResult{SaveDataOutput: *out}
 			return nil
 		}
+		opts.EndpointHealth.markDown(outputURI, primaryErr)
 
-		backupURI, err := buildBackupURI(outputURI, storageFallbackURLs)
+		backupURI, err := buildBackupURI(outputURI, opts.StorageFallbackURLs)
 		if err != nil {
 			glog.Errorf("failed to build backup URL: %v", err)
 			return primaryErr
 		}
 		glog.Warningf("Primary upload failed, uploading to backupURL=%s primaryErr=%q", backupURI.Redacted(), primaryErr)
 
-		out, bytesWritten, err = uploadFile(backupURI, fileName, fields, writeTimeout, withRetries)
+		out, bytesWritten, err = uploadFile(backupURI, fileName, fields, writeTimeout, withRetries, opts)
 		if err == nil {
+			result = &UploadResult{SaveDataOutput: *out, Fallback: true, BackupURI: backupURI}
 			return nil
 		}
 		return fmt.Errorf("upload file errors: primary: %w; backup: %w", primaryErr, err)
 	}, retryPolicy)
-	return out, bytesWritten, err
+	if result != nil {
+		if result.Fallback {
+			opts.warnings.add(WarningFallback, "uploaded to the backup %s instead of %s", RedactedURI(result.BackupURI), RedactedURI(outputURI))
+		}
+		result.Warnings = opts.warnings.list()
+	}
+	return result, bytesWritten, opts.attempts.wrap(err)
+}
+
+// HasBackup reports whether uploads to outputURI fall back to a backup of opts.StorageFallbackURLs
+func HasBackup(outputURI *url.URL, opts UploadOptions) bool {
+	_, err := buildBackupURI(outputURI, opts.StorageFallbackURLs)
+	return err == nil
 }
 
 func buildBackupURI(outputURI *url.URL, storageFallbackURLs map[string]string) (*url.URL, error) {
-	outputURIStr := outputURI.String()
+	outputURIStr := uriString(outputURI)
 	for primary, backup := range storageFallbackURLs {
 		if strings.HasPrefix(outputURIStr, primary) {
 			backupStr := strings.Replace(outputURIStr, primary, backup, 1)
-			return url.Parse(backupStr)
+			return ParseOutputURI(backupStr)
 		}
 	}
 	return nil, fmt.Errorf("no backup URL found for %s", outputURI.Redacted())
 }
 
-func uploadFile(outputURI *url.URL, fileName string, fields *drivers.FileProperties, writeTimeout time.Duration, withRetries bool) (out *drivers.SaveDataOutput, bytesWritten int64, err error) {
-	outputStr := outputURI.String()
-	// While we wait for storj to implement an easier method for global object deletion we are hacking something
-	// here to allow us to have recording objects deleted after 7 days.
-	if strings.Contains(outputStr, "gateway.storjshare.io/catalyst-recordings-com") {
-		var storjFields drivers.FileProperties
+func uploadFile(outputURI *url.URL, fileName string, fields *drivers.FileProperties, writeTimeout time.Duration, withRetries bool, opts UploadOptions) (out *drivers.SaveDataOutput, bytesWritten int64, err error) {
+	fields = withCorrelationIDs(applyHeaderRules(outputURI, fields, opts.HeaderRules), opts.CorrelationIDs)
+	if opts.Destination.CacheControl != "" {
+		var cacheFields drivers.FileProperties
 		if fields != nil {
-			storjFields = *fields
+			cacheFields = *fields
+		}
+		cacheFields.CacheControl = opts.Destination.CacheControl
+		fields = &cacheFields
+		if !supportsCacheControl(outputURI) {
+			opts.warnings.add(WarningCacheControlUnsupported, "Cache-Control isn't supported for %s destinations, uploaded %s without it", outputURI.Scheme, RedactedURI(outputURI))
 		}
-		storjFields.Metadata = expiryField
-		fields = &storjFields
 	}
 
-	driver, err := drivers.ParseOSURL(outputStr, true)
-	if err != nil {
-		return nil, 0, err
+	ctx := opts.ctx
+	if ctx == nil {
+		ctx = context.Background()
 	}
-	session := driver.NewSession("")
-
 	retryPolicy := NoRetries()
 	if withRetries {
-		retryPolicy = SingleRequestRetryBackoff()
+		retryPolicy = withRetryBudget(SingleRequestRetryBackoff(), opts.RetryBudget, outputURI)
+	}
+	retryPolicy = backoff.WithContext(retryPolicy, ctx)
+
+	var size int64
+	if info, err := os.Stat(fileName); err == nil {
+		size = info.Size()
 	}
+
+	if concurrency, partSize, ok := s3UploadTuning(outputURI, opts); ok && opts.Record == nil && opts.Replay == nil {
+		dest, err := parseS3URL(outputURI)
+		if err != nil {
+			return nil, 0, err
+		}
+		dest.storageClass = opts.Destination.StorageClass
+		dest.contentDisposition = contentDisposition(outputURI, opts)
+		dest.multipartUploads = opts.multipartUploads
+		sess, err := dest.newSession()
+		if err != nil {
+			return nil, 0, err
+		}
+		var rangeSplitSize int64
+		if opts.fileInput && !opts.LowMemory {
+			rangeSplitSize = opts.RangeSplitSize
+		}
+		attempt := 0
+		err = backoff.Retry(func() error {
+			if opts.FaultInjection != nil {
+				if err := opts.FaultInjection.inject(ctx, "SaveData"); err != nil {
+					opts.attempts.add(outputURI, err, 0)
+					return err
+				}
+			}
+			attempt++
+			progress := newProgressLogger(outputURI.Redacted(), size, opts.fileInput)
+			progress.onRead = opts.onProgress
+			defer progress.heartbeat(opts.HeartbeatInterval, attempt)()
+			defer progress.bar(opts.ProgressBar)()
+			sess := sess
+			if opts.paceRate > 0 {
+				// paced per attempt, so that retries start afresh
+				sess = pacedSession(sess, opts.paceRate)
+			}
+			out, bytesWritten, err = uploadS3File(ctx, sess, dest, fileName, fields, writeTimeout, concurrency, partSize, rangeSplitSize, progress)
+			if err != nil {
+				glog.Errorf("failed upload attempt for %s: %v%s", outputURI.Redacted(), err, opts.LogFields())
+				opts.attempts.add(outputURI, err, progress.read.Load())
+			}
+			return unreachablePermanent(err)
+		}, retryPolicy)
+		return out, bytesWritten, err
+	}
+
+	session, err := newSession(outputURI, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	attempt := 0
 	err = backoff.Retry(func() error {
 		file, err := os.Open(fileName)
 		if err != nil {
@@ -215,28 +863,120 @@ func uploadFile(outputURI *url.URL, fileName string, fields *drivers.FilePropert
 
 		// To count how many bytes we are trying to read then write (upload) to s3 storage
 		byteCounter := &ByteCounter{}
-		teeReader := io.TeeReader(file, byteCounter)
+		attempt++
+		progress := newProgressLogger(outputURI.Redacted(), size, opts.fileInput)
+		progress.onRead = opts.onProgress
+		defer progress.heartbeat(opts.HeartbeatInterval, attempt)()
+		defer progress.bar(opts.ProgressBar)()
+		var input io.Reader = &progressReader{Reader: io.TeeReader(file, byteCounter), progress: progress}
+		if opts.paceRate > 0 {
+			input = &pacedReader{Reader: input, pacer: newPacer(opts.paceRate)}
+		}
 
-		out, err = session.SaveData(context.Background(), "", teeReader, fields, writeTimeout)
+		out, err = session.SaveData(ctx, "", input, fields, writeTimeout)
 		bytesWritten = byteCounter.Count
 
 		if err != nil {
-			glog.Errorf("failed upload attempt for %s (%d bytes): %v", outputURI.Redacted(), bytesWritten, err)
+			glog.Errorf("failed upload attempt for %s (%d bytes): %v%s", outputURI.Redacted(), bytesWritten, err, opts.LogFields())
+			opts.attempts.add(outputURI, err, bytesWritten)
 		}
-		return err
+		return unreachablePermanent(err)
 	}, retryPolicy)
+	if err != nil {
+		return out, bytesWritten, err
+	}
+
+	if disposition := contentDisposition(outputURI, opts); disposition != "" {
+		// the drivers can't set the content disposition, so GCS objects are patched once uploaded
+		if outputURI.Scheme == "gs" && opts.Replay == nil {
+			ctx, cancel := context.WithTimeout(ctx, defaultSaveTimeout)
+			defer cancel()
+			if err := updateGCSMetadata(ctx, outputURI, MetadataUpdate{ContentDisposition: disposition}); err != nil {
+				return out, bytesWritten, fmt.Errorf("failed to set content disposition: %w", err)
+			}
+		} else {
+			glog.Warningf("Content-Disposition isn't supported for %s destinations, uploaded %s without it", outputURI.Scheme, outputURI.Redacted())
+			opts.warnings.add(WarningContentDispositionUnsupported, "Content-Disposition isn't supported for %s destinations, uploaded %s without it", outputURI.Scheme, RedactedURI(outputURI))
+		}
+	}
+	return out, bytesWritten, nil
+}
 
-	return out, bytesWritten, err
+// s3UploadTuning returns the multipart settings for destinations where the drivers' defaults don't fit.
+// Those are uploaded with uploadS3File instead of through the drivers. Settings from the destination's
+// options take precedence.
+func s3UploadTuning(outputURI *url.URL, opts UploadOptions) (concurrency int, partSize int64, ok bool) {
+	switch {
+	case opts.LowMemory && (isS3URL(outputURI) || isSpacesURL(outputURI)):
+		concurrency, partSize, ok = 1, lowMemoryPartSize, true
+	case isSpacesURL(outputURI):
+		concurrency, partSize, ok = spacesConcurrency, spacesPartSize, true
+	case opts.fileInput && isS3URL(outputURI):
+		// the file can be read in parts straight from disk instead of being buffered by the drivers
+		concurrency, partSize, ok = fileInputConcurrency, fileInputPartSize, true
+	case (opts.Destination.s3Tuned() || contentDisposition(outputURI, opts) != "" || opts.paceRate > 0) && isS3URL(outputURI):
+		// the drivers can't set the content disposition, nor pace what they send
+		concurrency, partSize, ok = s3manager.DefaultUploadConcurrency, s3manager.DefaultUploadPartSize, true
+	}
+	if ok && opts.Destination.Concurrency > 0 {
+		concurrency = opts.Destination.Concurrency
+	}
+	if ok && opts.Destination.PartSize > 0 {
+		partSize = opts.Destination.PartSize
+	}
+	return concurrency, partSize, ok
 }
 
-func extractThumb(outputURI *url.URL, segmentFileName string, storageFallbackURLs map[string]string, disableThumbs []string, thumbsURLReplacement map[string]string) error {
-	for _, playbackID := range disableThumbs {
+// thumbArgs are the ffmpeg arguments writing the first frame of a segment as a thumbnail, decoded with the
+// hwaccel method and device if set
+func thumbArgs(segmentFileName, outFile, hwaccel, hwaccelDevice string) []string {
+	var args []string
+	if hwaccel != "" {
+		// decoded frames are downloaded to system memory for the scale filter
+		args = append(args, "-hwaccel", hwaccel)
+		if hwaccelDevice != "" {
+			args = append(args, "-hwaccel_device", hwaccelDevice)
+		}
+	}
+	return append(args,
+		"-i", segmentFileName,
+		"-ss", "00:00:00",
+		"-vframes", "1",
+		"-vf", "scale=426:240:force_original_aspect_ratio=decrease",
+		"-y",
+		outFile,
+	)
+}
+
+func runThumbFFmpeg(args []string, opts UploadOptions) error {
+	release, err := opts.FFmpegLimiter.acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+	timeout, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(timeout, "ffmpeg", args...)
+
+	var outputBuf bytes.Buffer
+	var stdErr bytes.Buffer
+	cmd.Stdout = &outputBuf
+	cmd.Stderr = &stdErr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg failed[%s] [%s]: %w", outputBuf.String(), stdErr.String(), err)
+	}
+	return nil
+}
+
+func extractThumb(outputURI *url.URL, segmentFileName string, opts UploadOptions) ([]UploadWarning, error) {
+	for _, playbackID := range opts.DisableThumbs {
 		if strings.Contains(outputURI.Path, playbackID) {
 			glog.Infof("Thumbnails disabled for %s", outputURI.Redacted())
-			return nil
+			return nil, nil
 		}
 	}
-	for playbackIDs, replacement := range thumbsURLReplacement {
+	for playbackIDs, replacement := range opts.ThumbsURLReplacement {
 		for _, playbackID := range strings.Split(playbackIDs, " ") {
 			if strings.Contains(outputURI.Path, playbackID) {
 				outputURIStr := outputURI.String()
@@ -248,7 +988,7 @@ func extractThumb(outputURI *url.URL, segmentFileName string, storageFallbackURL
 
 				newURI, err := url.Parse(strings.Replace(outputURIStr, original, replaceWith, 1))
 				if err != nil {
-					return fmt.Errorf("failed to parse thumbnail URL: %w", err)
+					return nil, fmt.Errorf("failed to parse thumbnail URL: %w", err)
 				}
 				outputURI = newURI
 				glog.Infof("Replaced thumbnail location for %s", outputURI.Redacted())
@@ -259,48 +999,62 @@ func extractThumb(outputURI *url.URL, segmentFileName string, storageFallbackURL
 
 	tmpDir, err := os.MkdirTemp(os.TempDir(), "thumb-*")
 	if err != nil {
-		return fmt.Errorf("temp file creation failed: %w", err)
+		return nil, fmt.Errorf("temp file creation failed: %w", err)
 	}
 	defer os.RemoveAll(tmpDir)
 	outFile := filepath.Join(tmpDir, "out.png")
 
-	args := []string{
-		"-i", segmentFileName,
-		"-ss", "00:00:00",
-		"-vframes", "1",
-		"-vf", "scale=426:240:force_original_aspect_ratio=decrease",
-		"-y",
-		outFile,
+	if ffmpegInstalled() {
+		err = runThumbFFmpeg(thumbArgs(segmentFileName, outFile, opts.ThumbsHWAccel, opts.ThumbsHWAccelDevice), opts)
+		if err != nil && opts.ThumbsHWAccel != "" {
+			glog.Warningf("Thumbnail extraction with -hwaccel %s failed, retrying on the CPU: %v", opts.ThumbsHWAccel, err)
+			err = runThumbFFmpeg(thumbArgs(segmentFileName, outFile, "", ""), opts)
+		}
+	} else {
+		ffmpegMissingWarning.Do(func() {
+			glog.Warningf("ffmpeg isn't installed, thumbnails are only extracted from segments with JPEG keyframes")
+		})
+		err = errors.New("ffmpeg isn't installed")
 	}
-
-	timeout, cancel := context.WithTimeout(context.Background(), 8*time.Second)
-	defer cancel()
-	cmd := exec.CommandContext(timeout, "ffmpeg", args...)
-
-	var outputBuf bytes.Buffer
-	var stdErr bytes.Buffer
-	cmd.Stdout = &outputBuf
-	cmd.Stderr = &stdErr
-
-	err = cmd.Run()
 	if err != nil {
-		return fmt.Errorf("ffmpeg failed[%s] [%s]: %w", outputBuf.String(), stdErr.String(), err)
+		if nativeErr := nativeThumb(segmentFileName, outFile); nativeErr != nil {
+			return nil, fmt.Errorf("%w, and the native extraction failed: %w", err, nativeErr)
+		}
+		glog.Infof("Extracted the thumbnail of %s without ffmpeg: %v", outputURI.Redacted(), err)
 	}
 
 	// two thumbs, one at session level, the other at stream level
 	thumbURLs := []*url.URL{outputURI.JoinPath("../latest.png"), outputURI.JoinPath("../../../latest.png")}
 	fields := &drivers.FileProperties{CacheControl: "max-age=5"}
+	// the settings of the segment don't carry over to its thumbnail, only the rules for PNG files do
+	thumbOpts := opts
+	thumbOpts.Destination.CacheControl = ""
+	thumbOpts.ContentDisposition = ""
+	thumbOpts.HeaderRules = nil
+	for _, rule := range opts.HeaderRules {
+		if len(rule.Extensions) > 0 {
+			thumbOpts.HeaderRules = append(thumbOpts.HeaderRules, rule)
+		}
+	}
+	warnings := &uploadWarnings{}
 	errGroup := &errgroup.Group{}
+	if opts.LowMemory {
+		errGroup.SetLimit(1)
+	}
 
 	for _, thumbURL := range thumbURLs {
 		thumbURL := thumbURL
 		errGroup.Go(func() error {
-			_, _, err = uploadFileWithBackup(thumbURL, outFile, fields, 10*time.Second, true, storageFallbackURLs)
+			out, _, err := uploadFileWithBackup(thumbURL, outFile, fields, 10*time.Second, true, thumbOpts)
 			if err != nil {
 				return fmt.Errorf("saving thumbnail failed: %w", err)
 			}
+			for _, warning := range out.Warnings {
+				warnings.add(warning.Code, "%s", warning.Message)
+			}
 			return nil
 		})
 	}
-	return errGroup.Wait()
+	err = errGroup.Wait()
+	return warnings.list(), err
 }
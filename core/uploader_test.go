@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/stretchr/testify/require"
@@ -34,9 +35,13 @@ func TestItWritesSlowInputIncrementally(t *testing.T) {
 
 	// Kick off the upload in a goroutine so that we can check the file is incrementally written
 	go func() {
-		u, err := url.Parse(outputFile.Name())
+		u, err := ParseOutputURI(outputFile.Name())
 		require.NoError(t, err)
-		_, err = Upload(slowReader, u, 100*time.Millisecond, time.Second, nil, time.Minute, nil, nil)
+		_, err = Upload(slowReader, u, UploadOptions{
+			WaitBetweenWrites: 100 * time.Millisecond,
+			WriteTimeout:      time.Second,
+			SegmentTimeout:    time.Minute,
+		})
 		require.NoError(t, err, "")
 	}()
 
@@ -62,6 +67,49 @@ func TestItWritesSlowInputIncrementally(t *testing.T) {
 	require.Equal(t, 0, len(expectedLines), "Expected to have received each manifest line sequentially")
 }
 
+func TestItWritesChattyInputCompletely(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "index.m3u8")
+	manifest := "#EXTM3U\n" + strings.Repeat("#EXTINF:2.000,\nindex_1_8779957.ts\n", 1000)
+	u, err := ParseOutputURI(output)
+	require.NoError(t, err)
+	// one byte per read, all of it buffered between writes
+	_, err = Upload(iotest.OneByteReader(strings.NewReader(manifest)), u, UploadOptions{
+		WaitBetweenWrites: time.Hour,
+		WriteTimeout:      time.Second,
+	})
+	require.NoError(t, err)
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+	require.Equal(t, manifest, string(data))
+}
+
+func TestItWritesDataReadWithEOF(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "index.m3u8")
+	manifest := "#EXTM3U\n#EXTINF:2.000,\n0.ts\n"
+	u, err := ParseOutputURI(output)
+	require.NoError(t, err)
+	_, err = Upload(iotest.DataErrReader(strings.NewReader(manifest)), u, UploadOptions{WriteTimeout: time.Second})
+	require.NoError(t, err)
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+	require.Equal(t, manifest, string(data))
+}
+
+func TestManifestSizes(t *testing.T) {
+	manifest := "#EXTM3U\n" + strings.Repeat("#EXTINF:2.000,\nindex_1_8779957.ts\n", 10000)
+	output := filepath.Join(t.TempDir(), "index.m3u8")
+	u, err := ParseOutputURI(output)
+	require.NoError(t, err)
+	_, err = Upload(strings.NewReader(manifest), u, UploadOptions{WaitBetweenWrites: time.Hour, WriteTimeout: time.Second, ManifestBufferSize: 1024})
+	require.NoError(t, err)
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+	require.Equal(t, manifest, string(data))
+
+	_, err = Upload(strings.NewReader(manifest), u, UploadOptions{WriteTimeout: time.Second, MaxManifestSize: 1000})
+	require.ErrorIs(t, err, ErrManifestTooLarge)
+}
+
 func TestUploadFileWithBackup(t *testing.T) {
 	dir, err := os.MkdirTemp(os.TempDir(), "TestUploadFileWithBackup-*")
 	require.NoError(t, err)
@@ -71,17 +119,19 @@ func TestUploadFileWithBackup(t *testing.T) {
 	require.NoError(t, os.WriteFile(testFile, []byte("test"), 0644))
 
 	fakeStorage := "s3+https://fake.service.livepeer.com/bucket/"
-	backupStorage := filepath.Join(dir, "backup") + "/"
+	backupStorage := filepath.ToSlash(filepath.Join(dir, "backup")) + "/"
 	fakeOutput := fakeStorage + "hls/123/file.txt"
 	expectedOutFile := backupStorage + "hls/123/file.txt"
 
 	storageFallbackURLs := map[string]string{
 		fakeStorage: "file://" + backupStorage,
 	}
-	out, written, err := uploadFileWithBackup(mustParseURL(fakeOutput), testFile, nil, 0, false, storageFallbackURLs)
+	out, written, err := uploadFileWithBackup(mustParseURL(fakeOutput), testFile, nil, 0, false, UploadOptions{StorageFallbackURLs: storageFallbackURLs})
 	require.NoError(t, err)
 	require.Equal(t, expectedOutFile, out.URL)
 	require.Equal(t, int64(4), written)
+	require.True(t, out.Fallback)
+	require.Equal(t, expectedOutFile, out.BackupURI.Path)
 
 	b, err := os.ReadFile(expectedOutFile)
 	require.NoError(t, err)
@@ -153,9 +203,86 @@ func (sr *SlowReader) Read(b []byte) (n int, err error) {
 }
 
 func mustParseURL(s string) *url.URL {
-	u, err := url.Parse(s)
+	u, err := ParseOutputURI(s)
 	if err != nil {
 		panic(err)
 	}
 	return u
 }
+
+func TestUploadFiles(t *testing.T) {
+	dir := t.TempDir()
+	segment := filepath.Join(dir, "in.ts")
+	require.NoError(t, os.WriteFile(segment, []byte("segment"), 0644))
+	manifestParts := []string{filepath.Join(dir, "a.m3u8"), filepath.Join(dir, "b.m3u8")}
+	require.NoError(t, os.WriteFile(manifestParts[0], []byte("#EXTM3U\n"), 0644))
+	require.NoError(t, os.WriteFile(manifestParts[1], []byte("#EXT-X-VERSION:3\n"), 0644))
+
+	outputFile := filepath.Join(dir, "out", "0.ts")
+	_, err := UploadFiles([]string{segment}, mustParseURL(outputFile), UploadOptions{SegmentTimeout: time.Second, DisableThumbs: []string{"out"}})
+	require.NoError(t, err)
+	data, err := os.ReadFile(outputFile)
+	require.NoError(t, err)
+	require.Equal(t, []byte("segment"), data)
+	// the input file is left alone
+	_, err = os.Stat(segment)
+	require.NoError(t, err)
+
+	outputFile = filepath.Join(dir, "out", "index.m3u8")
+	_, err = UploadFiles(manifestParts, mustParseURL(outputFile), UploadOptions{WriteTimeout: time.Second})
+	require.NoError(t, err)
+	data, err = os.ReadFile(outputFile)
+	require.NoError(t, err)
+	require.Equal(t, []byte("#EXTM3U\n#EXT-X-VERSION:3\n"), data)
+
+	_, err = UploadFiles([]string{filepath.Join(dir, "missing.ts"), segment}, mustParseURL(outputFile), UploadOptions{})
+	require.ErrorContains(t, err, "failed to open input file")
+}
+
+func TestEmptyInput(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"0.ts", "index.m3u8"} {
+		outFile := filepath.Join(dir, name)
+		u := mustParseURL(filepath.ToSlash(outFile))
+		opts := UploadOptions{DisableThumbs: []string{filepath.ToSlash(dir)}}
+
+		opts.EmptyInput = EmptyInputFail
+		_, err := Upload(strings.NewReader(""), u, opts)
+		require.ErrorIs(t, err, ErrEmptyInput)
+		require.NoFileExists(t, outFile)
+
+		opts.EmptyInput = EmptyInputSkip
+		out, err := Upload(strings.NewReader(""), u, opts)
+		require.NoError(t, err)
+		require.True(t, out.Skipped)
+		require.NoFileExists(t, outFile)
+
+		opts.EmptyInput = ""
+		out, err = Upload(strings.NewReader(""), u, opts)
+		require.NoError(t, err)
+		require.False(t, out.Skipped)
+		require.FileExists(t, outFile)
+	}
+}
+
+func TestMinSegmentSize(t *testing.T) {
+	dir := t.TempDir()
+	opts := UploadOptions{MinSegmentSize: 100, DisableThumbs: []string{filepath.ToSlash(dir)}}
+	_, err := Upload(strings.NewReader("short"), mustParseURL(filepath.ToSlash(filepath.Join(dir, "0.ts"))), opts)
+	require.ErrorIs(t, err, ErrInvalidSegment)
+	require.NoFileExists(t, filepath.Join(dir, "0.ts"))
+
+	// manifests aren't segments
+	_, err = Upload(strings.NewReader("#EXTM3U"), mustParseURL(filepath.ToSlash(filepath.Join(dir, "index.m3u8"))), opts)
+	require.NoError(t, err)
+}
+
+func TestThumbArgs(t *testing.T) {
+	require.Equal(t,
+		[]string{"-i", "seg.ts", "-ss", "00:00:00", "-vframes", "1", "-vf", "scale=426:240:force_original_aspect_ratio=decrease", "-y", "out.png"},
+		thumbArgs("seg.ts", "out.png", "", ""))
+	require.Equal(t,
+		[]string{"-hwaccel", "vaapi", "-hwaccel_device", "/dev/dri/renderD128", "-i", "seg.ts", "-ss", "00:00:00", "-vframes", "1", "-vf", "scale=426:240:force_original_aspect_ratio=decrease", "-y", "out.png"},
+		thumbArgs("seg.ts", "out.png", "vaapi", "/dev/dri/renderD128"))
+	require.Equal(t, []string{"-hwaccel", "cuda", "-i", "seg.ts"}, thumbArgs("seg.ts", "out.png", "cuda", "")[:4])
+}
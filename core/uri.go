@@ -0,0 +1,235 @@
+package core
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var windowsDrivePath = regexp.MustCompile(`^[a-zA-Z]:[\\/]`)
+
+// ParseOutputURI parses a destination as given on the command line. On top of url.Parse
+// it understands Windows paths with a drive letter (C:\foo\bar.ts, file:///C:/foo/bar.ts),
+// which url.Parse would otherwise treat as a URL with a single-letter scheme. File system
+// paths, with or without the file:// scheme, are made absolute, expanding a leading ~ to
+// the home directory and resolving relative paths against the working directory.
+func ParseOutputURI(s string) (*url.URL, error) {
+	for _, prefix := range []string{"file:///", "file://"} {
+		if p := strings.TrimPrefix(s, prefix); p != s && windowsDrivePath.MatchString(p) {
+			s = p
+			break
+		}
+	}
+	if windowsDrivePath.MatchString(s) {
+		return &url.URL{Path: strings.ReplaceAll(s, `\`, "/")}, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case u.Scheme == "" && u.Host == "":
+		return absFileURI(u, u.Path)
+	case u.Scheme == "file" && u.Opaque != "":
+		// file:relative/path and file:~/path
+		return absFileURI(u, u.Opaque)
+	case u.Scheme == "file" && (u.Host == "~" || u.Host == "." || u.Host == ".."):
+		// file://~/path and file://./relative/path
+		return absFileURI(u, u.Host+u.Path)
+	case u.Scheme == "file" && (u.Host == "" || u.Host == "localhost"):
+		return absFileURI(u, u.Path)
+	}
+	return u, nil
+}
+
+// absFileURI returns u with its path replaced by p made absolute
+func absFileURI(u *url.URL, p string) (*url.URL, error) {
+	if p == "" {
+		return u, nil
+	}
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand ~: %w", err)
+		}
+		p = filepath.ToSlash(home) + p[1:]
+	}
+	abs, err := filepath.Abs(filepath.FromSlash(p))
+	if err != nil {
+		return nil, err
+	}
+	abs = filepath.ToSlash(abs)
+	if strings.HasSuffix(p, "/") && !strings.HasSuffix(abs, "/") {
+		abs += "/"
+	}
+	if windowsDrivePath.MatchString(abs) {
+		return &url.URL{Path: abs}, nil
+	}
+	fileURI := *u
+	fileURI.Opaque, fileURI.Host, fileURI.Path, fileURI.RawPath = "", "", abs, ""
+	return &fileURI, nil
+}
+
+// uriString is the inverse of ParseOutputURI. url.URL.String() would prefix Windows paths
+// with "./", which breaks prefix matching against configured storage URLs.
+func uriString(u *url.URL) string {
+	if isWindowsPathURI(u) {
+		return u.Path
+	}
+	return u.String()
+}
+
+// RedactedURI is url.URL.Redacted() with the same Windows path handling as uriString
+func RedactedURI(u *url.URL) string {
+	if isWindowsPathURI(u) {
+		return u.Path
+	}
+	return u.Redacted()
+}
+
+func isWindowsPathURI(u *url.URL) bool {
+	return u.Scheme == "" && u.Host == "" && windowsDrivePath.MatchString(u.Path)
+}
+
+// PublicURL maps the location of an upload to the URL it is served from, e.g. a CDN hostname in front
+// of the bucket. publicBaseURLs maps prefixes of the location (without credentials) to the public prefix
+// that replaces them, the longest matching prefix wins. Returns false if no prefix matches.
+func PublicURL(u *url.URL, publicBaseURLs map[string]string) (string, bool) {
+	location := locationString(u)
+	prefix, ok := longestPrefix(location, publicBaseURLs)
+	if !ok {
+		return "", false
+	}
+	return publicBaseURLs[prefix] + strings.TrimPrefix(location, prefix), true
+}
+
+// locationString is uriString without the credentials, for matching against configured prefixes
+func locationString(u *url.URL) string {
+	location := *u
+	location.User = nil
+	return uriString(&location)
+}
+
+// longestPrefix returns the longest key of m that is a prefix of s
+func longestPrefix[V any](s string, m map[string]V) (string, bool) {
+	var match string
+	for prefix := range m {
+		if strings.HasPrefix(s, prefix) && len(prefix) > len(match) {
+			match = prefix
+		}
+	}
+	return match, match != ""
+}
+
+// destinationTemplateFields are the placeholders ExpandDestinationTemplate replaces
+var destinationTemplateFields = []string{"{basename}", "{name}", "{ext}", "{index}"}
+
+// IsDestinationTemplate reports whether a destination contains placeholders for the input file, see ExpandDestinationTemplate
+func IsDestinationTemplate(s string) bool {
+	for _, field := range destinationTemplateFields {
+		if strings.Contains(s, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpandDestinationTemplate returns the destination of the index-th input file. {basename} is replaced with
+// the file name, {name} with the file name without extension, {ext} with the extension including the dot and
+// {index} with the position of the file in the inputs, starting at 0.
+func ExpandDestinationTemplate(template, fileName string, index int) string {
+	basename := filepath.Base(fileName)
+	ext := filepath.Ext(basename)
+	return strings.NewReplacer(
+		"{basename}", basename,
+		"{name}", strings.TrimSuffix(basename, ext),
+		"{ext}", ext,
+		"{index}", strconv.Itoa(index),
+	).Replace(template)
+}
+
+// timeTokens are the strftime-style tokens ExpandTimeTokens replaces. Tokens that could be the hex digits of
+// percent-encoded characters, such as %D or %F, aren't supported.
+var timeTokens = regexp.MustCompile(`%[YymdjHMS]`)
+
+// ExpandTimeTokens replaces strftime-style tokens in the path of a destination with the fields of t, so that
+// e.g. recordings are bucketed by date: %Y is the year, %y the year without the century, %m the month, %d the
+// day of the month, %j the day of the year, %H the hour, %M the minute and %S the second, all zero padded. The
+// credentials, host and query of URLs are left alone.
+func ExpandTimeTokens(destination string, t time.Time) string {
+	pathStart := 0
+	if _, rest, ok := strings.Cut(destination, "://"); ok {
+		pathStart = len(destination) - len(rest)
+		if slash := strings.Index(rest, "/"); slash >= 0 {
+			pathStart += slash
+		} else {
+			pathStart = len(destination)
+		}
+	}
+	pathEnd := len(destination)
+	if query := strings.Index(destination[pathStart:], "?"); query >= 0 {
+		pathEnd = pathStart + query
+	}
+	path := timeTokens.ReplaceAllStringFunc(destination[pathStart:pathEnd], func(token string) string {
+		switch token[1] {
+		case 'Y':
+			return fmt.Sprintf("%04d", t.Year())
+		case 'y':
+			return fmt.Sprintf("%02d", t.Year()%100)
+		case 'm':
+			return fmt.Sprintf("%02d", int(t.Month()))
+		case 'd':
+			return fmt.Sprintf("%02d", t.Day())
+		case 'j':
+			return fmt.Sprintf("%03d", t.YearDay())
+		case 'H':
+			return fmt.Sprintf("%02d", t.Hour())
+		case 'M':
+			return fmt.Sprintf("%02d", t.Minute())
+		}
+		return fmt.Sprintf("%02d", t.Second())
+	})
+	return destination[:pathStart] + path + destination[pathEnd:]
+}
+
+var envVarReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// ExpandEnv replaces ${VAR} references in a destination with the value of the environment variable, so that
+// templated destinations don't need to embed secrets. Values expanded into the credentials of a URL are escaped,
+// keeping secrets that contain e.g. "/" intact. Referencing an unset variable is an error.
+func ExpandEnv(s string) (string, error) {
+	userinfoEnd := -1
+	if _, rest, ok := strings.Cut(s, "://"); ok {
+		authority, _, _ := strings.Cut(rest, "/")
+		if at := strings.LastIndex(authority, "@"); at >= 0 {
+			userinfoEnd = len(s) - len(rest) + at
+		}
+	}
+	var expanded strings.Builder
+	last := 0
+	for _, match := range envVarReference.FindAllStringSubmatchIndex(s, -1) {
+		name := s[match[2]:match[3]]
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		if match[0] < userinfoEnd {
+			value = escapeUserinfo(value)
+		}
+		expanded.WriteString(s[last:match[0]])
+		expanded.WriteString(value)
+		last = match[1]
+	}
+	expanded.WriteString(s[last:])
+	return expanded.String(), nil
+}
+
+// escapeUserinfo escapes a value for the credentials part of a URL
+func escapeUserinfo(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
@@ -0,0 +1,295 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrInvalidSegment is returned when a segment fails validation, see UploadOptions.ValidateSegments
+var ErrInvalidSegment = errors.New("invalid segment")
+
+const tsPacketSize = 188
+
+// validateSegment checks that a .ts or .mp4 segment is complete, so that truncated segments can be requested
+// again instead of being archived. Other extensions aren't checked.
+func validateSegment(fileName, ext string) error {
+	var err error
+	switch ext {
+	case ".ts":
+		err = validateTS(fileName)
+	case ".mp4":
+		err = validateMP4(fileName)
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSegment, err)
+	}
+	return nil
+}
+
+// pesState tracks the PES packet being read on an elementary stream PID
+type pesState struct {
+	// expected is the size of the PES packet including its header, 0 if the header doesn't give it
+	expected int
+	read     int
+}
+
+// validateTS checks that an MPEG-TS segment is a whole number of packets, has a PAT and the PMTs it points to
+// with valid CRCs, and that the last PES packet of each elementary stream is complete where its length is
+// known. PSI sections are expected to fit in one packet, as they do for the streams we produce.
+func validateTS(fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return errors.New("empty segment")
+	}
+	if info.Size()%tsPacketSize != 0 {
+		return fmt.Errorf("%d bytes is not a whole number of %d byte packets", info.Size(), tsPacketSize)
+	}
+
+	var (
+		patFound  bool
+		pmtPIDs   = map[uint16]bool{}
+		pmtFound  bool
+		pesStates = map[uint16]*pesState{}
+	)
+	err = readTSPackets(file, func(n int, pid uint16, unitStart bool, payload []byte) error {
+		switch {
+		case pid == 0 && unitStart:
+			section, err := psiSection(payload, 0x00)
+			if err != nil {
+				return fmt.Errorf("PAT in packet %d: %w", n, err)
+			}
+			for _, pmtPID := range patPMTPIDs(section) {
+				pmtPIDs[pmtPID] = true
+			}
+			patFound = true
+		case pmtPIDs[pid] && unitStart:
+			section, err := psiSection(payload, 0x02)
+			if err != nil {
+				return fmt.Errorf("PMT in packet %d: %w", n, err)
+			}
+			streams, err := pmtStreams(section)
+			if err != nil {
+				return fmt.Errorf("PMT in packet %d: %w", n, err)
+			}
+			for _, stream := range streams {
+				if pesStates[stream.pid] == nil && stream.streamType != scte35StreamType {
+					pesStates[stream.pid] = &pesState{}
+				}
+			}
+			pmtFound = true
+		case pesStates[pid] != nil:
+			state := pesStates[pid]
+			if unitStart {
+				if !isPESStart(payload) {
+					return fmt.Errorf("packet %d doesn't start a PES packet", n)
+				}
+				if state.expected > state.read {
+					return fmt.Errorf("PES packet on PID %d ending before packet %d is truncated", pid, n)
+				}
+				*state = pesState{}
+				if length := int(binary.BigEndian.Uint16(payload[4:])); length > 0 {
+					state.expected = 6 + length
+				}
+			}
+			state.read += len(payload)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !patFound {
+		return errors.New("no PAT")
+	}
+	if !pmtFound {
+		return errors.New("no PMT")
+	}
+	for pid, state := range pesStates {
+		if state.expected > state.read {
+			return fmt.Errorf("final PES packet on PID %d is truncated: %d of %d bytes", pid, state.read, state.expected)
+		}
+	}
+	return nil
+}
+
+// readTSPackets calls fn with the PID, payload unit start indicator and payload of each packet of a TS stream
+func readTSPackets(r io.Reader, fn func(n int, pid uint16, unitStart bool, payload []byte) error) error {
+	br := bufio.NewReader(r)
+	packet := make([]byte, tsPacketSize)
+	for n := 0; ; n++ {
+		if _, err := io.ReadFull(br, packet); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if packet[0] != 0x47 {
+			return fmt.Errorf("packet %d has no sync byte", n)
+		}
+		payload, err := tsPayload(packet)
+		if err != nil {
+			return fmt.Errorf("packet %d: %w", n, err)
+		}
+		if err := fn(n, binary.BigEndian.Uint16(packet[1:3])&0x1fff, packet[1]&0x40 != 0, payload); err != nil {
+			return err
+		}
+	}
+}
+
+// isPESStart reports whether a payload starts with a PES packet header
+func isPESStart(payload []byte) bool {
+	return len(payload) >= 6 && payload[0] == 0 && payload[1] == 0 && payload[2] == 1
+}
+
+// patPMTPIDs returns the PMT PIDs listed in a PAT section. Program entries follow the 8 byte header and
+// program 0 is the network PID.
+func patPMTPIDs(section []byte) []uint16 {
+	var pids []uint16
+	for i := 8; i+4 <= len(section)-4; i += 4 {
+		if binary.BigEndian.Uint16(section[i:]) != 0 {
+			pids = append(pids, binary.BigEndian.Uint16(section[i+2:])&0x1fff)
+		}
+	}
+	return pids
+}
+
+// pmtStream is an elementary stream listed in a PMT
+type pmtStream struct {
+	streamType  byte
+	pid         uint16
+	descriptors []byte
+}
+
+// scte35StreamType is the PMT stream type of SCTE-35 splice information, which is carried in sections
+// rather than PES packets
+const scte35StreamType = 0x86
+
+// pmtStreams returns the elementary streams listed in a PMT section
+func pmtStreams(section []byte) ([]pmtStream, error) {
+	if len(section) < 16 {
+		return nil, errors.New("too short")
+	}
+	var streams []pmtStream
+	programInfoLength := int(binary.BigEndian.Uint16(section[10:]) & 0x0fff)
+	for i := 12 + programInfoLength; i+5 <= len(section)-4; {
+		infoLength := int(binary.BigEndian.Uint16(section[i+3:]) & 0x0fff)
+		if i+5+infoLength > len(section)-4 {
+			return nil, errors.New("stream descriptors don't fit in the section")
+		}
+		streams = append(streams, pmtStream{
+			streamType:  section[i],
+			pid:         binary.BigEndian.Uint16(section[i+1:]) & 0x1fff,
+			descriptors: section[i+5 : i+5+infoLength],
+		})
+		i += 5 + infoLength
+	}
+	return streams, nil
+}
+
+// tsPayload returns the payload of a TS packet, after its adaptation field
+func tsPayload(packet []byte) ([]byte, error) {
+	adaptationFieldControl := packet[3] >> 4 & 0x3
+	offset := 4
+	if adaptationFieldControl&0x2 != 0 {
+		offset += 1 + int(packet[4])
+		if offset > tsPacketSize {
+			return nil, errors.New("adaptation field is longer than the packet")
+		}
+	}
+	if adaptationFieldControl&0x1 == 0 {
+		return nil, nil
+	}
+	return packet[offset:], nil
+}
+
+// psiSection returns the PSI section starting in a payload, including its CRC, after checking the table ID
+// and the CRC
+func psiSection(payload []byte, tableID byte) ([]byte, error) {
+	if len(payload) == 0 || 1+int(payload[0])+3 > len(payload) {
+		return nil, errors.New("no section")
+	}
+	section := payload[1+int(payload[0]):]
+	if section[0] != tableID {
+		return nil, fmt.Errorf("unexpected table ID %d", section[0])
+	}
+	sectionLength := int(binary.BigEndian.Uint16(section[1:]) & 0x0fff)
+	if 3+sectionLength > len(section) {
+		return nil, errors.New("section doesn't fit in one packet")
+	}
+	section = section[:3+sectionLength]
+	if len(section) < 12 || crc32MPEG2(section) != 0 {
+		return nil, errors.New("bad CRC")
+	}
+	return section, nil
+}
+
+// crc32MPEG2 computes the CRC used by MPEG-TS sections. Over a section including its CRC it is 0.
+func crc32MPEG2(data []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// validateMP4 checks that the top level boxes of an MP4 file add up to its size, that it has a moov box or,
+// for fragments, moof boxes, and that each moof is followed by its mdat
+func validateMP4(fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	boxes, err := readMP4Boxes(file, info.Size())
+	if err != nil {
+		return err
+	}
+
+	var hasMoov, hasMoof, needMdat bool
+	for _, box := range boxes {
+		switch box.boxType {
+		case "moov":
+			hasMoov = true
+		case "moof":
+			if needMdat {
+				return fmt.Errorf("moof box at %d follows a moof without mdat", box.offset)
+			}
+			hasMoof, needMdat = true, true
+		case "mdat":
+			needMdat = false
+		}
+	}
+
+	if !hasMoov && !hasMoof {
+		return errors.New("no moov or moof box")
+	}
+	if needMdat {
+		return errors.New("last moof box has no mdat")
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testTSPacket builds a TS packet, padding the payload with 0xff
+func testTSPacket(pid uint16, unitStart bool, payload []byte) []byte {
+	packet := make([]byte, tsPacketSize)
+	packet[0] = 0x47
+	binary.BigEndian.PutUint16(packet[1:], pid)
+	if unitStart {
+		packet[1] |= 0x40
+	}
+	packet[3] = 0x10
+	for i := copy(packet[4:], payload) + 4; i < tsPacketSize; i++ {
+		packet[i] = 0xff
+	}
+	return packet
+}
+
+// testPSIPacket wraps a section without its length and CRC in a TS packet
+func testPSIPacket(pid uint16, tableID byte, body []byte) []byte {
+	section := append([]byte{tableID, 0xb0, 0}, body...)
+	binary.BigEndian.PutUint16(section[1:], 0xb000|uint16(len(body)+4))
+	section = binary.BigEndian.AppendUint32(section, crc32MPEG2(section))
+	return testTSPacket(pid, true, append([]byte{0}, section...))
+}
+
+// testTS builds a segment with a PAT, a PMT on PID 0x1000 for a video stream on PID 0x100, and a PES packet
+// with a length of pesLength spread over pesPackets packets
+func testTS(pesLength, pesPackets int) []byte {
+	pat := testPSIPacket(0, 0x00, []byte{0, 1, 0xc1, 0, 0, 0, 1, 0xf0, 0x00})
+	pmt := testPSIPacket(0x1000, 0x02, []byte{0, 1, 0xc1, 0, 0, 0xe1, 0x00, 0xf0, 0, 0x1b, 0xe1, 0x00, 0xf0, 0})
+	data := append(pat, pmt...)
+	pes := []byte{0, 0, 1, 0xe0, 0, 0}
+	binary.BigEndian.PutUint16(pes[4:], uint16(pesLength))
+	data = append(data, testTSPacket(0x100, true, pes)...)
+	for i := 1; i < pesPackets; i++ {
+		data = append(data, testTSPacket(0x100, false, nil)...)
+	}
+	return data
+}
+
+// testMP4Box builds a box with a zeroed body
+func testMP4Box(boxType string, bodySize int) []byte {
+	box := make([]byte, 8+bodySize)
+	binary.BigEndian.PutUint32(box, uint32(len(box)))
+	copy(box[4:], boxType)
+	return box
+}
+
+func concatBytes(parts ...[]byte) []byte {
+	var data []byte
+	for _, part := range parts {
+		data = append(data, part...)
+	}
+	return data
+}
+
+func TestValidateSegment(t *testing.T) {
+	dir := t.TempDir()
+	fragment := concatBytes(testMP4Box("styp", 8), testMP4Box("moof", 100), testMP4Box("mdat", 1000))
+	tests := []struct {
+		name string
+		ext  string
+		data []byte
+		err  string
+	}{
+		{name: "ts", ext: ".ts", data: testTS(500, 3)},
+		{name: "unbounded PES", ext: ".ts", data: testTS(0, 1)},
+		{name: "partial packet", ext: ".ts", data: testTS(500, 3)[:500], err: "whole number"},
+		{name: "truncated PES", ext: ".ts", data: testTS(500, 2), err: "final PES packet on PID 256 is truncated"},
+		{name: "no PAT", ext: ".ts", data: testTS(500, 3)[tsPacketSize:], err: "no PAT"},
+		{name: "no sync", ext: ".ts", data: make([]byte, tsPacketSize), err: "sync byte"},
+		{name: "bad CRC", ext: ".ts", data: append(testPSIPacket(0, 0, []byte{0, 1, 0xc1, 0, 0, 0, 1, 0xf0, 0x00})[:16], make([]byte, tsPacketSize-16)...), err: "bad CRC"},
+		{name: "empty", ext: ".ts", data: nil, err: "empty"},
+		{name: "mp4", ext: ".mp4", data: concatBytes(testMP4Box("ftyp", 16), testMP4Box("moov", 200), testMP4Box("mdat", 1000))},
+		{name: "fragment", ext: ".mp4", data: fragment},
+		{name: "truncated mdat", ext: ".mp4", data: fragment[:len(fragment)-10], err: "mdat box at 124 is truncated"},
+		{name: "no moov", ext: ".mp4", data: concatBytes(testMP4Box("ftyp", 16), testMP4Box("mdat", 1000)), err: "no moov"},
+		{name: "moof without mdat", ext: ".mp4", data: testMP4Box("moof", 100), err: "no mdat"},
+		{name: "not a segment", ext: ".m3u8", data: []byte("#EXTM3U")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileName := filepath.Join(dir, tt.name+tt.ext)
+			require.NoError(t, os.WriteFile(fileName, tt.data, 0644))
+			err := validateSegment(fileName, tt.ext)
+			if tt.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tt.err)
+			require.True(t, errors.Is(err, ErrInvalidSegment))
+		})
+	}
+}
+
+func TestUploadInvalidSegment(t *testing.T) {
+	dir := t.TempDir()
+	inputFile := filepath.Join(dir, "in.ts")
+	require.NoError(t, os.WriteFile(inputFile, testTS(500, 2), 0644))
+	outFile := filepath.Join(dir, "out.ts")
+
+	_, err := UploadFiles([]string{inputFile}, mustParseURL(filepath.ToSlash(outFile)), UploadOptions{ValidateSegments: true, DisableThumbs: []string{"out"}})
+	require.True(t, errors.Is(err, ErrInvalidSegment))
+	require.NoFileExists(t, outFile)
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+// Codes of the warnings of uploads that succeeded in a degraded way, see UploadWarning
+const (
+	// WarningFallback is an upload written to the backup destination because the primary failed or was down
+	WarningFallback = "fallback"
+	// WarningThumbnailFailed, WarningWaveformFailed and WarningTimedMetadataFailed are sidecars of a segment
+	// that couldn't be generated
+	WarningThumbnailFailed     = "thumbnail_failed"
+	WarningWaveformFailed      = "waveform_failed"
+	WarningTimedMetadataFailed = "timed_metadata_failed"
+	// WarningCacheControlUnsupported and WarningContentDispositionUnsupported are headers that were asked for
+	// but that the storage of the destination can't set
+	WarningCacheControlUnsupported       = "cache_control_unsupported"
+	WarningContentDispositionUnsupported = "content_disposition_unsupported"
+)
+
+// UploadWarning is a non-fatal problem of an upload that succeeded, so that callers can surface degraded uploads
+type UploadWarning struct {
+	// Code is one of the Warning codes, e.g. WarningFallback
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// uploadWarnings collects the warnings of uploadFileWithBackup, whose uploads may run concurrently when hedged
+type uploadWarnings struct {
+	mu       sync.Mutex
+	warnings []UploadWarning
+}
+
+func (w *uploadWarnings) add(code, format string, args ...interface{}) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.warnings = append(w.warnings, UploadWarning{Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+func (w *uploadWarnings) list() []UploadWarning {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]UploadWarning(nil), w.warnings...)
+}
+
+// addWarning adds a warning to the result of an upload
+func (r *UploadResult) addWarning(code, format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, UploadWarning{Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// supportsCacheControl reports whether the storage of a destination sets the Cache-Control of objects
+func supportsCacheControl(u *url.URL) bool {
+	return isS3URL(u) || isSpacesURL(u) || u.Scheme == "memory-s3" || u.Scheme == "gs"
+}
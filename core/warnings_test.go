@@ -0,0 +1,41 @@
+package core
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadWarnings(t *testing.T) {
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "1.ts")
+	require.NoError(t, os.WriteFile(testFile, []byte("segment"), 0644))
+
+	// file destinations can't set the Cache-Control
+	opts := UploadOptions{Destination: DestinationOptions{CacheControl: "max-age=60"}}
+	result, _, err := uploadFileWithBackup(mustParseURL(filepath.ToSlash(filepath.Join(dir, "out", "1.ts"))), testFile, nil, time.Second, false, opts)
+	require.NoError(t, err)
+	require.Len(t, result.Warnings, 1)
+	require.Equal(t, WarningCacheControlUnsupported, result.Warnings[0].Code)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+	primary := "bunny+http://zone:key@" + addr + "/"
+	backup := "file://" + filepath.ToSlash(dir) + "/backup/"
+	result, _, err = uploadFileWithBackup(mustParseURL(primary+"1.ts"), testFile, nil, time.Second, true, UploadOptions{StorageFallbackURLs: map[string]string{primary: backup}})
+	require.NoError(t, err)
+	require.Len(t, result.Warnings, 1)
+	require.Equal(t, WarningFallback, result.Warnings[0].Code)
+	require.Contains(t, result.Warnings[0].Message, "/backup/1.ts")
+	require.NotContains(t, result.Warnings[0].Message, "key")
+
+	result, _, err = uploadFileWithBackup(mustParseURL(filepath.ToSlash(filepath.Join(dir, "out", "2.ts"))), testFile, nil, time.Second, false, UploadOptions{})
+	require.NoError(t, err)
+	require.Empty(t, result.Warnings)
+}
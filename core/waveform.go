@@ -0,0 +1,99 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// waveformSampleRate is the rate the audio is resampled to before computing peaks
+	waveformSampleRate = 8000
+	// waveformSamplesPerPixel gives 100 peaks per second of audio
+	waveformSamplesPerPixel = 80
+)
+
+// waveform is the JSON format of BBC's audiowaveform, which waveform UIs such as peaks.js read. Data holds
+// a min and max value per pixel.
+type waveform struct {
+	Version         int    `json:"version"`
+	Channels        int    `json:"channels"`
+	SampleRate      int    `json:"sample_rate"`
+	SamplesPerPixel int    `json:"samples_per_pixel"`
+	Bits            int    `json:"bits"`
+	Length          int    `json:"length"`
+	Data            []int8 `json:"data"`
+}
+
+// waveformURI is where the waveform of a segment is written, next to it with a .waveform.json extension
+func waveformURI(segmentURI *url.URL) *url.URL {
+	u := *segmentURI
+	u.Path = strings.TrimSuffix(u.Path, path.Ext(u.Path)) + ".waveform.json"
+	u.RawPath = ""
+	return &u
+}
+
+// uploadWaveform computes the audio waveform of a segment and writes it next to the segment
+func uploadWaveform(segmentURI *url.URL, segmentFileName string, opts UploadOptions) error {
+	release, err := opts.FFmpegLimiter.acquire()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+	// decode to mono 16 bit samples at a rate that keeps the output small
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-i", segmentFileName, "-vn", "-ac", "1", "-ar", fmt.Sprint(waveformSampleRate), "-f", "s16le", "-acodec", "pcm_s16le", "-")
+	var samples, stdErr bytes.Buffer
+	cmd.Stdout = &samples
+	cmd.Stderr = &stdErr
+	err = cmd.Run()
+	release()
+	if err != nil {
+		return fmt.Errorf("ffmpeg failed [%s]: %w", stdErr.String(), err)
+	}
+	if samples.Len() == 0 {
+		return errors.New("no audio")
+	}
+	data, err := json.Marshal(computeWaveform(&samples, waveformSampleRate, waveformSamplesPerPixel))
+	if err != nil {
+		return err
+	}
+
+	if err := uploadJSON(waveformURI(segmentURI), data, opts); err != nil {
+		return fmt.Errorf("saving waveform failed: %w", err)
+	}
+	return nil
+}
+
+// computeWaveform reads mono signed 16 bit little endian samples and returns the 8 bit min and max of each
+// samplesPerPixel samples
+func computeWaveform(r io.Reader, sampleRate, samplesPerPixel int) *waveform {
+	w := &waveform{Version: 2, Channels: 1, SampleRate: sampleRate, SamplesPerPixel: samplesPerPixel, Bits: 8, Data: []int8{}}
+	buf := make([]byte, 2*samplesPerPixel)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n < 2 {
+			break
+		}
+		minSample, maxSample := int16(32767), int16(-32768)
+		for i := 0; i+1 < n; i += 2 {
+			sample := int16(binary.LittleEndian.Uint16(buf[i:]))
+			minSample, maxSample = min(minSample, sample), max(maxSample, sample)
+		}
+		w.Data = append(w.Data, int8(minSample>>8), int8(maxSample>>8))
+		w.Length++
+		if err != nil {
+			break
+		}
+	}
+	return w
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-uploader/core"
+)
+
+// runDownload implements `catalyst-uploader download <uri> <file>`, reading an uploaded object back to a local
+// file, or into a local directory under the object's name
+func runDownload(args []string) int {
+	fs := flag.NewFlagSet("catalyst-uploader download", flag.ExitOnError)
+	logs := addLogFlags(fs)
+	addConfigFlag(fs)
+	preserveMetadata := fs.Bool("preserve-metadata", false, "Restore the modification time recorded by uploads with -preserve-metadata, and name files downloaded into a directory after the Content-Type of objects without an extension")
+	timeout := fs.Duration("t", 30*time.Minute, "Timeout of the download")
+
+	if err := parseFlags(fs, args); err != nil {
+		glog.Errorf("error parsing cli: %s", err)
+		return 1
+	}
+	cleanupLogs, err := logs.apply()
+	if err != nil {
+		glog.Error(err)
+		return 1
+	}
+	defer cleanupLogs()
+	if fs.NArg() != 2 {
+		glog.Error("Expected an object URI and a local file or directory")
+		return 1
+	}
+	arg, err := core.ExpandEnv(fs.Arg(0))
+	if err != nil {
+		glog.Error(err)
+		return 1
+	}
+	uri, err := core.ParseOutputURI(arg)
+	if err != nil {
+		glog.Errorf("Failed to parse URI: %s", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	fileName, err := core.DownloadFile(ctx, uri, fs.Arg(1), *preserveMetadata, core.UploadOptions{})
+	if err != nil {
+		glog.Errorf("Failed to download %s: %s", uri.Redacted(), err)
+		return 1
+	}
+	glog.Infof("Downloaded %s to %s", uri.Redacted(), fileName)
+	return 0
+}
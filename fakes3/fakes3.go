@@ -0,0 +1,702 @@
+// Package fakes3 implements an in-memory S3-compatible server. It supports the subset of the S3 API used by
+// the storage drivers (objects, ranged reads, listing, copies, multipart uploads, browser-based POST uploads and lifecycle rules), so the S3 code paths can be
+// exercised without real credentials. Requests are not authenticated.
+package fakes3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AccessKey and SecretKey are the credentials put in URLs returned by Server.URL. Any credentials are accepted.
+const (
+	AccessKey = "fakes3"
+	SecretKey = "fakes3secret"
+)
+
+type Object struct {
+	Data               []byte
+	ContentType        string
+	CacheControl       string
+	ContentDisposition string
+	StorageClass       string
+	Metadata           map[string]string
+	ETag               string
+	LastModified       time.Time
+	// VersionID is only set in buckets with versioning enabled
+	VersionID string
+}
+
+type multipartUpload struct {
+	bucket, key string
+	object      Object
+	parts       map[int][]byte
+}
+
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	buckets   map[string]map[string]*Object
+	uploads   map[string]*multipartUpload
+	versioned map[string]bool
+	// lifecycles are the lifecycle configurations of the buckets, as the XML documents they were put with
+	lifecycles map[string][]byte
+}
+
+// New starts a server listening on a local port. Buckets are created on first write.
+func New() *Server {
+	s := &Server{
+		buckets:    map[string]map[string]*Object{},
+		uploads:    map[string]*multipartUpload{},
+		versioned:  map[string]bool{},
+		lifecycles: map[string][]byte{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// URL returns an s3+http:// URL pointing at key in bucket on this server, in the format expected by drivers.ParseOSURL
+func (s *Server) URL(bucket, key string) string {
+	host := strings.TrimPrefix(s.Server.URL, "http://")
+	return fmt.Sprintf("s3+http://%s:%s@%s/%s/%s", AccessKey, SecretKey, host, bucket, strings.TrimPrefix(key, "/"))
+}
+
+// EnableVersioning makes writes to bucket return a new version ID in the x-amz-version-id header, like S3
+// does for versioned buckets. Only the latest version of an object is kept.
+func (s *Server) EnableVersioning(bucket string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.versioned[bucket] = true
+}
+
+// Object returns a copy of the stored object, if it exists
+func (s *Server) Object(bucket, key string) (Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.buckets[bucket][key]
+	if !ok {
+		return Object{}, false
+	}
+	return *obj, true
+}
+
+// Keys lists all keys stored in bucket, sorted
+func (s *Server) Keys(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keysLocked(bucket)
+}
+
+// Uploads returns the keys of the multipart uploads in bucket that were started but not completed or aborted
+func (s *Server) Uploads(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for _, upload := range s.uploads {
+		if upload.bucket == bucket {
+			keys = append(keys, upload.key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket == "" {
+		writeError(w, http.StatusBadRequest, "InvalidBucketName", "bucket is required")
+		return
+	}
+	query := r.URL.Query()
+	switch {
+	case key == "" && query.Has("lifecycle"):
+		s.bucketLifecycle(w, r, bucket)
+	case key == "" && r.Method == http.MethodGet && query.Has("uploads"):
+		s.listMultipartUploads(w, bucket, query.Get("prefix"))
+	case key == "" && r.Method == http.MethodGet:
+		s.listObjects(w, bucket, query.Get("prefix"), query.Get("delimiter"), query.Get("marker"), query.Get("max-keys"))
+	case key == "" && r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data"):
+		s.postObject(w, r, bucket)
+	case key == "":
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "bucket operation not supported")
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s.createMultipartUpload(w, r, bucket, key)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		s.completeMultipartUpload(w, r, query.Get("uploadId"))
+	case r.Method == http.MethodPut && query.Has("uploadId") && r.Header.Get("X-Amz-Copy-Source") != "":
+		s.uploadPartCopy(w, r, query.Get("uploadId"), query.Get("partNumber"))
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		s.uploadPart(w, r, query.Get("uploadId"), query.Get("partNumber"))
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		s.copyObject(w, r, bucket, key)
+	case r.Method == http.MethodGet && query.Has("uploadId"):
+		s.listParts(w, query.Get("uploadId"))
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		s.abortMultipartUpload(w, query.Get("uploadId"))
+	case r.Method == http.MethodPut:
+		s.putObject(w, r, bucket, key)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		s.getObject(w, r, bucket, key)
+	case r.Method == http.MethodDelete:
+		s.deleteObject(w, bucket, key)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented", r.Method+" not supported")
+	}
+}
+
+// bucketLifecycle stores, returns or deletes the lifecycle configuration of a bucket. Rules are never applied.
+func (s *Server) bucketLifecycle(w http.ResponseWriter, r *http.Request, bucket string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+			return
+		}
+		s.lifecycles[bucket] = data
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		data, ok := s.lifecycles[bucket]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchLifecycleConfiguration", "The lifecycle configuration does not exist")
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write(data)
+	case http.MethodDelete:
+		delete(s.lifecycles, bucket)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented", r.Method+" not supported")
+	}
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	obj := objectFromRequest(r)
+	obj.Data = data
+	if !s.storeIf(bucket, key, &obj, r.Header.Get("If-Match"), r.Header.Get("If-None-Match")) {
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+		return
+	}
+	w.Header().Set("ETag", obj.ETag)
+	setVersionID(w, &obj)
+	w.WriteHeader(http.StatusOK)
+}
+
+// postObject stores the file of a browser-based upload form. The fields before the file are taken as its key, where
+// ${filename} is replaced by the name of the file like S3 does, and headers. The policy isn't checked.
+func (s *Server) postObject(w http.ResponseWriter, r *http.Request, bucket string) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedPOSTRequest", err.Error())
+		return
+	}
+	form := http.Header{}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "MalformedPOSTRequest", "The body of your POST request is not well-formed multipart/form-data.")
+			return
+		}
+		value, err := io.ReadAll(part)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+			return
+		}
+		if part.FormName() != "file" {
+			form.Set(part.FormName(), string(value))
+			continue
+		}
+		key := strings.ReplaceAll(form.Get("key"), "${filename}", part.FileName())
+		if key == "" {
+			writeError(w, http.StatusBadRequest, "InvalidArgument", "Bucket POST must contain a field named 'key'.")
+			return
+		}
+		obj := objectFromRequest(&http.Request{Header: form})
+		obj.Data = value
+		s.storeIf(bucket, key, &obj, "", "")
+		w.Header().Set("ETag", obj.ETag)
+		setVersionID(w, &obj)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	obj, ok := s.Object(bucket, key)
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	h := w.Header()
+	h.Set("ETag", obj.ETag)
+	h.Set("Last-Modified", obj.LastModified.UTC().Format(http.TimeFormat))
+	h.Set("Accept-Ranges", "bytes")
+	setVersionID(w, &obj)
+	if obj.ContentType != "" {
+		h.Set("Content-Type", obj.ContentType)
+	}
+	if obj.CacheControl != "" {
+		h.Set("Cache-Control", obj.CacheControl)
+	}
+	if obj.ContentDisposition != "" {
+		h.Set("Content-Disposition", obj.ContentDisposition)
+	}
+	if obj.StorageClass != "" {
+		h.Set("X-Amz-Storage-Class", obj.StorageClass)
+	}
+	for k, v := range obj.Metadata {
+		h.Set("X-Amz-Meta-"+k, v)
+	}
+
+	data, status := obj.Data, http.StatusOK
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		start, end, ok := parseRange(rangeHeader, int64(len(obj.Data)))
+		if !ok {
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable")
+			return
+		}
+		data, status = obj.Data[start:end+1], http.StatusPartialContent
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(obj.Data)))
+	}
+	h.Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(data)
+	}
+}
+
+func (s *Server) deleteObject(w http.ResponseWriter, bucket, key string) {
+	s.mu.Lock()
+	delete(s.buckets[bucket], key)
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type listBucketResult struct {
+	XMLName        xml.Name       `xml:"ListBucketResult"`
+	Name           string         `xml:"Name"`
+	Prefix         string         `xml:"Prefix"`
+	Marker         string         `xml:"Marker"`
+	NextMarker     string         `xml:"NextMarker,omitempty"`
+	MaxKeys        int            `xml:"MaxKeys"`
+	IsTruncated    bool           `xml:"IsTruncated"`
+	Contents       []listContents `xml:"Contents"`
+	CommonPrefixes []listPrefix   `xml:"CommonPrefixes"`
+}
+
+type listContents struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+}
+
+type listPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+func (s *Server) listObjects(w http.ResponseWriter, bucket, prefix, delimiter, marker, maxKeysStr string) {
+	maxKeys := 1000
+	if n, err := strconv.Atoi(maxKeysStr); err == nil && n > 0 && n < maxKeys {
+		maxKeys = n
+	}
+	res := listBucketResult{Name: bucket, Prefix: prefix, Marker: marker, MaxKeys: maxKeys}
+	seenPrefixes := map[string]bool{}
+
+	s.mu.Lock()
+	for _, key := range s.keysLocked(bucket) {
+		if !strings.HasPrefix(key, prefix) || key <= marker {
+			continue
+		}
+		if len(res.Contents)+len(res.CommonPrefixes) == maxKeys {
+			res.IsTruncated = true
+			break
+		}
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				commonPrefix := key[:len(prefix)+i+len(delimiter)]
+				if !seenPrefixes[commonPrefix] {
+					seenPrefixes[commonPrefix] = true
+					res.CommonPrefixes = append(res.CommonPrefixes, listPrefix{Prefix: commonPrefix})
+				}
+				res.NextMarker = key
+				continue
+			}
+		}
+		obj := s.buckets[bucket][key]
+		res.Contents = append(res.Contents, listContents{
+			Key:          key,
+			LastModified: obj.LastModified.UTC().Format(time.RFC3339),
+			ETag:         obj.ETag,
+			Size:         len(obj.Data),
+		})
+		res.NextMarker = key
+	}
+	s.mu.Unlock()
+
+	if !res.IsTruncated {
+		res.NextMarker = ""
+	}
+	writeXML(w, http.StatusOK, res)
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+func (s *Server) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	id := uuid.New().String()
+	s.mu.Lock()
+	s.uploads[id] = &multipartUpload{bucket: bucket, key: key, object: objectFromRequest(r), parts: map[int][]byte{}}
+	s.mu.Unlock()
+	writeXML(w, http.StatusOK, initiateMultipartUploadResult{Bucket: bucket, Key: key, UploadID: id})
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, uploadID, partNumberStr string) {
+	partNumber, err := strconv.Atoi(partNumberStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid part number")
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	s.mu.Lock()
+	upload, ok := s.uploads[uploadID]
+	if ok {
+		upload.parts[partNumber] = data
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+		return
+	}
+	w.Header().Set("ETag", etag(data))
+	w.WriteHeader(http.StatusOK)
+}
+
+type copyObjectResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	ETag         string   `xml:"ETag"`
+	LastModified string   `xml:"LastModified"`
+}
+
+type copyPartResult struct {
+	XMLName      xml.Name `xml:"CopyPartResult"`
+	ETag         string   `xml:"ETag"`
+	LastModified string   `xml:"LastModified"`
+}
+
+// copySource looks up the object named by the X-Amz-Copy-Source header
+func (s *Server) copySource(r *http.Request) (Object, bool) {
+	source, err := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+	if err != nil {
+		return Object{}, false
+	}
+	bucket, key, _ := strings.Cut(source, "/")
+	return s.Object(bucket, key)
+}
+
+// copyObject copies the source object's metadata unless the request replaces it
+func (s *Server) copyObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	src, ok := s.copySource(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	obj := src
+	if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+		obj = objectFromRequest(r)
+	}
+	obj.Data = append([]byte(nil), src.Data...)
+	s.store(bucket, key, &obj)
+	setVersionID(w, &obj)
+	writeXML(w, http.StatusOK, copyObjectResult{ETag: obj.ETag, LastModified: obj.LastModified.UTC().Format(time.RFC3339)})
+}
+
+func (s *Server) uploadPartCopy(w http.ResponseWriter, r *http.Request, uploadID, partNumberStr string) {
+	partNumber, err := strconv.Atoi(partNumberStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid part number")
+		return
+	}
+	src, ok := s.copySource(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	data := src.Data
+	if rangeHeader := r.Header.Get("X-Amz-Copy-Source-Range"); rangeHeader != "" {
+		start, end, ok := parseRange(rangeHeader, int64(len(data)))
+		if !ok {
+			writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid copy source range")
+			return
+		}
+		data = data[start : end+1]
+	}
+	s.mu.Lock()
+	upload, ok := s.uploads[uploadID]
+	if ok {
+		upload.parts[partNumber] = append([]byte(nil), data...)
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+		return
+	}
+	writeXML(w, http.StatusOK, copyPartResult{ETag: etag(data), LastModified: time.Now().UTC().Format(time.RFC3339)})
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+	Bucket  string   `xml:"Bucket"`
+	Key     string   `xml:"Key"`
+	ETag    string   `xml:"ETag"`
+}
+
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, r *http.Request, uploadID string) {
+	var req completeMultipartUpload
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+	s.mu.Lock()
+	upload, ok := s.uploads[uploadID]
+	delete(s.uploads, uploadID)
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+		return
+	}
+	var data bytes.Buffer
+	for _, part := range req.Parts {
+		partData, ok := upload.parts[part.PartNumber]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d was not uploaded", part.PartNumber))
+			return
+		}
+		data.Write(partData)
+	}
+	obj := upload.object
+	obj.Data = data.Bytes()
+	s.store(upload.bucket, upload.key, &obj)
+	setVersionID(w, &obj)
+	writeXML(w, http.StatusOK, completeMultipartUploadResult{Bucket: upload.bucket, Key: upload.key, ETag: obj.ETag})
+}
+
+type listMultipartUploadsResult struct {
+	XMLName xml.Name          `xml:"ListMultipartUploadsResult"`
+	Bucket  string            `xml:"Bucket"`
+	Prefix  string            `xml:"Prefix"`
+	Uploads []multipartListed `xml:"Upload"`
+}
+
+type multipartListed struct {
+	Key      string `xml:"Key"`
+	UploadID string `xml:"UploadId"`
+}
+
+func (s *Server) listMultipartUploads(w http.ResponseWriter, bucket, prefix string) {
+	res := listMultipartUploadsResult{Bucket: bucket, Prefix: prefix}
+	s.mu.Lock()
+	for id, upload := range s.uploads {
+		if upload.bucket == bucket && strings.HasPrefix(upload.key, prefix) {
+			res.Uploads = append(res.Uploads, multipartListed{Key: upload.key, UploadID: id})
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(res.Uploads, func(i, j int) bool { return res.Uploads[i].Key < res.Uploads[j].Key })
+	writeXML(w, http.StatusOK, res)
+}
+
+type listPartsResult struct {
+	XMLName  xml.Name     `xml:"ListPartsResult"`
+	Bucket   string       `xml:"Bucket"`
+	Key      string       `xml:"Key"`
+	UploadID string       `xml:"UploadId"`
+	Parts    []partListed `xml:"Part"`
+}
+
+type partListed struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+	Size       int    `xml:"Size"`
+}
+
+// listParts lists all parts of an upload in one page
+func (s *Server) listParts(w http.ResponseWriter, uploadID string) {
+	s.mu.Lock()
+	upload, ok := s.uploads[uploadID]
+	var res listPartsResult
+	if ok {
+		res = listPartsResult{Bucket: upload.bucket, Key: upload.key, UploadID: uploadID}
+		for n, data := range upload.parts {
+			res.Parts = append(res.Parts, partListed{PartNumber: n, ETag: etag(data), Size: len(data)})
+		}
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+		return
+	}
+	sort.Slice(res.Parts, func(i, j int) bool { return res.Parts[i].PartNumber < res.Parts[j].PartNumber })
+	writeXML(w, http.StatusOK, res)
+}
+
+func (s *Server) abortMultipartUpload(w http.ResponseWriter, uploadID string) {
+	s.mu.Lock()
+	delete(s.uploads, uploadID)
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) store(bucket, key string, obj *Object) {
+	s.storeIf(bucket, key, obj, "", "")
+}
+
+// storeIf stores obj only if the conditional headers of the request hold: with ifMatch, the stored object must
+// have that ETag, and with ifNoneMatch "*" there must be no stored object
+func (s *Server) storeIf(bucket, key string, obj *Object, ifMatch, ifNoneMatch string) bool {
+	obj.ETag = etag(obj.Data)
+	obj.LastModified = time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, exists := s.buckets[bucket][key]
+	if ifMatch != "" && (!exists || existing.ETag != ifMatch) {
+		return false
+	}
+	if ifNoneMatch == "*" && exists {
+		return false
+	}
+	if s.versioned[bucket] {
+		obj.VersionID = uuid.New().String()
+	}
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = map[string]*Object{}
+	}
+	s.buckets[bucket][key] = obj
+	return true
+}
+
+func (s *Server) keysLocked(bucket string) []string {
+	var keys []string
+	for k := range s.buckets[bucket] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func objectFromRequest(r *http.Request) Object {
+	obj := Object{
+		ContentType:        r.Header.Get("Content-Type"),
+		CacheControl:       r.Header.Get("Cache-Control"),
+		ContentDisposition: r.Header.Get("Content-Disposition"),
+		StorageClass:       r.Header.Get("X-Amz-Storage-Class"),
+		Metadata:           map[string]string{},
+	}
+	for k, v := range r.Header {
+		if name, ok := strings.CutPrefix(k, "X-Amz-Meta-"); ok && len(v) > 0 {
+			obj.Metadata[name] = v[0]
+		}
+	}
+	return obj
+}
+
+// parseRange handles the single "bytes=start-end", "bytes=start-" and "bytes=-suffix" forms
+func parseRange(header string, size int64) (start, end int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	startStr, endStr, _ := strings.Cut(spec, "-")
+	var err error
+	switch {
+	case startStr == "":
+		suffix, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || suffix <= 0 {
+			return 0, 0, false
+		}
+		start, end = max(size-suffix, 0), size-1
+	case endStr == "":
+		if start, err = strconv.ParseInt(startStr, 10, 64); err != nil {
+			return 0, 0, false
+		}
+		end = size - 1
+	default:
+		if start, err = strconv.ParseInt(startStr, 10, 64); err != nil {
+			return 0, 0, false
+		}
+		if end, err = strconv.ParseInt(endStr, 10, 64); err != nil {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	if start < 0 || start > end || start >= size {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+func setVersionID(w http.ResponseWriter, obj *Object) {
+	if obj.VersionID != "" {
+		w.Header().Set("x-amz-version-id", obj.VersionID)
+	}
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+type errorResponse struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeXML(w, status, errorResponse{Code: code, Message: message})
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(xml.Header))
+	_ = xml.NewEncoder(w).Encode(v)
+}
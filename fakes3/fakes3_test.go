@@ -0,0 +1,158 @@
+package fakes3
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/livepeer/go-tools/drivers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriverRoundTrip(t *testing.T) {
+	srv := New()
+	defer srv.Close()
+
+	driver, err := drivers.ParseOSURL(srv.URL("bucket", "/hls/123"), true)
+	require.NoError(t, err)
+	sess := driver.NewSession("")
+	ctx := context.Background()
+
+	fields := &drivers.FileProperties{CacheControl: "max-age=1", Metadata: map[string]string{"Foo": "bar"}}
+	_, err = sess.SaveData(ctx, "index.m3u8", bytes.NewReader([]byte("#EXTM3U\n")), fields, 0)
+	require.NoError(t, err)
+	_, err = sess.SaveData(ctx, "seg/0.ts", bytes.NewReader([]byte("0123456789")), nil, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"hls/123/index.m3u8", "hls/123/seg/0.ts"}, srv.Keys("bucket"))
+
+	obj, ok := srv.Object("bucket", "hls/123/index.m3u8")
+	require.True(t, ok)
+	require.Equal(t, "max-age=1", obj.CacheControl)
+	require.Equal(t, "bar", obj.Metadata["Foo"])
+
+	info, err := sess.ReadData(ctx, "index.m3u8")
+	require.NoError(t, err)
+	data, err := io.ReadAll(info.Body)
+	require.NoError(t, err)
+	require.Equal(t, "#EXTM3U\n", string(data))
+	require.Equal(t, "bar", info.Metadata["Foo"])
+
+	info, err = sess.ReadDataRange(ctx, "seg/0.ts", "bytes=2-4")
+	require.NoError(t, err)
+	data, err = io.ReadAll(info.Body)
+	require.NoError(t, err)
+	require.Equal(t, "234", string(data))
+	require.Equal(t, "bytes 2-4/10", info.ContentRange)
+
+	page, err := sess.ListFiles(ctx, "hls/123/", "/")
+	require.NoError(t, err)
+	require.Len(t, page.Files(), 1)
+	require.Equal(t, "hls/123/index.m3u8", page.Files()[0].Name)
+	require.Equal(t, []string{"hls/123/seg/"}, page.Directories())
+
+	require.NoError(t, sess.DeleteFile(ctx, "seg/0.ts"))
+	_, err = sess.ReadData(ctx, "seg/0.ts")
+	require.ErrorContains(t, err, "NoSuchKey")
+}
+
+func TestMultipartUpload(t *testing.T) {
+	srv := New()
+	defer srv.Close()
+
+	sess, err := session.NewSession(aws.NewConfig().
+		WithRegion("us-east-1").
+		WithCredentials(credentials.NewStaticCredentials(AccessKey, SecretKey, "")).
+		WithEndpoint(srv.Server.URL).
+		WithS3ForcePathStyle(true))
+	require.NoError(t, err)
+
+	data := make([]byte, 11*1024*1024)
+	_, err = rand.Read(data)
+	require.NoError(t, err)
+	uploader := s3manager.NewUploader(sess, func(u *s3manager.Uploader) {
+		u.PartSize = 5 * 1024 * 1024
+	})
+	_, err = uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("big.mp4"),
+		Body:   bytes.NewReader(data),
+	})
+	require.NoError(t, err)
+
+	obj, ok := srv.Object("bucket", "big.mp4")
+	require.True(t, ok)
+	require.Equal(t, data, obj.Data)
+}
+
+func TestVersioning(t *testing.T) {
+	srv := New()
+	defer srv.Close()
+	srv.EnableVersioning("versioned")
+
+	sess, err := session.NewSession(aws.NewConfig().
+		WithRegion("us-east-1").
+		WithCredentials(credentials.NewStaticCredentials(AccessKey, SecretKey, "")).
+		WithEndpoint(srv.Server.URL).
+		WithS3ForcePathStyle(true))
+	require.NoError(t, err)
+	uploader := s3manager.NewUploader(sess)
+
+	first, err := uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String("versioned"),
+		Key:    aws.String("index.m3u8"),
+		Body:   bytes.NewReader([]byte("#EXTM3U")),
+	})
+	require.NoError(t, err)
+	require.NotNil(t, first.VersionID)
+	second, err := uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String("versioned"),
+		Key:    aws.String("index.m3u8"),
+		Body:   bytes.NewReader([]byte("#EXTM3U")),
+	})
+	require.NoError(t, err)
+	require.NotEqual(t, *first.VersionID, *second.VersionID)
+
+	obj, ok := srv.Object("versioned", "index.m3u8")
+	require.True(t, ok)
+	require.Equal(t, *second.VersionID, obj.VersionID)
+
+	unversioned, err := uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("index.m3u8"),
+		Body:   bytes.NewReader([]byte("#EXTM3U")),
+	})
+	require.NoError(t, err)
+	require.Nil(t, unversioned.VersionID)
+}
+
+func TestConditionalPut(t *testing.T) {
+	srv := New()
+	defer srv.Close()
+	sess, err := session.NewSession(aws.NewConfig().
+		WithRegion("us-east-1").
+		WithCredentials(credentials.NewStaticCredentials(AccessKey, SecretKey, "")).
+		WithEndpoint(srv.Server.URL).
+		WithS3ForcePathStyle(true))
+	require.NoError(t, err)
+	svc := s3.New(sess)
+	put := func(body string, condition map[string]string) error {
+		_, err := svc.PutObjectWithContext(context.Background(), &s3.PutObjectInput{Bucket: aws.String("bucket"), Key: aws.String("events.log"), Body: bytes.NewReader([]byte(body))}, request.WithSetRequestHeaders(condition))
+		return err
+	}
+
+	require.NoError(t, put("a\n", map[string]string{"If-None-Match": "*"}))
+	require.Error(t, put("b\n", map[string]string{"If-None-Match": "*"}))
+	obj, _ := srv.Object("bucket", "events.log")
+	require.Error(t, put("a\nb\n", map[string]string{"If-Match": `"stale"`}))
+	require.NoError(t, put("a\nb\n", map[string]string{"If-Match": obj.ETag}))
+	obj, _ = srv.Object("bucket", "events.log")
+	require.Equal(t, "a\nb\n", string(obj.Data))
+}
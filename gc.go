@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-uploader/core"
+)
+
+// runGC implements `catalyst-uploader gc -older-than 168h <uri-prefix>`, deleting the expired objects under a
+// prefix and writing them to stdout as one JSON object per line
+func runGC(args []string) int {
+	fs := flag.NewFlagSet("catalyst-uploader gc", flag.ExitOnError)
+	logs := addLogFlags(fs)
+	addConfigFlag(fs)
+	olderThan := fs.Duration("older-than", 168*time.Hour, "Delete the objects last modified longer ago than this")
+	dryRun := fs.Bool("dry-run", false, "Only list the objects that would be deleted")
+	parallel := fs.Int("parallel", 8, "Number of objects deleted concurrently")
+	include := RepeatedFlag(fs, "include", "Only delete objects matching this pattern, see the uploader's -include. Can be given several times")
+	exclude := RepeatedFlag(fs, "exclude", "Never delete objects matching this pattern. Can be given several times")
+	includeHidden := fs.Bool("include-hidden", false, "Also delete dotfiles and temporary files ending in .tmp, .part, .partial or ~")
+	timeout := fs.Duration("t", 30*time.Minute, "Timeout of the whole collection")
+
+	if err := parseFlags(fs, args); err != nil {
+		glog.Errorf("error parsing cli: %s", err)
+		return 1
+	}
+	cleanupLogs, err := logs.apply()
+	if err != nil {
+		glog.Error(err)
+		return 1
+	}
+	defer cleanupLogs()
+	if fs.NArg() != 1 {
+		glog.Error("Expected a storage URI prefix")
+		return 1
+	}
+	arg, err := core.ExpandEnv(fs.Arg(0))
+	if err != nil {
+		glog.Error(err)
+		return 1
+	}
+	prefix, err := core.ParseOutputURI(arg)
+	if err != nil {
+		glog.Errorf("Failed to parse URI: %s", err)
+		return 1
+	}
+	filter := core.FileFilter{Include: *include, Exclude: *exclude, IncludeHidden: *includeHidden}
+	if err := filter.Validate(); err != nil {
+		glog.Errorf("Invalid -include or -exclude: %s", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	gcOpts := core.GCOptions{OlderThan: *olderThan, Filter: filter, DryRun: *dryRun, Parallel: *parallel}
+	expired, err := core.CollectGarbage(ctx, prefix, gcOpts, core.UploadOptions{})
+	if err != nil {
+		glog.Errorf("Failed to collect garbage under %s: %s", prefix.Redacted(), err)
+		return 1
+	}
+	exitCode := 0
+	enc := json.NewEncoder(os.Stdout)
+	for _, obj := range expired {
+		if err := enc.Encode(obj); err != nil {
+			glog.Error(err)
+			return 1
+		}
+		if obj.Error != "" {
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-uploader/core"
+)
+
+// runGrant implements `catalyst-uploader grant <uri>`, writing a signed POST policy that gives other nodes
+// temporary write access to the keys under an S3 URI to stdout as JSON
+func runGrant(args []string) int {
+	fs := flag.NewFlagSet("catalyst-uploader grant", flag.ExitOnError)
+	logs := addLogFlags(fs)
+	addConfigFlag(fs)
+	ttl := fs.Duration("ttl", time.Hour, "How long the grant is valid for")
+	conditions := RepeatedFlag(fs, "condition", `Add this condition to the POST policy, as JSON, e.g. '["content-length-range", 0, 104857600]' to limit uploads to 100MiB. Can be given several times`)
+
+	if err := parseFlags(fs, args); err != nil {
+		glog.Errorf("error parsing cli: %s", err)
+		return 1
+	}
+	cleanupLogs, err := logs.apply()
+	if err != nil {
+		glog.Error(err)
+		return 1
+	}
+	defer cleanupLogs()
+	if fs.NArg() != 1 {
+		glog.Error("Expected a single storage URI")
+		return 1
+	}
+	arg, err := core.ExpandEnv(fs.Arg(0))
+	if err != nil {
+		glog.Error(err)
+		return 1
+	}
+	uri, err := core.ParseOutputURI(arg)
+	if err != nil {
+		glog.Errorf("Failed to parse URI: %s", err)
+		return 1
+	}
+	var policyConditions []interface{}
+	for _, s := range *conditions {
+		condition, err := core.ParseGrantCondition(s)
+		if err != nil {
+			glog.Error(err)
+			return 1
+		}
+		policyConditions = append(policyConditions, condition)
+	}
+
+	grant, err := core.GrantPostPolicy(uri, *ttl, policyConditions)
+	if err != nil {
+		glog.Errorf("Failed to grant access to %s: %s", uri.Redacted(), err)
+		return 1
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(grant); err != nil {
+		glog.Error(err)
+		return 1
+	}
+	return 0
+}
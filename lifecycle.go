@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-uploader/core"
+)
+
+// runLifecycle implements `catalyst-uploader lifecycle apply <bucket-uri>`, configuring the expiry of the header
+// rules as lifecycle rules of the bucket and writing them to stdout as one JSON object per line
+func runLifecycle(args []string) int {
+	if len(args) == 0 || args[0] != "apply" {
+		glog.Error("Expected `lifecycle apply <bucket-uri>`")
+		return 1
+	}
+	fs := flag.NewFlagSet("catalyst-uploader lifecycle apply", flag.ExitOnError)
+	logs := addLogFlags(fs)
+	addConfigFlag(fs)
+	headerRules := fs.String("header-rules", "", "JSON file of the uploader's header rules, whose expire_after or Object-Expires metadata set the expiry of their prefix")
+	dryRun := fs.Bool("dry-run", false, "Only print the lifecycle rules without configuring them")
+	timeout := fs.Duration("t", time.Minute, "Timeout")
+
+	if err := parseFlags(fs, args[1:]); err != nil {
+		glog.Errorf("error parsing cli: %s", err)
+		return 1
+	}
+	cleanupLogs, err := logs.apply()
+	if err != nil {
+		glog.Error(err)
+		return 1
+	}
+	defer cleanupLogs()
+	if fs.NArg() != 1 {
+		glog.Error("Expected a bucket URI")
+		return 1
+	}
+	arg, err := core.ExpandEnv(fs.Arg(0))
+	if err != nil {
+		glog.Error(err)
+		return 1
+	}
+	bucket, err := core.ParseOutputURI(arg)
+	if err != nil {
+		glog.Errorf("Failed to parse URI: %s", err)
+		return 1
+	}
+	var rules []core.HeaderRule
+	if *headerRules != "" {
+		if rules, err = core.LoadHeaderRules(*headerRules); err != nil {
+			glog.Error(err)
+			return 1
+		}
+	}
+	lifecycleRules, err := core.LifecycleRules(bucket, rules)
+	if err != nil {
+		glog.Error(err)
+		return 1
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, rule := range lifecycleRules {
+		if err := enc.Encode(rule); err != nil {
+			glog.Error(err)
+			return 1
+		}
+	}
+	if *dryRun {
+		return 0
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if err := core.ApplyLifecycleRules(ctx, bucket, lifecycleRules); err != nil {
+		glog.Error(err)
+		return 1
+	}
+	return 0
+}
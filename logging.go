@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// logFlags are the logging flags shared by the uploader and its subcommands. Everything, including the storage
+// drivers, logs through glog, so -v is the glog verbosity everywhere, e.g. 5 logs the progress of each upload.
+// -quiet only logs errors to stderr whatever the verbosity.
+type logFlags struct {
+	verbosity *string
+	quiet     *bool
+}
+
+func addLogFlags(fs *flag.FlagSet) *logFlags {
+	return &logFlags{
+		verbosity: fs.String("v", "", "Log verbosity.  {4|5|6}"),
+		quiet:     fs.Bool("quiet", false, "Only log errors"),
+	}
+}
+
+// apply sets up glog once the flags are parsed, and returns a cleanup to run on exit
+func (l *logFlags) apply() (cleanup func(), err error) {
+	if *l.verbosity != "" {
+		if v, err := strconv.Atoi(*l.verbosity); err != nil || v < 0 {
+			return nil, fmt.Errorf("invalid -v %q, expected a level such as 4, 5 or 6", *l.verbosity)
+		}
+		if err := flag.Set("v", *l.verbosity); err != nil {
+			return nil, err
+		}
+	}
+	if !*l.quiet {
+		return func() {}, nil
+	}
+	// glog writes everything to stderr or else to files, with only errors also on stderr. The files
+	// of quiet runs go to a temp dir that is removed on exit.
+	dir, err := os.MkdirTemp("", "catalyst-uploader-logs-*")
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range map[string]string{"logtostderr": "false", "alsologtostderr": "false", "stderrthreshold": "ERROR", "log_dir": dir} {
+		if err := flag.Set(name, value); err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+	}
+	return func() { os.RemoveAll(dir) }, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/livepeer/catalyst-uploader/core"
+)
+
+// Formats of the JSON written to stdout, see -output-format
+const (
+	// OutputFormatLegacy is the format Catalyst has always parsed: the uploadOutput or uploadFailure of a single
+	// upload, or a JSON array of the batchOutput of each file of a batch
+	OutputFormatLegacy = "legacy"
+	// OutputFormatV2 is a resultV2 object for single uploads and batches alike
+	OutputFormatV2 = "v2"
+)
+
+// Statuses of resultV2
+const (
+	resultCompleted = "completed"
+	resultFailed    = "failed"
+)
+
+// resultV2 is the JSON written to stdout with -output-format v2. It has the version of its schema, so that
+// parsers can tell it from the legacy output and later versions, the status and duration of the whole run, and
+// either the fields of a single upload or the results of each file of a batch in Uploads.
+type resultV2 struct {
+	Schema     string `json:"schema"`
+	Status     string `json:"status"`
+	DurationMs int64  `json:"duration_ms"`
+	*uploadOutput
+	Error    string               `json:"error,omitempty"`
+	Attempts []core.UploadAttempt `json:"attempts,omitempty"`
+	Uploads  []batchOutput        `json:"uploads,omitempty"`
+}
+
+// resultWriter writes the result of a run to stdout in the -output-format, whatever the log verbosity, unless
+// -no-output is set, and to the -output-file if set
+type resultWriter struct {
+	stdout     io.Writer
+	format     string
+	start      time.Time
+	spacesCDN  bool
+	noOutput   bool
+	outputFile string
+}
+
+func newResultWriter(stdout io.Writer, format string, spacesCDN, noOutput bool, outputFile string) (*resultWriter, error) {
+	if format != OutputFormatLegacy && format != OutputFormatV2 {
+		return nil, fmt.Errorf("invalid -output-format %q, expected %s or %s", format, OutputFormatLegacy, OutputFormatV2)
+	}
+	return &resultWriter{stdout: stdout, format: format, start: time.Now(), spacesCDN: spacesCDN, noOutput: noOutput, outputFile: outputFile}, nil
+}
+
+func (w *resultWriter) v2(status string) resultV2 {
+	return resultV2{Schema: OutputFormatV2, Status: status, DurationMs: time.Since(w.start).Milliseconds()}
+}
+
+// completed writes the result of a single upload that completed
+func (w *resultWriter) completed(uri *url.URL, result *core.UploadResult, opts core.UploadOptions) error {
+	output := newUploadOutput(uri, result, opts, w.spacesCDN)
+	if w.format == OutputFormatLegacy {
+		return w.write(output)
+	}
+	v2 := w.v2(resultCompleted)
+	v2.uploadOutput = &output
+	return w.write(v2)
+}
+
+// failed writes the result of a single upload that failed with err
+func (w *resultWriter) failed(uri *url.URL, err error, opts core.UploadOptions) error {
+	failure := uploadFailure{URI: core.RedactedURI(uri), Error: err.Error(), Attempts: core.UploadAttempts(err), CorrelationIDs: opts.CorrelationIDs}
+	if w.format == OutputFormatLegacy {
+		return w.write(failure)
+	}
+	v2 := w.v2(resultFailed)
+	v2.uploadOutput = &uploadOutput{URI: failure.URI, CorrelationIDs: failure.CorrelationIDs}
+	v2.Error, v2.Attempts = failure.Error, failure.Attempts
+	return w.write(v2)
+}
+
+// batch writes the results of the files of a batch, failed if any of them failed
+func (w *resultWriter) batch(outputs []batchOutput, failed bool) error {
+	if w.format == OutputFormatLegacy {
+		return w.write(outputs)
+	}
+	v2 := w.v2(resultCompleted)
+	if failed {
+		v2.Status = resultFailed
+	}
+	v2.Uploads = outputs
+	return w.write(v2)
+}
+
+func (w *resultWriter) write(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if w.outputFile != "" {
+		if err := writeFileAtomic(w.outputFile, data); err != nil {
+			return fmt.Errorf("failed to write -output-file: %w", err)
+		}
+	}
+	if w.noOutput {
+		return nil
+	}
+	_, err = w.stdout.Write(data)
+	return err
+}
+
+// writeFileAtomic writes a file under a temporary name in the same directory and renames it, so that readers
+// never see a partial file
+func writeFileAtomic(fileName string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(fileName), "."+filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	// CreateTemp makes the file readable by its owner only
+	if err := os.Chmod(file.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), fileName)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-uploader/core"
+)
+
+// runQuota implements `catalyst-uploader quota`, writing the usage of the quotas counted with -quotas to stdout as
+// one JSON object per line, or in the Prometheus text format
+func runQuota(args []string) int {
+	fs := flag.NewFlagSet("catalyst-uploader quota", flag.ExitOnError)
+	logs := addLogFlags(fs)
+	addConfigFlag(fs)
+	quotas := fs.String("quotas", "", "JSON file of the quotas given to uploads, for their soft limits")
+	quotaDB := fs.String("quota-db", filepath.Join(defaultStateDir("quota"), "usage.db"), "SQLite database keeping the usage of quotas")
+	format := fs.String("format", "json", "Output format, prometheus for the textfile collector of the node exporter. {json|prometheus}")
+	reset := fs.String("reset", "", "Set the usage of this quota back to zero, e.g. at the start of a billing period, before reporting")
+
+	if err := parseFlags(fs, args); err != nil {
+		glog.Errorf("error parsing cli: %s", err)
+		return 1
+	}
+	cleanupLogs, err := logs.apply()
+	if err != nil {
+		glog.Error(err)
+		return 1
+	}
+	defer cleanupLogs()
+	if *format != "json" && *format != "prometheus" {
+		glog.Errorf("Invalid -format %q, expected json or prometheus", *format)
+		return 1
+	}
+
+	var rules []core.QuotaRule
+	if *quotas != "" {
+		if rules, err = core.LoadQuotaRules(*quotas); err != nil {
+			glog.Error(err)
+			return 1
+		}
+	}
+	uploadQuota, err := core.OpenUploadQuota(*quotaDB, rules, false)
+	if err != nil {
+		glog.Errorf("Failed to open quota database: %s", err)
+		return 1
+	}
+	defer uploadQuota.Close()
+	if *reset != "" {
+		if err := uploadQuota.Reset(*reset); err != nil {
+			glog.Errorf("Failed to reset quota %q: %s", *reset, err)
+			return 1
+		}
+	}
+	usage, err := uploadQuota.Usage()
+	if err != nil {
+		glog.Errorf("Failed to read quota usage: %s", err)
+		return 1
+	}
+
+	if *format == "prometheus" {
+		if err := core.WriteQuotaMetrics(os.Stdout, usage); err != nil {
+			glog.Error(err)
+			return 1
+		}
+		return 0
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for _, u := range usage {
+		if err := enc.Encode(u); err != nil {
+			glog.Error(err)
+			return 1
+		}
+	}
+	return 0
+}
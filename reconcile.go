@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-uploader/core"
+)
+
+// runReconcile implements `catalyst-uploader reconcile <localdir> <uri-prefix>`, writing the files that are
+// missing or differ at the destination to stdout as one JSON object per line, and uploading them again with -fix
+func runReconcile(args []string) int {
+	fs := flag.NewFlagSet("catalyst-uploader reconcile", flag.ExitOnError)
+	logs := addLogFlags(fs)
+	addConfigFlag(fs)
+	fix := fs.Bool("fix", false, "Upload the missing and mismatched files again")
+	checksum := fs.Bool("checksum", false, "Also compare the MD5 checksum of files of the same size, where the storage reports one")
+	parallel := fs.Int("parallel", 4, "Number of files uploaded concurrently with -fix")
+	include := RepeatedFlag(fs, "include", "Only compare files matching this pattern, see the uploader's -include. Can be given several times")
+	exclude := RepeatedFlag(fs, "exclude", "Don't compare files matching this pattern. Can be given several times")
+	includeHidden := fs.Bool("include-hidden", false, "Also compare dotfiles and temporary files ending in .tmp, .part, .partial or ~")
+	timeout := fs.Duration("t", 5*time.Minute, "Timeout of the listing and of each upload")
+
+	if err := parseFlags(fs, args); err != nil {
+		glog.Errorf("error parsing cli: %s", err)
+		return 1
+	}
+	cleanupLogs, err := logs.apply()
+	if err != nil {
+		glog.Error(err)
+		return 1
+	}
+	defer cleanupLogs()
+	if fs.NArg() != 2 {
+		glog.Error("Expected a local directory and a storage URI prefix")
+		return 1
+	}
+	arg, err := core.ExpandEnv(fs.Arg(1))
+	if err != nil {
+		glog.Error(err)
+		return 1
+	}
+	prefix, err := core.ParseOutputURI(arg)
+	if err != nil {
+		glog.Errorf("Failed to parse URI: %s", err)
+		return 1
+	}
+	filter := core.FileFilter{Include: *include, Exclude: *exclude, IncludeHidden: *includeHidden}
+	if err := filter.Validate(); err != nil {
+		glog.Errorf("Invalid -include or -exclude: %s", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	reconcileOpts := core.ReconcileOptions{Filter: filter, Checksum: *checksum, Fix: *fix, Parallel: *parallel}
+	diffs, err := core.Reconcile(ctx, fs.Arg(0), prefix, reconcileOpts, core.UploadOptions{WriteTimeout: *timeout, SegmentTimeout: *timeout})
+	if err != nil {
+		glog.Errorf("Failed to reconcile %s with %s: %s", fs.Arg(0), prefix.Redacted(), err)
+		return 1
+	}
+	exitCode := 0
+	enc := json.NewEncoder(os.Stdout)
+	for _, diff := range diffs {
+		if err := enc.Encode(diff); err != nil {
+			glog.Error(err)
+			return 1
+		}
+		// extra objects are only reported
+		if diff.Status != core.ReconcileExtra && !diff.Fixed {
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
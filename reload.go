@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-uploader/core"
+)
+
+// reloadableFlags are the settings that a long running uploader picks up again from its config on SIGHUP: the
+// fallback URLs and hedging, the timeouts and the thumbnail settings
+type reloadableFlags struct {
+	timeout              *time.Duration
+	segTimeout           *time.Duration
+	segTimeoutFactor     *float64
+	heartbeat            *time.Duration
+	storageFallbackURLs  *map[string]string
+	hedgeDelay           *time.Duration
+	disableThumbs        *[]string
+	thumbsURLReplacement *map[string]string
+	thumbsHWAccel        *string
+	thumbsHWAccelDevice  *string
+}
+
+func addReloadableFlags(fs *flag.FlagSet) *reloadableFlags {
+	return &reloadableFlags{
+		timeout:              fs.Duration("t", 30*time.Second, "Upload timeout"),
+		storageFallbackURLs:  CommaMapFlag(fs, "storage-fallback-urls", `Comma-separated map of primary to backup storage URLs. If a file fails uploading to one of the primary storages (detected by prefix), it will fallback to the corresponding backup URL after having the prefix replaced`),
+		hedgeDelay:           fs.Duration("hedge-delay", 0, "Also upload to the -storage-fallback-urls backup once the primary upload has made no progress for this long, keeping whichever completes first. 0 only falls back once the primary fails"),
+		segTimeout:           fs.Duration("segment-timeout", 5*time.Minute, "Segment write timeout"),
+		segTimeoutFactor:     fs.Float64("segment-timeout-factor", 0, "Derive the segment write timeout from the segment's duration, read from .ts and .mp4 segments or given by -segment-duration, times this factor, e.g. 3, instead of -segment-timeout. Segments of unknown duration keep -segment-timeout. 0 disables it"),
+		thumbsHWAccel:        fs.String("thumbs-hwaccel", "", "Decode segments for thumbnails with this ffmpeg -hwaccel method, e.g. vaapi or cuda, falling back to the CPU if it fails"),
+		thumbsHWAccelDevice:  fs.String("thumbs-hwaccel-device", "", "ffmpeg -hwaccel_device for -thumbs-hwaccel, e.g. /dev/dri/renderD128"),
+		heartbeat:            fs.Duration("heartbeat", 30*time.Second, "Log the bytes read, current part and attempt of uploads still in progress at this interval, 0 to disable"),
+		disableThumbs:        CommaSliceFlag(fs, "disable-thumbs", `Comma-separated list of playbackIDs to disable thumbs for`),
+		thumbsURLReplacement: CommaMapFlag(fs, "thumbs-replace-urls", `Map of space separated playbackIDs to space separated URL replacement to use when saving thumbnails. E.g. playbackID1 playbackID2=oldURL newURL`),
+	}
+}
+
+// apply sets the reloadable settings in opts
+func (r *reloadableFlags) apply(opts *core.UploadOptions) error {
+	fallbackURLs, err := expandEnvMap(*r.storageFallbackURLs)
+	if err != nil {
+		return fmt.Errorf("failed to expand storage fallback URLs: %w", err)
+	}
+	opts.WriteTimeout = *r.timeout
+	opts.SegmentTimeout = *r.segTimeout
+	opts.SegmentTimeoutFactor = *r.segTimeoutFactor
+	opts.HeartbeatInterval = *r.heartbeat
+	opts.StorageFallbackURLs = fallbackURLs
+	opts.HedgeDelay = *r.hedgeDelay
+	opts.DisableThumbs = *r.disableThumbs
+	opts.ThumbsURLReplacement = *r.thumbsURLReplacement
+	opts.ThumbsHWAccel = *r.thumbsHWAccel
+	opts.ThumbsHWAccelDevice = *r.thumbsHWAccelDevice
+	return nil
+}
+
+// ignoredFlag stands in for the flags that aren't reloaded, so that the command line parses as it did at startup
+type ignoredFlag struct{ boolFlag bool }
+
+func (f ignoredFlag) String() string   { return "" }
+func (f ignoredFlag) Set(string) error { return nil }
+func (f ignoredFlag) IsBoolFlag() bool { return f.boolFlag }
+
+// reloadOptions parses args, the environment and the config files again like at startup, and returns opts with
+// the reloadable settings replaced
+func reloadOptions(startup *flag.FlagSet, args []string, opts core.UploadOptions) (core.UploadOptions, error) {
+	fs := flag.NewFlagSet(startup.Name(), flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	reloadable := addReloadableFlags(fs)
+	fs.String("config", "", "")
+	startup.VisitAll(func(f *flag.Flag) {
+		if fs.Lookup(f.Name) == nil {
+			b, ok := f.Value.(interface{ IsBoolFlag() bool })
+			fs.Var(ignoredFlag{boolFlag: ok && b.IsBoolFlag()}, f.Name, f.Usage)
+		}
+	})
+	if err := parseFlags(fs, args); err != nil {
+		return opts, err
+	}
+	err := reloadable.apply(&opts)
+	return opts, err
+}
+
+// reloadOnSIGHUP reloads the settings of live from the config on each SIGHUP until ctx is done. A config that
+// fails to parse is logged and the previous settings kept.
+func reloadOnSIGHUP(ctx context.Context, startup *flag.FlagSet, live *core.LiveOptions) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-hup:
+			opts, err := reloadOptions(startup, os.Args[1:], live.Get())
+			if err != nil {
+				glog.Errorf("Failed to reload config, keeping the previous settings: %s", err)
+				continue
+			}
+			live.Set(opts)
+			glog.Info("Reloaded config")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-uploader/core"
+)
+
+// runReport implements `catalyst-uploader report`, writing the uploads recorded with -index to stdout as
+// one JSON object per line
+func runReport(args []string) int {
+	fs := flag.NewFlagSet("catalyst-uploader report", flag.ExitOnError)
+	logs := addLogFlags(fs)
+	addConfigFlag(fs)
+	index := fs.String("index", "", "SQLite database written by uploads with -index")
+	destination := fs.String("destination", "", "Only report uploads to this storage, e.g. s3://user:xxxxx@us-east-1")
+	keyPrefix := fs.String("key-prefix", "", "Only report uploads with keys starting with this prefix")
+	since := fs.Duration("since", 0, "Only report uploads completed within this duration")
+	limit := fs.Int("limit", 0, "Maximum number of uploads to report")
+
+	if err := parseFlags(fs, args); err != nil {
+		glog.Errorf("error parsing cli: %s", err)
+		return 1
+	}
+	cleanupLogs, err := logs.apply()
+	if err != nil {
+		glog.Error(err)
+		return 1
+	}
+	defer cleanupLogs()
+	if *index == "" {
+		glog.Error("Upload index is not specified")
+		return 1
+	}
+
+	uploadIndex, err := core.OpenUploadIndex(*index)
+	if err != nil {
+		glog.Errorf("Failed to open upload index: %s", err)
+		return 1
+	}
+	defer uploadIndex.Close()
+	query := core.IndexQuery{Destination: *destination, KeyPrefix: *keyPrefix, Limit: *limit}
+	if *since > 0 {
+		query.Since = time.Now().Add(-*since)
+	}
+	entries, err := uploadIndex.Query(query)
+	if err != nil {
+		glog.Errorf("Failed to query upload index: %s", err)
+		return 1
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			glog.Error(err)
+			return 1
+		}
+	}
+	return 0
+}
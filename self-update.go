@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-uploader/core"
+)
+
+// runSelfUpdate implements `catalyst-uploader self-update`, replacing the running binary with a signed release
+// so that nodes can be updated without a new Catalyst image
+func runSelfUpdate(args []string) int {
+	fs := flag.NewFlagSet("catalyst-uploader self-update", flag.ExitOnError)
+	logs := addLogFlags(fs)
+	addConfigFlag(fs)
+	updateURL := fs.String("update-url", "", "URL of the release binary. Its base64 encoded Ed25519 signature is read from the same URL with .sig appended")
+	publicKey := fs.String("update-public-key", "", "Base64 encoded Ed25519 public key the release must be signed with")
+	timeout := fs.Duration("t", 5*time.Minute, "Timeout of the download")
+
+	if err := parseFlags(fs, args); err != nil {
+		glog.Errorf("error parsing cli: %s", err)
+		return 1
+	}
+	cleanupLogs, err := logs.apply()
+	if err != nil {
+		glog.Error(err)
+		return 1
+	}
+	defer cleanupLogs()
+	if *updateURL == "" || *publicKey == "" {
+		glog.Error("-update-url and -update-public-key are required")
+		return 1
+	}
+	key, err := core.ParsePublicKey(*publicKey)
+	if err != nil {
+		glog.Errorf("Invalid -update-public-key: %s", err)
+		return 1
+	}
+	executable, err := os.Executable()
+	if err == nil {
+		executable, err = filepath.EvalSymlinks(executable)
+	}
+	if err != nil {
+		glog.Errorf("Failed to find the running binary: %s", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if err := core.SelfUpdate(ctx, *updateURL, key, executable); err != nil {
+		glog.Errorf("Self-update failed: %s", err)
+		return 1
+	}
+	glog.Infof("Updated %s from %s", executable, *updateURL)
+	return 0
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-uploader/core"
+)
+
+// runUpdateMetadata implements `catalyst-uploader update-metadata`, changing the properties of objects that
+// were already uploaded without uploading them again
+func runUpdateMetadata(args []string) int {
+	fs := flag.NewFlagSet("catalyst-uploader update-metadata", flag.ExitOnError)
+	logs := addLogFlags(fs)
+	addConfigFlag(fs)
+	cacheControl := fs.String("cache-control", "", "New Cache-Control of the objects")
+	contentType := fs.String("content-type", "", "New Content-Type of the objects")
+	contentDisposition := fs.String("content-disposition", "", "New Content-Disposition of the objects")
+	metadata := CommaMapFlag(fs, "metadata", "Comma-separated map of user metadata keys to values, merged into the objects' metadata, e.g. Object-Expires=+168h")
+	timeout := fs.Duration("t", 30*time.Second, "Timeout of each update")
+
+	if err := parseFlags(fs, args); err != nil {
+		glog.Errorf("error parsing cli: %s", err)
+		return 1
+	}
+	cleanupLogs, err := logs.apply()
+	if err != nil {
+		glog.Error(err)
+		return 1
+	}
+	defer cleanupLogs()
+	if fs.NArg() == 0 {
+		glog.Error("Object URI is not specified")
+		return 1
+	}
+	update := core.MetadataUpdate{CacheControl: *cacheControl, ContentType: *contentType, ContentDisposition: *contentDisposition, Metadata: *metadata}
+	if update.CacheControl == "" && update.ContentType == "" && update.ContentDisposition == "" && len(update.Metadata) == 0 {
+		glog.Error("Nothing to update, set -cache-control, -content-type, -content-disposition or -metadata")
+		return 1
+	}
+
+	for _, arg := range fs.Args() {
+		arg, err := core.ExpandEnv(arg)
+		if err != nil {
+			glog.Error(err)
+			return 1
+		}
+		uri, err := core.ParseOutputURI(arg)
+		if err != nil {
+			glog.Errorf("Failed to parse URI: %s", err)
+			return 1
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		err = core.UpdateMetadata(ctx, uri, update)
+		cancel()
+		if err != nil {
+			glog.Errorf("Failed to update metadata of %s: %s", uri.Redacted(), err)
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"github.com/livepeer/catalyst-uploader/core"
+)
+
+// Version, Commit and BuildDate are set at build time with -ldflags, see the Makefile. Commit and BuildDate
+// fall back to the VCS information Go embeds in binaries built from a checkout.
+var (
+	Version   string
+	Commit    string
+	BuildDate string
+)
+
+// versionInfo is the JSON printed by -version, for fleet tooling to inventory the capabilities of each node
+type versionInfo struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit"`
+	BuildDate string   `json:"build_date"`
+	GoVersion string   `json:"go_version"`
+	Drivers   []string `json:"drivers"`
+	Features  []string `json:"features"`
+}
+
+func newVersionInfo() versionInfo {
+	info := versionInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Drivers:   core.DriverSchemes(),
+		Features:  core.Features(),
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = setting.Value
+			}
+		}
+	}
+	return info
+}